@@ -0,0 +1,624 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Anti-entropy messages share the framing of the replication messages. The
+// initiator walks the tree of the responder level by level. It describes the
+// positions whose subtree hashes differ from its own, fetches the subtrees
+// that it lacks, and deletes the subtrees that the responder lacks. Records
+// are fetched in responses of about syncFetchSize bytes, each of which tells
+// how many of the requested ranges it completes.
+const (
+	msgSyncDescribe = byte(0x11) // Payload: list of paths.
+	msgSyncViews    = byte(0x12) // Payload: a syncView per path.
+	msgSyncFetch    = byte(0x13) // Payload: list of syncRanges.
+	msgSyncRecords  = byte(0x14) // Payload: completed ranges and records.
+	msgSyncDone     = byte(0x15) // Payload: empty.
+
+	// syncBatchSize is the maximum number of paths per request. Batching the
+	// paths of a tree level reduces the number of round trips.
+	syncBatchSize = 256
+
+	// syncFetchSize is the size beyond which a fetch response is cut off. It
+	// bounds the memory of a response regardless of the size of the fetched
+	// subtrees, except that a response holds at least one record.
+	syncFetchSize = 1 << 20
+)
+
+// syncRange is a part of a subtree that is fetched from the peer: the records
+// whose keys begin with the prefix, and that follow the after key if it is not
+// nil. The after key is set to resume a range that a response cut off.
+type syncRange struct {
+	prefix []byte
+	after  []byte
+}
+
+// SyncStats summarizes the work of an anti-entropy session.
+type SyncStats struct {
+	RoundTrips int // Number of request and response exchanges.
+	Described  int // Number of positions compared between the peers.
+	Put        int // Number of records that were written locally.
+	Deleted    int // Number of records that were deleted locally.
+}
+
+// SyncFrom reconciles the database with the peer on the other end of conn,
+// which must be running ServeSync. Records that differ are copied from the
+// peer, and records that the peer lacks are deleted, so that both databases
+// end up with equal contents. Only the subtrees whose Merkle hashes differ are
+// exchanged. Writes that either database receives during the session may or
+// may not be reconciled.
+func (a *Arc) SyncFrom(ctx context.Context, conn io.ReadWriter) (SyncStats, error) {
+	var stats SyncStats
+
+	queue := [][]byte{{}}
+
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		batch := queue[:min(len(queue), syncBatchSize)]
+		queue = queue[len(batch):]
+
+		payload, err := roundTrip(conn, msgSyncDescribe, encodePaths(batch), msgSyncViews)
+		stats.RoundTrips++
+
+		if err != nil {
+			return stats, err
+		}
+
+		views, err := decodeSyncViews(payload, len(batch))
+
+		if err != nil {
+			return stats, err
+		}
+
+		var fetch []syncRange
+
+		for i, path := range batch {
+			stats.Described++
+
+			next, missing := a.reconcile(path, views[i], &stats)
+			queue = append(queue, next...)
+			fetch = append(fetch, missing...)
+		}
+
+		for len(fetch) > 0 {
+			ranges := fetch[:min(len(fetch), syncBatchSize)]
+
+			payload, err := roundTrip(conn, msgSyncFetch, encodeSyncRanges(ranges), msgSyncRecords)
+			stats.RoundTrips++
+
+			if err != nil {
+				return stats, err
+			}
+
+			done, last, err := a.applySyncRecords(payload, &stats)
+
+			if err != nil {
+				return stats, err
+			}
+
+			if done == len(ranges) {
+				fetch = fetch[done:]
+				continue
+			}
+
+			// A response that was cut off must make progress on the first
+			// range that it did not complete. Otherwise the same range would
+			// be requested again forever.
+			if done > len(ranges) || last == nil || !bytes.HasPrefix(last, ranges[done].prefix) {
+				return stats, ErrReplicationProtocol
+			}
+
+			if after := ranges[done].after; after != nil && bytes.Compare(last, after) <= 0 {
+				return stats, ErrReplicationProtocol
+			}
+
+			fetch = fetch[done:]
+			fetch[0].after = last
+		}
+	}
+
+	return stats, writeFrame(conn, msgSyncDone, nil)
+}
+
+// ServeSync answers the requests of a peer that is running SyncFrom over conn.
+// It returns when the peer completes the session, the connection is closed,
+// or the context is done.
+func (a *Arc) ServeSync(ctx context.Context, conn io.ReadWriter) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		msgType, payload, err := readFrame(conn)
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		if msgType == msgSyncDone {
+			return nil
+		}
+
+		var responseType byte
+		var response []byte
+
+		switch msgType {
+		case msgSyncDescribe:
+			paths, err := decodePaths(payload)
+
+			if err != nil {
+				return err
+			}
+
+			responseType = msgSyncViews
+
+			for _, path := range paths {
+				response = a.describe(path).appendTo(response)
+			}
+
+		case msgSyncFetch:
+			ranges, err := decodeSyncRanges(payload)
+
+			if err != nil {
+				return err
+			}
+
+			responseType = msgSyncRecords
+			response = a.appendSyncRecords(nil, ranges)
+
+		default:
+			return ErrReplicationProtocol
+		}
+
+		if err := writeFrame(conn, responseType, response); err != nil {
+			return err
+		}
+	}
+}
+
+// syncView describes the position at a path: the record at the path, and the
+// subtrees that follow it.
+type syncView struct {
+	record    bool
+	value     []byte
+	expiresAt int64
+	children  []syncChild
+}
+
+// syncChild is a subtree that follows a position. The segment leads from the
+// position to the root node of the subtree.
+type syncChild struct {
+	segment []byte
+	hash    Hash
+}
+
+// describe returns the view of the position at the path. The position may be
+// in the middle of a node's key segment, in which case the view has a single
+// child. Expired records are treated as absent.
+func (a *Arc) describe(path []byte) syncView {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	a.hashMu.Lock()
+	defer a.hashMu.Unlock()
+
+	var ret syncView
+
+	// The position at the empty path is the root node if its key segment is
+	// empty, and otherwise a virtual node whose only child is the root node.
+	pos := &diffCursor{n: &node{firstChild: a.root}}
+
+	if a.root != nil && len(a.root.key) == 0 {
+		pos = &diffCursor{n: a.root}
+	}
+
+	for len(path) > 0 {
+		var next *diffCursor
+
+		for _, child := range pos.children() {
+			if child.rest[0] == path[0] {
+				next = child
+				break
+			}
+		}
+
+		if next == nil {
+			return ret
+		}
+
+		common := len(longestCommonPrefix(next.rest, path))
+
+		if common < len(next.rest) && common < len(path) {
+			return ret
+		}
+
+		pos = &diffCursor{rest: next.rest[common:], n: next.n}
+		path = path[common:]
+	}
+
+	if n := pos.record(); n != nil {
+		ret.record = true
+		ret.value = recordValue(n, a.blobs)
+		ret.expiresAt = n.expiresAt
+	}
+
+	for _, child := range pos.children() {
+		ret.children = append(ret.children, syncChild{
+			segment: bytes.Clone(child.rest),
+			hash:    child.n.merkleHash(),
+		})
+	}
+
+	return ret
+}
+
+// reconcile brings the record at the path in line with the remote view, and
+// deletes the local subtrees that the remote lacks. It returns the paths that
+// must be described further, and the prefixes whose records must be fetched.
+func (a *Arc) reconcile(path []byte, remote syncView, stats *SyncStats) (next [][]byte, fetch []syncRange) {
+	local := a.describe(path)
+
+	switch {
+	case remote.record:
+		if !local.record || local.expiresAt != remote.expiresAt || !bytes.Equal(local.value, remote.value) {
+			a.mu.Lock()
+			err := a.put(path, remote.value, remote.expiresAt, true)
+			a.mu.Unlock()
+
+			if err == nil {
+				stats.Put++
+			}
+		}
+
+	case local.record:
+		if a.Delete(path) == nil {
+			stats.Deleted++
+		}
+	}
+
+	// Children are sorted by segment, and siblings never share a first byte.
+	ls, rs := local.children, remote.children
+
+	for len(ls) > 0 || len(rs) > 0 {
+		switch {
+		case len(rs) == 0 || (len(ls) > 0 && ls[0].segment[0] < rs[0].segment[0]):
+			count, _ := a.DeletePrefix(appendPath(path, ls[0].segment))
+			stats.Deleted += count
+			ls = ls[1:]
+
+		case len(ls) == 0 || rs[0].segment[0] < ls[0].segment[0]:
+			fetch = append(fetch, syncRange{prefix: appendPath(path, rs[0].segment)})
+			rs = rs[1:]
+
+		default:
+			if !bytes.Equal(ls[0].segment, rs[0].segment) || ls[0].hash != rs[0].hash {
+				common := longestCommonPrefix(ls[0].segment, rs[0].segment)
+				next = append(next, appendPath(path, common))
+			}
+
+			ls = ls[1:]
+			rs = rs[1:]
+		}
+	}
+
+	return next, fetch
+}
+
+// appendSyncRecords appends the live records of the ranges to dst, preceded
+// by the number of ranges whose records are complete. The records are cut off
+// once they exceed syncFetchSize bytes, in which case the range that follows
+// the complete ones continues after the last record.
+func (a *Arc) appendSyncRecords(dst []byte, ranges []syncRange) []byte {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var records []byte
+
+	for i, r := range ranges {
+		start := r.prefix
+
+		if r.after != nil {
+			start = r.after
+		}
+
+		full := false
+
+		a.walk(start, prefixSuccessor(r.prefix), func(key []byte, n *node) bool {
+			if n.expired() || (r.after != nil && bytes.Equal(key, r.after)) {
+				return true
+			}
+
+			records = appendSyncBytes16(records, key)
+			records = binary.LittleEndian.AppendUint64(records, uint64(n.expiresAt))
+			records = appendSyncBytes32(records, n.value(a.blobs))
+			full = len(records) >= syncFetchSize
+
+			return !full
+		})
+
+		if full {
+			dst = binary.LittleEndian.AppendUint32(dst, uint32(i))
+			return append(dst, records...)
+		}
+	}
+
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(ranges)))
+
+	return append(dst, records...)
+}
+
+// applySyncRecords writes the records of a msgSyncRecords payload. It returns
+// the number of complete ranges, and the key of the last record, if any.
+func (a *Arc) applySyncRecords(src []byte, stats *SyncStats) (int, []byte, error) {
+	var last []byte
+
+	d := syncDecoder{src: src}
+	done := int(d.uint32())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for len(d.src) > 0 && d.err == nil {
+		key := d.bytes16()
+		expiresAt := int64(d.uint64())
+		value := d.bytes32()
+
+		if d.err == nil && a.put(key, value, expiresAt, true) == nil {
+			stats.Put++
+		}
+
+		last = key
+	}
+
+	return done, last, d.err
+}
+
+// appendTo appends the encoded view to dst.
+func (v syncView) appendTo(dst []byte) []byte {
+	if v.record {
+		dst = append(dst, flagIsRecord)
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(v.expiresAt))
+		dst = appendSyncBytes32(dst, v.value)
+	} else {
+		dst = append(dst, 0)
+	}
+
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(len(v.children)))
+
+	for _, child := range v.children {
+		dst = appendSyncBytes16(dst, child.segment)
+		dst = append(dst, child.hash[:]...)
+	}
+
+	return dst
+}
+
+// decodeSyncViews decodes the given number of views from a msgSyncViews
+// payload.
+func decodeSyncViews(src []byte, count int) ([]syncView, error) {
+	ret := make([]syncView, count)
+	d := syncDecoder{src: src}
+
+	for i := range ret {
+		switch d.uint8() {
+		case flagIsRecord:
+			ret[i].record = true
+			ret[i].expiresAt = int64(d.uint64())
+			ret[i].value = d.bytes32()
+		case 0:
+		default:
+			return nil, ErrReplicationProtocol
+		}
+
+		for n := d.uint16(); n > 0 && d.err == nil; n-- {
+			child := syncChild{segment: d.bytes16()}
+			copy(child.hash[:], d.bytes(len(child.hash)))
+
+			if len(child.segment) == 0 {
+				return nil, ErrReplicationProtocol
+			}
+
+			ret[i].children = append(ret[i].children, child)
+		}
+	}
+
+	if d.err == nil && len(d.src) > 0 {
+		return nil, ErrReplicationProtocol
+	}
+
+	return ret, d.err
+}
+
+// encodePaths encodes a list of paths.
+func encodePaths(paths [][]byte) []byte {
+	var ret []byte
+
+	for _, path := range paths {
+		ret = appendSyncBytes16(ret, path)
+	}
+
+	return ret
+}
+
+// decodePaths decodes a list of paths that was encoded by encodePaths.
+func decodePaths(src []byte) ([][]byte, error) {
+	var ret [][]byte
+
+	d := syncDecoder{src: src}
+
+	for len(d.src) > 0 && d.err == nil {
+		ret = append(ret, d.bytes16())
+	}
+
+	return ret, d.err
+}
+
+// encodeSyncRanges encodes a list of ranges. Each range is encoded as its
+// prefix, a flag that tells whether it has an after key, and the after key.
+func encodeSyncRanges(ranges []syncRange) []byte {
+	var ret []byte
+
+	for _, r := range ranges {
+		ret = appendSyncBytes16(ret, r.prefix)
+
+		if r.after == nil {
+			ret = append(ret, 0)
+			continue
+		}
+
+		ret = append(ret, 1)
+		ret = appendSyncBytes16(ret, r.after)
+	}
+
+	return ret
+}
+
+// decodeSyncRanges decodes a list of ranges that was encoded by
+// encodeSyncRanges. The after key of a range must begin with its prefix.
+func decodeSyncRanges(src []byte) ([]syncRange, error) {
+	var ret []syncRange
+
+	d := syncDecoder{src: src}
+
+	for len(d.src) > 0 && d.err == nil {
+		r := syncRange{prefix: d.bytes16()}
+
+		switch d.uint8() {
+		case 0:
+		case 1:
+			r.after = d.bytes16()
+		default:
+			return nil, ErrReplicationProtocol
+		}
+
+		if d.err == nil && r.after != nil && !bytes.HasPrefix(r.after, r.prefix) {
+			return nil, ErrReplicationProtocol
+		}
+
+		ret = append(ret, r)
+	}
+
+	return ret, d.err
+}
+
+// roundTrip sends a request and reads the response of the expected type.
+func roundTrip(conn io.ReadWriter, msgType byte, payload []byte, wantType byte) ([]byte, error) {
+	if err := writeFrame(conn, msgType, payload); err != nil {
+		return nil, err
+	}
+
+	gotType, response, err := readFrame(conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if gotType != wantType {
+		return nil, ErrReplicationProtocol
+	}
+
+	return response, nil
+}
+
+// appendSyncBytes16 appends src preceded by its uint16 length.
+func appendSyncBytes16(dst []byte, src []byte) []byte {
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(len(src)))
+	return append(dst, src...)
+}
+
+// appendSyncBytes32 appends src preceded by its uint32 length.
+func appendSyncBytes32(dst []byte, src []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(src)))
+	return append(dst, src...)
+}
+
+// syncDecoder reads the fields of an anti-entropy payload. The first error is
+// retained, and subsequent reads return zero values.
+type syncDecoder struct {
+	src []byte
+	err error
+}
+
+// bytes reads the next n bytes. It returns a non-nil slice on success, since
+// an empty key is distinct from a nil key.
+func (d *syncDecoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if n > len(d.src) {
+		d.err = ErrReplicationProtocol
+		return nil
+	}
+
+	if n == 0 {
+		return []byte{}
+	}
+
+	ret := d.src[:n:n]
+	d.src = d.src[n:]
+
+	return ret
+}
+
+// uint8 reads the next uint8.
+func (d *syncDecoder) uint8() uint8 {
+	if src := d.bytes(sizeOfUint8); src != nil {
+		return src[0]
+	}
+
+	return 0
+}
+
+// uint16 reads the next little-endian uint16.
+func (d *syncDecoder) uint16() uint16 {
+	if src := d.bytes(sizeOfUint16); src != nil {
+		return binary.LittleEndian.Uint16(src)
+	}
+
+	return 0
+}
+
+// uint32 reads the next little-endian uint32.
+func (d *syncDecoder) uint32() uint32 {
+	if src := d.bytes(sizeOfUint32); src != nil {
+		return binary.LittleEndian.Uint32(src)
+	}
+
+	return 0
+}
+
+// uint64 reads the next little-endian uint64.
+func (d *syncDecoder) uint64() uint64 {
+	if src := d.bytes(sizeOfUint64); src != nil {
+		return binary.LittleEndian.Uint64(src)
+	}
+
+	return 0
+}
+
+// bytes16 reads a byte slice that is preceded by its uint16 length.
+func (d *syncDecoder) bytes16() []byte {
+	return d.bytes(int(d.uint16()))
+}
+
+// bytes32 reads a byte slice that is preceded by its uint32 length.
+func (d *syncDecoder) bytes32() []byte {
+	return d.bytes(int(d.uint32()))
+}
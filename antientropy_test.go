@@ -0,0 +1,288 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// runSync reconciles the local database with the remote database over an
+// in-memory connection.
+func runSync(t *testing.T, local *Arc, remote *Arc) SyncStats {
+	t.Helper()
+
+	ctx := context.Background()
+	localConn, remoteConn := net.Pipe()
+	served := make(chan error, 1)
+
+	defer localConn.Close()
+	defer remoteConn.Close()
+
+	go func() { served <- remote.ServeSync(ctx, remoteConn) }()
+
+	stats, err := local.SyncFrom(ctx, localConn)
+
+	if err != nil {
+		t.Fatalf("SyncFrom(): %v", err)
+	}
+
+	if err := <-served; err != nil {
+		t.Fatalf("ServeSync(): %v", err)
+	}
+
+	return stats
+}
+
+func TestSyncFrom(t *testing.T) {
+	setTestClock(t)
+
+	testCases := []struct {
+		name   string
+		local  func() *Arc
+		remote func() *Arc
+	}{
+		{"empty local", New, basicTestTree},
+		{"empty remote", basicTestTree, New},
+		{"equal databases", basicTestTree, basicTestTree},
+		{"ip string trees", basicTestTree, ipStringTestTree},
+		{
+			name:  "divergent records",
+			local: basicTestTree,
+			remote: func() *Arc {
+				arc := basicTestTree()
+				arc.Put([]byte("apple"), blobValueX())
+				arc.Put([]byte("app"), []byte("store"))
+				arc.Delete([]byte("banana"))
+				arc.DeletePrefix([]byte("grape"))
+				arc.PutWithTTL([]byte("berry"), []byte("sweet"), time.Hour)
+
+				return arc
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			local := tc.local()
+			remote := tc.remote()
+
+			runSync(t, local, remote)
+
+			if got := slices.Collect(Diff(local, remote)); len(got) != 0 {
+				t.Errorf("unexpected diff after sync: %v", got)
+			}
+
+			if got, want := local.RootHash(), remote.RootHash(); got != want {
+				t.Errorf("unexpected root hash: got:%s, want:%s", got, want)
+			}
+		})
+	}
+}
+
+func TestSyncFromTransfersDifferencesOnly(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	local := New()
+
+	for i := 0; i < 5000; i++ {
+		local.Put([]byte(fmt.Sprintf("user/%08x", rng.Uint32())), []byte(fmt.Sprint(i)))
+	}
+
+	var snapshot bytes.Buffer
+
+	local.WriteTo(&snapshot)
+
+	remote := New()
+	remote.ReadFrom(&snapshot)
+
+	remote.Put([]byte("user/new"), []byte("added"))
+
+	for key := range local.Scan([]byte("user/0")) {
+		remote.Delete(key)
+		break
+	}
+
+	stats := runSync(t, local, remote)
+
+	if stats.Put != 1 || stats.Deleted != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// Only the paths to the two differences are described.
+	if stats.Described > 64 {
+		t.Errorf("too many positions described: %d", stats.Described)
+	}
+
+	if got, want := local.RootHash(), remote.RootHash(); got != want {
+		t.Errorf("unexpected root hash: got:%s, want:%s", got, want)
+	}
+}
+
+func TestSyncFromLargeSubtree(t *testing.T) {
+	remote := New()
+
+	// The subtree under the prefix holds several fetch responses worth of
+	// records, and is lacking entirely on the local side.
+	value := bytes.Repeat([]byte{0xab}, 8<<10)
+	numRecords := 3*syncFetchSize/len(value) + 1
+
+	for i := range numRecords {
+		remote.Put(fmt.Appendf(nil, "blob/%04d", i), value)
+	}
+
+	remote.Put([]byte("blob/"), []byte("first"))
+	remote.Put([]byte("other"), []byte("record"))
+
+	local := New()
+	local.Put([]byte("other"), []byte("record"))
+
+	stats := runSync(t, local, remote)
+
+	if got, want := stats.Put, numRecords+1; got != want {
+		t.Errorf("unexpected number of records written: got:%d, want:%d", got, want)
+	}
+
+	// The subtree is fetched in at least four responses.
+	if stats.RoundTrips < 5 {
+		t.Errorf("unexpected number of round trips: %d", stats.RoundTrips)
+	}
+
+	if got, want := local.RootHash(), remote.RootHash(); got != want {
+		t.Errorf("unexpected root hash: got:%s, want:%s", got, want)
+	}
+}
+
+func TestSyncRecordsCutOff(t *testing.T) {
+	a := New()
+	value := bytes.Repeat([]byte{0xcd}, syncFetchSize/2)
+
+	for _, key := range []string{"a/1", "a/2", "a/3", "b/1"} {
+		a.Put([]byte(key), value)
+	}
+
+	testCases := []struct {
+		ranges []syncRange
+		done   int
+		keys   []string
+	}{
+		{[]syncRange{{prefix: []byte("a/")}, {prefix: []byte("b/")}}, 0, []string{"a/1", "a/2"}},
+		{[]syncRange{{prefix: []byte("a/"), after: []byte("a/2")}, {prefix: []byte("b/")}}, 1, []string{"a/3", "b/1"}},
+		{[]syncRange{{prefix: []byte("b/"), after: []byte("b/1")}}, 1, nil},
+		{[]syncRange{{prefix: []byte("c/")}}, 1, nil},
+	}
+
+	for _, tc := range testCases {
+		src, err := decodeSyncRanges(encodeSyncRanges(tc.ranges))
+
+		if err != nil {
+			t.Fatalf("decodeSyncRanges(): %v", err)
+		}
+
+		b := New()
+		var stats SyncStats
+
+		done, last, err := b.applySyncRecords(a.appendSyncRecords(nil, src), &stats)
+
+		if err != nil {
+			t.Fatalf("applySyncRecords(): %v", err)
+		}
+
+		var keys []string
+
+		for key := range b.Scan(nil) {
+			keys = append(keys, string(key))
+		}
+
+		if done != tc.done || !slices.Equal(keys, tc.keys) {
+			t.Errorf("unexpected response for %q: got:%d %q, want:%d %q", tc.ranges, done, keys, tc.done, tc.keys)
+		}
+
+		if len(keys) > 0 && string(last) != keys[len(keys)-1] {
+			t.Errorf("unexpected last key: got:%q, want:%q", last, keys[len(keys)-1])
+		}
+	}
+
+	// The after key of a range must be within its prefix.
+	invalid := encodeSyncRanges([]syncRange{{prefix: []byte("b/"), after: []byte("a/1")}})
+
+	if _, err := decodeSyncRanges(invalid); !errors.Is(err, ErrReplicationProtocol) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrReplicationProtocol)
+	}
+}
+
+func TestSyncFromNoProgress(t *testing.T) {
+	remote := New()
+	value := bytes.Repeat([]byte{0xcd}, syncFetchSize/2)
+
+	for i := range 4 {
+		remote.Put([]byte(fmt.Sprintf("key/%d", i)), value)
+	}
+
+	localConn, remoteConn := net.Pipe()
+	defer localConn.Close()
+	defer remoteConn.Close()
+
+	// The peer answers every fetch with its first cut-off response, which
+	// makes no progress past the key that the response ends with.
+	go func() {
+		var replay []byte
+
+		for {
+			msgType, payload, err := readFrame(remoteConn)
+
+			if err != nil || msgType == msgSyncDone {
+				return
+			}
+
+			var responseType byte
+			var response []byte
+
+			switch msgType {
+			case msgSyncDescribe:
+				paths, _ := decodePaths(payload)
+				responseType = msgSyncViews
+
+				for _, path := range paths {
+					response = remote.describe(path).appendTo(response)
+				}
+
+			case msgSyncFetch:
+				if replay == nil {
+					ranges, _ := decodeSyncRanges(payload)
+					replay = remote.appendSyncRecords(nil, ranges)
+				}
+
+				responseType, response = msgSyncRecords, replay
+			}
+
+			if writeFrame(remoteConn, responseType, response) != nil {
+				return
+			}
+		}
+	}()
+
+	if _, err := New().SyncFrom(context.Background(), localConn); !errors.Is(err, ErrReplicationProtocol) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrReplicationProtocol)
+	}
+}
+
+func TestSyncFromCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	localConn, remoteConn := net.Pipe()
+	defer localConn.Close()
+	defer remoteConn.Close()
+
+	if _, err := New().SyncFrom(ctx, localConn); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, context.Canceled)
+	}
+}
@@ -8,20 +8,49 @@
 package arc
 
 import (
+	"bytes"
 	"errors"
+	"math/rand/v2"
 	"sync"
+	"sync/atomic"
+
+	"github.com/chronohq/arc/format"
 )
 
 var (
+	// ErrChangeLogTruncated is returned when the requested changes are no
+	// longer retained by the change log.
+	ErrChangeLogTruncated = errors.New("change log truncated")
+
 	// ErrCorrupted is returned when a database corruption is detected.
-	ErrCorrupted = errors.New("database corruption detected")
+	ErrCorrupted = format.ErrCorrupted
 
 	// ErrDuplicateKey is returned when an insertion is attempted using a
 	// key that already exists in the database.
 	ErrDuplicateKey = errors.New("cannot insert duplicate key")
 
+	// ErrInjectedFault is returned by MemFS when it injects a fault, and by
+	// every operation after a simulated crash.
+	ErrInjectedFault = errors.New("injected filesystem fault")
+
 	// ErrInvalidChecksum is returned when the node checksum is invalid.
-	ErrInvalidChecksum = errors.New("invalid checksum detected")
+	ErrInvalidChecksum = format.ErrInvalidChecksum
+
+	// ErrInvalidEncoding is returned when a codec cannot decode a value.
+	ErrInvalidEncoding = errors.New("invalid value encoding")
+
+	// ErrInvalidInterval is returned when a periodic task is given an
+	// interval that is not positive.
+	ErrInvalidInterval = errors.New("interval must be positive")
+
+	// ErrInvalidProof is returned when a Merkle proof does not hold.
+	ErrInvalidProof = errors.New("invalid merkle proof")
+
+	// ErrInvalidRange is returned when the start of a range sorts after its end.
+	ErrInvalidRange = errors.New("invalid key range")
+
+	// ErrInvalidTTL is returned when a record is given a non-positive TTL.
+	ErrInvalidTTL = errors.New("ttl must be positive")
 
 	// ErrKeyNotFound is returned when the key does not exist in the index.
 	ErrKeyNotFound = errors.New("key not found")
@@ -32,8 +61,32 @@ var (
 	// ErrNilKey is returned when an insertion is attempted using a nil key.
 	ErrNilKey = errors.New("key cannot be nil")
 
+	// ErrNoMergeOperator is returned when a merge is attempted on a key that
+	// has no registered merge operator.
+	ErrNoMergeOperator = errors.New("no merge operator registered for key")
+
 	// ErrNodeCorrupted is returned when an index node corruption is detected.
-	ErrNodeCorrupted = errors.New("index node corruption detected")
+	ErrNodeCorrupted = format.ErrNodeCorrupted
+
+	// ErrNotAttached is returned by Flush and Close when the database is not
+	// attached to a paged file.
+	ErrNotAttached = errors.New("database is not attached to a file")
+
+	// ErrNotPaged is returned by OpenPaged when the file does not use the
+	// paged layout.
+	ErrNotPaged = errors.New("file does not use the paged layout")
+
+	// ErrReplicationProtocol is returned when a replication peer sends an
+	// unexpected or malformed message.
+	ErrReplicationProtocol = errors.New("replication protocol violation")
+
+	// ErrUnsupportedFeature is returned when a database file uses an
+	// incompatible feature that this version of Arc does not know.
+	ErrUnsupportedFeature = format.ErrUnsupportedFeature
+
+	// ErrUnsupportedVersion is returned when a database file was written in a
+	// file format version that this version of Arc does not know.
+	ErrUnsupportedVersion = format.ErrUnsupportedVersion
 
 	// ErrValueTooLarge is returned when the value size exceeds the 4GB limit.
 	ErrValueTooLarge = errors.New("value is too large")
@@ -56,16 +109,46 @@ type Arc struct {
 	numRecords int          // Number of records in the tree.
 	mu         sync.RWMutex // RWLock for concurrency management.
 
+	// Serializes the caching of Merkle hashes by readers, which only hold the
+	// read lock. Writers hold the write lock, which already excludes readers.
+	hashMu sync.Mutex
+
 	// Stores deduplicated values that are larger than 32 bytes.
 	blobs blobStore
+
+	// Merge operators that are registered by key prefix.
+	mergeOps map[string]MergeOperator
+
+	// Active watchers that receive change notifications.
+	watchers map[*watcher]struct{}
+
+	// Closed by the next mutation if not nil. See published.
+	publishSignal atomic.Pointer[chan struct{}]
+
+	// Sequence number of the most recent mutation.
+	seq uint64
+
+	// Identifies the history of mutations that the sequence numbers count.
+	// It is drawn anew whenever the contents are replaced, since the sequence
+	// numbers of the previous contents no longer describe them.
+	epoch uint64
+
+	// Retains the most recent changes if enabled by EnableChangeLog.
+	changeLog *changeLog
+
+	// Selects the compact node encoding if enabled by EnableCompactNodes.
+	compactNodes bool
+
+	// Writes the changes to the attached file if opened by OpenPaged.
+	pager *pager
 }
 
 // New returns an empty Arc database handler.
 func New() *Arc {
-	return &Arc{blobs: blobStore{}}
+	return &Arc{blobs: blobStore{}, epoch: rand.Uint64()}
 }
 
-// Len returns the number of records.
+// Len returns the number of records, including the records of buckets.
 func (a *Arc) Len() int {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
@@ -74,12 +157,12 @@ func (a *Arc) Len() int {
 }
 
 // Add inserts a new key-value pair in the database. It returns ErrDuplicateKey
-// if the key already exists.
+// if the key already exists, which makes Add an atomic put-if-absent.
 func (a *Arc) Add(key []byte, value []byte) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	return a.insert(key, value, false)
+	return a.put(key, value, 0, false)
 }
 
 // Put inserts or updates a key-value pair in the database.
@@ -87,23 +170,55 @@ func (a *Arc) Put(key []byte, value []byte) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	return a.insert(key, value, true)
+	return a.put(key, value, 0, true)
+}
+
+// put inserts or updates a record that expires at the given time, and notifies
+// the observers of the change. A zero expiry time never expires. The caller
+// must hold the write lock.
+func (a *Arc) put(key []byte, value []byte, expiresAt int64, overwrite bool) error {
+	var oldHash Hash
+
+	if a.observed() {
+		if n, err := a.findRecord(key); err == nil {
+			oldHash = n.valueHash()
+		}
+	}
+
+	n, err := a.insert(key, value, overwrite)
+
+	if err != nil {
+		return err
+	}
+
+	n.expiresAt = expiresAt
+
+	var events []Event
+
+	if a.observed() {
+		events = append(events, Event{Type: EventPut, Key: key, OldHash: oldHash, NewHash: n.valueHash()})
+	}
+
+	a.publish(Change{Op: OpPut, Key: key, Value: value, ExpiresAt: expiryTime(expiresAt)}, events...)
+
+	return nil
 }
 
 // insert adds a key-value pair to the database. If the key already exists and
 // overwrite is true, the existing value is updated. If overwrite is false and
-// the key exists, ErrDuplicateKey is returned. It returns nil on success.
-func (a *Arc) insert(key []byte, value []byte, overwrite bool) error {
+// the key exists, ErrDuplicateKey is returned. It returns the record node that
+// holds the value on success.
+func (a *Arc) insert(key []byte, value []byte, overwrite bool) (*node, error) {
 	if key == nil {
-		return ErrNilKey
+		return nil, ErrNilKey
 	}
 
 	if len(key) > maxKeyBytes {
-		return ErrKeyTooLarge
+		return nil, ErrKeyTooLarge
 	}
 
 	if len(value) > maxValueBytes {
-		return ErrValueTooLarge
+		return nil, ErrValueTooLarge
 	}
 
 	// Empty tree, set the new record node as the root node.
@@ -112,21 +227,23 @@ func (a *Arc) insert(key []byte, value []byte, overwrite bool) error {
 		a.numNodes = 1
 		a.numRecords = 1
 
-		return nil
+		return a.root, nil
 	}
 
-	// Create a common root node for keys with no shared prefix.
-	if len(a.root.key) > 0 && longestCommonPrefix(a.root.key, key) == nil {
+	// Create a common root node for keys with no shared prefix. The empty
+	// key is a prefix of every key, and is handled by the traversal below.
+	if len(a.root.key) > 0 && len(key) > 0 && longestCommonPrefix(a.root.key, key) == nil {
 		oldRoot := a.root
+		record := newRecordNode(a.blobs, key, value)
 
 		a.root = &node{key: nil}
 		a.root.addChild(oldRoot)
-		a.root.addChild(newRecordNode(a.blobs, key, value))
+		a.root.addChild(record)
 
 		a.numNodes += 2
 		a.numRecords++
 
-		return nil
+		return record, nil
 	}
 
 	var parent *node
@@ -138,9 +255,10 @@ func (a *Arc) insert(key []byte, value []byte, overwrite bool) error {
 
 		// Found exact match. Put() will overwrite the existing value.
 		// Do not update counters because this is an in-place update.
+		// Expired records are treated as if they do not exist.
 		if prefixLen == len(current.key) && prefixLen == len(key) {
-			if !overwrite {
-				return ErrDuplicateKey
+			if !overwrite && current.isRecord && !current.expired() {
+				return nil, ErrDuplicateKey
 			}
 
 			if !current.isRecord {
@@ -149,7 +267,7 @@ func (a *Arc) insert(key []byte, value []byte, overwrite bool) error {
 
 			current.setValue(a.blobs, value)
 
-			return nil
+			return current, nil
 		}
 
 		// The longest common prefix matches the entire key, but is shorter
@@ -160,34 +278,36 @@ func (a *Arc) insert(key []byte, value []byte, overwrite bool) error {
 		// "le", and then becomes a child of the "app" node, forming the path:
 		// ["app"(new node) -> "le"(current)].
 		if prefixLen == len(key) && prefixLen < len(current.key) {
+			record := newRecordNode(a.blobs, key, value)
+
 			if current == a.root {
 				current.setKey(current.key[len(key):])
 
-				a.root = newRecordNode(a.blobs, key, value)
+				a.root = record
 				a.root.addChild(current)
 			} else {
 				if err := parent.removeChild(current); err != nil {
-					return err
+					return nil, err
 				}
 
 				current.setKey(current.key[len(key):])
 
-				n := newRecordNode(a.blobs, key, value)
-				n.addChild(current)
-
-				parent.addChild(n)
+				record.addChild(current)
+				parent.addChild(record)
 			}
 
 			a.numNodes++
 			a.numRecords++
 
-			return nil
+			return record, nil
 		}
 
 		// Partial match with key exhaustion: Insert via node splitting.
 		if prefixLen > 0 && prefixLen < len(current.key) {
-			a.splitNode(parent, current, newRecordNode(a.blobs, key, value), prefix)
-			return nil
+			record := newRecordNode(a.blobs, key, value)
+			a.splitNode(parent, current, record, prefix)
+
+			return record, nil
 		}
 
 		// Search for a child whose key is compatible with the remaining
@@ -204,17 +324,19 @@ func (a *Arc) insert(key []byte, value []byte, overwrite bool) error {
 		//
 		// TODO(toru): These conditions can likely be further simplified.
 		if nextNode == nil {
+			record := newRecordNode(a.blobs, key, value)
+
 			if current == a.root {
 				if a.root.key == nil || prefixLen == len(a.root.key) {
-					a.root.addChild(newRecordNode(a.blobs, key, value))
+					a.root.addChild(record)
 				}
 			} else {
-				current.addChild(newRecordNode(a.blobs, key, value))
+				current.addChild(record)
 			}
 
 			a.numNodes++
 			a.numRecords++
-			return nil
+			return record, nil
 		}
 
 		// Reaching this point means that a compatible child was found.
@@ -235,21 +357,74 @@ func (a *Arc) Get(key []byte) ([]byte, error) {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 
+	node, err := a.findRecord(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return node.value(a.blobs), nil
+}
+
+// findRecord returns the record node that matches the given key. It returns
+// ErrKeyNotFound if the key does not exist, refers to a non-record node, or
+// refers to an expired record.
+func (a *Arc) findRecord(key []byte) (*node, error) {
 	node, _, err := a.findNodeAndParent(key)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if !node.isRecord {
+	if !node.isRecord || node.expired() {
 		return nil, ErrKeyNotFound
 	}
 
-	return node.value(a.blobs), nil
+	return node, nil
 }
 
 // Delete removes a record that matches the given key.
 func (a *Arc) Delete(key []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.delete(key)
+}
+
+// DeletePrefix removes every record whose key begins with the given prefix.
+// The records are removed by detaching the subtree that holds them, rather
+// than by deleting each record. An empty prefix removes every record. It
+// returns the number of removed records.
+func (a *Arc) DeletePrefix(prefix []byte) (int, error) {
+	if prefix == nil {
+		return 0, ErrNilKey
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.deletePrefix(prefix), nil
+}
+
+// DeleteRange removes every record whose key is within the half-open interval
+// [start, end). A nil start or end leaves the corresponding side unbounded,
+// as in Range. Subtrees that fall entirely within the interval are detached
+// as a whole. It returns the number of removed records, or ErrInvalidRange if
+// start sorts after end.
+func (a *Arc) DeleteRange(start []byte, end []byte) (int, error) {
+	if start != nil && end != nil && bytes.Compare(start, end) > 0 {
+		return 0, ErrInvalidRange
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.deleteRange(start, end), nil
+}
+
+// delete removes a record that matches the given key. The caller must hold
+// the write lock.
+func (a *Arc) delete(key []byte) error {
 	if key == nil {
 		return ErrNilKey
 	}
@@ -262,9 +437,6 @@ func (a *Arc) Delete(key []byte) error {
 		return ErrKeyTooLarge
 	}
 
-	a.mu.Lock()
-	defer a.mu.Unlock()
-
 	delNode, parent, err := a.findNodeAndParent(key)
 
 	if err != nil {
@@ -275,6 +447,41 @@ func (a *Arc) Delete(key []byte) error {
 		return ErrKeyNotFound
 	}
 
+	// An expired record is reclaimed, but reported as non-existent since it
+	// was no longer visible to the caller.
+	expired := delNode.expired()
+
+	var oldHash Hash
+
+	if a.observed() {
+		oldHash = delNode.valueHash()
+	}
+
+	if err := a.removeRecord(delNode, parent); err != nil {
+		return err
+	}
+
+	var events []Event
+
+	if a.observed() {
+		events = append(events, Event{Type: EventDelete, Key: key, OldHash: oldHash})
+	}
+
+	a.publish(Change{Op: OpDelete, Key: key}, events...)
+
+	if expired {
+		return ErrKeyNotFound
+	}
+
+	return nil
+}
+
+// removeRecord removes the given record node from the tree, and releases its
+// value. The parent must be nil if, and only if, the node is the root node.
+func (a *Arc) removeRecord(delNode *node, parent *node) error {
+	// Every branch below discards the value, therefore release it up front.
+	delNode.deleteValue(a.blobs)
+
 	// Root node deletion is handled separately to improve code readability.
 	if delNode == a.root {
 		a.deleteRootNode()
@@ -340,13 +547,150 @@ func (a *Arc) Delete(key []byte) error {
 	// Reaching this point means we are deleting a non-root internal node
 	// that has more than one edges. Convert the node to a non-record type.
 	delNode.isRecord = false
-	delNode.deleteValue(a.blobs)
 
 	a.numRecords--
 
 	return nil
 }
 
+// deletePrefix removes every record whose key begins with the given prefix.
+// Such records always form a single subtree, therefore the subtree is simply
+// detached from its parent rather than deleting each record. It returns the
+// number of removed records.
+func (a *Arc) deletePrefix(prefix []byte) int {
+	target, parent, path := a.findPrefixNode(prefix)
+
+	if target == nil {
+		return 0
+	}
+
+	// Collect the deletion events before the subtree is released.
+	var events []Event
+
+	if a.observed() {
+		events = subtreeDeleteEvents(target, path[:len(path)-len(target.key)])
+	}
+
+	defer a.publish(Change{Op: OpDeletePrefix, Key: prefix}, events...)
+
+	numNodes, numRecords := target.releaseSubtree(a.blobs)
+
+	if target == a.root {
+		a.clear()
+		return numRecords
+	}
+
+	// The parent of the target is known to exist, and so is the target
+	// within its list of children. Therefore removeChild cannot fail.
+	parent.removeChild(target)
+
+	a.numNodes -= numNodes
+	a.numRecords -= numRecords
+
+	a.mergeRedundantNode(parent)
+
+	return numRecords
+}
+
+// deleteRange removes every record whose key is within [start, end). The range
+// is first broken down into the prefixes of the subtrees that fall entirely
+// within it, and the individual records that share a subtree with keys
+// outside of it. Both are then removed using deletePrefix and delete, which
+// keep the tree structure and its counters consistent. It returns the number
+// of removed records.
+func (a *Arc) deleteRange(start []byte, end []byte) int {
+	if a.empty() {
+		return 0
+	}
+
+	var prefixes [][]byte
+	var keys [][]byte
+
+	planRangeDeletion(a.root, nil, start, end, &prefixes, &keys)
+
+	ret := 0
+
+	for _, prefix := range prefixes {
+		ret += a.deletePrefix(prefix)
+	}
+
+	for _, key := range keys {
+		if err := a.delete(key); err == nil {
+			ret++
+		}
+	}
+
+	return ret
+}
+
+// planRangeDeletion collects the prefixes of the subtrees that fall entirely
+// within [start, end), and the keys of the records within [start, end) whose
+// subtrees also hold keys outside of it. It returns false once the remaining
+// keys are beyond the end of the range.
+func planRangeDeletion(n *node, path []byte, start []byte, end []byte, prefixes *[][]byte, keys *[][]byte) bool {
+	path = append(path, n.key...)
+
+	if end != nil && bytes.Compare(path, end) >= 0 {
+		return false
+	}
+
+	if bytes.Compare(path, start) < 0 && !bytes.HasPrefix(start, path) {
+		return true
+	}
+
+	// Every key in the subtree sorts at or after path, and thus after start.
+	// The subtree is within the range unless end extends path, in which case
+	// some keys of the subtree may sort after end.
+	if bytes.Compare(path, start) >= 0 && (end == nil || !bytes.HasPrefix(end, path)) {
+		*prefixes = append(*prefixes, bytes.Clone(path))
+		return true
+	}
+
+	// The path of the empty key is nil, but the record key must not be.
+	if n.isRecord && bytes.Compare(path, start) >= 0 {
+		*keys = append(*keys, append([]byte{}, path...))
+	}
+
+	for child := n.firstChild; child != nil; child = child.nextSibling {
+		if !planRangeDeletion(child, path, start, end, prefixes, keys) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// mergeRedundantNode restores the tree structure around a non-record node that
+// has lost children. A non-record node with a single child is redundant, and
+// is merged with the child. A non-record root without children is removed.
+func (a *Arc) mergeRedundantNode(n *node) {
+	if n.isRecord {
+		return
+	}
+
+	switch n.numChildren {
+	case 0:
+		if n == a.root {
+			a.clear()
+		}
+	case 1:
+		child := n.firstChild
+		child.prependKey(n.key)
+
+		if n == a.root {
+			a.root = child
+		} else {
+			// The grandparent is unknown, therefore replace the node with
+			// its child by copying the child into the node in place.
+			sibling := n.nextSibling
+			n.shallowCopyFrom(child)
+			n.nextSibling = sibling
+		}
+
+		a.numNodes--
+	}
+}
+
 // deleteRootNode removes the root node from the tree, while ensuring that
 // the tree structure remains valid and consistent.
 func (a *Arc) deleteRootNode() {
@@ -483,6 +827,43 @@ func (a *Arc) findNodeAndParent(key []byte) (current *node, parent *node, err er
 	}
 }
 
+// findPrefixNode returns the topmost node whose full key begins with the given
+// prefix, along with its parent and its full key. The subtree rooted at the
+// returned node holds exactly the records whose keys begin with the prefix.
+// The returned node is nil if no such record exists.
+func (a *Arc) findPrefixNode(prefix []byte) (current *node, parent *node, path []byte) {
+	if a.empty() {
+		return nil, nil, nil
+	}
+
+	current = a.root
+	remaining := prefix
+
+	for {
+		// The remaining prefix ends within the current node's key.
+		if len(remaining) <= len(current.key) {
+			if !bytes.HasPrefix(current.key, remaining) {
+				return nil, nil, nil
+			}
+
+			return current, parent, append(path, current.key...)
+		}
+
+		if !bytes.HasPrefix(remaining, current.key) {
+			return nil, nil, nil
+		}
+
+		path = append(path, current.key...)
+		remaining = remaining[len(current.key):]
+		parent = current
+		current = current.findCompatibleChild(remaining)
+
+		if current == nil {
+			return nil, nil, nil
+		}
+	}
+}
+
 // longestCommonPrefix compares the two given byte slices, and returns the
 // longest common prefix. Memory-safety is ensured by establishing an index
 // boundary based on the length of the shorter parameter.
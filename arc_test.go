@@ -1017,6 +1017,28 @@ func TestDelete(t *testing.T) {
 	})
 }
 
+func TestDeleteMergesBlobValue(t *testing.T) {
+	arc := New()
+	arc.Put([]byte("ab"), blobValueX())
+	arc.Put([]byte("ac"), []byte("inline"))
+
+	// Deleting "ac" merges the non-record "a" node with its "b" child, which
+	// must carry over the blob reference.
+	if err := arc.Delete([]byte("ac")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := arc.Get([]byte("ab"))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(got, blobValueX()) {
+		t.Errorf("unexpected value: got:%q, want:%q", got, blobValueX())
+	}
+}
+
 func TestDeleteWithIPStringTree(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -1141,6 +1163,164 @@ func TestDeleteWithIPStringTree(t *testing.T) {
 	}
 }
 
+func TestDeletePrefix(t *testing.T) {
+	testCases := []struct {
+		name   string
+		prefix []byte
+		want   int
+	}{
+		{name: "with prefix ending within a node", prefix: []byte("appl"), want: 3},
+		{name: "with prefix matching a record", prefix: []byte("band"), want: 3},
+		{name: "with prefix matching a non-record node", prefix: []byte("b"), want: 6},
+		{name: "with prefix matching a leaf", prefix: []byte("orange"), want: 1},
+		{name: "with prefix merging the parent", prefix: []byte("ap"), want: 4},
+		{name: "with prefix merging the child", prefix: []byte("lemon"), want: 2},
+		{name: "with non-existing prefix", prefix: []byte("bogus"), want: 0},
+		{name: "with empty prefix", prefix: []byte{}, want: len(basicTestTreeData()) * 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			arc := basicTestTree()
+
+			for _, row := range basicTestTreeData() {
+				arc.Put(append([]byte("x/"), row.key...), blobValueX())
+			}
+
+			got, err := arc.DeletePrefix(tc.prefix)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("unexpected deletion count: got:%d, want:%d", got, tc.want)
+			}
+
+			want := New()
+
+			for _, row := range basicTestTreeData() {
+				if !bytes.HasPrefix(row.key, tc.prefix) {
+					want.Put(row.key, row.data)
+				}
+
+				if key := append([]byte("x/"), row.key...); !bytes.HasPrefix(key, tc.prefix) {
+					want.Put(key, blobValueX())
+				}
+			}
+
+			assertEquivalentTree(t, arc, want)
+		})
+	}
+
+	t.Run("with nil prefix", func(t *testing.T) {
+		if _, err := New().DeletePrefix(nil); err != ErrNilKey {
+			t.Fatalf("unexpected error: got:%v, want:%v", err, ErrNilKey)
+		}
+	})
+}
+
+func TestDeleteRange(t *testing.T) {
+	testCases := []struct {
+		name  string
+		start []byte
+		end   []byte
+		want  int
+	}{
+		{name: "with bounded range", start: []byte("applet"), end: []byte("bandsaw"), want: 6},
+		{name: "with range covering subtrees", start: []byte("b"), end: []byte("l"), want: 8},
+		{name: "with range ending within a subtree", start: []byte("lemon"), end: []byte("limestone"), want: 3},
+		{name: "with nil start", start: nil, end: []byte("b"), want: 4},
+		{name: "with nil end", start: []byte("grapefruit"), end: nil, want: 6},
+		{name: "with unbounded range", start: nil, end: nil, want: len(basicTestTreeData())},
+		{name: "with empty range", start: []byte("c"), end: []byte("d"), want: 0},
+		{name: "with equal bounds", start: []byte("band"), end: []byte("band"), want: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			arc := basicTestTree()
+
+			got, err := arc.DeleteRange(tc.start, tc.end)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("unexpected deletion count: got:%d, want:%d", got, tc.want)
+			}
+
+			want := New()
+
+			for _, row := range basicTestTreeData() {
+				inRange := bytes.Compare(row.key, tc.start) >= 0 && (tc.end == nil || bytes.Compare(row.key, tc.end) < 0)
+
+				if !inRange {
+					want.Put(row.key, row.data)
+				}
+			}
+
+			assertEquivalentTree(t, arc, want)
+		})
+	}
+
+	t.Run("with inverted range", func(t *testing.T) {
+		if _, err := basicTestTree().DeleteRange([]byte("b"), []byte("a")); err != ErrInvalidRange {
+			t.Fatalf("unexpected error: got:%v, want:%v", err, ErrInvalidRange)
+		}
+	})
+}
+
+// assertEquivalentTree fails the test unless both trees have the same shape,
+// counters, records and blob references.
+func assertEquivalentTree(t *testing.T, got *Arc, want *Arc) {
+	t.Helper()
+
+	if got.numNodes != want.numNodes {
+		t.Fatalf("unexpected numNodes: got:%d, want:%d", got.numNodes, want.numNodes)
+	}
+
+	if got.numRecords != want.numRecords {
+		t.Fatalf("unexpected numRecords: got:%d, want:%d", got.numRecords, want.numRecords)
+	}
+
+	gotLevels := collectNodesByLevel(got.root)
+	wantLevels := collectNodesByLevel(want.root)
+
+	if len(gotLevels) != len(wantLevels) {
+		t.Fatalf("unexpected tree depth: got:%d, want:%d", len(gotLevels), len(wantLevels))
+	}
+
+	for level := range wantLevels {
+		if len(gotLevels[level]) != len(wantLevels[level]) {
+			t.Fatalf("invalid node count on level:%d, got:%d, want:%d", level, len(gotLevels[level]), len(wantLevels[level]))
+		}
+
+		for i, wantNode := range wantLevels[level] {
+			gotNode := gotLevels[level][i]
+
+			if !bytes.Equal(gotNode.key, wantNode.key) || gotNode.isRecord != wantNode.isRecord {
+				t.Fatalf("unexpected node on level:%d, got:%q, want:%q", level, gotNode.key, wantNode.key)
+			}
+
+			if !bytes.Equal(gotNode.value(got.blobs), wantNode.value(want.blobs)) {
+				t.Fatalf("unexpected value of %q: got:%q, want:%q", gotNode.key, gotNode.value(got.blobs), wantNode.value(want.blobs))
+			}
+		}
+	}
+
+	if len(got.blobs) != len(want.blobs) {
+		t.Fatalf("unexpected blob count: got:%d, want:%d", len(got.blobs), len(want.blobs))
+	}
+
+	for id, wantBlob := range want.blobs {
+		if gotBlob, found := got.blobs[id]; !found || gotBlob.refCount != wantBlob.refCount {
+			t.Fatalf("unexpected blob reference for %x", id)
+		}
+	}
+}
+
 func collectNodesByLevel(root *node) [][]*node {
 	if root == nil {
 		return nil
@@ -0,0 +1,292 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/chronohq/arc/format"
+	"github.com/chronohq/arc/internal/workload"
+)
+
+// benchRecords is the number of records that the benchmarks operate on.
+const benchRecords = 100000
+
+// benchData holds the keys and values of a benchmark workload.
+type benchData struct {
+	keys   [][]byte
+	values [][]byte
+}
+
+// newBenchData generates the benchmark workload of the given key distribution.
+func newBenchData(kind workload.Kind) benchData {
+	g := workload.New(kind, 1)
+
+	return benchData{keys: g.Keys(benchRecords), values: g.Values(benchRecords)}
+}
+
+// populate returns a database that contains the records of the workload.
+func (bd benchData) populate() *Arc {
+	arc := New()
+
+	for i, key := range bd.keys {
+		arc.Put(key, bd.values[i])
+	}
+
+	return arc
+}
+
+// forEachWorkload runs the benchmark for every key distribution.
+func forEachWorkload(b *testing.B, fn func(b *testing.B, bd benchData)) {
+	for _, kind := range workload.Kinds {
+		bd := newBenchData(kind)
+
+		b.Run(kind.String(), func(b *testing.B) {
+			fn(b, bd)
+		})
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	forEachWorkload(b, func(b *testing.B, bd benchData) {
+		arc := New()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if i%benchRecords == 0 && i > 0 {
+				b.StopTimer()
+				arc = New()
+				b.StartTimer()
+			}
+
+			arc.Put(bd.keys[i%benchRecords], bd.values[i%benchRecords])
+		}
+	})
+}
+
+func BenchmarkGet(b *testing.B) {
+	forEachWorkload(b, func(b *testing.B, bd benchData) {
+		arc := bd.populate()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			arc.Get(bd.keys[i%benchRecords])
+		}
+	})
+}
+
+func BenchmarkDelete(b *testing.B) {
+	forEachWorkload(b, func(b *testing.B, bd benchData) {
+		arc := bd.populate()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if i%benchRecords == 0 && i > 0 {
+				b.StopTimer()
+				arc = bd.populate()
+				b.StartTimer()
+			}
+
+			arc.Delete(bd.keys[i%benchRecords])
+		}
+	})
+}
+
+func BenchmarkScan(b *testing.B) {
+	forEachWorkload(b, func(b *testing.B, bd benchData) {
+		arc := bd.populate()
+		records := 0
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			for range arc.Scan(nil) {
+				records++
+			}
+		}
+
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(records), "ns/record")
+	})
+}
+
+// forEachNodeEncoding runs the benchmark for every node encoding of the
+// database file.
+func forEachNodeEncoding(b *testing.B, fn func(b *testing.B, compact bool)) {
+	for _, compact := range []bool{false, true} {
+		name := "fixed"
+
+		if compact {
+			name = "compact"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			fn(b, compact)
+		})
+	}
+}
+
+func BenchmarkSave(b *testing.B) {
+	forEachWorkload(b, func(b *testing.B, bd benchData) {
+		arc := bd.populate()
+
+		forEachNodeEncoding(b, func(b *testing.B, compact bool) {
+			arc.EnableCompactNodes(compact)
+
+			var written int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				n, err := arc.WriteTo(io.Discard)
+
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				written = n
+			}
+
+			b.ReportMetric(float64(written)/float64(arc.Len()), "bytes/record")
+		})
+	})
+}
+
+func BenchmarkLoad(b *testing.B) {
+	forEachWorkload(b, func(b *testing.B, bd benchData) {
+		arc := bd.populate()
+
+		forEachNodeEncoding(b, func(b *testing.B, compact bool) {
+			arc.EnableCompactNodes(compact)
+
+			var snapshot bytes.Buffer
+
+			if _, err := arc.WriteTo(&snapshot); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := New().ReadFrom(bytes.NewReader(snapshot.Bytes())); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+// BenchmarkFileSize reports the size of the database file, and of its index
+// nodes alone, which excludes the header and the blobs that both node
+// encodings share.
+func BenchmarkFileSize(b *testing.B) {
+	forEachWorkload(b, func(b *testing.B, bd benchData) {
+		arc := bd.populate()
+
+		var blobBytes int64
+
+		for _, blob := range arc.blobs {
+			blobBytes += int64(format.BlobSize(len(blob.value)))
+		}
+
+		forEachNodeEncoding(b, func(b *testing.B, compact bool) {
+			arc.EnableCompactNodes(compact)
+
+			var written int64
+
+			for i := 0; i < b.N; i++ {
+				n, err := arc.WriteTo(io.Discard)
+
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				written = n
+			}
+
+			indexBytes := written - arcHeaderBytesLen - blobBytes
+
+			b.ReportMetric(float64(written), "file-bytes")
+			b.ReportMetric(float64(indexBytes)/float64(arc.numNodes), "bytes/node")
+		})
+	})
+}
+
+func BenchmarkFlush(b *testing.B) {
+	forEachWorkload(b, func(b *testing.B, bd benchData) {
+		// MemFS copies the whole file on every sync, so the OS filesystem
+		// shows the cost of a flush more faithfully.
+		fsys := &countingFS{VFS: OSFS{}}
+		arc, err := OpenPagedFS(fsys, filepath.Join(b.TempDir(), "arc.db"))
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for i, key := range bd.keys {
+			arc.Put(key, bd.values[i])
+		}
+
+		if err := arc.Flush(); err != nil {
+			b.Fatal(err)
+		}
+
+		fileBytes := arc.pager.meta.NumPages * format.PageSize
+		fsys.written = 0
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		// Every flush persists a single update.
+		for i := 0; i < b.N; i++ {
+			key := bd.keys[i%len(bd.keys)]
+			arc.Put(key, bd.values[(i+1)%len(bd.values)])
+
+			if err := arc.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.ReportMetric(float64(fsys.written)/float64(b.N), "written-bytes/op")
+		b.ReportMetric(float64(fileBytes), "file-bytes")
+	})
+}
+
+func BenchmarkMemoryPerRecord(b *testing.B) {
+	forEachWorkload(b, func(b *testing.B, bd benchData) {
+		var perRecord float64
+
+		for i := 0; i < b.N; i++ {
+			var before, after runtime.MemStats
+
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+
+			arc := bd.populate()
+
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+
+			// The keys and values of the workload are shared with the
+			// database, so this measures the overhead of the index and
+			// the blob store.
+			perRecord = float64(after.HeapAlloc-before.HeapAlloc) / float64(arc.Len())
+			runtime.KeepAlive(arc)
+		}
+
+		b.ReportMetric(perRecord, "B/record")
+	})
+}
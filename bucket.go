@@ -0,0 +1,237 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"iter"
+)
+
+const (
+	// bucketKeyMarker is the first byte of every key that belongs to a bucket.
+	// The database does not enforce the reservation, since files written
+	// before buckets existed may hold keys that begin with this byte.
+	bucketKeyMarker = byte(0xff)
+
+	// bucketPrefixOverhead is the number of bytes that the bucket namespace
+	// adds to the bucket name: the marker and the uint16 name length.
+	bucketPrefixOverhead = sizeOfUint8 + sizeOfUint16
+
+	// maxBucketNameBytes is the maximum length of a bucket name.
+	maxBucketNameBytes = maxKeyBytes - bucketPrefixOverhead
+)
+
+// Bucket is a named collection of records within an Arc database. The keys of
+// a bucket are transparently stored under a namespace prefix that is derived
+// from the bucket name. Since the Radix tree groups keys by prefix, all of the
+// records of a bucket reside within a single subtree, which allows the bucket
+// to be counted, scanned and dropped without visiting the rest of the tree.
+//
+// Buckets share the key space of the database. The namespace of a bucket named
+// n is the byte 0xff followed by the big-endian uint16 length of n and n
+// itself. The database-level API does not tell bucket records apart from other
+// records, so Len, Scan and Range include them, and a key that begins with
+// 0xff may be written into a bucket by Put. Applications that use buckets
+// should therefore not write keys that begin with 0xff directly.
+type Bucket struct {
+	db     *Arc
+	name   []byte
+	prefix []byte
+}
+
+// Bucket returns a handle to the bucket with the given name. Buckets do not
+// need to be created: a bucket exists for as long as it holds records. Every
+// operation on a bucket whose name exceeds the key size limit returns
+// ErrKeyTooLarge.
+func (a *Arc) Bucket(name []byte) *Bucket {
+	name = bytes.Clone(name)
+
+	return &Bucket{db: a, name: name, prefix: makeBucketPrefix(name)}
+}
+
+// Buckets returns an iterator over the names of the buckets that hold records,
+// which are ordered by length, and then by content. Each bucket is located by
+// a single seek, without visiting its records. Keys that begin with 0xff but
+// are not in the namespace of a bucket are skipped. The consistency guarantees
+// are the same as those of Scan.
+func (a *Arc) Buckets() iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		start := []byte{bucketKeyMarker}
+
+		for {
+			keys, _ := a.collect(start, nil, 1)
+
+			if len(keys) == 0 {
+				return
+			}
+
+			key := keys[0]
+			name, ok := parseBucketKey(key)
+
+			if !ok {
+				start = append(key, 0x00)
+				continue
+			}
+
+			if !yield(name) {
+				return
+			}
+
+			// The successor of a bucket prefix exists, since the length
+			// field of the prefix is never 0xffff.
+			start = prefixSuccessor(key[:bucketPrefixOverhead+len(name)])
+		}
+	}
+}
+
+// Name returns the name of the bucket.
+func (b *Bucket) Name() []byte {
+	return bytes.Clone(b.name)
+}
+
+// Add inserts a new key-value pair in the bucket. It returns ErrDuplicateKey
+// if the key already exists.
+func (b *Bucket) Add(key []byte, value []byte) error {
+	k, err := b.key(key)
+
+	if err != nil {
+		return err
+	}
+
+	return b.db.Add(k, value)
+}
+
+// Put inserts or updates a key-value pair in the bucket.
+func (b *Bucket) Put(key []byte, value []byte) error {
+	k, err := b.key(key)
+
+	if err != nil {
+		return err
+	}
+
+	return b.db.Put(k, value)
+}
+
+// Get retrieves the value that matches the given key. Returns ErrKeyNotFound
+// if the key does not exist in the bucket.
+func (b *Bucket) Get(key []byte) ([]byte, error) {
+	k, err := b.key(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b.db.Get(k)
+}
+
+// Delete removes a record that matches the given key from the bucket.
+func (b *Bucket) Delete(key []byte) error {
+	k, err := b.key(key)
+
+	if err != nil {
+		return err
+	}
+
+	return b.db.Delete(k)
+}
+
+// Len returns the number of records in the bucket. Only the subtree that holds
+// the bucket is visited.
+func (b *Bucket) Len() int {
+	if b.prefix == nil {
+		return 0
+	}
+
+	b.db.mu.RLock()
+	defer b.db.mu.RUnlock()
+
+	n, _, _ := b.db.findPrefixNode(b.prefix)
+
+	if n == nil {
+		return 0
+	}
+
+	_, numRecords := n.countSubtree()
+
+	return numRecords
+}
+
+// Scan returns an iterator over the records of the bucket whose keys begin with
+// the given prefix, in ascending key order. The yielded keys do not include
+// the bucket namespace. See (*Arc).Scan for the consistency guarantees.
+func (b *Bucket) Scan(prefix []byte) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		if b.prefix == nil {
+			return
+		}
+
+		for k, v := range b.db.Scan(append(bytes.Clone(b.prefix), prefix...)) {
+			if !yield(k[len(b.prefix):], v) {
+				return
+			}
+		}
+	}
+}
+
+// Drop removes every record of the bucket. The subtree that holds the bucket
+// is detached from the tree as a whole. It returns the number of removed
+// records.
+func (b *Bucket) Drop() int {
+	if b.prefix == nil {
+		return 0
+	}
+
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	return b.db.deletePrefix(b.prefix)
+}
+
+// key returns the namespaced database key of the given bucket key.
+func (b *Bucket) key(key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, ErrNilKey
+	}
+
+	if b.prefix == nil || len(b.prefix)+len(key) > maxKeyBytes {
+		return nil, ErrKeyTooLarge
+	}
+
+	ret := make([]byte, 0, len(b.prefix)+len(key))
+	ret = append(ret, b.prefix...)
+
+	return append(ret, key...), nil
+}
+
+// parseBucketKey returns the name of the bucket that the database key belongs
+// to, and false if the key is not in the namespace of a bucket.
+func parseBucketKey(key []byte) ([]byte, bool) {
+	if len(key) < bucketPrefixOverhead || key[0] != bucketKeyMarker {
+		return nil, false
+	}
+
+	size := int(binary.BigEndian.Uint16(key[sizeOfUint8:]))
+
+	if size > len(key)-bucketPrefixOverhead {
+		return nil, false
+	}
+
+	return key[bucketPrefixOverhead : bucketPrefixOverhead+size], true
+}
+
+// makeBucketPrefix returns the namespace prefix of the named bucket. The name
+// is length-prefixed so that no bucket prefix is a prefix of another bucket's
+// prefix. It returns nil if the name is too large.
+func makeBucketPrefix(name []byte) []byte {
+	if len(name) > maxBucketNameBytes {
+		return nil
+	}
+
+	ret := make([]byte, 0, bucketPrefixOverhead+len(name))
+	ret = append(ret, bucketKeyMarker)
+	ret = binary.BigEndian.AppendUint16(ret, uint16(len(name)))
+
+	return append(ret, name...)
+}
@@ -0,0 +1,204 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestBucketIsolation(t *testing.T) {
+	arc := basicTestTree()
+
+	// Bucket "a" must not observe the records of bucket "ab", even though
+	// one name is a prefix of the other.
+	a := arc.Bucket([]byte("a"))
+	ab := arc.Bucket([]byte("ab"))
+
+	if err := a.Put([]byte("bcd"), []byte("1")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := ab.Put([]byte("cd"), []byte("2")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := ab.Add([]byte("cd"), []byte("3")); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrDuplicateKey)
+	}
+
+	if _, err := a.Get([]byte("cd")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrKeyNotFound)
+	}
+
+	if got, _ := ab.Get([]byte("cd")); !bytes.Equal(got, []byte("2")) {
+		t.Errorf("unexpected value: got:%q, want:%q", got, "2")
+	}
+
+	if a.Len() != 1 || ab.Len() != 1 {
+		t.Errorf("unexpected lengths: got:%d and %d, want:1 and 1", a.Len(), ab.Len())
+	}
+
+	// Bucket records are not visible as top-level keys.
+	if _, err := arc.Get([]byte("abcd")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrKeyNotFound)
+	}
+
+	if _, err := a.Get(nil); !errors.Is(err, ErrNilKey) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrNilKey)
+	}
+}
+
+func TestBucketScan(t *testing.T) {
+	arc := New()
+	fruits := arc.Bucket([]byte("fruits"))
+
+	for _, row := range basicTestTreeData() {
+		if err := fruits.Put(row.key, row.data); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	arc.Bucket([]byte("fruit")).Put([]byte("apple"), []byte("other"))
+	arc.Put([]byte("apple"), []byte("top-level"))
+
+	var got []string
+
+	for k, v := range fruits.Scan([]byte("ap")) {
+		want, _ := fruits.Get(k)
+
+		if !bytes.Equal(v, want) {
+			t.Errorf("unexpected value: got:%q, want:%q", v, want)
+		}
+
+		got = append(got, string(k))
+	}
+
+	want := []string{"apple", "applet", "application", "apricot"}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected keys: got:%q, want:%q", got, want)
+	}
+
+	if fruits.Len() != len(basicTestTreeData()) {
+		t.Errorf("unexpected length: got:%d, want:%d", fruits.Len(), len(basicTestTreeData()))
+	}
+}
+
+func TestBucketDrop(t *testing.T) {
+	arc := basicTestTree()
+	users := arc.Bucket([]byte("users"))
+	other := arc.Bucket([]byte("users2"))
+
+	for _, row := range basicTestTreeData() {
+		users.Put(row.key, blobValueX())
+		other.Put(row.key, row.data)
+	}
+
+	if blob := arc.blobs[makeBlobID(blobValueX())]; blob.refCount != len(basicTestTreeData()) {
+		t.Fatalf("unexpected refCount: got:%d, want:%d", blob.refCount, len(basicTestTreeData()))
+	}
+
+	if got := users.Drop(); got != len(basicTestTreeData()) {
+		t.Errorf("unexpected drop count: got:%d, want:%d", got, len(basicTestTreeData()))
+	}
+
+	if users.Len() != 0 {
+		t.Errorf("unexpected length: got:%d, want:0", users.Len())
+	}
+
+	if _, found := arc.blobs[makeBlobID(blobValueX())]; found {
+		t.Error("expected the blob to be released")
+	}
+
+	if other.Len() != len(basicTestTreeData()) {
+		t.Errorf("unexpected length: got:%d, want:%d", other.Len(), len(basicTestTreeData()))
+	}
+
+	// The remaining tree must be identical to a tree that never contained
+	// the dropped bucket.
+	want := basicTestTree()
+
+	for _, row := range basicTestTreeData() {
+		want.Bucket([]byte("users2")).Put(row.key, row.data)
+	}
+
+	if arc.numNodes != want.numNodes || arc.numRecords != want.numRecords {
+		t.Errorf("unexpected counters: got:%d/%d, want:%d/%d", arc.numNodes, arc.numRecords, want.numNodes, want.numRecords)
+	}
+
+	if got := users.Drop(); got != 0 {
+		t.Errorf("unexpected drop count: got:%d, want:0", got)
+	}
+
+	if got := other.Drop(); got != len(basicTestTreeData()) {
+		t.Errorf("unexpected drop count: got:%d, want:%d", got, len(basicTestTreeData()))
+	}
+
+	if arc.numNodes != basicTreeNumNodes() || arc.Len() != len(basicTestTreeData()) {
+		t.Errorf("unexpected counters: got:%d/%d, want:%d/%d", arc.numNodes, arc.Len(), basicTreeNumNodes(), len(basicTestTreeData()))
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	arc := basicTestTree()
+
+	for _, name := range []string{"users", "a", "ab", "", "b", "\xff\xff"} {
+		for _, key := range []string{"1", "2", "3"} {
+			arc.Bucket([]byte(name)).Put([]byte(key), []byte("v"))
+		}
+	}
+
+	// Keys that begin with the marker but are not in the namespace of a
+	// bucket are skipped.
+	arc.Put([]byte{bucketKeyMarker}, []byte("short"))
+	arc.Put([]byte{bucketKeyMarker, 0x00, 0x09, 'x'}, []byte("truncated"))
+	arc.Put([]byte{bucketKeyMarker, 0xff, 0xff}, []byte("truncated"))
+
+	var got []string
+
+	for name := range arc.Buckets() {
+		got = append(got, string(name))
+	}
+
+	want := []string{"", "a", "b", "ab", "\xff\xff", "users"}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected buckets: got:%q, want:%q", got, want)
+	}
+
+	// Bucket records count towards the records of the database.
+	if got, want := arc.Len(), len(basicTestTreeData())+len(want)*3+3; got != want {
+		t.Errorf("unexpected length: got:%d, want:%d", got, want)
+	}
+
+	arc.Bucket([]byte("a")).Drop()
+	got = got[:0]
+
+	for name := range arc.Buckets() {
+		got = append(got, string(name))
+	}
+
+	if want = slices.Delete(want, 1, 2); !slices.Equal(got, want) {
+		t.Errorf("unexpected buckets after Drop: got:%q, want:%q", got, want)
+	}
+
+	if slices.Collect(New().Buckets()) != nil {
+		t.Error("expected no buckets")
+	}
+}
+
+func TestBucketNameTooLarge(t *testing.T) {
+	b := New().Bucket(make([]byte, maxBucketNameBytes+1))
+
+	if err := b.Put([]byte("k"), nil); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrKeyTooLarge)
+	}
+
+	if b.Len() != 0 || b.Drop() != 0 {
+		t.Error("expected an empty bucket")
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"iter"
+	"time"
+)
+
+// ChangeOp identifies the kind of mutation that a Change describes.
+type ChangeOp uint8
+
+const (
+	// OpPut inserts or updates the record of Key.
+	OpPut ChangeOp = iota + 1
+
+	// OpDelete removes the record of Key.
+	OpDelete
+
+	// OpDeletePrefix removes every record whose key begins with Key.
+	OpDeletePrefix
+)
+
+// String returns the name of the operation.
+func (op ChangeOp) String() string {
+	switch op {
+	case OpPut:
+		return "put"
+	case OpDelete:
+		return "delete"
+	case OpDeletePrefix:
+		return "delete-prefix"
+	}
+
+	return "unknown"
+}
+
+// Change is a single mutation of the database. Applying the changes of a
+// database in sequence order to a copy of the database reproduces its state.
+type Change struct {
+	Seq       uint64    // Sequence number of the mutation.
+	Op        ChangeOp  // Kind of mutation.
+	Key       []byte    // Key of the record, or the prefix of OpDeletePrefix.
+	Value     []byte    // Value of the record. Only set for OpPut.
+	ExpiresAt time.Time // Expiry time of the record, or zero if it never expires.
+}
+
+// changeLog is a fixed-capacity ring buffer of the most recent changes.
+type changeLog struct {
+	entries []Change // Ring buffer storage.
+	head    int      // Index of the oldest change.
+	size    int      // Number of retained changes.
+}
+
+// newChangeLog returns an empty change log that retains up to capacity changes.
+func newChangeLog(capacity int) *changeLog {
+	return &changeLog{entries: make([]Change, capacity)}
+}
+
+// append adds a copy of the change to the log. The oldest change is discarded
+// if the log is full.
+func (cl *changeLog) append(c Change) {
+	c.Key = bytes.Clone(c.Key)
+	c.Value = bytes.Clone(c.Value)
+
+	if cl.size < len(cl.entries) {
+		cl.entries[(cl.head+cl.size)%len(cl.entries)] = c
+		cl.size++
+
+		return
+	}
+
+	cl.entries[cl.head] = c
+	cl.head = (cl.head + 1) % len(cl.entries)
+}
+
+// since returns the retained changes whose sequence numbers are greater than
+// seq, in sequence order.
+func (cl *changeLog) since(seq uint64) []Change {
+	var ret []Change
+
+	for i := 0; i < cl.size; i++ {
+		c := cl.entries[(cl.head+i)%len(cl.entries)]
+
+		if c.Seq > seq {
+			ret = append(ret, c)
+		}
+	}
+
+	return ret
+}
+
+// oldest returns the sequence number of the oldest retained change, or zero if
+// the log is empty.
+func (cl *changeLog) oldest() uint64 {
+	if cl.size == 0 {
+		return 0
+	}
+
+	return cl.entries[cl.head].Seq
+}
+
+// Seq returns the sequence number of the most recent mutation. Every mutation
+// increments the sequence number, starting from one.
+func (a *Arc) Seq() uint64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.seq
+}
+
+// EnableChangeLog starts retaining the most recent changes in memory, so that
+// they can be retrieved with ChangesSince. The log retains up to capacity
+// changes, and discards the oldest changes beyond that. Calling the function
+// again discards the retained changes. A non-positive capacity disables the
+// change log.
+func (a *Arc) EnableChangeLog(capacity int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if capacity <= 0 {
+		a.changeLog = nil
+		return
+	}
+
+	a.changeLog = newChangeLog(capacity)
+}
+
+// ChangesSince returns an iterator over the changes whose sequence numbers are
+// greater than seq, in sequence order. The iterator visits the changes that
+// were made before ChangesSince was called. It returns ErrChangeLogTruncated
+// if some of the requested changes are no longer retained, or if seq is ahead
+// of the database, which happens when the caller followed a different history
+// of it. Either way, the caller must resynchronize from the current state of
+// the database.
+func (a *Arc) ChangesSince(seq uint64) (iter.Seq[Change], error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	changes, err := a.changesSince(seq)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(yield func(Change) bool) {
+		for _, c := range changes {
+			if !yield(c) {
+				return
+			}
+		}
+	}, nil
+}
+
+// changesSince is the lock-free version of ChangesSince, which returns the
+// changes as a slice.
+func (a *Arc) changesSince(seq uint64) ([]Change, error) {
+	if seq > a.seq {
+		return nil, ErrChangeLogTruncated
+	}
+
+	if seq == a.seq {
+		return nil, nil
+	}
+
+	if a.changeLog == nil || a.changeLog.size == 0 || a.changeLog.oldest() > seq+1 {
+		return nil, ErrChangeLogTruncated
+	}
+
+	return a.changeLog.since(seq), nil
+}
+
+// publish assigns the next sequence number to the change, retains it in the
+// change log, and delivers the accompanying events to the watchers. Every
+// mutation must be published exactly once. The caller must hold the write
+// lock.
+func (a *Arc) publish(c Change, events ...Event) {
+	a.seq++
+	c.Seq = a.seq
+
+	a.invalidateHashes(c.Key)
+
+	if a.changeLog != nil {
+		a.changeLog.append(c)
+	}
+
+	for _, ev := range events {
+		ev.Seq = c.Seq
+		a.notify(ev)
+	}
+
+	a.signalPublished()
+}
+
+// signalPublished closes the channel that published returned, if any. The
+// caller must hold the write lock.
+func (a *Arc) signalPublished() {
+	if ch := a.publishSignal.Swap(nil); ch != nil {
+		close(*ch)
+	}
+}
+
+// published returns a channel that is closed by the next mutation, or when
+// the contents are replaced. Unlike a
+// watcher, it carries no information about the mutation, which spares the
+// writers the work of describing it. Mutations that happen before the call
+// are not signaled, so callers must obtain the channel before they read the
+// state that it signals changes of.
+func (a *Arc) published() <-chan struct{} {
+	for {
+		if ch := a.publishSignal.Load(); ch != nil {
+			return *ch
+		}
+
+		ch := make(chan struct{})
+
+		if a.publishSignal.CompareAndSwap(nil, &ch) {
+			return ch
+		}
+	}
+}
+
+// expiryTime converts an expiry time in Unix nanoseconds to a time.Time. The
+// zero expiry time is converted to the zero time.Time.
+func expiryTime(expiresAt int64) time.Time {
+	if expiresAt == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, expiresAt)
+}
@@ -0,0 +1,286 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSeq(t *testing.T) {
+	arc := New()
+
+	if got := arc.Seq(); got != 0 {
+		t.Fatalf("unexpected seq: got:%d, want:0", got)
+	}
+
+	arc.Put([]byte("apple"), []byte("red"))
+	arc.Put([]byte("apple"), []byte("green"))
+	arc.Put([]byte("apricot"), []byte("orange"))
+	arc.Delete([]byte("apple"))
+
+	// Failed mutations do not consume sequence numbers.
+	arc.Delete([]byte("banana"))
+	arc.Add([]byte("apricot"), []byte("yellow"))
+
+	if got := arc.Seq(); got != 4 {
+		t.Errorf("unexpected seq: got:%d, want:4", got)
+	}
+
+	arc.DeletePrefix([]byte("ap"))
+
+	if got := arc.Seq(); got != 5 {
+		t.Errorf("unexpected seq: got:%d, want:5", got)
+	}
+}
+
+func TestChangesSince(t *testing.T) {
+	setTestClock(t)
+
+	arc := New()
+	arc.EnableChangeLog(16)
+
+	value := []byte("red")
+	arc.Put([]byte("apple"), value)
+	arc.PutWithTTL([]byte("session"), []byte("token"), time.Minute)
+	arc.Delete([]byte("apple"))
+	arc.DeletePrefix([]byte("sess"))
+
+	// The change log must not alias the caller's buffers.
+	value[0] = 'b'
+
+	changes, err := arc.ChangesSince(0)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := slices.Collect(changes)
+	want := []Change{
+		{Seq: 1, Op: OpPut, Key: []byte("apple"), Value: []byte("red")},
+		{Seq: 2, Op: OpPut, Key: []byte("session"), Value: []byte("token"), ExpiresAt: timeNow().Add(time.Minute)},
+		{Seq: 3, Op: OpDelete, Key: []byte("apple")},
+		{Seq: 4, Op: OpDeletePrefix, Key: []byte("sess")},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("unexpected changes: got:%d, want:%d", len(got), len(want))
+	}
+
+	for i := range want {
+		if got[i].Seq != want[i].Seq || got[i].Op != want[i].Op ||
+			!bytes.Equal(got[i].Key, want[i].Key) || !bytes.Equal(got[i].Value, want[i].Value) ||
+			!got[i].ExpiresAt.Equal(want[i].ExpiresAt) {
+			t.Errorf("unexpected change %d: got:%+v, want:%+v", i, got[i], want[i])
+		}
+	}
+
+	changes, _ = arc.ChangesSince(2)
+
+	if got := slices.Collect(changes); len(got) != 2 || got[0].Seq != 3 {
+		t.Errorf("unexpected changes since 2: %+v", got)
+	}
+
+	changes, err = arc.ChangesSince(arc.Seq())
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := slices.Collect(changes); len(got) != 0 {
+		t.Errorf("unexpected changes: %+v", got)
+	}
+}
+
+func TestChangesSinceTruncated(t *testing.T) {
+	arc := New()
+
+	arc.Put([]byte("apple"), []byte("red"))
+
+	if _, err := arc.ChangesSince(0); !errors.Is(err, ErrChangeLogTruncated) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrChangeLogTruncated)
+	}
+
+	arc.EnableChangeLog(2)
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		arc.Put([]byte(key), nil)
+	}
+
+	testCases := []struct {
+		seq     uint64
+		wantErr error
+		wantLen int
+	}{
+		{0, ErrChangeLogTruncated, 0},
+		{2, ErrChangeLogTruncated, 0},
+		{3, nil, 2},
+		{4, nil, 1},
+		{5, nil, 0},
+		{6, ErrChangeLogTruncated, 0},
+	}
+
+	for _, tc := range testCases {
+		changes, err := arc.ChangesSince(tc.seq)
+
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("ChangesSince(%d): unexpected error: got:%v, want:%v", tc.seq, err, tc.wantErr)
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if got := len(slices.Collect(changes)); got != tc.wantLen {
+			t.Errorf("ChangesSince(%d): unexpected length: got:%d, want:%d", tc.seq, got, tc.wantLen)
+		}
+	}
+}
+
+func TestSeqSurvivesRestart(t *testing.T) {
+	testCases := []struct {
+		name   string
+		save   func(a *Arc, fsys VFS) error
+		reopen func(fsys VFS) (*Arc, error)
+	}{
+		{
+			"Save",
+			func(a *Arc, fsys VFS) error { return a.SaveFS(fsys, "arc.db") },
+			func(fsys VFS) (*Arc, error) { return OpenFS(fsys, "arc.db") },
+		},
+		{
+			"Flush",
+			func(a *Arc, fsys VFS) error {
+				paged, err := OpenPagedFS(fsys, "arc.db")
+
+				if err != nil {
+					return err
+				}
+
+				var buf bytes.Buffer
+
+				if _, err := a.WriteTo(&buf); err != nil {
+					return err
+				}
+
+				if _, err := paged.ReadFrom(&buf); err != nil {
+					return err
+				}
+
+				return paged.Close()
+			},
+			func(fsys VFS) (*Arc, error) { return OpenPagedFS(fsys, "arc.db") },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMemFS()
+			arc := New()
+
+			for _, key := range []string{"a", "b", "c"} {
+				arc.Put([]byte(key), nil)
+			}
+
+			if err := tc.save(arc, m); err != nil {
+				t.Fatal(err)
+			}
+
+			// A consumer keeps following the database past the saved state,
+			// which is lost in a restart.
+			arc.Put([]byte("d"), nil)
+			consumed := arc.Seq()
+
+			got, err := tc.reopen(m)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Seq() != 3 {
+				t.Fatalf("unexpected seq: got:%d, want:3", got.Seq())
+			}
+
+			// The consumer is ahead of the reopened database, and must
+			// resynchronize.
+			if _, err := got.ChangesSince(consumed); !errors.Is(err, ErrChangeLogTruncated) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrChangeLogTruncated)
+			}
+
+			got.EnableChangeLog(8)
+			got.Put([]byte("e"), nil)
+
+			changes, err := got.ChangesSince(3)
+
+			if err != nil {
+				t.Fatalf("ChangesSince(): %v", err)
+			}
+
+			if got := slices.Collect(changes); len(got) != 1 || got[0].Seq != 4 {
+				t.Errorf("unexpected changes: %+v", got)
+			}
+		})
+	}
+}
+
+func TestPublished(t *testing.T) {
+	arc := New()
+	published := arc.published()
+
+	if arc.published() != published {
+		t.Fatal("expected the pending channel to be shared")
+	}
+
+	assertSignaled := func(t *testing.T, ch <-chan struct{}, want bool) {
+		t.Helper()
+
+		select {
+		case <-ch:
+			if !want {
+				t.Error("unexpected signal")
+			}
+		default:
+			if want {
+				t.Error("missing signal")
+			}
+		}
+	}
+
+	assertSignaled(t, published, false)
+	arc.Get([]byte("apple"))
+	assertSignaled(t, published, false)
+
+	arc.Put([]byte("apple"), []byte("red"))
+	assertSignaled(t, published, true)
+
+	// Mutations without a pending channel do not allocate one.
+	arc.Put([]byte("banana"), []byte("yellow"))
+
+	if arc.publishSignal.Load() != nil {
+		t.Error("unexpected pending channel")
+	}
+
+	published = arc.published()
+
+	var buf bytes.Buffer
+
+	basicTestTree().WriteTo(&buf)
+	arc.ReadFrom(&buf)
+	assertSignaled(t, published, true)
+
+	// The source does not register a watcher.
+	follower := startReplication(t, arc, New())
+	waitForSeq(t, follower, arc.Seq())
+
+	arc.mu.RLock()
+	defer arc.mu.RUnlock()
+
+	if len(arc.watchers) != 0 {
+		t.Errorf("unexpected watchers: %d", len(arc.watchers))
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import "math/bits"
+
+// Nodes with few children rely on their sorted sibling list alone, which plays
+// the role of the Node4 and Node16 layouts of adaptive radix trees. Wider
+// nodes additionally maintain a childIndex over the first bytes of the child
+// keys, so that lookups no longer scan the sibling list. The sibling list is
+// kept in every layout, since ordered traversals and serialization rely on it.
+const (
+	// node48MaxChildren is the capacity of the Node48 layout.
+	node48MaxChildren = 48
+
+	// node256MinChildren is the fan-out at which Node48 grows into Node256.
+	node256MinChildren = node48MaxChildren + 1
+
+	// node256ShrinkChildren is the fan-out below which Node256 shrinks into
+	// Node48. The gap to node256MinChildren prevents thrashing.
+	node256ShrinkChildren = 40
+
+	// indexShrinkChildren is the fan-out below which the index is dropped.
+	indexShrinkChildren = 12
+)
+
+// minIndexedChildren is the fan-out at which a node starts maintaining a
+// childIndex. It is a variable so that benchmarks can compare the layouts.
+var minIndexedChildren = 17
+
+// childIndex maps the first bytes of child keys to the children of a wide
+// node. The Node48 layout maps each byte to a position in a compact array of
+// 48 children, whereas the Node256 layout maps each byte to a child directly.
+type childIndex struct {
+	slots    *[256]uint8 // Node48 only. One-based positions in children.
+	children []*node     // 48 children for Node48, or 256 for Node256.
+	present  [4]uint64   // Bitmap of the indexed first bytes.
+}
+
+// newChildIndex returns an index over the node's children in the layout that
+// fits its fan-out.
+func newChildIndex(n *node) *childIndex {
+	ret := &childIndex{}
+
+	if n.numChildren > node48MaxChildren {
+		ret.children = make([]*node, 256)
+	} else {
+		ret.slots = &[256]uint8{}
+		ret.children = make([]*node, node48MaxChildren)
+	}
+
+	for child := n.firstChild; child != nil; child = child.nextSibling {
+		ret.set(child)
+	}
+
+	return ret
+}
+
+// isNode256 returns true if the index uses the Node256 layout.
+func (ci *childIndex) isNode256() bool {
+	return ci.slots == nil
+}
+
+// get returns the child whose key begins with the given byte, or nil.
+func (ci *childIndex) get(b byte) *node {
+	if ci.isNode256() {
+		return ci.children[b]
+	}
+
+	if slot := ci.slots[b]; slot != 0 {
+		return ci.children[slot-1]
+	}
+
+	return nil
+}
+
+// set adds the child to the index. Children with empty keys are not indexed.
+func (ci *childIndex) set(child *node) {
+	if len(child.key) == 0 {
+		return
+	}
+
+	b := child.key[0]
+	ci.present[b/64] |= 1 << (b % 64)
+
+	if ci.isNode256() {
+		ci.children[b] = child
+		return
+	}
+
+	if slot := ci.slots[b]; slot != 0 {
+		ci.children[slot-1] = child
+		return
+	}
+
+	for i, c := range ci.children {
+		if c == nil {
+			ci.children[i] = child
+			ci.slots[b] = uint8(i + 1)
+
+			return
+		}
+	}
+}
+
+// unset removes the child whose key begins with the given byte.
+func (ci *childIndex) unset(b byte) {
+	ci.present[b/64] &^= 1 << (b % 64)
+
+	if ci.isNode256() {
+		ci.children[b] = nil
+		return
+	}
+
+	if slot := ci.slots[b]; slot != 0 {
+		ci.children[slot-1] = nil
+		ci.slots[b] = 0
+	}
+}
+
+// predecessor returns the child with the greatest first byte that is smaller
+// than the given byte, or nil. The bitmap of the indexed bytes bounds the
+// search to four words regardless of the layout.
+func (ci *childIndex) predecessor(b byte) *node {
+	mask := uint64(1)<<(b%64) - 1
+
+	for word := int(b / 64); word >= 0; word-- {
+		if set := ci.present[word] & mask; set != 0 {
+			return ci.get(byte(word*64 + 63 - bits.LeadingZeros64(set)))
+		}
+
+		mask = ^uint64(0)
+	}
+
+	return nil
+}
+
+// resizeIndex switches the node to the child layout that fits its fan-out.
+func (n *node) resizeIndex() {
+	switch {
+	case n.index == nil:
+		if n.numChildren >= minIndexedChildren {
+			n.index = newChildIndex(n)
+		}
+
+	case n.numChildren < indexShrinkChildren:
+		n.index = nil
+
+	case n.index.isNode256() && n.numChildren < node256ShrinkChildren:
+		n.index = newChildIndex(n)
+
+	case !n.index.isNode256() && n.numChildren >= node256MinChildren:
+		n.index = newChildIndex(n)
+	}
+}
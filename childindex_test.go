@@ -0,0 +1,265 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestChildIndexLayouts(t *testing.T) {
+	subject := &node{}
+	rng := rand.New(rand.NewSource(1))
+	order := rng.Perm(256)
+
+	testCases := []struct {
+		numChildren int
+		wantIndex   bool
+		wantNode256 bool
+	}{
+		{minIndexedChildren - 1, false, false},
+		{minIndexedChildren, true, false},
+		{node48MaxChildren, true, false},
+		{node256MinChildren, true, true},
+		{256, true, true},
+	}
+
+	added := 0
+
+	for _, tc := range testCases {
+		for ; added < tc.numChildren; added++ {
+			b := byte(order[added])
+			subject.addChild(&node{key: []byte{b, 'x'}})
+		}
+
+		assertChildIndex(t, subject, tc.wantIndex, tc.wantNode256)
+	}
+
+	for i := 0; i < 256; i++ {
+		key := []byte{byte(i), 'x'}
+
+		if child, err := subject.findChild(key); err != nil || !bytes.Equal(child.key, key) {
+			t.Fatalf("findChild(%q): unexpected result: %v", key, err)
+		}
+
+		if child := subject.findCompatibleChild([]byte{byte(i), 'y'}); child == nil || child.key[0] != byte(i) {
+			t.Fatalf("findCompatibleChild(%q): unexpected result", key)
+		}
+	}
+
+	if _, err := subject.findChild([]byte{0, 'y'}); err == nil {
+		t.Error("findChild(): expected an error for a mismatching key")
+	}
+
+	// Removing children shrinks the layout with hysteresis.
+	shrinkCases := []struct {
+		numChildren int
+		wantIndex   bool
+		wantNode256 bool
+	}{
+		{node256ShrinkChildren, true, true},
+		{node256ShrinkChildren - 1, true, false},
+		{indexShrinkChildren, true, false},
+		{indexShrinkChildren - 1, false, false},
+		{0, false, false},
+	}
+
+	for _, tc := range shrinkCases {
+		for ; added > tc.numChildren; added-- {
+			b := byte(order[added-1])
+
+			if err := subject.removeChild(&node{key: []byte{b, 'x'}}); err != nil {
+				t.Fatalf("removeChild(%d): %v", b, err)
+			}
+		}
+
+		assertChildIndex(t, subject, tc.wantIndex, tc.wantNode256)
+	}
+}
+
+func TestChildIndexTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	arc := New()
+	want := map[string]string{}
+
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("%c%c", rune(rng.Intn(256)), rune('a'+rng.Intn(3)))
+		value := fmt.Sprint(i)
+
+		if rng.Intn(3) == 0 {
+			arc.Delete([]byte(key))
+			delete(want, key)
+		} else {
+			arc.Put([]byte(key), []byte(value))
+			want[key] = value
+		}
+	}
+
+	if arc.Len() != len(want) {
+		t.Fatalf("unexpected length: got:%d, want:%d", arc.Len(), len(want))
+	}
+
+	for key, value := range want {
+		if got, err := arc.Get([]byte(key)); err != nil || string(got) != value {
+			t.Fatalf("Get(%q): got:%q, err:%v, want:%q", key, got, err, value)
+		}
+	}
+
+	var verify func(n *node)
+	verify = func(n *node) {
+		// Whatever the history of the node, an index is never retained
+		// below the shrink threshold.
+		hasIndex := n.index != nil && n.numChildren >= indexShrinkChildren
+		assertChildIndex(t, n, hasIndex, hasIndex && n.index.isNode256())
+
+		for child := n.firstChild; child != nil; child = child.nextSibling {
+			verify(child)
+		}
+	}
+
+	verify(arc.root)
+}
+
+func TestChildIndexPredecessor(t *testing.T) {
+	for _, numChildren := range []int{minIndexedChildren, node256MinChildren} {
+		subject := &node{}
+
+		// Every third byte starting at 1 leaves byte 0 without a child, and
+		// spans several words of the bitmap.
+		for b := 1; b < 3*numChildren; b += 3 {
+			subject.addChild(&node{key: []byte{byte(b)}})
+		}
+
+		want := -1
+
+		for b := range 256 {
+			got := subject.index.predecessor(byte(b))
+
+			switch {
+			case want < 0 && got != nil:
+				t.Fatalf("%d children: predecessor(%d): got:%q, want:nil", numChildren, b, got.key)
+
+			case want >= 0 && (got == nil || got.key[0] != byte(want)):
+				t.Fatalf("%d children: predecessor(%d): got:%v, want:%d", numChildren, b, got, want)
+			}
+
+			if subject.index.get(byte(b)) != nil {
+				want = b
+			}
+		}
+	}
+}
+
+// assertChildIndex verifies the sibling list and the index of the node.
+func assertChildIndex(t *testing.T, n *node, wantIndex bool, wantNode256 bool) {
+	t.Helper()
+
+	if (n.index != nil) != wantIndex {
+		t.Fatalf("unexpected index presence with %d children: got:%v, want:%v", n.numChildren, n.index != nil, wantIndex)
+	}
+
+	if wantIndex && n.index.isNode256() != wantNode256 {
+		t.Fatalf("unexpected layout with %d children: got Node256:%v, want:%v", n.numChildren, n.index.isNode256(), wantNode256)
+	}
+
+	count := 0
+
+	for child := n.firstChild; child != nil; child = child.nextSibling {
+		if child.nextSibling != nil && bytes.Compare(child.key, child.nextSibling.key) >= 0 {
+			t.Fatalf("unsorted siblings: %q, %q", child.key, child.nextSibling.key)
+		}
+
+		if n.index != nil && n.index.get(child.key[0]) != child {
+			t.Fatalf("child %q is missing from the index", child.key)
+		}
+
+		count++
+	}
+
+	if count != n.numChildren {
+		t.Fatalf("unexpected sibling count: got:%d, want:%d", count, n.numChildren)
+	}
+
+	if n.index == nil {
+		return
+	}
+
+	for b := range 256 {
+		present := n.index.present[b/64]&(1<<(b%64)) != 0
+
+		if present != (n.index.get(byte(b)) != nil) {
+			t.Fatalf("unexpected bitmap bit for byte %d: got:%v", b, present)
+		}
+	}
+}
+
+// wideTreeKeys returns random keys, whose first bytes make the root node as
+// wide as possible.
+func wideTreeKeys(n int) [][]byte {
+	rng := rand.New(rand.NewSource(1))
+	ret := make([][]byte, n)
+
+	for i := range ret {
+		ret[i] = make([]byte, 16)
+		rng.Read(ret[i])
+	}
+
+	return ret
+}
+
+// withChildIndex runs the benchmark with and without the child index.
+func withChildIndex(b *testing.B, fn func(b *testing.B)) {
+	for _, layout := range []struct {
+		name      string
+		threshold int
+	}{
+		{"linear", 1 << 30},
+		{"indexed", minIndexedChildren},
+	} {
+		b.Run(layout.name, func(b *testing.B) {
+			defer func(prev int) { minIndexedChildren = prev }(minIndexedChildren)
+			minIndexedChildren = layout.threshold
+
+			fn(b)
+		})
+	}
+}
+
+func BenchmarkWideGet(b *testing.B) {
+	keys := wideTreeKeys(100000)
+
+	withChildIndex(b, func(b *testing.B) {
+		arc := New()
+
+		for _, key := range keys {
+			arc.Put(key, key[:8])
+		}
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			arc.Get(keys[i%len(keys)])
+		}
+	})
+}
+
+func BenchmarkWidePut(b *testing.B) {
+	keys := wideTreeKeys(100000)
+
+	withChildIndex(b, func(b *testing.B) {
+		arc := New()
+
+		for i := 0; i < b.N; i++ {
+			if i%len(keys) == 0 {
+				b.StopTimer()
+				arc = New()
+				b.StartTimer()
+			}
+
+			arc.Put(keys[i%len(keys)], nil)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec converts values of type T to and from the byte slices that are stored
+// in the database. Key codecs should preserve the natural order of T so that
+// the byte order of the encoded keys matches the order of the original values.
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(src []byte) (T, error)
+}
+
+// signed is the set of signed integer types supported by IntCodec.
+type signed interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
+
+// unsigned is the set of unsigned integer types supported by UintCodec.
+type unsigned interface {
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// intCodecLen is the length of an integer encoded by IntCodec and UintCodec.
+const intCodecLen = sizeOfUint64
+
+// StringCodec encodes strings as their raw UTF-8 bytes.
+type StringCodec struct{}
+
+// Encode returns the bytes of the given string.
+func (StringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+// Decode returns the string representation of the given bytes.
+func (StringCodec) Decode(src []byte) (string, error) {
+	return string(src), nil
+}
+
+// IntCodec encodes signed integers as 8-byte big-endian values with the sign
+// bit flipped. This makes the byte order of encoded values match the numeric
+// order, with negative numbers sorting before positive numbers.
+type IntCodec[T signed] struct{}
+
+// Encode returns the order-preserving encoding of v.
+func (IntCodec[T]) Encode(v T) ([]byte, error) {
+	return encodeInt64(int64(v)), nil
+}
+
+// Decode returns the integer encoded in src. It returns ErrInvalidEncoding if
+// src is malformed or if the value does not fit in T.
+func (IntCodec[T]) Decode(src []byte) (T, error) {
+	if len(src) != intCodecLen {
+		return 0, ErrInvalidEncoding
+	}
+
+	v := decodeInt64(src)
+
+	if int64(T(v)) != v {
+		return 0, ErrInvalidEncoding
+	}
+
+	return T(v), nil
+}
+
+// UintCodec encodes unsigned integers as 8-byte big-endian values, which makes
+// the byte order of encoded values match the numeric order.
+type UintCodec[T unsigned] struct{}
+
+// Encode returns the order-preserving encoding of v.
+func (UintCodec[T]) Encode(v T) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, uint64(v)), nil
+}
+
+// Decode returns the integer encoded in src. It returns ErrInvalidEncoding if
+// src is malformed or if the value does not fit in T.
+func (UintCodec[T]) Decode(src []byte) (T, error) {
+	if len(src) != intCodecLen {
+		return 0, ErrInvalidEncoding
+	}
+
+	v := binary.BigEndian.Uint64(src)
+
+	if uint64(T(v)) != v {
+		return 0, ErrInvalidEncoding
+	}
+
+	return T(v), nil
+}
+
+// JSONCodec encodes values using the encoding/json package. The encoding does
+// not preserve order, therefore it is best suited for values.
+type JSONCodec[T any] struct{}
+
+// Encode returns the JSON encoding of v.
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode parses the JSON-encoded src into a value of type T.
+func (JSONCodec[T]) Decode(src []byte) (T, error) {
+	var ret T
+
+	err := json.Unmarshal(src, &ret)
+
+	return ret, err
+}
+
+// GobCodec encodes values using the encoding/gob package. The encoding does
+// not preserve order, therefore it is best suited for values.
+type GobCodec[T any] struct{}
+
+// Encode returns the gob encoding of v.
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode parses the gob-encoded src into a value of type T.
+func (GobCodec[T]) Decode(src []byte) (T, error) {
+	var ret T
+
+	err := gob.NewDecoder(bytes.NewReader(src)).Decode(&ret)
+
+	return ret, err
+}
+
+// binaryMarshalerPtr is satisfied by pointers to types that implement both
+// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+type binaryMarshalerPtr[T any] interface {
+	*T
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// BinaryCodec encodes values whose pointer type implements the standard
+// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler interfaces. Both
+// the value type and its pointer type must be given, for example:
+//
+//	var c arc.BinaryCodec[time.Time, *time.Time]
+type BinaryCodec[T any, PT binaryMarshalerPtr[T]] struct{}
+
+// Encode returns the result of v.MarshalBinary().
+func (BinaryCodec[T, PT]) Encode(v T) ([]byte, error) {
+	return PT(&v).MarshalBinary()
+}
+
+// Decode returns a value of type T populated by UnmarshalBinary.
+func (BinaryCodec[T, PT]) Decode(src []byte) (T, error) {
+	var ret T
+
+	err := PT(&ret).UnmarshalBinary(src)
+
+	return ret, err
+}
+
+// encodeInt64 returns the order-preserving big-endian encoding of v.
+func encodeInt64(v int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(v)^(1<<63))
+}
+
+// decodeInt64 decodes an 8-byte value that was encoded by encodeInt64.
+func decodeInt64(src []byte) int64 {
+	return int64(binary.BigEndian.Uint64(src) ^ (1 << 63))
+}
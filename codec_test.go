@@ -0,0 +1,208 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStringCodec(t *testing.T) {
+	var codec StringCodec
+
+	for _, want := range []string{"", "apple", "日本語"} {
+		encoded, err := codec.Encode(want)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if encoded == nil {
+			t.Fatalf("unexpected nil encoding for %q", want)
+		}
+
+		got, err := codec.Decode(encoded)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != want {
+			t.Errorf("unexpected value: got:%q, want:%q", got, want)
+		}
+	}
+}
+
+func TestIntCodecOrder(t *testing.T) {
+	var codec IntCodec[int64]
+
+	values := []int64{math.MinInt64, -1 << 32, -256, -1, 0, 1, 255, 1 << 40, math.MaxInt64}
+
+	var prev []byte
+
+	for i, v := range values {
+		encoded, err := codec.Encode(v)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if i > 0 && bytes.Compare(prev, encoded) >= 0 {
+			t.Errorf("encoding of %d does not sort after %d", v, values[i-1])
+		}
+
+		got, err := codec.Decode(encoded)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != v {
+			t.Errorf("unexpected value: got:%d, want:%d", got, v)
+		}
+
+		prev = encoded
+	}
+}
+
+func TestUintCodecOrder(t *testing.T) {
+	var codec UintCodec[uint32]
+
+	values := []uint32{0, 1, 255, 256, 1 << 20, math.MaxUint32}
+
+	var prev []byte
+
+	for i, v := range values {
+		encoded, err := codec.Encode(v)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if i > 0 && bytes.Compare(prev, encoded) >= 0 {
+			t.Errorf("encoding of %d does not sort after %d", v, values[i-1])
+		}
+
+		got, err := codec.Decode(encoded)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != v {
+			t.Errorf("unexpected value: got:%d, want:%d", got, v)
+		}
+
+		prev = encoded
+	}
+}
+
+func TestIntCodecDecodeErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		fn   func() error
+	}{
+		{
+			name: "signed with short input",
+			fn: func() error {
+				_, err := IntCodec[int64]{}.Decode([]byte{0x80})
+				return err
+			},
+		},
+		{
+			name: "signed with overflow",
+			fn: func() error {
+				src, _ := IntCodec[int64]{}.Encode(math.MaxInt8 + 1)
+				_, err := IntCodec[int8]{}.Decode(src)
+				return err
+			},
+		},
+		{
+			name: "unsigned with long input",
+			fn: func() error {
+				_, err := UintCodec[uint64]{}.Decode(make([]byte, intCodecLen+1))
+				return err
+			},
+		},
+		{
+			name: "unsigned with overflow",
+			fn: func() error {
+				src, _ := UintCodec[uint64]{}.Encode(math.MaxUint16 + 1)
+				_, err := UintCodec[uint16]{}.Decode(src)
+				return err
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.fn(); !errors.Is(err, ErrInvalidEncoding) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidEncoding)
+			}
+		})
+	}
+}
+
+type codecTestValue struct {
+	Name string
+	Tags []string
+}
+
+func TestJSONAndGobCodecs(t *testing.T) {
+	want := codecTestValue{Name: "apple", Tags: []string{"fruit", "red"}}
+
+	codecs := map[string]Codec[codecTestValue]{
+		"json": JSONCodec[codecTestValue]{},
+		"gob":  GobCodec[codecTestValue]{},
+	}
+
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := codec.Encode(want)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, err := codec.Decode(encoded)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got.Name != want.Name || !slices.Equal(got.Tags, want.Tags) {
+				t.Errorf("unexpected value: got:%+v, want:%+v", got, want)
+			}
+
+			if _, err := codec.Decode([]byte("\x00bogus")); err == nil {
+				t.Error("expected decoding error")
+			}
+		})
+	}
+}
+
+func TestBinaryCodec(t *testing.T) {
+	var codec BinaryCodec[time.Time, *time.Time]
+
+	want := time.Date(2024, time.November, 15, 9, 30, 0, 0, time.UTC)
+	encoded, err := codec.Encode(want)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := codec.Decode(encoded)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !got.Equal(want) {
+		t.Errorf("unexpected value: got:%v, want:%v", got, want)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/chronohq/arc/format"
+)
+
+const (
+	// compactFileSuffix is appended to the path of a paged file to name the
+	// file that Compact rewrites it into.
+	compactFileSuffix = ".compact"
+
+	// compactBatchSize is the size of the objects that Compact encodes while
+	// holding the lock of the database, which bounds the latency that it adds
+	// to writers.
+	compactBatchSize = 1 << 20
+)
+
+// CompactStats summarizes the work of a Compact call.
+type CompactStats struct {
+	Done      bool  // Whether the compacted file replaced the attached file.
+	Written   int64 // Number of bytes written to the compacted file.
+	Reclaimed int64 // Number of bytes by which the file shrank, if Done.
+}
+
+// Compact rewrites the live nodes and blobs of the attached file back to back
+// into a fresh file, which then replaces it. Flush reuses free pages, but
+// neither moves live objects nor shrinks the file, so a file accumulates free
+// pages and sparsely used pages as records are updated and deleted.
+//
+// The database stays readable and writable during Compact, since its lock is
+// only held while a batch of objects is encoded. Every batch skips the
+// subtrees that earlier batches rewrote and that are unchanged since, so
+// writes made in the meantime are carried over, and the last batch completes
+// the tree as of its start. Changes made after that are left to Flush.
+//
+// The deadline of ctx acts as a time budget: once it passes, Compact returns
+// the progress so far without an error, and the next call resumes where it
+// left off. Compact returns ErrNotAttached if the database is not attached to
+// a file.
+func (a *Arc) Compact(ctx context.Context) (CompactStats, error) {
+	return a.CompactWithLimit(ctx, 0)
+}
+
+// CompactWithLimit is like Compact, but returns once it has written at least
+// maxBytes bytes, which bounds the I/O of one increment. A limit of 0 means
+// no limit.
+func (a *Arc) CompactWithLimit(ctx context.Context, maxBytes int64) (CompactStats, error) {
+	var stats CompactStats
+
+	a.mu.RLock()
+	p := a.pager
+	a.mu.RUnlock()
+
+	if p == nil {
+		return stats, ErrNotAttached
+	}
+
+	for !stats.Done && (maxBytes == 0 || stats.Written < maxBytes) {
+		if err := ctx.Err(); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return stats, nil
+			}
+
+			return stats, err
+		}
+
+		limit := int64(compactBatchSize)
+
+		if maxBytes != 0 {
+			limit = min(limit, maxBytes-stats.Written)
+		}
+
+		if err := p.compact(a, uint64(limit), &stats); err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// compaction is an incremental rewrite of a paged file. Its state indexes the
+// objects written so far, and its meta is only written once the objects of a
+// complete tree are.
+type compaction struct {
+	f   File
+	end uint64 // End of the objects written so far.
+
+	pagedState
+}
+
+// compactBatch is the contents of a compaction batch.
+type compactBatch struct {
+	offset uint64       // Offset at which the batch is written.
+	buf    []byte       // Encoded objects of the batch.
+	meta   *format.Meta // Meta of the compacted file, if the batch completes it.
+}
+
+// compact writes the next batch of the compaction, which is started if there
+// is none, and replaces the file of the pager once the batch completes the
+// tree. A failed batch abandons the compaction.
+func (p *pager) compact(a *Arc, limit uint64, stats *CompactStats) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	if p.compaction == nil {
+		if err := p.startCompaction(stats); err != nil {
+			return err
+		}
+	}
+
+	c := p.compaction
+
+	a.mu.Lock()
+	batch, err := c.plan(a, limit, p.meta.Generation+1)
+	a.mu.Unlock()
+
+	if err == nil {
+		err = c.write(batch, stats)
+	}
+
+	if err != nil {
+		p.abortCompaction()
+		return err
+	}
+
+	if batch.meta == nil {
+		return nil
+	}
+
+	if err := p.fsys.Rename(p.path+compactFileSuffix, p.path); err != nil {
+		// It is unknown which file the path refers to, so the pager can
+		// neither keep writing to the previous file nor switch to the new one.
+		p.abortCompaction()
+		p.err = err
+		return err
+	}
+
+	stats.Done = true
+	stats.Reclaimed = (int64(p.meta.NumPages) - int64(c.meta.NumPages)) * format.PageSize
+
+	// The previous file is no longer reachable, and closing it cannot lose
+	// any data.
+	p.f.Close()
+	p.f = c.f
+	p.pagedState = c.pagedState
+	p.compaction = nil
+
+	return nil
+}
+
+// startCompaction creates the compacted file, and writes its static header.
+func (p *pager) startCompaction(stats *CompactStats) error {
+	tmp := p.path + compactFileSuffix
+
+	f, err := p.fsys.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	header, err := appendPagedHeader(nil)
+
+	if err == nil {
+		_, err = f.WriteAt(header, 0)
+	}
+
+	if err != nil {
+		f.Close()
+		p.fsys.Remove(tmp)
+		return err
+	}
+
+	stats.Written += int64(len(header))
+
+	p.compaction = &compaction{
+		f:   f,
+		end: format.NumReservedPages * format.PageSize,
+		pagedState: *newPagedState(format.Meta{
+			PageSize: format.PageSize,
+			NumPages: format.NumReservedPages,
+		}),
+	}
+
+	return nil
+}
+
+// abortCompaction abandons the compaction, and removes the compacted file on
+// a best-effort basis.
+func (p *pager) abortCompaction() {
+	p.compaction.f.Close()
+	p.fsys.Remove(p.path + compactFileSuffix)
+	p.compaction = nil
+}
+
+// plan encodes the objects of the next batch, and adds them to the state of
+// the compaction. If the batch completes the tree, the objects that earlier
+// batches wrote but that are no longer reachable are released, and the batch
+// ends with the free-space map and the meta of the given generation.
+func (c *compaction) plan(a *Arc, limit uint64, generation uint64) (*compactBatch, error) {
+	b := newFlushBuilder(&c.pagedState, a.blobs, limit)
+
+	var root *diskRecord
+
+	if a.root != nil {
+		root = b.visit(a.root)
+	}
+
+	ret := &compactBatch{offset: c.end}
+	c.end = b.assign(c.end)
+
+	buf, err := b.appendObjects(make([]byte, 0, c.end-ret.offset))
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret.buf = buf
+	c.add(b.plan)
+
+	if a.root != nil && root == nil {
+		return ret, nil
+	}
+
+	meta := format.Meta{
+		Generation: generation,
+		PageSize:   format.PageSize,
+		NumNodes:   uint64(a.numNodes),
+		NumRecords: uint64(a.numRecords),
+		Sequence:   a.seq,
+	}
+
+	if root != nil {
+		meta.RootOffset = root.offset
+	}
+
+	c.release(meta.RootOffset)
+
+	// The free-space map follows the objects, and its size depends on the
+	// number of pages of the file, which includes the map itself.
+	c.freeMap.offset = c.end
+	meta.NumPages = pagesFor(c.end)
+
+	for {
+		c.freeMap.size = uint64(format.BlobSize(int(format.FreeMapSize(meta.NumPages))))
+
+		numPages := pagesFor(c.freeMap.end())
+
+		if numPages <= meta.NumPages {
+			break
+		}
+
+		meta.NumPages = numPages
+	}
+
+	meta.FreeMapOffset = c.freeMap.offset
+	c.growPages(meta.NumPages)
+	addPages(c.pages, c.freeMap, 1)
+
+	if ret.buf, err = format.AppendBlob(ret.buf, freeMapBitmap(c.pages)); err != nil {
+		return nil, err
+	}
+
+	size := meta.NumPages*format.PageSize - ret.offset
+	ret.buf = append(ret.buf, make([]byte, size-uint64(len(ret.buf)))...)
+
+	c.meta = meta
+	ret.meta = &meta
+
+	return ret, nil
+}
+
+// add adds the new objects of the plan to the state of the compaction.
+func (c *compaction) add(plan *flushPlan) {
+	c.growPages(pagesFor(c.end))
+
+	for _, blob := range plan.blobs {
+		c.blobs[blob.id] = blob
+		addPages(c.pages, blob.extent, 1)
+	}
+
+	for blob, delta := range plan.refs {
+		blob.refs += delta
+	}
+
+	for _, rec := range plan.records {
+		c.records[rec.offset] = rec
+		c.nodes[rec.node] = rec
+		addPages(c.pages, rec.extent, 1)
+	}
+}
+
+// release removes the records that are not reachable from the record at the
+// given offset, and the blobs that only they referenced.
+func (c *compaction) release(root uint64) {
+	live := map[uint64]bool{}
+	queue := []uint64{root}
+
+	for len(queue) > 0 && root != 0 {
+		offset := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		live[offset] = true
+		queue = append(queue, c.records[offset].children...)
+	}
+
+	for offset, rec := range c.records {
+		if live[offset] {
+			continue
+		}
+
+		delete(c.records, offset)
+		addPages(c.pages, rec.extent, -1)
+
+		if c.nodes[rec.node] == rec {
+			delete(c.nodes, rec.node)
+		}
+
+		if rec.blob == nil {
+			continue
+		}
+
+		if rec.blob.refs--; rec.blob.refs == 0 {
+			delete(c.blobs, rec.blob.id)
+			addPages(c.pages, rec.blob.extent, -1)
+		}
+	}
+}
+
+// growPages extends the object counts of the compaction to the given number
+// of pages.
+func (c *compaction) growPages(numPages uint64) {
+	if n := int(numPages) - len(c.pages); n > 0 {
+		c.pages = append(c.pages, make([]int, n)...)
+	}
+}
+
+// write writes the batch to the compacted file. The file only becomes
+// reachable when it is renamed, so the objects and the meta of the last batch
+// are synced together.
+func (c *compaction) write(batch *compactBatch, stats *CompactStats) error {
+	n, err := c.f.WriteAt(batch.buf, int64(batch.offset))
+	stats.Written += int64(n)
+
+	if err != nil || batch.meta == nil {
+		return err
+	}
+
+	meta := batch.meta.Append(nil)
+
+	n, err = c.f.WriteAt(meta, int64(format.MetaOffset(batch.meta.Generation)))
+	stats.Written += int64(n)
+
+	if err != nil {
+		return err
+	}
+
+	return c.f.Sync()
+}
@@ -0,0 +1,295 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/chronohq/arc/format"
+)
+
+// newChurnedArc returns a database attached to a file of the given VFS, whose
+// records were written, updated and mostly deleted by a series of flushes.
+func newChurnedArc(t *testing.T, fsys VFS) *Arc {
+	t.Helper()
+
+	arc, err := OpenPagedFS(fsys, "arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 1000 {
+		arc.Put(fmt.Appendf(nil, "key-%04d", i), bytes.Repeat([]byte{byte(i)}, inlineValueThreshold*2))
+
+		if i%100 == 99 {
+			if err := arc.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for i := range 1000 {
+		if i%10 != 0 {
+			arc.Delete(fmt.Appendf(nil, "key-%04d", i))
+		}
+	}
+
+	if err := arc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return arc
+}
+
+func TestCompact(t *testing.T) {
+	m := NewMemFS()
+	arc := newChurnedArc(t, m)
+	before, _ := m.ReadFile("arc.db")
+
+	stats, err := arc.Compact(context.Background())
+
+	if err != nil {
+		t.Fatalf("Compact(): %v", err)
+	}
+
+	after, _ := m.ReadFile("arc.db")
+
+	if !stats.Done {
+		t.Fatal("Compact() did not complete")
+	}
+
+	if got, want := stats.Reclaimed, int64(len(before)-len(after)); got <= 0 || got != want {
+		t.Errorf("unexpected reclaimed bytes: got:%d, want:%d", got, want)
+	}
+
+	if got, want := stats.Written, int64(len(after)); got > want {
+		t.Errorf("unexpected written bytes: got:%d, want at most:%d", got, want)
+	}
+
+	if _, err := m.ReadFile("arc.db" + compactFileSuffix); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("compacted file was not renamed: %v", err)
+	}
+
+	got, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatalf("OpenPagedFS(): %v", err)
+	}
+
+	assertEquivalentTree(t, got, arc)
+	assertPagedState(t, arc.pager.pagedState, got.pager.pagedState)
+
+	// The pager continues with the compacted file.
+	arc.Put([]byte("key-0001"), []byte("restored"))
+
+	if err := arc.Flush(); err != nil {
+		t.Fatalf("Flush(): %v", err)
+	}
+
+	if got, err = OpenPagedFS(m, "arc.db"); err != nil {
+		t.Fatalf("OpenPagedFS(): %v", err)
+	}
+
+	assertEquivalentTree(t, got, arc)
+	assertPagedState(t, arc.pager.pagedState, got.pager.pagedState)
+}
+
+func TestCompactEmpty(t *testing.T) {
+	m := NewMemFS()
+	arc, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arc.Put([]byte("apple"), []byte("red"))
+
+	if err := arc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	arc.Delete([]byte("apple"))
+
+	if stats, err := arc.Compact(context.Background()); err != nil || !stats.Done {
+		t.Fatalf("Compact(): %+v, %v", stats, err)
+	}
+
+	got, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatalf("OpenPagedFS(): %v", err)
+	}
+
+	if got.Len() != 0 {
+		t.Errorf("unexpected record count: got:%d, want:0", got.Len())
+	}
+
+	assertPagedState(t, arc.pager.pagedState, got.pager.pagedState)
+}
+
+func TestCompactWithLimit(t *testing.T) {
+	m := NewMemFS()
+	arc := newChurnedArc(t, m)
+
+	var calls int
+
+	for i := 0; ; i++ {
+		// Writes between the increments are carried over by later batches,
+		// or flushed to either file.
+		arc.Put(fmt.Appendf(nil, "key-%04d", i*7), fmt.Appendf(nil, "value-%d", i))
+		arc.Delete(fmt.Appendf(nil, "key-%04d", i*20))
+
+		if i%3 == 0 {
+			if err := arc.Flush(); err != nil {
+				t.Fatalf("Flush(): %v", err)
+			}
+		}
+
+		stats, err := arc.CompactWithLimit(context.Background(), format.PageSize)
+
+		if err != nil {
+			t.Fatalf("CompactWithLimit(): %v", err)
+		}
+
+		calls++
+
+		if stats.Done {
+			break
+		}
+
+		if stats.Written < format.PageSize {
+			t.Fatalf("unexpected written bytes: got:%d, want at least:%d", stats.Written, format.PageSize)
+		}
+	}
+
+	if calls < 3 {
+		t.Errorf("unexpected number of increments: got:%d, want at least:3", calls)
+	}
+
+	arc.Put([]byte("key-0001"), []byte("restored"))
+
+	if err := arc.Flush(); err != nil {
+		t.Fatalf("Flush(): %v", err)
+	}
+
+	got, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatalf("OpenPagedFS(): %v", err)
+	}
+
+	assertEquivalentTree(t, got, arc)
+	assertPagedState(t, arc.pager.pagedState, got.pager.pagedState)
+}
+
+func TestCompactContext(t *testing.T) {
+	arc := newChurnedArc(t, NewMemFS())
+
+	// An expired deadline ends the increment without an error.
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+
+	if stats, err := arc.Compact(ctx); err != nil || stats != (CompactStats{}) {
+		t.Errorf("Compact(): %+v, %v", stats, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := arc.Compact(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, context.Canceled)
+	}
+}
+
+func TestCompactNotAttached(t *testing.T) {
+	if _, err := New().Compact(context.Background()); !errors.Is(err, ErrNotAttached) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrNotAttached)
+	}
+}
+
+func TestCompactFaults(t *testing.T) {
+	// Count the steps of a fault-free compaction.
+	m := NewMemFS()
+	arc := newChurnedArc(t, m)
+	start := m.Steps()
+
+	if _, err := arc.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	numSteps := m.Steps() - start
+
+	// Inject every fault into every step of the compaction. The file must
+	// hold the database after a restart, and must be compactable again.
+	for step := range numSteps {
+		for _, fault := range []Fault{FaultCrash, FaultTornWrite, FaultError} {
+			t.Run(fmt.Sprintf("fault %d at step %d", fault, step), func(t *testing.T) {
+				m := NewMemFS()
+				arc := newChurnedArc(t, m)
+
+				m.InjectFault(m.Steps()+step, fault)
+
+				if _, err := arc.Compact(context.Background()); !errors.Is(err, ErrInjectedFault) {
+					t.Fatalf("unexpected error: got:%v, want:%v", err, ErrInjectedFault)
+				}
+
+				m.Crash()
+				m.Restart()
+
+				got, err := OpenPagedFS(m, "arc.db")
+
+				if err != nil {
+					t.Fatalf("OpenPagedFS(): %v", err)
+				}
+
+				assertEquivalentTree(t, got, arc)
+
+				if stats, err := got.Compact(context.Background()); err != nil || !stats.Done {
+					t.Fatalf("Compact(): %+v, %v", stats, err)
+				}
+
+				reopened, err := OpenPagedFS(m, "arc.db")
+
+				if err != nil {
+					t.Fatalf("OpenPagedFS(): %v", err)
+				}
+
+				assertEquivalentTree(t, reopened, arc)
+			})
+		}
+	}
+}
+
+func TestCompactClose(t *testing.T) {
+	m := NewMemFS()
+	arc := newChurnedArc(t, m)
+
+	if stats, err := arc.CompactWithLimit(context.Background(), format.PageSize); err != nil || stats.Done {
+		t.Fatalf("CompactWithLimit(): %+v, %v", stats, err)
+	}
+
+	if err := arc.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+
+	// Close abandons the compaction in progress.
+	if _, err := m.ReadFile("arc.db" + compactFileSuffix); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("compacted file was not removed: %v", err)
+	}
+
+	got, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatalf("OpenPagedFS(): %v", err)
+	}
+
+	assertEquivalentTree(t, got, arc)
+}
@@ -0,0 +1,91 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+// CompareAndSwap replaces the value of the given key with newValue, only if its
+// current value is equal to oldValue. The comparison and the replacement are
+// performed atomically with respect to other operations. It returns true if
+// the value was replaced, or ErrKeyNotFound if the key does not exist. The
+// record keeps its expiry time, if any. Use Add to atomically insert a key
+// that does not exist.
+func (a *Arc) CompareAndSwap(key []byte, oldValue []byte, newValue []byte) (bool, error) {
+	if key == nil {
+		return false, ErrNilKey
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n, err := a.findRecord(key)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !n.valueEquals(oldValue) {
+		return false, nil
+	}
+
+	if err := a.put(key, newValue, n.expiresAt, true); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeleteIf removes the record of the given key, only if its current value is
+// equal to the expected value. The comparison and the deletion are performed
+// atomically with respect to other operations. It returns true if the record
+// was removed, or ErrKeyNotFound if the key does not exist.
+func (a *Arc) DeleteIf(key []byte, expected []byte) (bool, error) {
+	if key == nil {
+		return false, ErrNilKey
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n, err := a.findRecord(key)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !n.valueEquals(expected) {
+		return false, nil
+	}
+
+	if err := a.delete(key); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Swap stores the value for the given key and returns the previous value, if
+// any. The loaded result reports whether the key existed, in which case the
+// record keeps its expiry time, if any. The read and the write are performed
+// atomically with respect to other operations.
+func (a *Arc) Swap(key []byte, value []byte) (previous []byte, loaded bool, err error) {
+	if key == nil {
+		return nil, false, ErrNilKey
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var expiresAt int64
+
+	if n, err := a.findRecord(key); err == nil {
+		previous = n.value(a.blobs)
+		loaded = true
+		expiresAt = n.expiresAt
+	}
+
+	if err := a.put(key, value, expiresAt, true); err != nil {
+		return nil, false, err
+	}
+
+	return previous, loaded, nil
+}
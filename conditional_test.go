@@ -0,0 +1,186 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	testCases := []struct {
+		name     string
+		key      []byte
+		oldValue []byte
+		newValue []byte
+		swapped  bool
+		err      error
+		want     []byte
+	}{
+		{
+			name:     "with matching inline value",
+			key:      []byte("apple"),
+			oldValue: []byte("cider"),
+			newValue: []byte("pie"),
+			swapped:  true,
+			want:     []byte("pie"),
+		},
+		{
+			name:     "with mismatching inline value",
+			key:      []byte("apple"),
+			oldValue: []byte("pie"),
+			newValue: []byte("tart"),
+			swapped:  false,
+			want:     []byte("cider"),
+		},
+		{
+			name:     "with matching blob value",
+			key:      []byte("blob"),
+			oldValue: blobValueX(),
+			newValue: []byte("small"),
+			swapped:  true,
+			want:     []byte("small"),
+		},
+		{
+			name:     "with mismatching blob value",
+			key:      []byte("blob"),
+			oldValue: bytes.Repeat([]byte("y"), inlineValueThreshold*2),
+			newValue: []byte("small"),
+			swapped:  false,
+			want:     blobValueX(),
+		},
+		{
+			name:     "with non-record key",
+			key:      []byte("ap"),
+			oldValue: nil,
+			newValue: []byte("value"),
+			err:      ErrKeyNotFound,
+		},
+		{
+			name:     "with nil key",
+			key:      nil,
+			oldValue: nil,
+			newValue: []byte("value"),
+			err:      ErrNilKey,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			arc := basicTestTree()
+			arc.Put([]byte("blob"), blobValueX())
+
+			swapped, err := arc.CompareAndSwap(tc.key, tc.oldValue, tc.newValue)
+
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("unexpected error: got:%v, want:%v", err, tc.err)
+			}
+
+			if swapped != tc.swapped {
+				t.Errorf("unexpected swapped: got:%t, want:%t", swapped, tc.swapped)
+			}
+
+			if tc.err != nil {
+				return
+			}
+
+			if got, _ := arc.Get(tc.key); !bytes.Equal(got, tc.want) {
+				t.Errorf("unexpected value: got:%q, want:%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	arc := basicTestTree()
+
+	if deleted, err := arc.DeleteIf([]byte("apple"), []byte("juice")); err != nil || deleted {
+		t.Fatalf("unexpected result: got:%t/%v, want:false/nil", deleted, err)
+	}
+
+	if deleted, err := arc.DeleteIf([]byte("apple"), []byte("cider")); err != nil || !deleted {
+		t.Fatalf("unexpected result: got:%t/%v, want:true/nil", deleted, err)
+	}
+
+	if _, err := arc.DeleteIf([]byte("apple"), []byte("cider")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("unexpected error: got:%v, want:%v", err, ErrKeyNotFound)
+	}
+
+	if arc.Len() != len(basicTestTreeData())-1 {
+		t.Errorf("unexpected record count: got:%d, want:%d", arc.Len(), len(basicTestTreeData())-1)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	arc := basicTestTree()
+
+	previous, loaded, err := arc.Swap([]byte("apple"), blobValueX())
+
+	if err != nil || !loaded || !bytes.Equal(previous, []byte("cider")) {
+		t.Fatalf("unexpected result: got:%q/%t/%v, want:%q/true/nil", previous, loaded, err, "cider")
+	}
+
+	previous, loaded, err = arc.Swap([]byte("apple"), []byte("pie"))
+
+	if err != nil || !loaded || !bytes.Equal(previous, blobValueX()) {
+		t.Fatalf("unexpected result: got:%q/%t/%v, want:%q/true/nil", previous, loaded, err, blobValueX())
+	}
+
+	if len(arc.blobs) != 0 {
+		t.Errorf("expected the blob to be released")
+	}
+
+	previous, loaded, err = arc.Swap([]byte("kiwi"), []byte("green"))
+
+	if err != nil || loaded || previous != nil {
+		t.Fatalf("unexpected result: got:%q/%t/%v, want:nil/false/nil", previous, loaded, err)
+	}
+
+	if got, _ := arc.Get([]byte("kiwi")); !bytes.Equal(got, []byte("green")) {
+		t.Errorf("unexpected value: got:%q, want:%q", got, "green")
+	}
+}
+
+func TestCompareAndSwapConcurrency(t *testing.T) {
+	const numWorkers = 8
+	const numIncrements = 200
+
+	arc := New()
+	key := []byte("counter")
+	arc.Put(key, encodeInt64(0))
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < numIncrements; j++ {
+				for {
+					current, _ := arc.Get(key)
+					next := encodeInt64(decodeInt64(current) + 1)
+
+					if swapped, err := arc.CompareAndSwap(key, current, next); err != nil {
+						t.Errorf("unexpected error: %v", err)
+						return
+					} else if swapped {
+						break
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	got, _ := arc.Get(key)
+
+	if decodeInt64(got) != numWorkers*numIncrements {
+		t.Errorf("unexpected counter: got:%d, want:%d", decodeInt64(got), numWorkers*numIncrements)
+	}
+}
@@ -0,0 +1,441 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "regenerate the golden files in testdata")
+
+// goldenDir is the directory of the conformance vectors of the current file
+// format version.
+const goldenDir = "testdata/v2"
+
+// compactGoldenDir is the directory of the conformance vectors that use the
+// compact node encoding. They hold the same records as the vectors of the same
+// name in goldenDir, whose JSON files they share.
+const compactGoldenDir = goldenDir + "/compact"
+
+// pagedGoldenDir is the directory of the conformance vectors that use the
+// paged layout, which share the JSON files of goldenDir as well.
+const pagedGoldenDir = goldenDir + "/paged"
+
+// legacyGoldenDirs are the directories of the conformance vectors of older
+// file format versions. They hold the same vectors as goldenDir, and must stay
+// readable, but are never written.
+var legacyGoldenDirs = []string{"testdata/v1"}
+
+// goldenVector is the expected contents of a conformance vector, which is
+// stored as JSON next to the Arc file. Keys and values are hex-encoded, and
+// expiry times are Unix nanoseconds, where zero means no expiry.
+type goldenVector struct {
+	Description string         `json:"description"`
+	NumNodes    int            `json:"num_nodes"`
+	NumRecords  int            `json:"num_records"`
+	NumBlobs    int            `json:"num_blobs"`
+	Sequence    uint64         `json:"sequence"`
+	Records     []goldenRecord `json:"records"`
+}
+
+// goldenRecord is a record of a conformance vector.
+type goldenRecord struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// goldenVectors returns the conformance vectors by name. The records are
+// given in arbitrary order.
+func goldenVectors() map[string]goldenVector {
+	record := func(key []byte, value []byte, expiresAt int64) goldenRecord {
+		return goldenRecord{Key: hex.EncodeToString(key), Value: hex.EncodeToString(value), ExpiresAt: expiresAt}
+	}
+
+	var inline, blobs, deep, wide, maxKeys, expiry []goldenRecord
+
+	inline = append(inline,
+		record([]byte{}, []byte("root"), 0),
+		record([]byte("a"), []byte{}, 0),
+		record([]byte("apple"), []byte("fruit"), 0),
+		record([]byte("application"), []byte("software"), 0),
+		record([]byte("apricot"), bytes.Repeat([]byte{0xa5}, inlineValueThreshold), 0),
+		record([]byte("banana"), []byte{0x00}, 0),
+	)
+
+	blobs = append(blobs,
+		record([]byte("small"), []byte("inline"), 0),
+		record([]byte("threshold"), bytes.Repeat([]byte("t"), inlineValueThreshold+1), 0),
+		record([]byte("shared/1"), bytes.Repeat([]byte("shared value "), 8), 0),
+		record([]byte("shared/2"), bytes.Repeat([]byte("shared value "), 8), 0),
+		record([]byte("shared/3"), bytes.Repeat([]byte("shared value "), 8), 0),
+		record([]byte("large"), bytes.Repeat([]byte{0x00, 0x01, 0xfe, 0xff}, 1024), 0),
+	)
+
+	for depth := 1; depth <= 64; depth++ {
+		deep = append(deep, record(bytes.Repeat([]byte("d"), depth), []byte{byte(depth)}, 0))
+	}
+
+	deep = append(deep, record(append(bytes.Repeat([]byte("d"), 32), 'x'), []byte("branch"), 0))
+
+	for b := range 256 {
+		wide = append(wide, record([]byte{byte(b)}, []byte{byte(b)}, 0))
+		wide = append(wide, record([]byte{'w', byte(b)}, []byte{byte(b)}, 0))
+	}
+
+	maxKey := bytes.Repeat([]byte("k"), maxKeyBytes)
+	maxKeys = append(maxKeys,
+		record(maxKey, []byte("max"), 0),
+		record(append(maxKey[:maxKeyBytes-1:maxKeyBytes-1], 0xff), bytes.Repeat([]byte("v"), 100), 0),
+		record([]byte("k"), []byte("short"), 0),
+	)
+
+	expiry = append(expiry,
+		record([]byte("expired"), []byte("gone"), 1_000_000_000),
+		record([]byte("future"), []byte("kept"), 4_102_444_800_000_000_000),
+		record([]byte("future/blob"), bytes.Repeat([]byte("b"), 64), 4_102_444_800_000_000_000),
+		record([]byte("permanent"), []byte("forever"), 0),
+	)
+
+	return map[string]goldenVector{
+		"empty":         {Description: "A database without records."},
+		"inline_values": {Description: "Values of up to 32 bytes stored inline, including the empty key and an empty value.", Records: inline},
+		"blob_values":   {Description: "Values of more than 32 bytes stored as deduplicated blobs.", Records: blobs},
+		"deep_tree":     {Description: "A chain of 64 nested keys with a branch in the middle.", Records: deep},
+		"wide_tree":     {Description: "Nodes with 256 children, one for every first byte.", Records: wide},
+		"max_size_keys": {Description: "Keys of the maximum size of 65535 bytes that branch at the last byte.", Records: maxKeys},
+		"expiry":        {Description: "Records with expiry times in the past and the future.", Sequence: 1000, Records: expiry},
+	}
+}
+
+// buildGoldenTree returns a database with the records of the vector.
+func buildGoldenTree(t *testing.T, v goldenVector) *Arc {
+	t.Helper()
+
+	ret := New()
+
+	for _, r := range v.Records {
+		key, err := hex.DecodeString(r.Key)
+
+		if err != nil {
+			t.Fatalf("invalid key %q: %v", r.Key, err)
+		}
+
+		value, err := hex.DecodeString(r.Value)
+
+		if err != nil {
+			t.Fatalf("invalid value %q: %v", r.Value, err)
+		}
+
+		if err := ret.put(key, value, r.ExpiresAt, false); err != nil {
+			t.Fatalf("put(%q): %v", r.Key, err)
+		}
+	}
+
+	// The vectors are written as if the records had a history of the given
+	// length, regardless of the number of puts.
+	ret.seq = v.Sequence
+
+	return ret
+}
+
+// describeGoldenTree returns the vector that describes the database, with the
+// records in ascending key order.
+func describeGoldenTree(a *Arc, description string) goldenVector {
+	ret := goldenVector{
+		Description: description,
+		NumNodes:    a.numNodes,
+		NumRecords:  a.numRecords,
+		NumBlobs:    len(a.blobs),
+		Sequence:    a.seq,
+		Records:     []goldenRecord{},
+	}
+
+	// Expired records that have not been reclaimed are part of the file.
+	a.walk(nil, nil, func(key []byte, n *node) bool {
+		ret.Records = append(ret.Records, goldenRecord{
+			Key:       hex.EncodeToString(key),
+			Value:     hex.EncodeToString(n.value(a.blobs)),
+			ExpiresAt: n.expiresAt,
+		})
+
+		return true
+	})
+
+	return ret
+}
+
+func TestConformanceVectors(t *testing.T) {
+	vectors := goldenVectors()
+
+	if *updateGolden {
+		for _, dir := range []string{compactGoldenDir, pagedGoldenDir} {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for name, v := range vectors {
+			arc := buildGoldenTree(t, v)
+
+			var buf bytes.Buffer
+
+			if _, err := arc.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(goldenDir, name+".arc"), buf.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			buf.Reset()
+			arc.EnableCompactNodes(true)
+
+			if _, err := arc.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(compactGoldenDir, name+".arc"), buf.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(pagedGoldenDir, name+".arc"), writePagedGolden(t, arc), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			contents, err := json.MarshalIndent(describeGoldenTree(arc, v.Description), "", "  ")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(goldenDir, name+".json"), append(contents, '\n'), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	names, err := filepath.Glob(filepath.Join(goldenDir, "*.arc"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != len(vectors) {
+		t.Fatalf("unexpected number of vectors: got:%d, want:%d", len(names), len(vectors))
+	}
+
+	for _, name := range names {
+		base := name[:len(name)-len(".arc")]
+
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			src, err := os.ReadFile(name)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			contents, err := os.ReadFile(base + ".json")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want goldenVector
+
+			if err := json.Unmarshal(contents, &want); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+
+			// Reading the file yields the expected contents.
+			arc := New()
+
+			if _, err := arc.ReadFrom(bytes.NewReader(src)); err != nil {
+				t.Fatalf("ReadFrom(): %v", err)
+			}
+
+			got := describeGoldenTree(arc, want.Description)
+
+			if got.NumNodes != want.NumNodes || got.NumRecords != want.NumRecords || got.NumBlobs != want.NumBlobs {
+				t.Errorf("unexpected counts: got:(%d, %d, %d), want:(%d, %d, %d)",
+					got.NumNodes, got.NumRecords, got.NumBlobs, want.NumNodes, want.NumRecords, want.NumBlobs)
+			}
+
+			if got.Sequence != want.Sequence {
+				t.Errorf("unexpected sequence number: got:%d, want:%d", got.Sequence, want.Sequence)
+			}
+
+			if !slices.Equal(got.Records, want.Records) {
+				t.Errorf("unexpected records: got:%d records, want:%d records", len(got.Records), len(want.Records))
+			}
+
+			// Writing the expected contents yields the file byte for byte.
+			var buf bytes.Buffer
+
+			if _, err := buildGoldenTree(t, want).WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo(): %v", err)
+			}
+
+			if !bytes.Equal(buf.Bytes(), src) {
+				t.Error("written file differs from the vector")
+			}
+
+			// The same holds for the compact node encoding.
+			compact, err := os.ReadFile(filepath.Join(compactGoldenDir, filepath.Base(name)))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			arc = New()
+
+			if _, err := arc.ReadFrom(bytes.NewReader(compact)); err != nil {
+				t.Fatalf("ReadFrom() of the compact vector: %v", err)
+			}
+
+			if got := describeGoldenTree(arc, want.Description); !slices.Equal(got.Records, want.Records) {
+				t.Errorf("unexpected records in the compact vector: got:%d records, want:%d records", len(got.Records), len(want.Records))
+			}
+
+			buf.Reset()
+			expected := buildGoldenTree(t, want)
+			expected.EnableCompactNodes(true)
+
+			if _, err := expected.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo(): %v", err)
+			}
+
+			if !bytes.Equal(buf.Bytes(), compact) {
+				t.Error("written compact file differs from the vector")
+			}
+
+			// The same holds for the paged layout, whose vectors are written
+			// by a single flush into an empty file.
+			paged, err := os.ReadFile(filepath.Join(pagedGoldenDir, filepath.Base(name)))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			arc = New()
+
+			if _, err := arc.ReadFrom(bytes.NewReader(paged)); err != nil {
+				t.Fatalf("ReadFrom() of the paged vector: %v", err)
+			}
+
+			if got := describeGoldenTree(arc, want.Description); !slices.Equal(got.Records, want.Records) {
+				t.Errorf("unexpected records in the paged vector: got:%d records, want:%d records", len(got.Records), len(want.Records))
+			}
+
+			if !bytes.Equal(writePagedGolden(t, buildGoldenTree(t, want)), paged) {
+				t.Error("written paged file differs from the vector")
+			}
+		})
+	}
+}
+
+// writePagedGolden returns a paged file that holds the contents of the
+// database, as written by a single flush into an empty file.
+func writePagedGolden(t testing.TB, a *Arc) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	if _, err := a.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMemFS()
+	paged, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := paged.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := paged.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := m.ReadFile("arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return src
+}
+
+func TestLegacyConformanceVectors(t *testing.T) {
+	for _, dir := range legacyGoldenDirs {
+		names, err := filepath.Glob(filepath.Join(dir, "*.arc"))
+
+		if err != nil || len(names) == 0 {
+			t.Fatalf("no vectors in %s: %v", dir, err)
+		}
+
+		for _, name := range names {
+			base := filepath.Base(name[:len(name)-len(".arc")])
+
+			t.Run(filepath.Join(filepath.Base(dir), base), func(t *testing.T) {
+				src, err := os.ReadFile(name)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				contents, err := os.ReadFile(filepath.Join(dir, base+".json"))
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var want goldenVector
+
+				if err := json.Unmarshal(contents, &want); err != nil {
+					t.Fatalf("invalid JSON: %v", err)
+				}
+
+				// Reading the file yields the expected contents.
+				arc := New()
+
+				if _, err := arc.ReadFrom(bytes.NewReader(src)); err != nil {
+					t.Fatalf("ReadFrom(): %v", err)
+				}
+
+				if got := describeGoldenTree(arc, want.Description); !slices.Equal(got.Records, want.Records) {
+					t.Errorf("unexpected records: got:%d records, want:%d records", len(got.Records), len(want.Records))
+				}
+
+				// Upgrading the file yields the vector of the current version.
+				current, err := os.ReadFile(filepath.Join(goldenDir, base+".arc"))
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				m := NewMemFS()
+				m.WriteFile("arc.db", src)
+
+				if err := UpgradeFS(m, "arc.db"); err != nil {
+					t.Fatalf("UpgradeFS(): %v", err)
+				}
+
+				if got, _ := m.ReadFile("arc.db"); !bytes.Equal(got, current) {
+					t.Error("upgraded file differs from the current vector")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"iter"
+	"unsafe"
+)
+
+// DiffType identifies how a record differs between two databases.
+type DiffType uint8
+
+const (
+	// DiffAdded means that the record only exists in the second database.
+	DiffAdded DiffType = iota + 1
+
+	// DiffRemoved means that the record only exists in the first database.
+	DiffRemoved
+
+	// DiffChanged means that the value or expiry time of the record differs.
+	DiffChanged
+)
+
+// String returns the name of the diff type.
+func (dt DiffType) String() string {
+	switch dt {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	}
+
+	return "unknown"
+}
+
+// DiffEntry describes a record that differs between two databases.
+type DiffEntry struct {
+	Type     DiffType
+	Key      []byte
+	OldValue []byte // Value in the first database. Nil for DiffAdded.
+	NewValue []byte // Value in the second database. Nil for DiffRemoved.
+}
+
+// Diff returns an iterator over the records that differ between databases a
+// and b, in ascending key order. The trees are walked in parallel, and
+// subtrees whose Merkle hashes are equal are skipped without being visited.
+// Expired records are treated as absent. To compare snapshots, load them into
+// databases with ReadFrom.
+//
+// The differences are yielded during the walk, while both databases are
+// locked. The loop body must therefore not access either database. To modify
+// one from the other, for example to repair it, collect the entries first.
+func Diff(a *Arc, b *Arc) iter.Seq[DiffEntry] {
+	return func(yield func(DiffEntry) bool) {
+		if a == b {
+			return
+		}
+
+		// Lock the pair in address order, which prevents concurrent diffs
+		// of the same pair from acquiring the locks in opposite order.
+		first, second := a, b
+
+		if uintptr(unsafe.Pointer(second)) < uintptr(unsafe.Pointer(first)) {
+			first, second = second, first
+		}
+
+		first.mu.RLock()
+		defer first.mu.RUnlock()
+
+		second.mu.RLock()
+		defer second.mu.RUnlock()
+
+		first.hashMu.Lock()
+		defer first.hashMu.Unlock()
+
+		second.hashMu.Lock()
+		defer second.hashMu.Unlock()
+
+		d := differ{a: a, b: b, yield: yield}
+		d.diff(nil, newDiffCursor(a.root), newDiffCursor(b.root))
+	}
+}
+
+// diffCursor is a position in a tree. The position precedes node n by the rest
+// of its key segment, which is empty if the position is at node n itself.
+type diffCursor struct {
+	rest []byte
+	n    *node
+}
+
+// newDiffCursor returns a cursor that precedes node n by its key segment, or
+// nil if the node is nil.
+func newDiffCursor(n *node) *diffCursor {
+	if n == nil {
+		return nil
+	}
+
+	return &diffCursor{rest: n.key, n: n}
+}
+
+// record returns the live record at the position of the cursor, if any.
+func (c *diffCursor) record() *node {
+	if c == nil || len(c.rest) > 0 || !c.n.isRecord || c.n.expired() {
+		return nil
+	}
+
+	return c.n
+}
+
+// children returns the cursors that follow the position of the cursor.
+func (c *diffCursor) children() []*diffCursor {
+	if c == nil {
+		return nil
+	}
+
+	if len(c.rest) > 0 {
+		return []*diffCursor{c}
+	}
+
+	var ret []*diffCursor
+
+	for child := c.n.firstChild; child != nil; child = child.nextSibling {
+		ret = append(ret, newDiffCursor(child))
+	}
+
+	return ret
+}
+
+// differ holds the state of a parallel walk of two trees.
+type differ struct {
+	a       *Arc
+	b       *Arc
+	yield   func(DiffEntry) bool
+	stopped bool // Set when yield asks to stop the walk.
+}
+
+// diff compares the subtrees at the positions of the cursors, which share the
+// given path. A nil cursor denotes an empty subtree.
+func (d *differ) diff(path []byte, x *diffCursor, y *diffCursor) {
+	if d.stopped || (x == nil && y == nil) {
+		return
+	}
+
+	// The Merkle hash of a node excludes its own key segment, so equal rests
+	// and hashes prove that the subtrees are identical.
+	if x != nil && y != nil && bytes.Equal(x.rest, y.rest) {
+		if x.n == y.n || x.n.merkleHash() == y.n.merkleHash() {
+			return
+		}
+	}
+
+	d.diffRecord(path, x.record(), y.record())
+
+	xs := x.children()
+	ys := y.children()
+
+	// Children are sorted by key, and siblings never share a first byte.
+	for (len(xs) > 0 || len(ys) > 0) && !d.stopped {
+		switch {
+		case len(ys) == 0 || (len(xs) > 0 && xs[0].rest[0] < ys[0].rest[0]):
+			d.diff(appendPath(path, xs[0].rest), &diffCursor{n: xs[0].n}, nil)
+			xs = xs[1:]
+
+		case len(xs) == 0 || ys[0].rest[0] < xs[0].rest[0]:
+			d.diff(appendPath(path, ys[0].rest), nil, &diffCursor{n: ys[0].n})
+			ys = ys[1:]
+
+		default:
+			common := len(longestCommonPrefix(xs[0].rest, ys[0].rest))
+			x := &diffCursor{rest: xs[0].rest[common:], n: xs[0].n}
+			y := &diffCursor{rest: ys[0].rest[common:], n: ys[0].n}
+
+			d.diff(appendPath(path, xs[0].rest[:common]), x, y)
+
+			xs = xs[1:]
+			ys = ys[1:]
+		}
+	}
+}
+
+// diffRecord compares the records at the given path, either of which may be
+// nil if the record does not exist.
+func (d *differ) diffRecord(path []byte, x *node, y *node) {
+	switch {
+	case x == nil && y == nil:
+		return
+
+	case x == nil:
+		d.emit(DiffEntry{Type: DiffAdded, Key: path, NewValue: recordValue(y, d.b.blobs)})
+
+	case y == nil:
+		d.emit(DiffEntry{Type: DiffRemoved, Key: path, OldValue: recordValue(x, d.a.blobs)})
+
+	case x.blobValue != y.blobValue || !bytes.Equal(x.data, y.data) || x.expiresAt != y.expiresAt:
+		d.emit(DiffEntry{
+			Type:     DiffChanged,
+			Key:      path,
+			OldValue: recordValue(x, d.a.blobs),
+			NewValue: recordValue(y, d.b.blobs),
+		})
+	}
+}
+
+// emit yields the entry, and stops the walk if the consumer asks to stop.
+func (d *differ) emit(entry DiffEntry) {
+	if !d.yield(entry) {
+		d.stopped = true
+	}
+}
+
+// recordValue returns a copy of the record's value, which is never nil.
+func recordValue(n *node, bs blobStore) []byte {
+	if ret := n.value(bs); ret != nil {
+		return ret
+	}
+
+	return []byte{}
+}
+
+// appendPath returns a new path that consists of the path and the segment.
+func appendPath(path []byte, segment []byte) []byte {
+	ret := make([]byte, 0, len(path)+len(segment))
+	ret = append(ret, path...)
+
+	return append(ret, segment...)
+}
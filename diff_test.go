@@ -0,0 +1,192 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	setTestClock(t)
+
+	a := basicTestTree()
+	b := basicTestTree()
+
+	b.Put([]byte("apple"), []byte("pie"))                     // changed
+	b.Put([]byte("app"), []byte("store"))                     // added, splits a node
+	b.Put([]byte("lemon"), blobValueX())                      // changed to a blob
+	b.Put([]byte("zucchini"), []byte("green"))                // added
+	b.Delete([]byte("banana"))                                // removed
+	b.DeletePrefix([]byte("grape"))                           // removed subtree
+	b.PutWithTTL([]byte("berry"), []byte("sweet"), time.Hour) // changed expiry
+
+	want := []DiffEntry{
+		{Type: DiffAdded, Key: []byte("app"), NewValue: []byte("store")},
+		{Type: DiffChanged, Key: []byte("apple"), OldValue: []byte("cider"), NewValue: []byte("pie")},
+		{Type: DiffRemoved, Key: []byte("banana"), OldValue: []byte("ripe")},
+		{Type: DiffChanged, Key: []byte("berry"), OldValue: []byte("sweet"), NewValue: []byte("sweet")},
+		{Type: DiffRemoved, Key: []byte("grape"), OldValue: []byte("vine")},
+		{Type: DiffRemoved, Key: []byte("grapefruit"), OldValue: []byte("citrus")},
+		{Type: DiffChanged, Key: []byte("lemon"), OldValue: []byte("sour"), NewValue: blobValueX()},
+		{Type: DiffAdded, Key: []byte("zucchini"), NewValue: []byte("green")},
+	}
+
+	assertDiff(t, slices.Collect(Diff(a, b)), want)
+
+	// The reverse diff swaps additions and removals.
+	var reversed []DiffEntry
+
+	for _, entry := range want {
+		entry.OldValue, entry.NewValue = entry.NewValue, entry.OldValue
+
+		switch entry.Type {
+		case DiffAdded:
+			entry.Type = DiffRemoved
+		case DiffRemoved:
+			entry.Type = DiffAdded
+		}
+
+		reversed = append(reversed, entry)
+	}
+
+	assertDiff(t, slices.Collect(Diff(b, a)), reversed)
+
+	if got := slices.Collect(Diff(a, basicTestTree())); len(got) != 0 {
+		t.Errorf("unexpected diff of equal databases: %v", got)
+	}
+
+	if got := slices.Collect(Diff(a, a)); len(got) != 0 {
+		t.Errorf("unexpected diff of the same database: %v", got)
+	}
+
+	assertDiff(t, slices.Collect(Diff(New(), b)), collectAdded(b))
+}
+
+func TestDiffRepair(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	a := New()
+	b := New()
+
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("%x", rng.Intn(1024)))
+		value := []byte(fmt.Sprint(rng.Intn(3)))
+
+		switch rng.Intn(3) {
+		case 0:
+			a.Put(key, value)
+		case 1:
+			b.Put(key, value)
+		case 2:
+			a.Put(key, value)
+			b.Put(key, value)
+		}
+	}
+
+	// Applying the diff to the first database makes it equal to the second.
+	// The entries are collected first, since the loop body of Diff must not
+	// access either database.
+	for _, entry := range slices.Collect(Diff(a, b)) {
+		switch entry.Type {
+		case DiffAdded, DiffChanged:
+			a.Put(entry.Key, entry.NewValue)
+		case DiffRemoved:
+			a.Delete(entry.Key)
+		}
+	}
+
+	if got, want := a.RootHash(), b.RootHash(); got != want {
+		t.Errorf("unexpected root hash after repair: got:%s, want:%s", got, want)
+	}
+
+	if got := slices.Collect(Diff(a, b)); len(got) != 0 {
+		t.Errorf("unexpected diff after repair: %d entries", len(got))
+	}
+}
+
+func TestDiffConcurrent(t *testing.T) {
+	a := basicTestTree()
+	b := basicTestTree()
+	b.Put([]byte("apple"), []byte("green"))
+
+	// Stopping the iteration early releases the locks.
+	for range Diff(a, b) {
+		break
+	}
+
+	if err := a.Put([]byte("cherry"), []byte("red")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Diffs of the same pair in opposite order, interleaved with writers,
+	// must not deadlock.
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for i := range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range 200 {
+				switch i {
+				case 0:
+					for range Diff(a, b) {
+					}
+				case 1:
+					for range Diff(b, a) {
+					}
+				case 2:
+					a.Put(fmt.Appendf(nil, "key-%d", j), []byte("v"))
+				case 3:
+					b.Put(fmt.Appendf(nil, "key-%d", j), []byte("v"))
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("concurrent diffs deadlocked")
+	}
+}
+
+func collectAdded(a *Arc) []DiffEntry {
+	var ret []DiffEntry
+
+	for key, value := range a.Scan(nil) {
+		ret = append(ret, DiffEntry{Type: DiffAdded, Key: key, NewValue: value})
+	}
+
+	return ret
+}
+
+func assertDiff(t *testing.T, got []DiffEntry, want []DiffEntry) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("unexpected diff length: got:%d, want:%d\n%v", len(got), len(want), got)
+	}
+
+	for i := range want {
+		if got[i].Type != want[i].Type || !bytes.Equal(got[i].Key, want[i].Key) ||
+			!bytes.Equal(got[i].OldValue, want[i].OldValue) || !bytes.Equal(got[i].NewValue, want[i].NewValue) {
+			t.Errorf("unexpected entry %d: got:{%s %q %q %q}, want:{%s %q %q %q}", i,
+				got[i].Type, got[i].Key, got[i].OldValue, got[i].NewValue,
+				want[i].Type, want[i].Key, want[i].OldValue, want[i].NewValue)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package format
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+)
+
+// BlobID returns the ID of a blob value, which is its SHA-256 hash.
+func BlobID(value []byte) [BlobIDSize]byte {
+	return sha256.Sum256(value)
+}
+
+// BlobSize returns the size of an encoded blob with the given value length.
+func BlobSize(valueLen int) int {
+	return 4 + valueLen + ChecksumSize
+}
+
+// AppendBlob appends the encoded blob value to dst and returns the extended
+// buffer. It returns ErrCorrupted if the value exceeds the length field.
+func AppendBlob(dst []byte, value []byte) ([]byte, error) {
+	if uint64(len(value)) > math.MaxUint32 {
+		return dst, ErrCorrupted
+	}
+
+	start := len(dst)
+
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(value)))
+	dst = append(dst, value...)
+
+	return binary.LittleEndian.AppendUint32(dst, Checksum(dst[start:])), nil
+}
+
+// DecodeBlob decodes the blob at the start of src, and returns its value along
+// with its encoded size. The value aliases src.
+func DecodeBlob(src []byte) ([]byte, int, error) {
+	if len(src) < 4 {
+		return nil, 0, ErrCorrupted
+	}
+
+	valueLen := uint64(binary.LittleEndian.Uint32(src))
+	checksumPos := 4 + valueLen
+
+	if uint64(len(src)) < checksumPos+ChecksumSize {
+		return nil, 0, ErrCorrupted
+	}
+
+	if Checksum(src[:checksumPos]) != binary.LittleEndian.Uint32(src[checksumPos:]) {
+		return nil, 0, ErrInvalidChecksum
+	}
+
+	return src[4:checksumPos:checksumPos], int(checksumPos + ChecksumSize), nil
+}
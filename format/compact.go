@@ -0,0 +1,167 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package format
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// CompactNodeMinSize is the size of the smallest compact node, which has no
+// children, no key and no data.
+const CompactNodeMinSize = 1 + 1 + 1 + 1 + 1 + ChecksumSize
+
+// The compact node encoding of FeatureCompactNodes replaces the fixed-length
+// integers of a Node with unsigned varints, and its absolute offsets with the
+// distance from the start of the node to its first child. The children of a
+// node are laid out back to back, so that the next sibling of a child starts
+// where the child ends, and is not encoded.
+
+// CompactSize returns the size of the compact encoding of the node in bytes,
+// if the node starts at the given offset.
+func (n Node) CompactSize(offset uint64) int {
+	ret := 1 + uvarintSize(uint64(n.NumChildren)) + uvarintSize(uint64(len(n.Key))) +
+		uvarintSize(uint64(len(n.Data))) + uvarintSize(n.firstChildDelta(offset)) +
+		len(n.Key) + len(n.Data) + ChecksumSize
+
+	if n.HasExpiry() {
+		ret += 8
+	}
+
+	return ret
+}
+
+// firstChildDelta returns the distance from the given offset of the node to
+// its first child, or 0 if the node has no children.
+func (n Node) firstChildDelta(offset uint64) uint64 {
+	if n.FirstChild == 0 {
+		return 0
+	}
+
+	return n.FirstChild - offset
+}
+
+// AppendCompact appends the compact encoding of the node to dst, and returns
+// the extended buffer. The node starts at the given offset, and its first
+// child, if any, must start after the end of the node. NextSibling is not
+// encoded. It returns ErrNodeCorrupted if the key or data exceed their length
+// limits, or if the first child does not follow the node.
+func (n Node) AppendCompact(dst []byte, offset uint64) ([]byte, error) {
+	if len(n.Key) > math.MaxUint16 || uint64(len(n.Data)) > math.MaxUint32 {
+		return dst, ErrNodeCorrupted
+	}
+
+	if (n.NumChildren == 0) != (n.FirstChild == 0) {
+		return dst, ErrNodeCorrupted
+	}
+
+	if n.FirstChild != 0 && (n.FirstChild <= offset || n.FirstChild-offset < uint64(n.CompactSize(offset))) {
+		return dst, ErrNodeCorrupted
+	}
+
+	start := len(dst)
+
+	dst = append(dst, n.Flags)
+	dst = binary.AppendUvarint(dst, uint64(n.NumChildren))
+	dst = binary.AppendUvarint(dst, uint64(len(n.Key)))
+	dst = binary.AppendUvarint(dst, uint64(len(n.Data)))
+	dst = binary.AppendUvarint(dst, n.firstChildDelta(offset))
+	dst = append(dst, n.Key...)
+	dst = append(dst, n.Data...)
+
+	if n.HasExpiry() {
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(n.ExpiresAt))
+	}
+
+	return binary.LittleEndian.AppendUint32(dst, Checksum(dst[start:])), nil
+}
+
+// DecodeCompactNode decodes the compact node at the start of src, which starts
+// at the given offset of the file. It returns the node along with its encoded
+// size. FirstChild is resolved to an absolute offset, and NextSibling is zero,
+// since the next sibling of a child starts where the child ends. The key and
+// data of the node alias src. Only the canonical, shortest encoding of each
+// varint is accepted.
+func DecodeCompactNode(src []byte, offset uint64) (Node, int, error) {
+	var ret Node
+
+	if len(src) < CompactNodeMinSize {
+		return ret, 0, ErrNodeCorrupted
+	}
+
+	ret.Flags = src[0]
+	pos := 1
+
+	var fields [4]uint64
+
+	for i := range fields {
+		v, n := binary.Uvarint(src[pos:])
+
+		if n <= 0 || n != uvarintSize(v) {
+			return ret, 0, ErrNodeCorrupted
+		}
+
+		fields[i] = v
+		pos += n
+	}
+
+	numChildren, keyLen, dataLen, delta := fields[0], fields[1], fields[2], fields[3]
+
+	if numChildren > math.MaxUint16 || keyLen > math.MaxUint16 || dataLen > math.MaxUint32 {
+		return ret, 0, ErrNodeCorrupted
+	}
+
+	// The lengths are bounded, so the size cannot overflow, and no allocation
+	// depends on them.
+	size := uint64(pos) + keyLen + dataLen + ChecksumSize
+
+	if ret.HasExpiry() {
+		size += 8
+	}
+
+	if uint64(len(src)) < size {
+		return ret, 0, ErrNodeCorrupted
+	}
+
+	checksumPos := size - ChecksumSize
+
+	if Checksum(src[:checksumPos]) != binary.LittleEndian.Uint32(src[checksumPos:]) {
+		return ret, 0, ErrInvalidChecksum
+	}
+
+	// A node has a first child if and only if it has children, and the first
+	// child follows the node.
+	if (numChildren == 0) != (delta == 0) || (delta != 0 && delta < size) || delta > math.MaxUint64-offset {
+		return ret, 0, ErrNodeCorrupted
+	}
+
+	ret.NumChildren = uint16(numChildren)
+
+	if delta != 0 {
+		ret.FirstChild = offset + delta
+	}
+
+	end := uint64(pos)
+	ret.Key = src[end : end+keyLen : end+keyLen]
+	end += keyLen
+	ret.Data = src[end : end+dataLen : end+dataLen]
+	end += dataLen
+
+	if ret.HasExpiry() {
+		ret.ExpiresAt = int64(binary.LittleEndian.Uint64(src[end:]))
+	}
+
+	return ret, int(size), nil
+}
+
+// uvarintSize returns the size of the unsigned varint encoding of v in bytes.
+func uvarintSize(v uint64) int {
+	ret := 1
+
+	for ; v >= 0x80; v >>= 7 {
+		ret++
+	}
+
+	return ret
+}
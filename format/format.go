@@ -0,0 +1,228 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+// Package format implements the low-level encoding of Arc database files. It
+// allows tools to read and write Arc files without depending on the Arc
+// type, and is the encoding that the arc package itself uses.
+//
+// An Arc file consists of a Header, followed by the index nodes of the Radix
+// tree and the blobs. Integers are little-endian or varints, and every
+// structure carries an IEEE CRC32 checksum over its preceding bytes. The
+// complete specification is in SPEC.md, and the Layout variables describe the
+// field layouts in machine-readable form.
+package format
+
+import (
+	"errors"
+	"hash/crc32"
+)
+
+var (
+	// ErrCorrupted is returned when a header or blob is malformed.
+	ErrCorrupted = errors.New("database corruption detected")
+
+	// ErrInvalidChecksum is returned when the checksum of a structure does
+	// not match its contents.
+	ErrInvalidChecksum = errors.New("invalid checksum detected")
+
+	// ErrNodeCorrupted is returned when an index node is malformed.
+	ErrNodeCorrupted = errors.New("index node corruption detected")
+
+	// ErrUnsupportedFeature is returned when a file uses an incompatible
+	// feature that this version does not know.
+	ErrUnsupportedFeature = errors.New("unsupported file format feature")
+
+	// ErrUnsupportedVersion is returned when a file was written in a format
+	// version that this version does not know.
+	ErrUnsupportedVersion = errors.New("unsupported file format version")
+)
+
+const (
+	// Magic is the first byte of an Arc file.
+	Magic = byte(0x41)
+
+	// Version1 is the first version of the file format.
+	Version1 = uint8(1)
+
+	// Version2 adds feature bitmaps, record and node counts, the root offset
+	// and the sequence number to the header.
+	Version2 = uint8(2)
+
+	// CurrentVersion is the version that writers produce.
+	CurrentVersion = Version2
+
+	// ChecksumSize is the size of a CRC32 checksum in bytes.
+	ChecksumSize = 4
+
+	// BlobIDSize is the size of a blob ID, which is the SHA-256 hash of the
+	// blob value.
+	BlobIDSize = 32
+
+	// MaxInlineValueSize is the maximum size of a value that is stored in its
+	// node. Larger values are stored as blobs, and referenced by blob ID.
+	MaxInlineValueSize = BlobIDSize
+
+	// MaxKeySize is the maximum size of a full key, which is the concatenation
+	// of the key segments along its path.
+	MaxKeySize = 1<<16 - 1
+)
+
+// Node flags.
+const (
+	FlagIsRecord  = 1 << iota // The node holds a record.
+	FlagHasBlob               // The data of the record is a blob ID.
+	FlagHasExpiry             // The node carries an expiry time. Requires FeatureNodeExpiry.
+
+	// KnownFlags is the union of the flags of the current format version.
+	KnownFlags = FlagIsRecord | FlagHasBlob | FlagHasExpiry
+)
+
+// Features. A file lists the features it uses in the Compat and Incompat
+// bitmaps of its Header.
+const (
+	// FeatureNodeExpiry is an incompatible feature. Nodes may carry an
+	// expiry time with FlagHasExpiry, which is invalid in files without it.
+	FeatureNodeExpiry = uint32(1) << 0
+
+	// FeatureCompactNodes is an incompatible feature. The nodes of the file
+	// use the compact encoding of AppendCompact instead of the encoding of
+	// Append, and are laid out in breadth-first order.
+	FeatureCompactNodes = uint32(1) << 1
+
+	// FeaturePagedLayout is an incompatible feature. The file is divided
+	// into pages, its nodes use the paged encoding of AppendPaged, and the
+	// Meta holds the root offset and the counts instead of the Header.
+	FeaturePagedLayout = uint32(1) << 2
+
+	// KnownCompatFeatures is the union of the known compatible features.
+	KnownCompatFeatures = uint32(0)
+
+	// KnownIncompatFeatures is the union of the known incompatible features.
+	KnownIncompatFeatures = FeatureNodeExpiry | FeatureCompactNodes | FeaturePagedLayout
+)
+
+// Header statuses.
+const (
+	StatusClosed = uint8(0) // The file was closed cleanly.
+	StatusOpened = uint8(1) // The file is open for writing.
+)
+
+// Field describes a field of an on-disk structure. Fields are listed in the
+// order of their appearance, and every integer field is little-endian.
+type Field struct {
+	Name string // Name of the field in the specification.
+
+	// Offset of the field from the start of the structure, or -1 if the
+	// offset depends on preceding variable-length fields.
+	Offset int
+
+	// Size of the field in bytes, or -1 if the field has a variable length.
+	Size int
+
+	// SizeField names the field that holds the size of a variable-length
+	// field, and is empty for fixed-length fields.
+	SizeField string
+
+	// Flag is non-zero if the field is only present when the flag is set.
+	Flag uint8
+
+	// Varint is true if the field is an unsigned varint, whose size depends
+	// on its value.
+	Varint bool
+
+	// ElemSize is non-zero if the field is an array of elements of ElemSize
+	// bytes, in which case SizeField holds the number of elements.
+	ElemSize int
+}
+
+// HeaderLayout is the layout of the Header of the current version.
+var HeaderLayout = []Field{
+	{Name: "magic", Offset: 0, Size: 1},
+	{Name: "version", Offset: 1, Size: 1},
+	{Name: "status", Offset: 2, Size: 1},
+	{Name: "compat_features", Offset: 3, Size: 4},
+	{Name: "incompat_features", Offset: 7, Size: 4},
+	{Name: "num_nodes", Offset: 11, Size: 8},
+	{Name: "num_records", Offset: 19, Size: 8},
+	{Name: "root_offset", Offset: 27, Size: 8},
+	{Name: "sequence", Offset: 35, Size: 8},
+	{Name: "checksum", Offset: 43, Size: ChecksumSize},
+}
+
+// HeaderV1Layout is the layout of the Header of version 1.
+var HeaderV1Layout = []Field{
+	{Name: "magic", Offset: 0, Size: 1},
+	{Name: "version", Offset: 1, Size: 1},
+	{Name: "status", Offset: 2, Size: 1},
+	{Name: "checksum", Offset: 3, Size: ChecksumSize},
+}
+
+// NodeLayout is the layout of a Node. The checksum covers every preceding
+// field of the node.
+var NodeLayout = []Field{
+	{Name: "flags", Offset: 0, Size: 1},
+	{Name: "num_children", Offset: 1, Size: 2},
+	{Name: "key_len", Offset: 3, Size: 2},
+	{Name: "data_len", Offset: 5, Size: 4},
+	{Name: "first_child_offset", Offset: 9, Size: 8},
+	{Name: "next_sibling_offset", Offset: 17, Size: 8},
+	{Name: "key", Offset: 25, Size: -1, SizeField: "key_len"},
+	{Name: "data", Offset: -1, Size: -1, SizeField: "data_len"},
+	{Name: "expires_at", Offset: -1, Size: 8, Flag: FlagHasExpiry},
+	{Name: "checksum", Offset: -1, Size: ChecksumSize},
+}
+
+// CompactNodeLayout is the layout of a Node in the compact encoding of
+// FeatureCompactNodes. The checksum covers every preceding field of the node.
+var CompactNodeLayout = []Field{
+	{Name: "flags", Offset: 0, Size: 1},
+	{Name: "num_children", Offset: 1, Size: -1, Varint: true},
+	{Name: "key_len", Offset: -1, Size: -1, Varint: true},
+	{Name: "data_len", Offset: -1, Size: -1, Varint: true},
+	{Name: "first_child_delta", Offset: -1, Size: -1, Varint: true},
+	{Name: "key", Offset: -1, Size: -1, SizeField: "key_len"},
+	{Name: "data", Offset: -1, Size: -1, SizeField: "data_len"},
+	{Name: "expires_at", Offset: -1, Size: 8, Flag: FlagHasExpiry},
+	{Name: "checksum", Offset: -1, Size: ChecksumSize},
+}
+
+// PagedNodeLayout is the layout of a Node in the paged encoding of
+// FeaturePagedLayout. The checksum covers every preceding field of the node.
+var PagedNodeLayout = []Field{
+	{Name: "flags", Offset: 0, Size: 1},
+	{Name: "num_children", Offset: 1, Size: 2},
+	{Name: "key_len", Offset: 3, Size: 2},
+	{Name: "data_len", Offset: 5, Size: 4},
+	{Name: "key", Offset: 9, Size: -1, SizeField: "key_len"},
+	{Name: "data", Offset: -1, Size: -1, SizeField: "data_len"},
+	{Name: "blob_offset", Offset: -1, Size: 8, Flag: FlagHasBlob},
+	{Name: "expires_at", Offset: -1, Size: 8, Flag: FlagHasExpiry},
+	{Name: "children", Offset: -1, Size: -1, SizeField: "num_children", ElemSize: 8},
+	{Name: "checksum", Offset: -1, Size: ChecksumSize},
+}
+
+// MetaLayout is the layout of the Meta.
+var MetaLayout = []Field{
+	{Name: "generation", Offset: 0, Size: 8},
+	{Name: "page_size", Offset: 8, Size: 4},
+	{Name: "num_pages", Offset: 12, Size: 8},
+	{Name: "root_offset", Offset: 20, Size: 8},
+	{Name: "num_nodes", Offset: 28, Size: 8},
+	{Name: "num_records", Offset: 36, Size: 8},
+	{Name: "free_map_offset", Offset: 44, Size: 8},
+	{Name: "sequence", Offset: 52, Size: 8},
+	{Name: "checksum", Offset: 60, Size: ChecksumSize},
+}
+
+// BlobLayout is the layout of a blob. The checksum covers the length and the
+// value.
+var BlobLayout = []Field{
+	{Name: "length", Offset: 0, Size: 4},
+	{Name: "value", Offset: 4, Size: -1, SizeField: "length"},
+	{Name: "checksum", Offset: -1, Size: ChecksumSize},
+}
+
+// Checksum returns the IEEE CRC32 checksum of src.
+func Checksum(src []byte) uint32 {
+	return crc32.ChecksumIEEE(src)
+}
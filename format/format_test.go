@@ -0,0 +1,1027 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header Header
+	}{
+		{"new header", NewHeader()},
+		{"version 1", Header{Magic: Magic, Version: Version1, Status: StatusOpened}},
+		{
+			name: "version 2",
+			header: Header{
+				Magic:      Magic,
+				Version:    Version2,
+				Status:     StatusOpened,
+				Compat:     0x01020304,
+				Incompat:   0x11121314,
+				NumNodes:   0x2122232425262728,
+				NumRecords: 0x3132333435363738,
+				RootOffset: HeaderV2Size,
+				Sequence:   0x4142434445464748,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src, err := tc.header.Append(nil)
+
+			if err != nil {
+				t.Fatalf("Append(): %v", err)
+			}
+
+			if len(src) != tc.header.Size() {
+				t.Fatalf("unexpected size: got:%d, want:%d", len(src), tc.header.Size())
+			}
+
+			got, err := DecodeHeader(src)
+
+			if err != nil {
+				t.Fatalf("DecodeHeader(): %v", err)
+			}
+
+			if got != tc.header {
+				t.Errorf("unexpected header: got:%+v, want:%+v", got, tc.header)
+			}
+
+			src[2] ^= 0x01
+
+			if _, err := DecodeHeader(src); !errors.Is(err, ErrInvalidChecksum) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidChecksum)
+			}
+
+			if _, err := DecodeHeader(src[:len(src)-1]); !errors.Is(err, ErrCorrupted) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrCorrupted)
+			}
+		})
+	}
+}
+
+func TestHeaderErrors(t *testing.T) {
+	if _, err := (Header{Magic: Magic, Version: 0xff}).Append(nil); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("unexpected Append() error: got:%v, want:%v", err, ErrUnsupportedVersion)
+	}
+
+	testCases := []struct {
+		name    string
+		src     []byte
+		wantErr error
+	}{
+		{"empty", nil, ErrCorrupted},
+		{"magic only", []byte{Magic}, ErrCorrupted},
+		{"invalid magic", []byte{0x00, Version2, StatusClosed}, ErrCorrupted},
+		{"unknown version", []byte{Magic, 0xff, StatusClosed}, ErrUnsupportedVersion},
+		{"truncated version 2", []byte{Magic, Version2, StatusClosed, 0, 0, 0, 0}, ErrCorrupted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := DecodeHeader(tc.src); !errors.Is(err, tc.wantErr) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestHeaderUnknownIncompat(t *testing.T) {
+	h := NewHeader()
+
+	if got := h.UnknownIncompat(); got != 0 {
+		t.Errorf("unexpected unknown features: got:%#x, want:0", got)
+	}
+
+	h.Incompat = KnownIncompatFeatures | 1<<31
+	h.Compat = 1 << 30
+
+	if got := h.UnknownIncompat(); got != 1<<31 {
+		t.Errorf("unexpected unknown features: got:%#x, want:%#x", got, uint32(1<<31))
+	}
+}
+
+func TestNode(t *testing.T) {
+	id := BlobID([]byte("blob value"))
+
+	testCases := []struct {
+		name string
+		node Node
+	}{
+		{"non-record node", Node{NumChildren: 2, FirstChild: 64, Key: []byte("app"), Data: []byte{}}},
+		{"record node", Node{Flags: FlagIsRecord, NextSibling: 128, Key: []byte("apple"), Data: []byte("fruit")}},
+		{"blob record node", Node{Flags: FlagIsRecord | FlagHasBlob, Key: []byte("b"), Data: id[:]}},
+		{"expiring record node", Node{Flags: FlagIsRecord | FlagHasExpiry, Key: []byte{}, Data: []byte{}, ExpiresAt: -1}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src, err := tc.node.Append([]byte("prefix"))
+
+			if err != nil {
+				t.Fatalf("Append(): %v", err)
+			}
+
+			src = src[len("prefix"):]
+
+			if len(src) != tc.node.Size() {
+				t.Fatalf("unexpected size: got:%d, want:%d", len(src), tc.node.Size())
+			}
+
+			// Trailing bytes belong to the next structure.
+			got, size, err := DecodeNode(append(src, 0xff))
+
+			if err != nil {
+				t.Fatalf("DecodeNode(): %v", err)
+			}
+
+			if size != len(src) {
+				t.Errorf("unexpected size: got:%d, want:%d", size, len(src))
+			}
+
+			if got.Flags != tc.node.Flags || got.NumChildren != tc.node.NumChildren ||
+				got.FirstChild != tc.node.FirstChild || got.NextSibling != tc.node.NextSibling ||
+				!bytes.Equal(got.Key, tc.node.Key) || !bytes.Equal(got.Data, tc.node.Data) ||
+				got.ExpiresAt != tc.node.ExpiresAt {
+				t.Errorf("unexpected node: got:%+v, want:%+v", got, tc.node)
+			}
+
+			for i := range src {
+				corrupted := bytes.Clone(src)
+				corrupted[i] ^= 0x01
+
+				if _, _, err := DecodeNode(corrupted); err == nil {
+					t.Errorf("undetected corruption at offset %d", i)
+				}
+			}
+
+			if _, _, err := DecodeNode(src[:len(src)-1]); !errors.Is(err, ErrNodeCorrupted) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrNodeCorrupted)
+			}
+		})
+	}
+}
+
+func TestCompactNode(t *testing.T) {
+	id := BlobID([]byte("blob value"))
+
+	testCases := []struct {
+		name   string
+		node   Node
+		offset uint64
+	}{
+		{"non-record node", Node{NumChildren: 2, FirstChild: 1064, Key: []byte("app"), Data: []byte{}}, 1000},
+		{"record node", Node{Flags: FlagIsRecord, Key: []byte("apple"), Data: []byte("fruit")}, 39},
+		{"blob record node", Node{Flags: FlagIsRecord | FlagHasBlob, Key: []byte("b"), Data: id[:]}, 39},
+		{"expiring record node", Node{Flags: FlagIsRecord | FlagHasExpiry, Key: []byte{}, Data: []byte{}, ExpiresAt: -1}, 39},
+		{"far child", Node{NumChildren: 256, FirstChild: math.MaxUint64, Key: bytes.Repeat([]byte("k"), 300)}, 39},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src, err := tc.node.AppendCompact([]byte("prefix"), tc.offset)
+
+			if err != nil {
+				t.Fatalf("AppendCompact(): %v", err)
+			}
+
+			src = src[len("prefix"):]
+
+			if len(src) != tc.node.CompactSize(tc.offset) {
+				t.Fatalf("unexpected size: got:%d, want:%d", len(src), tc.node.CompactSize(tc.offset))
+			}
+
+			if len(src) >= tc.node.Size() {
+				t.Errorf("compact node is not smaller: got:%d, fixed:%d", len(src), tc.node.Size())
+			}
+
+			// Trailing bytes belong to the next structure.
+			got, size, err := DecodeCompactNode(append(src, 0xff), tc.offset)
+
+			if err != nil {
+				t.Fatalf("DecodeCompactNode(): %v", err)
+			}
+
+			if size != len(src) {
+				t.Errorf("unexpected size: got:%d, want:%d", size, len(src))
+			}
+
+			if got.Flags != tc.node.Flags || got.NumChildren != tc.node.NumChildren ||
+				got.FirstChild != tc.node.FirstChild || got.NextSibling != 0 ||
+				!bytes.Equal(got.Key, tc.node.Key) || !bytes.Equal(got.Data, tc.node.Data) ||
+				got.ExpiresAt != tc.node.ExpiresAt {
+				t.Errorf("unexpected node: got:%+v, want:%+v", got, tc.node)
+			}
+
+			for i := range src {
+				corrupted := bytes.Clone(src)
+				corrupted[i] ^= 0x01
+
+				if _, _, err := DecodeCompactNode(corrupted, tc.offset); err == nil {
+					t.Errorf("undetected corruption at offset %d", i)
+				}
+			}
+
+			if _, _, err := DecodeCompactNode(src[:len(src)-1], tc.offset); !errors.Is(err, ErrNodeCorrupted) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrNodeCorrupted)
+			}
+		})
+	}
+}
+
+func TestCompactNodeErrors(t *testing.T) {
+	appendCases := []struct {
+		name string
+		node Node
+	}{
+		{"children without first child", Node{NumChildren: 1}},
+		{"first child without children", Node{FirstChild: 100}},
+		{"first child before the node", Node{NumChildren: 1, FirstChild: 10}},
+		{"first child inside the node", Node{NumChildren: 1, FirstChild: 41}},
+		{"oversized key", Node{Key: make([]byte, math.MaxUint16+1)}},
+	}
+
+	for _, tc := range appendCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.node.AppendCompact(nil, 39); !errors.Is(err, ErrNodeCorrupted) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrNodeCorrupted)
+			}
+		})
+	}
+
+	// encode returns a node of the given varint fields with a valid checksum.
+	encode := func(fields ...[]byte) []byte {
+		src := []byte{0}
+
+		for _, f := range fields {
+			src = append(src, f...)
+		}
+
+		return binary.LittleEndian.AppendUint32(src, Checksum(src))
+	}
+
+	decodeCases := []struct {
+		name string
+		src  []byte
+	}{
+		{"overlong varint", encode([]byte{0x80, 0x00}, []byte{0}, []byte{0}, []byte{0})},
+		{"children without first child", encode([]byte{1}, []byte{0}, []byte{0}, []byte{0})},
+		{"first child without children", encode([]byte{0}, []byte{0}, []byte{0}, []byte{100})},
+		{"first child inside the node", encode([]byte{1}, []byte{0}, []byte{0}, []byte{1})},
+		{"too many children", encode([]byte{0x80, 0x80, 0x04}, []byte{0}, []byte{0}, []byte{100})},
+		{"oversized key length", encode([]byte{0}, []byte{0x80, 0x80, 0x04}, []byte{0}, []byte{0})},
+	}
+
+	for _, tc := range decodeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := DecodeCompactNode(tc.src, 39); !errors.Is(err, ErrNodeCorrupted) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrNodeCorrupted)
+			}
+		})
+	}
+
+	// The first child offset must not overflow.
+	src := encode([]byte{1}, []byte{0}, binary.AppendUvarint(nil, 0), binary.AppendUvarint(nil, math.MaxUint64))
+
+	if _, _, err := DecodeCompactNode(src, 39); !errors.Is(err, ErrNodeCorrupted) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrNodeCorrupted)
+	}
+}
+
+func TestPagedNode(t *testing.T) {
+	id := BlobID([]byte("blob value"))
+
+	testCases := []struct {
+		name string
+		node Node
+	}{
+		{"non-record node", Node{NumChildren: 2, Key: []byte("app"), Data: []byte{}, Children: []uint64{12288, 12400}}},
+		{"record node", Node{Flags: FlagIsRecord, Key: []byte("apple"), Data: []byte("fruit"), Children: []uint64{}}},
+		{"blob record node", Node{Flags: FlagIsRecord | FlagHasBlob, Key: []byte("b"), Data: id[:], BlobOffset: 16384, Children: []uint64{}}},
+		{"expiring record node", Node{Flags: FlagIsRecord | FlagHasExpiry, Key: []byte{}, Data: []byte{}, ExpiresAt: -1, Children: []uint64{}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src, err := tc.node.AppendPaged([]byte("prefix"))
+
+			if err != nil {
+				t.Fatalf("AppendPaged(): %v", err)
+			}
+
+			src = src[len("prefix"):]
+
+			if len(src) != tc.node.PagedSize() {
+				t.Fatalf("unexpected size: got:%d, want:%d", len(src), tc.node.PagedSize())
+			}
+
+			// Trailing bytes belong to the next structure.
+			got, size, err := DecodePagedNode(append(src, 0xff))
+
+			if err != nil {
+				t.Fatalf("DecodePagedNode(): %v", err)
+			}
+
+			if size != len(src) {
+				t.Errorf("unexpected size: got:%d, want:%d", size, len(src))
+			}
+
+			if got.Flags != tc.node.Flags || int(got.NumChildren) != len(tc.node.Children) ||
+				!slices.Equal(got.Children, tc.node.Children) || got.BlobOffset != tc.node.BlobOffset ||
+				!bytes.Equal(got.Key, tc.node.Key) || !bytes.Equal(got.Data, tc.node.Data) ||
+				got.ExpiresAt != tc.node.ExpiresAt {
+				t.Errorf("unexpected node: got:%+v, want:%+v", got, tc.node)
+			}
+
+			for i := range src {
+				corrupted := bytes.Clone(src)
+				corrupted[i] ^= 0x01
+
+				if _, _, err := DecodePagedNode(corrupted); err == nil {
+					t.Errorf("undetected corruption at offset %d", i)
+				}
+			}
+
+			if _, _, err := DecodePagedNode(src[:len(src)-1]); !errors.Is(err, ErrNodeCorrupted) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrNodeCorrupted)
+			}
+		})
+	}
+
+	if _, err := (Node{Children: make([]uint64, math.MaxUint16+1)}).AppendPaged(nil); !errors.Is(err, ErrNodeCorrupted) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrNodeCorrupted)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	meta := Meta{
+		Generation:    5,
+		PageSize:      PageSize,
+		NumPages:      10,
+		RootOffset:    4*PageSize + 100,
+		NumNodes:      3,
+		NumRecords:    2,
+		FreeMapOffset: 9 * PageSize,
+		Sequence:      7,
+	}
+
+	src := meta.Append(nil)
+
+	if len(src) != MetaSize {
+		t.Fatalf("unexpected size: got:%d, want:%d", len(src), MetaSize)
+	}
+
+	got, err := DecodeMeta(src)
+
+	if err != nil || got != meta {
+		t.Fatalf("unexpected meta: got:%+v, %v, want:%+v", got, err, meta)
+	}
+
+	if MetaOffset(meta.Generation) != 2*PageSize || MetaOffset(meta.Generation+1) != PageSize {
+		t.Errorf("generations do not alternate between the slots")
+	}
+
+	testCases := []struct {
+		name    string
+		modify  func(m *Meta)
+		wantErr error
+	}{
+		{"page size", func(m *Meta) { m.PageSize = 512 }, ErrCorrupted},
+		{"too few pages", func(m *Meta) { m.NumPages = NumReservedPages - 1 }, ErrCorrupted},
+		{"too many pages", func(m *Meta) { m.NumPages = math.MaxUint64 }, ErrCorrupted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := meta
+			tc.modify(&m)
+
+			if _, err := DecodeMeta(m.Append(nil)); !errors.Is(err, tc.wantErr) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, tc.wantErr)
+			}
+		})
+	}
+
+	src[0] ^= 0x01
+
+	if _, err := DecodeMeta(src); !errors.Is(err, ErrInvalidChecksum) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidChecksum)
+	}
+
+	if _, err := DecodeMeta(src[:MetaSize-1]); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrCorrupted)
+	}
+
+	// Bit i%8 of byte i/8 marks page i.
+	bitmap := make([]byte, FreeMapSize(10))
+	bitmap[1] = 0x02
+
+	if len(bitmap) != 2 || !PageInUse(bitmap, 9) || PageInUse(bitmap, 8) {
+		t.Errorf("unexpected free-space map: %x", bitmap)
+	}
+}
+
+func TestBlob(t *testing.T) {
+	for _, value := range [][]byte{{}, []byte("value"), bytes.Repeat([]byte{0xab}, 1000)} {
+		src, err := AppendBlob(nil, value)
+
+		if err != nil {
+			t.Fatalf("AppendBlob(): %v", err)
+		}
+
+		if len(src) != BlobSize(len(value)) {
+			t.Fatalf("unexpected size: got:%d, want:%d", len(src), BlobSize(len(value)))
+		}
+
+		got, size, err := DecodeBlob(src)
+
+		if err != nil {
+			t.Fatalf("DecodeBlob(): %v", err)
+		}
+
+		if !bytes.Equal(got, value) || size != len(src) {
+			t.Errorf("unexpected blob: got:(%q, %d), want:(%q, %d)", got, size, value, len(src))
+		}
+
+		if _, _, err := DecodeBlob(src[:len(src)-1]); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("unexpected error: got:%v, want:%v", err, ErrCorrupted)
+		}
+
+		src[len(src)-1] ^= 0x01
+
+		if _, _, err := DecodeBlob(src); !errors.Is(err, ErrInvalidChecksum) {
+			t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidChecksum)
+		}
+	}
+}
+
+// fieldValue returns the value of an encoded integer field, which is either
+// little-endian or a varint.
+func fieldValue(src []byte, varint bool) uint64 {
+	if varint {
+		v, _ := binary.Uvarint(src)
+		return v
+	}
+
+	var buf [8]byte
+	copy(buf[:], src)
+
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+// layoutFields returns the encoded fields of a structure by name, following
+// the machine-readable layout. The sizes of variable-length fields are read
+// from the integer fields that they name.
+func layoutFields(t *testing.T, layout []Field, flags uint8, src []byte) map[string][]byte {
+	t.Helper()
+
+	ret := map[string][]byte{}
+	varints := map[string]bool{}
+	pos := 0
+
+	for _, f := range layout {
+		varints[f.Name] = f.Varint
+
+		if f.Flag != 0 && flags&f.Flag == 0 {
+			continue
+		}
+
+		if f.Offset >= 0 && f.Offset != pos {
+			t.Fatalf("field %q: unexpected offset: got:%d, want:%d", f.Name, pos, f.Offset)
+		}
+
+		size := f.Size
+
+		if f.Varint {
+			if _, size = binary.Uvarint(src[pos:]); size <= 0 {
+				t.Fatalf("field %q: invalid varint", f.Name)
+			}
+		}
+
+		if f.SizeField != "" {
+			size = int(fieldValue(ret[f.SizeField], varints[f.SizeField]))
+		}
+
+		if f.ElemSize != 0 {
+			size *= f.ElemSize
+		}
+
+		if pos+size > len(src) {
+			t.Fatalf("field %q exceeds the structure", f.Name)
+		}
+
+		ret[f.Name] = src[pos : pos+size]
+		pos += size
+	}
+
+	if pos != len(src) {
+		t.Fatalf("layout covers %d of %d bytes", pos, len(src))
+	}
+
+	return ret
+}
+
+func TestLayouts(t *testing.T) {
+	header, err := Header{Magic: Magic, Version: Version2, RootOffset: HeaderV2Size}.Append(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := layoutFields(t, HeaderLayout, 0, header)
+
+	if fields["magic"][0] != Magic || fields["version"][0] != Version2 || fields["root_offset"][0] != HeaderV2Size {
+		t.Errorf("unexpected header fields: %v", fields)
+	}
+
+	header, err = Header{Magic: Magic, Version: Version1}.Append(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fields := layoutFields(t, HeaderV1Layout, 0, header); fields["version"][0] != Version1 {
+		t.Errorf("unexpected version 1 header fields: %v", fields)
+	}
+
+	n := Node{
+		Flags:       FlagIsRecord | FlagHasExpiry,
+		NumChildren: 3,
+		FirstChild:  0x0102030405060708,
+		NextSibling: 0x1112131415161718,
+		Key:         []byte("key"),
+		Data:        []byte("data"),
+		ExpiresAt:   0x2122232425262728,
+	}
+
+	src, err := n.Append(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields = layoutFields(t, NodeLayout, n.Flags, src)
+
+	testCases := []struct {
+		field string
+		want  uint64
+	}{
+		{"flags", uint64(n.Flags)},
+		{"num_children", uint64(n.NumChildren)},
+		{"key_len", uint64(len(n.Key))},
+		{"data_len", uint64(len(n.Data))},
+		{"first_child_offset", n.FirstChild},
+		{"next_sibling_offset", n.NextSibling},
+		{"expires_at", uint64(n.ExpiresAt)},
+		{"checksum", uint64(Checksum(src[:len(src)-ChecksumSize]))},
+	}
+
+	for _, tc := range testCases {
+		var buf [8]byte
+		copy(buf[:], fields[tc.field])
+
+		if got := binary.LittleEndian.Uint64(buf[:]); got != tc.want {
+			t.Errorf("field %q: got:%#x, want:%#x", tc.field, got, tc.want)
+		}
+	}
+
+	if string(fields["key"]) != "key" || string(fields["data"]) != "data" {
+		t.Errorf("unexpected variable-length fields: %q, %q", fields["key"], fields["data"])
+	}
+
+	blob, err := AppendBlob(nil, []byte("value"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fields := layoutFields(t, BlobLayout, 0, blob); string(fields["value"]) != "value" {
+		t.Errorf("unexpected blob value: %q", fields["value"])
+	}
+
+	// The compact encoding holds the same values in varints.
+	n.NextSibling = 0
+	n.FirstChild = 1000
+
+	if src, err = n.AppendCompact(nil, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	fields = layoutFields(t, CompactNodeLayout, n.Flags, src)
+
+	if got := fieldValue(fields["first_child_delta"], true); got != 900 {
+		t.Errorf("unexpected first child delta: got:%d, want:900", got)
+	}
+
+	if got := fieldValue(fields["num_children"], true); got != uint64(n.NumChildren) {
+		t.Errorf("unexpected number of children: got:%d, want:%d", got, n.NumChildren)
+	}
+
+	if string(fields["key"]) != "key" || string(fields["data"]) != "data" {
+		t.Errorf("unexpected variable-length fields: %q, %q", fields["key"], fields["data"])
+	}
+
+	// The paged encoding lists the offsets of the children instead.
+	n.Flags |= FlagHasBlob
+	n.Children = []uint64{0x3132333435363738, 2, 3}
+	n.BlobOffset = 0x4142434445464748
+
+	if src, err = n.AppendPaged(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	fields = layoutFields(t, PagedNodeLayout, n.Flags, src)
+
+	if got := fieldValue(fields["blob_offset"], false); got != n.BlobOffset {
+		t.Errorf("unexpected blob offset: got:%#x, want:%#x", got, n.BlobOffset)
+	}
+
+	if got := fieldValue(fields["children"][:8], false); len(fields["children"]) != 24 || got != n.Children[0] {
+		t.Errorf("unexpected children: %x", fields["children"])
+	}
+
+	meta := Meta{Generation: 7, PageSize: PageSize, NumPages: 9, RootOffset: 0x5152535455565758, FreeMapOffset: 3 * PageSize}
+	fields = layoutFields(t, MetaLayout, 0, meta.Append(nil))
+
+	if fieldValue(fields["generation"], false) != meta.Generation || fieldValue(fields["root_offset"], false) != meta.RootOffset {
+		t.Errorf("unexpected meta fields: %v", fields)
+	}
+}
+
+// vectorRecord is a record of a conformance vector in testdata.
+type vectorRecord struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// readFile decodes an Arc file into its records in ascending key order, using
+// nothing but this package. It is the reference for tools that read Arc files.
+func readFile(t *testing.T, src []byte) []vectorRecord {
+	t.Helper()
+
+	header, err := DecodeHeader(src)
+
+	if err != nil || header.UnknownIncompat() != 0 {
+		t.Fatalf("invalid header: %+v, %v", header, err)
+	}
+
+	// Version 1 has no root offset, and the root follows the header.
+	root := header.RootOffset
+
+	if header.Version == Version1 && len(src) > HeaderV1Size {
+		root = HeaderV1Size
+	}
+
+	// Decode the nodes first, since the blobs follow the last node.
+	type visit struct {
+		path []byte
+		n    Node
+	}
+
+	var visits []visit
+
+	end := header.Size()
+
+	compact := header.Incompat&FeatureCompactNodes != 0
+	paged := header.Incompat&FeaturePagedLayout != 0
+
+	// The root of a paged file is published by the valid meta with the
+	// highest generation, whose slot matches its generation.
+	if paged {
+		var current Meta
+
+		for slot := range uint64(2) {
+			meta, err := DecodeMeta(src[MetaOffset(slot):])
+
+			if err == nil && MetaOffset(meta.Generation) == MetaOffset(slot) && meta.Generation >= current.Generation {
+				current = meta
+			}
+		}
+
+		if current.PageSize != PageSize {
+			t.Fatal("no valid meta")
+		}
+
+		root = current.RootOffset
+	}
+
+	// In the compact encoding, siblings are contiguous, and the walk stops
+	// after the given number of siblings.
+	var walk func(offset uint64, siblings int, path []byte)
+	walk = func(offset uint64, siblings int, path []byte) {
+		for ; offset != 0 && siblings > 0; siblings-- {
+			var n Node
+			var size int
+
+			switch {
+			case paged:
+				n, size, err = DecodePagedNode(src[offset:])
+			case compact:
+				n, size, err = DecodeCompactNode(src[offset:], offset)
+			default:
+				n, size, err = DecodeNode(src[offset:])
+			}
+
+			if err != nil {
+				t.Fatalf("decode node at %d: %v", offset, err)
+			}
+
+			if n.HasExpiry() && header.Incompat&FeatureNodeExpiry == 0 {
+				t.Fatalf("node at %d has an expiry without the node_expiry feature", offset)
+			}
+
+			end = max(end, int(offset)+size)
+			full := append(bytes.Clone(path), n.Key...)
+			visits = append(visits, visit{path: full, n: n})
+
+			// Paged nodes list the offsets of their children.
+			if paged {
+				for _, child := range n.Children {
+					walk(child, 1, full)
+				}
+
+				continue
+			}
+
+			walk(n.FirstChild, int(n.NumChildren), full)
+
+			if compact {
+				offset += uint64(size)
+			} else {
+				offset = n.NextSibling
+			}
+		}
+	}
+
+	if root != 0 {
+		walk(root, 1, nil)
+	}
+
+	// Breadth-first layouts do not visit the records in key order.
+	slices.SortFunc(visits, func(x, y visit) int {
+		return bytes.Compare(x.path, y.path)
+	})
+
+	blobs := map[[BlobIDSize]byte][]byte{}
+
+	// Paged files have no blob region, and every blob is found at the offset
+	// that its record holds.
+	for _, v := range visits {
+		if paged && v.n.HasBlob() {
+			value, _, err := DecodeBlob(src[v.n.BlobOffset:])
+
+			if err != nil {
+				t.Fatalf("DecodeBlob(%d): %v", v.n.BlobOffset, err)
+			}
+
+			blobs[BlobID(value)] = value
+		}
+	}
+
+	for pos := end; !paged && pos < len(src); {
+		value, size, err := DecodeBlob(src[pos:])
+
+		if err != nil {
+			t.Fatalf("DecodeBlob(%d): %v", pos, err)
+		}
+
+		blobs[BlobID(value)] = value
+		pos += size
+	}
+
+	ret := []vectorRecord{}
+
+	for _, v := range visits {
+		if !v.n.IsRecord() {
+			continue
+		}
+
+		value := v.n.Data
+
+		if v.n.HasBlob() {
+			value = blobs[[BlobIDSize]byte(v.n.Data)]
+		}
+
+		ret = append(ret, vectorRecord{
+			Key:       hex.EncodeToString(v.path),
+			Value:     hex.EncodeToString(value),
+			ExpiresAt: v.n.ExpiresAt,
+		})
+	}
+
+	return ret
+}
+
+func TestConformanceVectors(t *testing.T) {
+	names, err := filepath.Glob(filepath.Join("..", "testdata", "v*", "*.arc"))
+
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no conformance vectors: %v", err)
+	}
+
+	// The vectors of the compact node encoding and of the paged layout share
+	// the expected contents of the vectors in their parent directory.
+	for _, feature := range []string{"compact", "paged"} {
+		vectors, err := filepath.Glob(filepath.Join("..", "testdata", "v*", feature, "*.arc"))
+
+		if err != nil || len(vectors) == 0 {
+			t.Fatalf("no %s conformance vectors: %v", feature, err)
+		}
+
+		names = append(names, vectors...)
+	}
+
+	for _, name := range names {
+		rel, _ := filepath.Rel(filepath.Join("..", "testdata"), name)
+		base := filepath.Base(name[:len(name)-len(".arc")])
+		dir := filepath.Dir(name)
+
+		if d := filepath.Base(dir); d == "compact" || d == "paged" {
+			dir = filepath.Dir(dir)
+		}
+
+		t.Run(rel, func(t *testing.T) {
+			src, err := os.ReadFile(name)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			contents, err := os.ReadFile(filepath.Join(dir, base+".json"))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want struct {
+				Records []vectorRecord `json:"records"`
+			}
+
+			if err := json.Unmarshal(contents, &want); err != nil {
+				t.Fatal(err)
+			}
+
+			got := readFile(t, src)
+
+			if len(got) != len(want.Records) {
+				t.Fatalf("unexpected number of records: got:%d, want:%d", len(got), len(want.Records))
+			}
+
+			for i := range got {
+				if got[i] != want.Records[i] {
+					t.Errorf("record %d: got:%+v, want:%+v", i, got[i], want.Records[i])
+				}
+			}
+		})
+	}
+}
+
+func FuzzDecodeNode(f *testing.F) {
+	n := Node{Flags: FlagIsRecord | FlagHasExpiry, Key: []byte("key"), Data: []byte("data"), ExpiresAt: 1}
+	src, err := n.Append(nil)
+
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(src)
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		n, size, err := DecodeNode(src)
+
+		if err != nil {
+			if !errors.Is(err, ErrNodeCorrupted) && !errors.Is(err, ErrInvalidChecksum) {
+				t.Fatalf("untyped error: %v", err)
+			}
+
+			return
+		}
+
+		got, err := n.Append(nil)
+
+		if err != nil {
+			t.Fatalf("Append(): %v", err)
+		}
+
+		if !bytes.Equal(got, src[:size]) {
+			t.Fatalf("node does not round-trip: got:%x, want:%x", got, src[:size])
+		}
+	})
+}
+
+func FuzzDecodeCompactNode(f *testing.F) {
+	n := Node{Flags: FlagIsRecord | FlagHasExpiry, NumChildren: 1, FirstChild: 1000, Key: []byte("key"), Data: []byte("data"), ExpiresAt: 1}
+	src, err := n.AppendCompact(nil, 100)
+
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(src, uint64(100))
+
+	f.Fuzz(func(t *testing.T, src []byte, offset uint64) {
+		n, size, err := DecodeCompactNode(src, offset)
+
+		if err != nil {
+			if !errors.Is(err, ErrNodeCorrupted) && !errors.Is(err, ErrInvalidChecksum) {
+				t.Fatalf("untyped error: %v", err)
+			}
+
+			return
+		}
+
+		if n.FirstChild != 0 && n.FirstChild < offset+uint64(size) {
+			t.Fatalf("first child %d precedes the end of the node at %d", n.FirstChild, offset+uint64(size))
+		}
+
+		got, err := n.AppendCompact(nil, offset)
+
+		if err != nil {
+			t.Fatalf("AppendCompact(): %v", err)
+		}
+
+		if !bytes.Equal(got, src[:size]) {
+			t.Fatalf("node does not round-trip: got:%x, want:%x", got, src[:size])
+		}
+	})
+}
+
+func FuzzDecodePagedNode(f *testing.F) {
+	n := Node{Flags: FlagIsRecord | FlagHasBlob | FlagHasExpiry, Key: []byte("key"), Data: []byte("data"), BlobOffset: 12288, ExpiresAt: 1, Children: []uint64{16384}}
+	src, err := n.AppendPaged(nil)
+
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(src)
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		n, size, err := DecodePagedNode(src)
+
+		if err != nil {
+			if !errors.Is(err, ErrNodeCorrupted) && !errors.Is(err, ErrInvalidChecksum) {
+				t.Fatalf("untyped error: %v", err)
+			}
+
+			return
+		}
+
+		got, err := n.AppendPaged(nil)
+
+		if err != nil {
+			t.Fatalf("AppendPaged(): %v", err)
+		}
+
+		if !bytes.Equal(got, src[:size]) {
+			t.Fatalf("node does not round-trip: got:%x, want:%x", got, src[:size])
+		}
+	})
+}
+
+func FuzzDecodeBlob(f *testing.F) {
+	src, err := AppendBlob(nil, []byte("value"))
+
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(src)
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		value, size, err := DecodeBlob(src)
+
+		if err != nil {
+			if !errors.Is(err, ErrCorrupted) && !errors.Is(err, ErrInvalidChecksum) {
+				t.Fatalf("untyped error: %v", err)
+			}
+
+			return
+		}
+
+		got, err := AppendBlob(nil, value)
+
+		if err != nil {
+			t.Fatalf("AppendBlob(): %v", err)
+		}
+
+		if !bytes.Equal(got, src[:size]) {
+			t.Fatalf("blob does not round-trip: got:%x, want:%x", got, src[:size])
+		}
+	})
+}
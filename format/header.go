@@ -0,0 +1,133 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package format
+
+import "encoding/binary"
+
+const (
+	// HeaderV1Size is the size of a version 1 Header in bytes.
+	HeaderV1Size = 3 + ChecksumSize
+
+	// HeaderV2Size is the size of a version 2 Header in bytes.
+	HeaderV2Size = 3 + 4 + 4 + 8 + 8 + 8 + 8 + ChecksumSize
+
+	// HeaderSize is the size of a Header of the current version in bytes.
+	HeaderSize = HeaderV2Size
+)
+
+// Header is the header at the start of an Arc file. The fields after Status
+// only exist as of version 2, and are zero in headers of version 1.
+type Header struct {
+	Magic   byte  // Always Magic.
+	Version uint8 // Version of the file format.
+	Status  uint8 // StatusClosed or StatusOpened.
+
+	// Compat is the set of compatible features that the file uses. Readers
+	// that do not know a compatible feature may ignore it.
+	Compat uint32
+
+	// Incompat is the set of incompatible features that the file uses.
+	// Readers must refuse files with incompatible features they do not know.
+	Incompat uint32
+
+	NumNodes   uint64 // Number of index nodes.
+	NumRecords uint64 // Number of records.
+	RootOffset uint64 // Offset of the root node, or 0 if there is none.
+	Sequence   uint64 // Sequence number of the last mutation of the contents.
+}
+
+// NewHeader returns the header of a closed file of the current version.
+func NewHeader() Header {
+	return Header{Magic: Magic, Version: CurrentVersion, Status: StatusClosed}
+}
+
+// HeaderSizeOf returns the size of a header of the given version in bytes,
+// or 0 if the version is unknown.
+func HeaderSizeOf(version uint8) int {
+	switch version {
+	case Version1:
+		return HeaderV1Size
+	case Version2:
+		return HeaderV2Size
+	default:
+		return 0
+	}
+}
+
+// Size returns the size of the encoded header in bytes, or 0 if its version
+// is unknown.
+func (h Header) Size() int {
+	return HeaderSizeOf(h.Version)
+}
+
+// UnknownIncompat returns the incompatible features of the header that the
+// current version of this package does not know.
+func (h Header) UnknownIncompat() uint32 {
+	return h.Incompat &^ KnownIncompatFeatures
+}
+
+// Append appends the encoded header to dst and returns the extended buffer.
+// The header is encoded in the layout of its version, and ErrUnsupportedVersion
+// is returned if the version is unknown.
+func (h Header) Append(dst []byte) ([]byte, error) {
+	start := len(dst)
+
+	switch h.Version {
+	case Version1:
+		dst = append(dst, h.Magic, h.Version, h.Status)
+	case Version2:
+		dst = append(dst, h.Magic, h.Version, h.Status)
+		dst = binary.LittleEndian.AppendUint32(dst, h.Compat)
+		dst = binary.LittleEndian.AppendUint32(dst, h.Incompat)
+		dst = binary.LittleEndian.AppendUint64(dst, h.NumNodes)
+		dst = binary.LittleEndian.AppendUint64(dst, h.NumRecords)
+		dst = binary.LittleEndian.AppendUint64(dst, h.RootOffset)
+		dst = binary.LittleEndian.AppendUint64(dst, h.Sequence)
+	default:
+		return dst, ErrUnsupportedVersion
+	}
+
+	return binary.LittleEndian.AppendUint32(dst, Checksum(dst[start:])), nil
+}
+
+// DecodeHeader decodes the header at the start of src, in the layout of the
+// version that its second byte names. It returns ErrCorrupted if the magic
+// byte does not match, and ErrUnsupportedVersion if the version is unknown.
+// The checksum is verified, but the features are left to the caller.
+func DecodeHeader(src []byte) (Header, error) {
+	var ret Header
+
+	if len(src) < 2 || src[0] != Magic {
+		return ret, ErrCorrupted
+	}
+
+	size := HeaderSizeOf(src[1])
+
+	if size == 0 {
+		return ret, ErrUnsupportedVersion
+	}
+
+	if len(src) < size {
+		return ret, ErrCorrupted
+	}
+
+	if Checksum(src[:size-ChecksumSize]) != binary.LittleEndian.Uint32(src[size-ChecksumSize:]) {
+		return ret, ErrInvalidChecksum
+	}
+
+	ret.Magic = src[0]
+	ret.Version = src[1]
+	ret.Status = src[2]
+
+	if ret.Version >= Version2 {
+		ret.Compat = binary.LittleEndian.Uint32(src[3:])
+		ret.Incompat = binary.LittleEndian.Uint32(src[7:])
+		ret.NumNodes = binary.LittleEndian.Uint64(src[11:])
+		ret.NumRecords = binary.LittleEndian.Uint64(src[19:])
+		ret.RootOffset = binary.LittleEndian.Uint64(src[27:])
+		ret.Sequence = binary.LittleEndian.Uint64(src[35:])
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,134 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package format
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// NodeFixedSize is the size of the fixed-length fields that begin a Node.
+const NodeFixedSize = 1 + 2 + 2 + 4 + 8 + 8
+
+// Node is an index node of the Radix tree. Offsets are absolute offsets from
+// the start of the file, where zero denotes the absence of a node.
+type Node struct {
+	Flags       uint8  // Combination of FlagIsRecord, FlagHasBlob and FlagHasExpiry.
+	NumChildren uint16 // Number of children of the node.
+	FirstChild  uint64 // Offset of the first child.
+	NextSibling uint64 // Offset of the next sibling.
+	Key         []byte // Key segment of the node.
+	Data        []byte // Inline value, or blob ID if FlagHasBlob is set.
+	ExpiresAt   int64  // Expiry time in Unix nanoseconds if FlagHasExpiry is set.
+
+	// Children holds the offsets of the children in the paged encoding,
+	// which replaces FirstChild and NextSibling.
+	Children []uint64
+
+	// BlobOffset is the offset of the blob in the paged encoding, if
+	// FlagHasBlob is set.
+	BlobOffset uint64
+}
+
+// IsRecord returns true if FlagIsRecord is set.
+func (n Node) IsRecord() bool {
+	return n.Flags&FlagIsRecord != 0
+}
+
+// HasBlob returns true if FlagHasBlob is set.
+func (n Node) HasBlob() bool {
+	return n.Flags&FlagHasBlob != 0
+}
+
+// HasExpiry returns true if FlagHasExpiry is set.
+func (n Node) HasExpiry() bool {
+	return n.Flags&FlagHasExpiry != 0
+}
+
+// Size returns the size of the encoded node in bytes.
+func (n Node) Size() int {
+	return int(nodeSize(n.Flags, uint64(len(n.Key)), uint64(len(n.Data))))
+}
+
+// nodeSize returns the size of an encoded node with the given flags and
+// lengths in bytes.
+func nodeSize(flags uint8, keyLen uint64, dataLen uint64) uint64 {
+	ret := NodeFixedSize + keyLen + dataLen + ChecksumSize
+
+	if flags&FlagHasExpiry != 0 {
+		ret += 8
+	}
+
+	return ret
+}
+
+// Append appends the encoded node to dst and returns the extended buffer. It
+// returns ErrNodeCorrupted if the key or data exceed their length fields.
+func (n Node) Append(dst []byte) ([]byte, error) {
+	if len(n.Key) > math.MaxUint16 || uint64(len(n.Data)) > math.MaxUint32 {
+		return dst, ErrNodeCorrupted
+	}
+
+	start := len(dst)
+
+	dst = append(dst, n.Flags)
+	dst = binary.LittleEndian.AppendUint16(dst, n.NumChildren)
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(len(n.Key)))
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(n.Data)))
+	dst = binary.LittleEndian.AppendUint64(dst, n.FirstChild)
+	dst = binary.LittleEndian.AppendUint64(dst, n.NextSibling)
+	dst = append(dst, n.Key...)
+	dst = append(dst, n.Data...)
+
+	if n.HasExpiry() {
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(n.ExpiresAt))
+	}
+
+	return binary.LittleEndian.AppendUint32(dst, Checksum(dst[start:])), nil
+}
+
+// DecodeNode decodes the node at the start of src, and returns it along with
+// its encoded size. The key and data of the node alias src. It only verifies
+// the encoding of the node, and leaves the validation of its contents and
+// offsets to the caller.
+func DecodeNode(src []byte) (Node, int, error) {
+	var ret Node
+
+	if len(src) < NodeFixedSize+ChecksumSize {
+		return ret, 0, ErrNodeCorrupted
+	}
+
+	ret.Flags = src[0]
+	ret.NumChildren = binary.LittleEndian.Uint16(src[1:])
+	keyLen := uint64(binary.LittleEndian.Uint16(src[3:]))
+	dataLen := uint64(binary.LittleEndian.Uint32(src[5:]))
+	ret.FirstChild = binary.LittleEndian.Uint64(src[9:])
+	ret.NextSibling = binary.LittleEndian.Uint64(src[17:])
+
+	// The lengths are bounded by their field sizes, so the size cannot
+	// overflow, and no allocation depends on them.
+	size := nodeSize(ret.Flags, keyLen, dataLen)
+
+	if uint64(len(src)) < size {
+		return ret, 0, ErrNodeCorrupted
+	}
+
+	checksumPos := size - ChecksumSize
+
+	if Checksum(src[:checksumPos]) != binary.LittleEndian.Uint32(src[checksumPos:]) {
+		return ret, 0, ErrInvalidChecksum
+	}
+
+	pos := uint64(NodeFixedSize)
+	ret.Key = src[pos : pos+keyLen : pos+keyLen]
+	pos += keyLen
+	ret.Data = src[pos : pos+dataLen : pos+dataLen]
+	pos += dataLen
+
+	if ret.HasExpiry() {
+		ret.ExpiresAt = int64(binary.LittleEndian.Uint64(src[pos:]))
+	}
+
+	return ret, int(size), nil
+}
@@ -0,0 +1,220 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package format
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The paged layout of FeaturePagedLayout divides the file into pages of
+// PageSize bytes. The first page holds the Header, and the next two pages hold
+// the two slots of the Meta, of which the valid one with the higher generation
+// is current. Every other object is written into free pages, and is never
+// modified in place. A change is published by writing the Meta of the next
+// generation into the slot of the older one.
+
+const (
+	// PageSize is the size of a page of the paged layout in bytes.
+	PageSize = 4096
+
+	// NumReservedPages is the number of pages at the start of a paged file,
+	// which hold the Header and the two Meta slots.
+	NumReservedPages = 3
+
+	// MetaSize is the size of a Meta in bytes.
+	MetaSize = 8 + 4 + 8 + 8 + 8 + 8 + 8 + 8 + ChecksumSize
+)
+
+// Meta is the root pointer of a paged file, along with the state that it
+// publishes.
+type Meta struct {
+	Generation    uint64 // Incremented by every published change.
+	PageSize      uint32 // Always PageSize.
+	NumPages      uint64 // Number of pages of the file.
+	RootOffset    uint64 // Offset of the root node, or 0 if there is none.
+	NumNodes      uint64 // Number of index nodes.
+	NumRecords    uint64 // Number of records.
+	FreeMapOffset uint64 // Offset of the free-space map.
+	Sequence      uint64 // Sequence number of the last mutation of the contents.
+}
+
+// MetaOffset returns the offset of the Meta slot that the given generation is
+// written to.
+func MetaOffset(generation uint64) uint64 {
+	return (1 + generation%2) * PageSize
+}
+
+// Append appends the encoded meta to dst and returns the extended buffer.
+func (m Meta) Append(dst []byte) []byte {
+	start := len(dst)
+
+	dst = binary.LittleEndian.AppendUint64(dst, m.Generation)
+	dst = binary.LittleEndian.AppendUint32(dst, m.PageSize)
+	dst = binary.LittleEndian.AppendUint64(dst, m.NumPages)
+	dst = binary.LittleEndian.AppendUint64(dst, m.RootOffset)
+	dst = binary.LittleEndian.AppendUint64(dst, m.NumNodes)
+	dst = binary.LittleEndian.AppendUint64(dst, m.NumRecords)
+	dst = binary.LittleEndian.AppendUint64(dst, m.FreeMapOffset)
+	dst = binary.LittleEndian.AppendUint64(dst, m.Sequence)
+
+	return binary.LittleEndian.AppendUint32(dst, Checksum(dst[start:]))
+}
+
+// DecodeMeta decodes the meta at the start of src. It verifies the checksum
+// and the page size, but leaves the validation of the offsets to the caller.
+func DecodeMeta(src []byte) (Meta, error) {
+	var ret Meta
+
+	if len(src) < MetaSize {
+		return ret, ErrCorrupted
+	}
+
+	if Checksum(src[:MetaSize-ChecksumSize]) != binary.LittleEndian.Uint32(src[MetaSize-ChecksumSize:]) {
+		return ret, ErrInvalidChecksum
+	}
+
+	ret.Generation = binary.LittleEndian.Uint64(src)
+	ret.PageSize = binary.LittleEndian.Uint32(src[8:])
+	ret.NumPages = binary.LittleEndian.Uint64(src[12:])
+	ret.RootOffset = binary.LittleEndian.Uint64(src[20:])
+	ret.NumNodes = binary.LittleEndian.Uint64(src[28:])
+	ret.NumRecords = binary.LittleEndian.Uint64(src[36:])
+	ret.FreeMapOffset = binary.LittleEndian.Uint64(src[44:])
+	ret.Sequence = binary.LittleEndian.Uint64(src[52:])
+
+	if ret.PageSize != PageSize || ret.NumPages < NumReservedPages || ret.NumPages > math.MaxUint64/PageSize {
+		return ret, ErrCorrupted
+	}
+
+	return ret, nil
+}
+
+// FreeMapSize returns the size of the bitmap of the free-space map of a file
+// with the given number of pages in bytes. The free-space map is stored as a
+// blob, whose value is the bitmap.
+func FreeMapSize(numPages uint64) uint64 {
+	return (numPages + 7) / 8
+}
+
+// PageInUse returns true if the bitmap of a free-space map marks the page as
+// in use. Bit i%8 of byte i/8 is set if page i is in use.
+func PageInUse(bitmap []byte, page uint64) bool {
+	return bitmap[page/8]&(1<<(page%8)) != 0
+}
+
+// PagedSize returns the size of the paged encoding of the node in bytes.
+func (n Node) PagedSize() int {
+	ret := 1 + 2 + 2 + 4 + len(n.Key) + len(n.Data) + 8*len(n.Children) + ChecksumSize
+
+	if n.HasBlob() {
+		ret += 8
+	}
+
+	if n.HasExpiry() {
+		ret += 8
+	}
+
+	return ret
+}
+
+// AppendPaged appends the paged encoding of the node to dst, and returns the
+// extended buffer. The paged encoding lists the offsets of the children in
+// Children, and holds the offset of the blob in BlobOffset if FlagHasBlob is
+// set. FirstChild and NextSibling are not encoded. It returns
+// ErrNodeCorrupted if the key, data or children exceed their length fields.
+func (n Node) AppendPaged(dst []byte) ([]byte, error) {
+	if len(n.Key) > math.MaxUint16 || uint64(len(n.Data)) > math.MaxUint32 || len(n.Children) > math.MaxUint16 {
+		return dst, ErrNodeCorrupted
+	}
+
+	start := len(dst)
+
+	dst = append(dst, n.Flags)
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(len(n.Children)))
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(len(n.Key)))
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(n.Data)))
+	dst = append(dst, n.Key...)
+	dst = append(dst, n.Data...)
+
+	if n.HasBlob() {
+		dst = binary.LittleEndian.AppendUint64(dst, n.BlobOffset)
+	}
+
+	if n.HasExpiry() {
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(n.ExpiresAt))
+	}
+
+	for _, offset := range n.Children {
+		dst = binary.LittleEndian.AppendUint64(dst, offset)
+	}
+
+	return binary.LittleEndian.AppendUint32(dst, Checksum(dst[start:])), nil
+}
+
+// DecodePagedNode decodes the paged node at the start of src, and returns it
+// along with its encoded size. NumChildren is the length of Children. The key
+// and data of the node alias src, but Children does not.
+func DecodePagedNode(src []byte) (Node, int, error) {
+	var ret Node
+
+	const fixedSize = 1 + 2 + 2 + 4
+
+	if len(src) < fixedSize+ChecksumSize {
+		return ret, 0, ErrNodeCorrupted
+	}
+
+	ret.Flags = src[0]
+	ret.NumChildren = binary.LittleEndian.Uint16(src[1:])
+	keyLen := uint64(binary.LittleEndian.Uint16(src[3:]))
+	dataLen := uint64(binary.LittleEndian.Uint32(src[5:]))
+
+	// The lengths are bounded by their field sizes, so the size cannot
+	// overflow, and no allocation depends on them before the checksum is
+	// verified.
+	size := fixedSize + keyLen + dataLen + 8*uint64(ret.NumChildren) + ChecksumSize
+
+	if ret.HasBlob() {
+		size += 8
+	}
+
+	if ret.HasExpiry() {
+		size += 8
+	}
+
+	if uint64(len(src)) < size {
+		return ret, 0, ErrNodeCorrupted
+	}
+
+	checksumPos := size - ChecksumSize
+
+	if Checksum(src[:checksumPos]) != binary.LittleEndian.Uint32(src[checksumPos:]) {
+		return ret, 0, ErrInvalidChecksum
+	}
+
+	pos := uint64(fixedSize)
+	ret.Key = src[pos : pos+keyLen : pos+keyLen]
+	pos += keyLen
+	ret.Data = src[pos : pos+dataLen : pos+dataLen]
+	pos += dataLen
+
+	if ret.HasBlob() {
+		ret.BlobOffset = binary.LittleEndian.Uint64(src[pos:])
+		pos += 8
+	}
+
+	if ret.HasExpiry() {
+		ret.ExpiresAt = int64(binary.LittleEndian.Uint64(src[pos:]))
+		pos += 8
+	}
+
+	ret.Children = make([]uint64, ret.NumChildren)
+
+	for i := range ret.Children {
+		ret.Children[i] = binary.LittleEndian.Uint64(src[pos:])
+		pos += 8
+	}
+
+	return ret, int(size), nil
+}
@@ -0,0 +1,178 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+// Package workload generates deterministic keys and values for benchmarking
+// Arc. The key distributions resemble common workloads, and the value sizes
+// straddle the 32-byte threshold between inline and blob values.
+package workload
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+)
+
+// Kind identifies a key distribution.
+type Kind int
+
+const (
+	// Sequential keys are big-endian uint64 counters, which share long
+	// prefixes and arrive in ascending order.
+	Sequential Kind = iota
+
+	// Random keys are 16 random bytes, which make the upper levels of the
+	// tree as wide as possible.
+	Random
+
+	// URL keys are URL-like strings with shared scheme, host and path
+	// prefixes.
+	URL
+
+	// IP keys are dotted IPv4 addresses drawn from a few private ranges.
+	IP
+
+	// Zipfian keys are drawn from a keyspace with a Zipfian distribution,
+	// so that a few hot keys recur frequently.
+	Zipfian
+)
+
+// Kinds lists every key distribution.
+var Kinds = []Kind{Sequential, Random, URL, IP, Zipfian}
+
+// String returns the name of the key distribution.
+func (k Kind) String() string {
+	switch k {
+	case Sequential:
+		return "sequential"
+	case Random:
+		return "random"
+	case URL:
+		return "url"
+	case IP:
+		return "ip"
+	case Zipfian:
+		return "zipfian"
+	}
+
+	return "unknown"
+}
+
+// DefaultValueSizes are the value sizes that the generator cycles through by
+// default. They cluster around the 32-byte inline value threshold.
+var DefaultValueSizes = []int{0, 8, 16, 31, 32, 33, 48, 64, 256, 1024}
+
+const (
+	// zipfianKeyspace is the number of distinct Zipfian keys.
+	zipfianKeyspace = 1 << 20
+
+	// zipfianSkew is the s parameter of the Zipfian distribution.
+	zipfianSkew = 1.1
+)
+
+var (
+	urlHosts    = []string{"example.com", "example.org", "cdn.example.net", "api.example.io"}
+	urlSections = []string{"blog", "docs", "products", "users", "images", "search", "static", "news"}
+	urlWords    = []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel"}
+)
+
+// Generator produces a deterministic stream of keys and values. It is not
+// safe for concurrent use.
+type Generator struct {
+	kind       Kind
+	rng        *rand.Rand
+	zipf       *rand.Zipf
+	seq        uint64
+	valueSizes []int
+	numValues  int
+}
+
+// New returns a generator of the given key distribution. Generators with the
+// same kind and seed produce the same stream.
+func New(kind Kind, seed int64) *Generator {
+	rng := rand.New(rand.NewSource(seed))
+
+	return &Generator{
+		kind:       kind,
+		rng:        rng,
+		zipf:       rand.NewZipf(rng, zipfianSkew, 1, zipfianKeyspace-1),
+		valueSizes: DefaultValueSizes,
+	}
+}
+
+// WithValueSizes sets the value sizes that the generator cycles through, and
+// returns the generator.
+func (g *Generator) WithValueSizes(sizes ...int) *Generator {
+	g.valueSizes = sizes
+	return g
+}
+
+// Key returns the next key.
+func (g *Generator) Key() []byte {
+	switch g.kind {
+	case Sequential:
+		g.seq++
+		return binary.BigEndian.AppendUint64(nil, g.seq)
+
+	case Random:
+		ret := make([]byte, 16)
+		g.rng.Read(ret)
+
+		return ret
+
+	case URL:
+		return fmt.Appendf(nil, "https://%s/%s/%s-%s/%d",
+			urlHosts[g.rng.Intn(len(urlHosts))],
+			urlSections[g.rng.Intn(len(urlSections))],
+			urlWords[g.rng.Intn(len(urlWords))],
+			urlWords[g.rng.Intn(len(urlWords))],
+			g.rng.Intn(100000))
+
+	case IP:
+		if g.rng.Intn(2) == 0 {
+			return fmt.Appendf(nil, "10.%d.%d.%d", g.rng.Intn(256), g.rng.Intn(256), g.rng.Intn(256))
+		}
+
+		return fmt.Appendf(nil, "192.168.%d.%d", g.rng.Intn(256), g.rng.Intn(256))
+
+	case Zipfian:
+		return fmt.Appendf(nil, "user/%08d", g.zipf.Uint64())
+	}
+
+	panic(fmt.Sprintf("workload: unknown kind %d", g.kind))
+}
+
+// Keys returns the next n keys.
+func (g *Generator) Keys(n int) [][]byte {
+	ret := make([][]byte, n)
+
+	for i := range ret {
+		ret[i] = g.Key()
+	}
+
+	return ret
+}
+
+// Value returns the next value. The value sizes cycle through the configured
+// sizes, and the contents are random.
+func (g *Generator) Value() []byte {
+	if len(g.valueSizes) == 0 {
+		return nil
+	}
+
+	ret := make([]byte, g.valueSizes[g.numValues%len(g.valueSizes)])
+	g.rng.Read(ret)
+	g.numValues++
+
+	return ret
+}
+
+// Values returns the next n values.
+func (g *Generator) Values(n int) [][]byte {
+	ret := make([][]byte, n)
+
+	for i := range ret {
+		ret[i] = g.Value()
+	}
+
+	return ret
+}
@@ -0,0 +1,88 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package workload
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGeneratorDeterministic(t *testing.T) {
+	for _, kind := range Kinds {
+		t.Run(kind.String(), func(t *testing.T) {
+			a := New(kind, 1)
+			b := New(kind, 1)
+
+			for i := 0; i < 100; i++ {
+				if got, want := a.Key(), b.Key(); !bytes.Equal(got, want) {
+					t.Fatalf("unexpected key: got:%q, want:%q", got, want)
+				}
+
+				if got, want := a.Value(), b.Value(); !bytes.Equal(got, want) {
+					t.Fatalf("unexpected value: got:%q, want:%q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratorKeys(t *testing.T) {
+	testCases := []struct {
+		kind         Kind
+		wantPrefix   []byte
+		wantDistinct bool
+	}{
+		{Sequential, []byte{0, 0, 0, 0, 0}, true},
+		{Random, nil, true},
+		{URL, []byte("https://"), false},
+		{IP, nil, false},
+		{Zipfian, []byte("user/"), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.kind.String(), func(t *testing.T) {
+			keys := New(tc.kind, 1).Keys(1000)
+			seen := map[string]bool{}
+
+			for _, key := range keys {
+				if !bytes.HasPrefix(key, tc.wantPrefix) {
+					t.Fatalf("unexpected key: %q", key)
+				}
+
+				seen[string(key)] = true
+			}
+
+			if tc.wantDistinct && len(seen) != len(keys) {
+				t.Errorf("unexpected duplicate keys: %d distinct", len(seen))
+			}
+		})
+	}
+
+	// Zipfian keys concentrate on a few hot keys.
+	counts := map[string]int{}
+	hottest := 0
+
+	for _, key := range New(Zipfian, 1).Keys(1000) {
+		counts[string(key)]++
+		hottest = max(hottest, counts[string(key)])
+	}
+
+	if hottest < 50 {
+		t.Errorf("unexpected Zipfian skew: the hottest key occurs %d times", hottest)
+	}
+}
+
+func TestGeneratorValues(t *testing.T) {
+	g := New(Random, 1).WithValueSizes(31, 32, 33)
+
+	for i, want := range []int{31, 32, 33, 31} {
+		if got := len(g.Value()); got != want {
+			t.Errorf("value %d: unexpected size: got:%d, want:%d", i, got, want)
+		}
+	}
+
+	if got := New(Random, 1).WithValueSizes().Value(); got != nil {
+		t.Errorf("unexpected value: %q", got)
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+// Package keys implements an order-preserving encoding for composite keys.
+// The encoding is compatible with the FoundationDB tuple layer: the byte
+// order of packed tuples matches the element-wise order of the tuples, and
+// the packed form of a tuple is a prefix of the packed form of every tuple
+// that extends it. This makes packed tuples suitable for prefix and range
+// scans over an Arc database, for example to visit every key of a tenant.
+package keys
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrInvalidTuple is returned when the packed bytes are malformed.
+	ErrInvalidTuple = errors.New("invalid packed tuple")
+
+	// ErrNoSuccessor is returned by Strinc when the prefix has no successor.
+	ErrNoSuccessor = errors.New("prefix has no successor")
+
+	// ErrUnsupportedType is returned when a tuple element cannot be packed.
+	ErrUnsupportedType = errors.New("unsupported tuple element type")
+)
+
+// Type codes of the tuple encoding.
+const (
+	codeNil       = 0x00
+	codeBytes     = 0x01
+	codeString    = 0x02
+	codeNested    = 0x05
+	codeIntZero   = 0x14
+	codeFloat32   = 0x20
+	codeFloat64   = 0x21
+	codeFalse     = 0x26
+	codeTrue      = 0x27
+	codeEscape    = 0xff
+	maxIntBytes   = 8
+	minIntCode    = codeIntZero - maxIntBytes
+	maxIntCode    = codeIntZero + maxIntBytes
+	float32Length = 4
+	float64Length = 8
+)
+
+// Tuple is an ordered list of elements. Supported element types are nil,
+// []byte, string, bool, float32, float64, all signed and unsigned integer
+// types, and nested Tuples.
+type Tuple []any
+
+// Pack returns the order-preserving encoding of the tuple.
+func (t Tuple) Pack() ([]byte, error) {
+	return t.AppendPack(nil)
+}
+
+// AppendPack appends the encoding of the tuple to dst and returns the
+// extended slice.
+func (t Tuple) AppendPack(dst []byte) ([]byte, error) {
+	var err error
+
+	for i, elem := range t {
+		if dst, err = appendElement(dst, elem, false); err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+	}
+
+	if dst == nil {
+		dst = []byte{}
+	}
+
+	return dst, nil
+}
+
+// Range returns the [begin, end) key range that contains the packed form of
+// every tuple that strictly extends t. For example, the range of the partial
+// tuple ("acme",) contains ("acme", 1) and ("acme", "x", 2), but not ("acme",)
+// itself. Use Pack when the tuple itself should be included in a prefix scan.
+func (t Tuple) Range() (begin []byte, end []byte, err error) {
+	prefix, err := t.Pack()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	begin = append(bytes.Clone(prefix), 0x00)
+	end = append(prefix, 0xff)
+
+	return begin, end, nil
+}
+
+// Strinc returns the smallest key that sorts after every key that starts with
+// the given prefix. Trailing 0xff bytes are dropped before the last byte is
+// incremented. It returns ErrNoSuccessor if the prefix consists only of 0xff
+// bytes, in which case the range is unbounded.
+func Strinc(prefix []byte) ([]byte, error) {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			ret := bytes.Clone(prefix[:i+1])
+			ret[i]++
+
+			return ret, nil
+		}
+	}
+
+	return nil, ErrNoSuccessor
+}
+
+// Unpack decodes a packed tuple. Integers are returned as int64, unless the
+// value only fits in a uint64. Byte strings are returned as []byte, and
+// nested tuples are returned as Tuple.
+func Unpack(src []byte) (Tuple, error) {
+	ret, pos, err := decodeTuple(src, 0, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if pos != len(src) {
+		return nil, ErrInvalidTuple
+	}
+
+	return ret, nil
+}
+
+// appendElement appends the encoding of a single element to dst.
+func appendElement(dst []byte, elem any, nested bool) ([]byte, error) {
+	switch v := elem.(type) {
+	case nil:
+		if nested {
+			return append(dst, codeNil, codeEscape), nil
+		}
+
+		return append(dst, codeNil), nil
+	case []byte:
+		return appendEscaped(append(dst, codeBytes), v), nil
+	case string:
+		return appendEscaped(append(dst, codeString), []byte(v)), nil
+	case bool:
+		if v {
+			return append(dst, codeTrue), nil
+		}
+
+		return append(dst, codeFalse), nil
+	case float32:
+		bits := math.Float32bits(v)
+
+		if bits&(1<<31) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 31
+		}
+
+		return binary.BigEndian.AppendUint32(append(dst, codeFloat32), bits), nil
+	case float64:
+		bits := math.Float64bits(v)
+
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+
+		return binary.BigEndian.AppendUint64(append(dst, codeFloat64), bits), nil
+	case int:
+		return appendInt(dst, int64(v)), nil
+	case int8:
+		return appendInt(dst, int64(v)), nil
+	case int16:
+		return appendInt(dst, int64(v)), nil
+	case int32:
+		return appendInt(dst, int64(v)), nil
+	case int64:
+		return appendInt(dst, v), nil
+	case uint:
+		return appendUint(dst, uint64(v)), nil
+	case uint8:
+		return appendUint(dst, uint64(v)), nil
+	case uint16:
+		return appendUint(dst, uint64(v)), nil
+	case uint32:
+		return appendUint(dst, uint64(v)), nil
+	case uint64:
+		return appendUint(dst, v), nil
+	case Tuple:
+		var err error
+
+		dst = append(dst, codeNested)
+
+		for _, e := range v {
+			if dst, err = appendElement(dst, e, true); err != nil {
+				return nil, err
+			}
+		}
+
+		return append(dst, codeNil), nil
+	}
+
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedType, elem)
+}
+
+// appendEscaped appends src followed by a terminating 0x00 byte. Any 0x00 byte
+// within src is escaped as 0x00 0xff to keep the terminator unambiguous.
+func appendEscaped(dst []byte, src []byte) []byte {
+	for _, b := range src {
+		dst = append(dst, b)
+
+		if b == 0x00 {
+			dst = append(dst, codeEscape)
+		}
+	}
+
+	return append(dst, 0x00)
+}
+
+// appendInt appends a signed integer. Negative integers are encoded as the
+// ones' complement of their magnitude, so that they sort before positives.
+func appendInt(dst []byte, v int64) []byte {
+	if v >= 0 {
+		return appendUint(dst, uint64(v))
+	}
+
+	// Negating math.MinInt64 overflows, but the two's complement conversion
+	// to uint64 still yields its correct magnitude of 1<<63.
+	magnitude := uint64(-v)
+	n := byteLen(magnitude)
+	var buf [maxIntBytes]byte
+
+	binary.BigEndian.PutUint64(buf[:], ^magnitude)
+
+	return append(append(dst, byte(codeIntZero-n)), buf[maxIntBytes-n:]...)
+}
+
+// appendUint appends an unsigned integer using its minimal big-endian form.
+func appendUint(dst []byte, v uint64) []byte {
+	n := byteLen(v)
+	var buf [maxIntBytes]byte
+
+	binary.BigEndian.PutUint64(buf[:], v)
+
+	return append(append(dst, byte(codeIntZero+n)), buf[maxIntBytes-n:]...)
+}
+
+// byteLen returns the number of bytes required to represent v.
+func byteLen(v uint64) int {
+	n := 0
+
+	for v > 0 {
+		n++
+		v >>= 8
+	}
+
+	return n
+}
+
+// decodeTuple decodes elements starting at pos, until the end of src or, when
+// nested is true, until the terminator of the nested tuple.
+func decodeTuple(src []byte, pos int, nested bool) (Tuple, int, error) {
+	ret := Tuple{}
+
+	for pos < len(src) {
+		if nested && src[pos] == codeNil {
+			if pos+1 < len(src) && src[pos+1] == codeEscape {
+				ret = append(ret, nil)
+				pos += 2
+				continue
+			}
+
+			return ret, pos + 1, nil
+		}
+
+		elem, next, err := decodeElement(src, pos)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		ret = append(ret, elem)
+		pos = next
+	}
+
+	if nested {
+		return nil, 0, ErrInvalidTuple
+	}
+
+	return ret, pos, nil
+}
+
+// decodeElement decodes the element at pos and returns the position of the
+// next element.
+func decodeElement(src []byte, pos int) (any, int, error) {
+	code := src[pos]
+	pos++
+
+	switch {
+	case code == codeNil:
+		return nil, pos, nil
+	case code == codeBytes:
+		return decodeEscaped(src, pos)
+	case code == codeString:
+		v, next, err := decodeEscaped(src, pos)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return string(v), next, nil
+	case code == codeNested:
+		return decodeTuple(src, pos, true)
+	case code == codeFalse:
+		return false, pos, nil
+	case code == codeTrue:
+		return true, pos, nil
+	case code == codeFloat32:
+		if len(src)-pos < float32Length {
+			return nil, 0, ErrInvalidTuple
+		}
+
+		bits := binary.BigEndian.Uint32(src[pos:])
+
+		if bits&(1<<31) != 0 {
+			bits &^= 1 << 31
+		} else {
+			bits = ^bits
+		}
+
+		return math.Float32frombits(bits), pos + float32Length, nil
+	case code == codeFloat64:
+		if len(src)-pos < float64Length {
+			return nil, 0, ErrInvalidTuple
+		}
+
+		bits := binary.BigEndian.Uint64(src[pos:])
+
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+
+		return math.Float64frombits(bits), pos + float64Length, nil
+	case code >= minIntCode && code <= maxIntCode:
+		return decodeInt(src, pos, int(code)-codeIntZero)
+	}
+
+	return nil, 0, fmt.Errorf("%w: unknown type code 0x%02x", ErrInvalidTuple, code)
+}
+
+// decodeEscaped decodes a 0x00-terminated byte string starting at pos.
+func decodeEscaped(src []byte, pos int) ([]byte, int, error) {
+	ret := []byte{}
+
+	for pos < len(src) {
+		b := src[pos]
+		pos++
+
+		if b != 0x00 {
+			ret = append(ret, b)
+			continue
+		}
+
+		if pos < len(src) && src[pos] == codeEscape {
+			ret = append(ret, 0x00)
+			pos++
+			continue
+		}
+
+		return ret, pos, nil
+	}
+
+	return nil, 0, ErrInvalidTuple
+}
+
+// decodeInt decodes an integer whose magnitude occupies |n| bytes. A negative
+// n denotes a negative integer.
+func decodeInt(src []byte, pos int, n int) (any, int, error) {
+	negative := n < 0
+
+	if negative {
+		n = -n
+	}
+
+	if len(src)-pos < n {
+		return nil, 0, ErrInvalidTuple
+	}
+
+	var buf [maxIntBytes]byte
+
+	if negative {
+		// Pad with 0xff so that the complement of the padding is zero.
+		for i := range buf {
+			buf[i] = 0xff
+		}
+	}
+
+	copy(buf[maxIntBytes-n:], src[pos:pos+n])
+	v := binary.BigEndian.Uint64(buf[:])
+	pos += n
+
+	if !negative {
+		if v > math.MaxInt64 {
+			return v, pos, nil
+		}
+
+		return int64(v), pos, nil
+	}
+
+	magnitude := ^v
+
+	if magnitude > 1<<63 {
+		return nil, 0, ErrInvalidTuple
+	}
+
+	return -int64(magnitude), pos, nil
+}
@@ -0,0 +1,219 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPackVectors(t *testing.T) {
+	testCases := []struct {
+		tuple Tuple
+		want  []byte
+	}{
+		{Tuple{}, []byte{}},
+		{Tuple{nil}, []byte{0x00}},
+		{Tuple{"hello"}, []byte("\x02hello\x00")},
+		{Tuple{[]byte("a\x00b")}, []byte("\x01a\x00\xffb\x00")},
+		{Tuple{0}, []byte{0x14}},
+		{Tuple{1}, []byte{0x15, 0x01}},
+		{Tuple{255}, []byte{0x15, 0xff}},
+		{Tuple{256}, []byte{0x16, 0x01, 0x00}},
+		{Tuple{-1}, []byte{0x13, 0xfe}},
+		{Tuple{-255}, []byte{0x13, 0x00}},
+		{Tuple{-256}, []byte{0x12, 0xfe, 0xff}},
+		{Tuple{uint64(math.MaxUint64)}, []byte{0x1c, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{Tuple{false, true}, []byte{0x26, 0x27}},
+		{Tuple{Tuple{nil, "a"}}, []byte("\x05\x00\xff\x02a\x00\x00")},
+	}
+
+	for _, tc := range testCases {
+		got, err := tc.tuple.Pack()
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("unexpected encoding of %v: got:%x, want:%x", tc.tuple, got, tc.want)
+		}
+	}
+}
+
+func TestPackUnpack(t *testing.T) {
+	testCases := []struct {
+		tuple Tuple
+		want  Tuple
+	}{
+		{Tuple{}, Tuple{}},
+		{Tuple{nil, []byte{0x00, 0xff}, "日本"}, Tuple{nil, []byte{0x00, 0xff}, "日本"}},
+		{Tuple{int8(-5), uint16(300), math.MinInt64, math.MaxInt64}, Tuple{int64(-5), int64(300), int64(math.MinInt64), int64(math.MaxInt64)}},
+		{Tuple{uint64(math.MaxUint64)}, Tuple{uint64(math.MaxUint64)}},
+		{Tuple{float32(-1.5), 2.25, math.Inf(-1)}, Tuple{float32(-1.5), 2.25, math.Inf(-1)}},
+		{Tuple{"acme", Tuple{1, nil, Tuple{}}, true}, Tuple{"acme", Tuple{int64(1), nil, Tuple{}}, true}},
+	}
+
+	for _, tc := range testCases {
+		packed, err := tc.tuple.Pack()
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, err := Unpack(packed)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("unexpected tuple: got:%#v, want:%#v", got, tc.want)
+		}
+	}
+}
+
+func TestPackOrder(t *testing.T) {
+	// Tuples in ascending order.
+	tuples := []Tuple{
+		{nil},
+		{[]byte("a")},
+		{"a"},
+		{"a", nil},
+		{"a", []byte("b")},
+		{"a", "b"},
+		{"a", Tuple{}},
+		{"a", math.MinInt64},
+		{"a", -256},
+		{"a", -1},
+		{"a", 0},
+		{"a", 1},
+		{"a", 255},
+		{"a", 256},
+		{"a", uint64(math.MaxUint64)},
+		{"a", 1.5},
+		{"a", true},
+		{"a\x00"},
+		{"ab"},
+		{Tuple{"a"}},
+		{Tuple{"a", nil}},
+		{Tuple{"a", 1}},
+		{float32(-1)},
+		{float32(0)},
+		{float32(1)},
+		{math.Inf(-1)},
+		{-1.5},
+		{0.0},
+		{1.5},
+		{math.Inf(1)},
+		{false},
+		{true},
+	}
+
+	var prev []byte
+
+	for i, tuple := range tuples {
+		packed, err := tuple.Pack()
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if i > 0 && bytes.Compare(prev, packed) >= 0 {
+			t.Errorf("%v does not sort after %v", tuple, tuples[i-1])
+		}
+
+		prev = packed
+	}
+}
+
+func TestRange(t *testing.T) {
+	begin, end, err := Tuple{"acme"}.Range()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inside := []Tuple{{"acme", nil}, {"acme", 1}, {"acme", "x", 2}, {"acme", Tuple{}}}
+	outside := []Tuple{{"acme"}, {"acm"}, {"acme\x00"}, {"acmf"}, {"b", 1}}
+
+	for _, tuple := range inside {
+		packed, _ := tuple.Pack()
+
+		if bytes.Compare(packed, begin) < 0 || bytes.Compare(packed, end) >= 0 {
+			t.Errorf("%v should be inside the range", tuple)
+		}
+	}
+
+	for _, tuple := range outside {
+		packed, _ := tuple.Pack()
+
+		if bytes.Compare(packed, begin) >= 0 && bytes.Compare(packed, end) < 0 {
+			t.Errorf("%v should be outside the range", tuple)
+		}
+	}
+
+	// The packed partial tuple is a prefix of every tuple in the range.
+	prefix, _ := Tuple{"acme"}.Pack()
+
+	for _, tuple := range inside {
+		packed, _ := tuple.Pack()
+
+		if !bytes.HasPrefix(packed, prefix) {
+			t.Errorf("%v should start with the partial tuple prefix", tuple)
+		}
+	}
+}
+
+func TestStrinc(t *testing.T) {
+	testCases := []struct {
+		prefix []byte
+		want   []byte
+		err    error
+	}{
+		{[]byte("a"), []byte("b"), nil},
+		{[]byte("a\xff"), []byte("b"), nil},
+		{[]byte("ab\xfe"), []byte("ab\xff"), nil},
+		{[]byte("\xff\xff"), nil, ErrNoSuccessor},
+		{nil, nil, ErrNoSuccessor},
+	}
+
+	for _, tc := range testCases {
+		got, err := Strinc(tc.prefix)
+
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("unexpected error: got:%v, want:%v", err, tc.err)
+		}
+
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("unexpected successor: got:%q, want:%q", got, tc.want)
+		}
+	}
+}
+
+func TestPackUnsupportedType(t *testing.T) {
+	if _, err := (Tuple{"a", struct{}{}}).Pack(); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrUnsupportedType)
+	}
+}
+
+func TestUnpackInvalid(t *testing.T) {
+	testCases := [][]byte{
+		[]byte("\x02unterminated"),
+		[]byte("\x05\x02a\x00"),
+		{0x16, 0x01},
+		{0x21, 0x00},
+		{0x0b},
+		{0x0c, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe},
+	}
+
+	for _, src := range testCases {
+		if _, err := Unpack(src); !errors.Is(err, ErrInvalidTuple) {
+			t.Errorf("unexpected error for %x: got:%v, want:%v", src, err, ErrInvalidTuple)
+		}
+	}
+}
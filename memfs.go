@@ -0,0 +1,490 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// Fault is a fault that MemFS injects into a step.
+type Fault uint8
+
+const (
+	// FaultCrash crashes the filesystem before the step takes effect.
+	FaultCrash Fault = iota + 1
+
+	// FaultTornWrite makes the first half of a write durable, and then
+	// crashes the filesystem. On steps other than writes, it is FaultCrash.
+	FaultTornWrite
+
+	// FaultError fails the step with ErrInjectedFault without any effect. A
+	// failed sync leaves the written data volatile.
+	FaultError
+)
+
+// MemFS is an in-memory VFS that injects faults at chosen steps. Every
+// operation that modifies the filesystem or makes it durable is a step, and
+// steps are numbered from zero. A crash discards the data that was written
+// but not synced, and fails every operation with ErrInjectedFault until
+// Restart is called. Creating, renaming and removing files is durable
+// immediately, as it is with OSFS. A MemFS is safe for concurrent use.
+type MemFS struct {
+	mu         sync.Mutex
+	files      map[string]*memInode
+	faults     map[int]Fault
+	steps      int
+	crashed    bool
+	generation int // Invalidates the open files on Restart.
+}
+
+// memInode holds the contents of a MemFS file.
+type memInode struct {
+	data   []byte // Contents as seen by readers.
+	synced []byte // Contents that survive a crash.
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files:  map[string]*memInode{},
+		faults: map[int]Fault{},
+	}
+}
+
+// InjectFault injects the fault into the given step.
+func (m *MemFS) InjectFault(step int, f Fault) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.faults[step] = f
+}
+
+// Steps returns the number of steps that have been taken. Running a workload
+// once and counting its steps allows tests to inject a fault at each of them.
+func (m *MemFS) Steps() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.steps
+}
+
+// Crashed returns true if the filesystem has crashed since the last Restart.
+func (m *MemFS) Crashed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.crashed
+}
+
+// Crash discards the data that was not synced, as if the machine lost power.
+func (m *MemFS) Crash() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.crash()
+}
+
+// Restart recovers the filesystem from a crash, and clears the faults that
+// were not injected yet. Files that were open before the restart are closed.
+func (m *MemFS) Restart() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.crashed = false
+	m.faults = map[int]Fault{}
+	m.generation++
+}
+
+// ReadFile returns a copy of the contents of the named file.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inode, found := m.files[name]
+
+	if !found {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return bytes.Clone(inode.data), nil
+}
+
+// WriteFile durably replaces the contents of the named file, bypassing the
+// steps and faults. It is meant for preparing the files of a test.
+func (m *MemFS) WriteFile(name string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.files[name] = &memInode{data: bytes.Clone(data), synced: bytes.Clone(data)}
+}
+
+// OpenFile opens the named file. It supports os.O_RDONLY, os.O_WRONLY,
+// os.O_RDWR, os.O_APPEND, os.O_CREATE, os.O_EXCL and os.O_TRUNC. Opening a
+// file is a step if it creates or truncates the file.
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inode, found := m.files[name]
+
+	switch {
+	case found && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+
+	case !found && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if !found || flag&os.O_TRUNC != 0 {
+		if _, err := m.step(false); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+
+		if !found {
+			inode = &memInode{}
+			m.files[name] = inode
+		}
+
+		if flag&os.O_TRUNC != 0 {
+			inode.data = nil
+		}
+	}
+
+	return &memFile{
+		fs:         m,
+		inode:      inode,
+		name:       name,
+		flag:       flag,
+		generation: m.generation,
+	}, nil
+}
+
+// Rename atomically replaces newpath with oldpath.
+func (m *MemFS) Rename(oldpath string, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inode, found := m.files[oldpath]
+
+	if !found {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+
+	if _, err := m.step(false); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	delete(m.files, oldpath)
+	m.files[newpath] = inode
+
+	return nil
+}
+
+// Remove removes the named file.
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.files[name]; !found {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if _, err := m.step(false); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	delete(m.files, name)
+
+	return nil
+}
+
+// step takes a step and returns the fault to inject, if any. It returns
+// ErrInjectedFault if the step must not take effect. Torn writes are left to
+// writes, which are the only steps that pass canTear. The caller must hold
+// the lock.
+func (m *MemFS) step(canTear bool) (Fault, error) {
+	if m.crashed {
+		return 0, ErrInjectedFault
+	}
+
+	f := m.faults[m.steps]
+	delete(m.faults, m.steps)
+	m.steps++
+
+	if f == FaultTornWrite && !canTear {
+		f = FaultCrash
+	}
+
+	switch f {
+	case FaultCrash:
+		m.crash()
+		return f, ErrInjectedFault
+
+	case FaultError:
+		return f, ErrInjectedFault
+	}
+
+	return f, nil
+}
+
+// crash discards the data that was not synced. The caller must hold the lock.
+func (m *MemFS) crash() {
+	m.crashed = true
+
+	for _, inode := range m.files {
+		inode.data = bytes.Clone(inode.synced)
+	}
+}
+
+// memFile is an open file of a MemFS.
+type memFile struct {
+	fs         *MemFS
+	inode      *memInode
+	name       string
+	flag       int
+	generation int
+	offset     int64
+	closed     bool
+}
+
+// check returns an error if the file is closed, or if the filesystem has
+// crashed. The caller must hold the lock of the filesystem.
+func (f *memFile) check(op string) error {
+	var err error
+
+	switch {
+	case f.closed || f.generation != f.fs.generation:
+		err = fs.ErrClosed
+
+	case f.fs.crashed:
+		err = ErrInjectedFault
+	}
+
+	if err != nil {
+		return &fs.PathError{Op: op, Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+// checkWrite is like check, and additionally requires the file to be opened
+// for writing.
+func (f *memFile) checkWrite(op string) error {
+	if err := f.check(op); err != nil {
+		return err
+	}
+
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrPermission}
+	}
+
+	return nil
+}
+
+// Read reads from the current offset of the file.
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+// ReadAt reads from the given offset of the file.
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	n, err := f.readAt(p, off)
+
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// readAt is the lock-free version of ReadAt, which reports io.EOF only if no
+// bytes were read.
+func (f *memFile) readAt(p []byte, off int64) (int, error) {
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrPermission}
+	}
+
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if off >= int64(len(f.inode.data)) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+
+		return 0, io.EOF
+	}
+
+	return copy(p, f.inode.data[off:]), nil
+}
+
+// Write writes at the current offset of the file, or at its end if the file
+// was opened with os.O_APPEND.
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.inode.data))
+	}
+
+	n, err := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+// WriteAt writes at the given offset of the file.
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	return f.writeAt(p, off)
+}
+
+// writeAt is the lock-free version of WriteAt.
+func (f *memFile) writeAt(p []byte, off int64) (int, error) {
+	if err := f.checkWrite("write"); err != nil {
+		return 0, err
+	}
+
+	if off < 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	fault, err := f.fs.step(true)
+
+	if err != nil {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: err}
+	}
+
+	if fault == FaultTornWrite {
+		torn := p[:len(p)/2]
+		f.inode.synced = overwrite(f.inode.synced, torn, off)
+		f.fs.crash()
+
+		return len(torn), &fs.PathError{Op: "write", Path: f.name, Err: ErrInjectedFault}
+	}
+
+	f.inode.data = overwrite(f.inode.data, p, off)
+
+	return len(p), nil
+}
+
+// overwrite writes src into dst at the given offset, growing dst with zeros
+// as needed, and returns the result.
+func overwrite(dst []byte, src []byte, off int64) []byte {
+	if end := off + int64(len(src)); end > int64(len(dst)) {
+		dst = append(dst, make([]byte, end-int64(len(dst)))...)
+	}
+
+	copy(dst[off:], src)
+
+	return dst
+}
+
+// Seek sets the offset for the next Read or Write.
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("seek"); err != nil {
+		return 0, err
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.inode.data))
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	f.offset = offset
+
+	return offset, nil
+}
+
+// Sync makes the contents of the file durable.
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.check("sync"); err != nil {
+		return err
+	}
+
+	if _, err := f.fs.step(false); err != nil {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: err}
+	}
+
+	f.inode.synced = bytes.Clone(f.inode.data)
+
+	return nil
+}
+
+// Truncate changes the size of the file.
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if err := f.checkWrite("truncate"); err != nil {
+		return err
+	}
+
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if _, err := f.fs.step(false); err != nil {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+
+	if size <= int64(len(f.inode.data)) {
+		f.inode.data = f.inode.data[:size:size]
+	} else {
+		f.inode.data = overwrite(f.inode.data, nil, size)
+	}
+
+	return nil
+}
+
+// Close closes the file.
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	f.closed = true
+
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+// writeMemFile writes the contents to the named file, optionally syncing it.
+func writeMemFile(t *testing.T, m *MemFS, name string, contents string, sync bool) error {
+	t.Helper()
+
+	f, err := m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if _, err := f.Write([]byte(contents)); err != nil {
+		return err
+	}
+
+	if sync {
+		return f.Sync()
+	}
+
+	return nil
+}
+
+func TestMemFSFileOperations(t *testing.T) {
+	m := NewMemFS()
+
+	f, err := m.OpenFile("db", os.O_RDWR|os.O_CREATE, 0o644)
+
+	if err != nil {
+		t.Fatalf("OpenFile(): %v", err)
+	}
+
+	if _, err := f.Write([]byte("hello world")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+
+	if _, err := f.WriteAt([]byte("W"), 6); err != nil {
+		t.Fatalf("WriteAt(): %v", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek(): %v", err)
+	}
+
+	if got, _ := io.ReadAll(f); string(got) != "hello World" {
+		t.Errorf("unexpected contents: got:%q, want:%q", got, "hello World")
+	}
+
+	buf := make([]byte, 8)
+
+	if n, err := f.ReadAt(buf, 6); n != 5 || err != io.EOF {
+		t.Errorf("unexpected ReadAt(): got:(%d, %v), want:(5, EOF)", n, err)
+	}
+
+	if err := f.Truncate(5); err != nil {
+		t.Fatalf("Truncate(): %v", err)
+	}
+
+	if err := f.Truncate(7); err != nil {
+		t.Fatalf("Truncate(): %v", err)
+	}
+
+	if got, _ := m.ReadFile("db"); string(got) != "hello\x00\x00" {
+		t.Errorf("unexpected contents: got:%q, want:%q", got, "hello\x00\x00")
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+
+	if _, err := f.Write([]byte("x")); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, fs.ErrClosed)
+	}
+
+	if _, err := m.OpenFile("db", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, fs.ErrExist)
+	}
+
+	if _, err := m.OpenFile("missing", os.O_RDONLY, 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, fs.ErrNotExist)
+	}
+
+	ro, err := m.OpenFile("db", os.O_RDONLY, 0)
+
+	if err != nil {
+		t.Fatalf("OpenFile(): %v", err)
+	}
+
+	if _, err := ro.Write([]byte("x")); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, fs.ErrPermission)
+	}
+
+	if err := m.Rename("db", "renamed"); err != nil {
+		t.Fatalf("Rename(): %v", err)
+	}
+
+	if _, err := m.ReadFile("db"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, fs.ErrNotExist)
+	}
+
+	if err := m.Remove("renamed"); err != nil {
+		t.Fatalf("Remove(): %v", err)
+	}
+
+	if _, err := m.ReadFile("renamed"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, fs.ErrNotExist)
+	}
+}
+
+func TestMemFSCrash(t *testing.T) {
+	m := NewMemFS()
+
+	if err := writeMemFile(t, m, "synced", "durable", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeMemFile(t, m, "volatile", "lost", false); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := m.OpenFile("synced", os.O_RDWR, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteAt([]byte("unsynced"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Crash()
+
+	if !m.Crashed() {
+		t.Error("expected the filesystem to have crashed")
+	}
+
+	if _, err := m.OpenFile("other", os.O_RDWR|os.O_CREATE, 0o644); !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("unexpected error after crash: got:%v, want:%v", err, ErrInjectedFault)
+	}
+
+	m.Restart()
+
+	if _, err := f.Write([]byte("x")); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("unexpected error after restart: got:%v, want:%v", err, fs.ErrClosed)
+	}
+
+	testCases := []struct {
+		name string
+		want string
+	}{
+		{"synced", "durable"},
+		{"volatile", ""},
+	}
+
+	for _, tc := range testCases {
+		if got, err := m.ReadFile(tc.name); err != nil || string(got) != tc.want {
+			t.Errorf("unexpected contents of %q: got:(%q, %v), want:%q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestMemFSFaults(t *testing.T) {
+	// The steps of writeMemFile are the creation, the write and the sync.
+	testCases := []struct {
+		name        string
+		step        int
+		fault       Fault
+		wantCrashed bool
+		want        string
+	}{
+		{"crash on create", 0, FaultCrash, true, ""},
+		{"crash on write", 1, FaultCrash, true, ""},
+		{"crash on sync", 2, FaultCrash, true, ""},
+		{"torn write", 1, FaultTornWrite, true, "abc"},
+		{"torn sync", 2, FaultTornWrite, true, ""},
+		{"failed write", 1, FaultError, false, ""},
+		{"failed sync", 2, FaultError, false, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMemFS()
+			m.InjectFault(tc.step, tc.fault)
+
+			if err := writeMemFile(t, m, "db", "abcdef", true); !errors.Is(err, ErrInjectedFault) {
+				t.Fatalf("unexpected error: got:%v, want:%v", err, ErrInjectedFault)
+			}
+
+			if m.Crashed() != tc.wantCrashed {
+				t.Errorf("unexpected crash state: got:%t, want:%t", m.Crashed(), tc.wantCrashed)
+			}
+
+			// Whatever was not synced is lost in a crash.
+			m.Crash()
+			m.Restart()
+
+			if got, _ := m.ReadFile("db"); string(got) != tc.want {
+				t.Errorf("unexpected durable contents: got:%q, want:%q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+)
+
+// RootHash returns the Merkle root hash of the database. Databases with equal
+// contents have equal root hashes, regardless of the order in which the
+// records were written. The root hash of an empty database is zero.
+//
+// Node hashes are cached and only the nodes along the paths of modified keys
+// are recomputed, so the cost of RootHash is proportional to the changes made
+// since it was last called. RootHash, Prove and Diff only hold the read lock,
+// and merely serialize with each other while they compute hashes.
+func (a *Arc) RootHash() Hash {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	a.hashMu.Lock()
+	defer a.hashMu.Unlock()
+
+	return a.rootHash()
+}
+
+// rootHash is the lock-free version of RootHash. The root hash is the hash of
+// a virtual non-record node whose only child is the root node, which combines
+// the key segment of the root node like that of any other node.
+func (a *Arc) rootHash() Hash {
+	if a.root == nil {
+		return Hash{}
+	}
+
+	mh := newMerkleHasher(false, nil, 0)
+	mh.addChild(a.root.key, a.root.merkleHash())
+
+	return mh.sum()
+}
+
+// merkleHash returns the hash of the node's subtree, which combines the node's
+// value with the key segments and hashes of its children. The node's own key
+// segment is combined into the hash of its parent instead, so that splitting
+// or merging a node's key does not invalidate the hash of its subtree. The
+// caller must hold either the write lock, or the read lock and hashMu.
+func (n *node) merkleHash() Hash {
+	if n.hash != nil {
+		return *n.hash
+	}
+
+	// The blobID represents blob values, so that the hash of a large value
+	// is never recomputed.
+	mh := newMerkleHasher(n.isRecord, n.data, n.expiresAt)
+
+	for child := n.firstChild; child != nil; child = child.nextSibling {
+		mh.addChild(child.key, child.merkleHash())
+	}
+
+	ret := mh.sum()
+	n.hash = &ret
+
+	return ret
+}
+
+// invalidateHashes marks the hashes of the nodes whose paths are prefixes of
+// the key as stale. These are the only nodes whose subtrees change when the
+// key is modified. The caller must hold the write lock.
+func (a *Arc) invalidateHashes(key []byte) {
+	for current := a.root; current != nil && bytes.HasPrefix(key, current.key); {
+		current.hash = nil
+		key = key[len(current.key):]
+
+		if len(key) == 0 {
+			return
+		}
+
+		current = current.findCompatibleChild(key)
+	}
+}
+
+// merkleHasher computes the Merkle hash of a node. It is shared by the tree and
+// the proof verifier, which must hash nodes identically.
+type merkleHasher struct {
+	h hash.Hash
+}
+
+// newMerkleHasher returns a hasher that is initialized with the node's value.
+// The data is the node's inline value or blobID.
+func newMerkleHasher(isRecord bool, data []byte, expiresAt int64) merkleHasher {
+	mh := merkleHasher{h: sha256.New()}
+
+	if isRecord {
+		mh.h.Write([]byte{flagIsRecord})
+		mh.writeSegment(data)
+		binary.Write(mh.h, binary.LittleEndian, expiresAt)
+	} else {
+		mh.h.Write([]byte{0})
+	}
+
+	return mh
+}
+
+// addChild combines the key segment and hash of the next child. Children must
+// be added in ascending key order.
+func (mh merkleHasher) addChild(key []byte, h Hash) {
+	mh.writeSegment(key)
+	mh.h.Write(h[:])
+}
+
+// sum returns the hash of the node.
+func (mh merkleHasher) sum() Hash {
+	var ret Hash
+	mh.h.Sum(ret[:0])
+
+	return ret
+}
+
+// writeSegment writes the length-prefixed segment to the hash, which prevents
+// adjacent segments from being ambiguous.
+func (mh merkleHasher) writeSegment(segment []byte) {
+	var length [sizeOfUint32]byte
+
+	binary.LittleEndian.PutUint32(length[:], uint32(len(segment)))
+	mh.h.Write(length[:])
+	mh.h.Write(segment)
+}
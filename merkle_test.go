@@ -0,0 +1,175 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRootHash(t *testing.T) {
+	if got := New().RootHash(); !got.IsZero() {
+		t.Errorf("unexpected root hash of empty database: %s", got)
+	}
+
+	arc := basicTestTree()
+	want := arc.RootHash()
+
+	// The root hash does not depend on the insertion order.
+	reversed := New()
+
+	for _, row := range slices.Backward(basicTestTreeData()) {
+		reversed.Put(row.key, row.data)
+	}
+
+	if got := reversed.RootHash(); got != want {
+		t.Errorf("unexpected root hash: got:%s, want:%s", got, want)
+	}
+
+	testCases := []struct {
+		name   string
+		mutate func(*Arc)
+	}{
+		{"update value", func(a *Arc) { a.Put([]byte("apple"), []byte("pie")) }},
+		{"update to blob", func(a *Arc) { a.Put([]byte("apple"), blobValueX()) }},
+		{"insert key", func(a *Arc) { a.Put([]byte("app"), []byte("store")) }},
+		{"delete key", func(a *Arc) { a.Delete([]byte("banana")) }},
+		{"delete prefix", func(a *Arc) { a.DeletePrefix([]byte("gr")) }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subject := basicTestTree()
+			subject.RootHash()
+
+			tc.mutate(subject)
+
+			if got := subject.RootHash(); got == want {
+				t.Errorf("root hash did not change: %s", got)
+			}
+
+			assertCachedHashes(t, subject)
+		})
+	}
+
+	// Reverting a change restores the root hash.
+	arc.Put([]byte("app"), []byte("store"))
+	arc.RootHash()
+	arc.Delete([]byte("app"))
+
+	if got := arc.RootHash(); got != want {
+		t.Errorf("unexpected root hash: got:%s, want:%s", got, want)
+	}
+}
+
+func TestRootHashIncremental(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	arc := New()
+
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("%x", rng.Intn(512)))
+
+		switch rng.Intn(4) {
+		case 0, 1:
+			arc.Put(key, []byte(fmt.Sprint(rng.Intn(4))))
+		case 2:
+			arc.Delete(key)
+		case 3:
+			arc.DeletePrefix(key[:1])
+		}
+
+		if i%7 == 0 {
+			assertCachedHashes(t, arc)
+		}
+	}
+
+	assertCachedHashes(t, arc)
+}
+
+func TestRootHashConcurrentReaders(t *testing.T) {
+	arc := basicTestTree()
+	other := basicTestTree()
+	other.Put([]byte("apple"), []byte("green"))
+
+	// Hash queries only take the read lock, so they proceed while another
+	// reader holds it.
+	arc.mu.RLock()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		arc.RootHash()
+		arc.Prove([]byte("apple"))
+		for range Diff(arc, other) {
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hash queries blocked by a reader")
+	}
+
+	arc.mu.RUnlock()
+
+	// Concurrent queries fill the hash cache while a writer invalidates it.
+	var wg sync.WaitGroup
+
+	for i := range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range 200 {
+				switch (i + j) % 3 {
+				case 0:
+					arc.RootHash()
+				case 1:
+					arc.Prove(fmt.Appendf(nil, "key-%d", j))
+				case 2:
+					for range Diff(arc, other) {
+					}
+				}
+			}
+		}()
+	}
+
+	for j := range 200 {
+		arc.Put(fmt.Appendf(nil, "key-%d", j), []byte("v"))
+	}
+
+	wg.Wait()
+	assertCachedHashes(t, arc)
+}
+
+// assertCachedHashes verifies that the cached root hash matches the root hash
+// that is computed from scratch.
+func assertCachedHashes(t *testing.T, arc *Arc) {
+	t.Helper()
+
+	got := arc.RootHash()
+
+	var clear func(n *node)
+	clear = func(n *node) {
+		n.hash = nil
+
+		for child := n.firstChild; child != nil; child = child.nextSibling {
+			clear(child)
+		}
+	}
+
+	if arc.root != nil {
+		clear(arc.root)
+	}
+
+	if want := arc.RootHash(); got != want {
+		t.Fatalf("stale root hash: got:%s, want:%s", got, want)
+	}
+}
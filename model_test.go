@@ -0,0 +1,499 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+	"testing"
+)
+
+// Model operations that are decoded from the fuzz input.
+const (
+	modelOpAdd = iota
+	modelOpPut
+	modelOpDelete
+	modelOpGet
+	modelOpScan
+	modelOpDeletePrefix
+	modelOpDeleteRange
+	modelOpRootHash
+	modelOpPutFanout
+	modelOpDeleteFanout
+	numModelOps
+)
+
+// modelKeyAlphabet is the alphabet of the fuzzed keys. A small alphabet makes
+// the keys share prefixes, which exercises node splitting and merging. The
+// fan-out operations extend a key with arbitrary bytes instead, which widens
+// a node enough to exercise the layouts of its child index.
+var modelKeyAlphabet = []byte{'a', 'b', 'c', 0x00, 0xff}
+
+// modelValueSizes are the sizes of the fuzzed values, which straddle the
+// inline value threshold.
+var modelValueSizes = []int{0, 1, inlineValueThreshold, inlineValueThreshold + 1, 40}
+
+// modelInput decodes operations from the fuzz input.
+type modelInput struct {
+	src []byte
+}
+
+// byte returns the next byte of the input, or zero if it is exhausted.
+func (in *modelInput) byte() byte {
+	if len(in.src) == 0 {
+		return 0
+	}
+
+	ret := in.src[0]
+	in.src = in.src[1:]
+
+	return ret
+}
+
+// key decodes a key of up to five bytes, which may be empty.
+func (in *modelInput) key() []byte {
+	ret := []byte{}
+
+	for n := in.byte() % 6; n > 0; n-- {
+		ret = append(ret, modelKeyAlphabet[int(in.byte())%len(modelKeyAlphabet)])
+	}
+
+	return ret
+}
+
+// value decodes a value. The values are drawn from a small set, so that blob
+// values are shared between records.
+func (in *modelInput) value() []byte {
+	b := in.byte()
+	size := modelValueSizes[int(b)%len(modelValueSizes)]
+
+	return bytes.Repeat([]byte{'v' + b%3}, size)
+}
+
+// fanout decodes a key prefix and a run of up to 255 consecutive bytes, and
+// returns the keys that extend the prefix by each byte of the run.
+func (in *modelInput) fanout() [][]byte {
+	prefix := in.key()
+	count, first := int(in.byte()), in.byte()
+
+	ret := make([][]byte, count)
+
+	for i := range ret {
+		ret[i] = append(bytes.Clone(prefix), first+byte(i))
+	}
+
+	return ret
+}
+
+// model is the reference implementation of the database.
+type model map[string][]byte
+
+// sortedKeys returns the keys of the model within [start, end) in ascending
+// order. A nil end is unbounded.
+func (m model) sortedKeys(start []byte, end []byte) []string {
+	var ret []string
+
+	for key := range m {
+		if key >= string(start) && (end == nil || key < string(end)) {
+			ret = append(ret, key)
+		}
+	}
+
+	slices.Sort(ret)
+
+	return ret
+}
+
+func FuzzModel(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{modelOpPut, 3, 0, 1, 2, 1, modelOpPut, 0, 0, modelOpGet, 0})
+	f.Add([]byte{modelOpPut, 2, 0, 0, 3, modelOpPut, 2, 0, 1, 3, modelOpDelete, 2, 0, 0})
+	f.Add([]byte{modelOpPut, 1, 0, 0, modelOpPut, 2, 1, 1, 0, modelOpDeletePrefix, 1, 1})
+	f.Add([]byte{modelOpPut, 3, 0, 1, 2, 4, modelOpAdd, 3, 0, 1, 2, 3, modelOpDeleteRange, 1, 0, 1, 2})
+	f.Add([]byte{modelOpPutFanout, 1, 0, 60, 0, 1, modelOpRootHash, modelOpDeleteFanout, 1, 0, 30, 10, modelOpRootHash})
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		runModel(t, src)
+	})
+}
+
+// runModel interprets the input as a sequence of operations, and checks every
+// step against the model along with the structural invariants of the tree.
+func runModel(t *testing.T, src []byte) {
+	arc := New()
+	want := model{}
+	in := &modelInput{src: src}
+
+	for step := 0; len(in.src) > 0; step++ {
+		op := in.byte() % numModelOps
+		desc := fmt.Sprintf("step %d", step)
+
+		switch op {
+		case modelOpAdd:
+			key, value := in.key(), in.value()
+			desc = fmt.Sprintf("%s: Add(%q, %d bytes)", desc, key, len(value))
+
+			err := arc.Add(key, value)
+
+			if _, found := want[string(key)]; found {
+				assertModelErr(t, desc, err, ErrDuplicateKey)
+			} else {
+				assertModelErr(t, desc, err, nil)
+				want[string(key)] = value
+			}
+
+		case modelOpPut:
+			key, value := in.key(), in.value()
+			desc = fmt.Sprintf("%s: Put(%q, %d bytes)", desc, key, len(value))
+
+			assertModelErr(t, desc, arc.Put(key, value), nil)
+			want[string(key)] = value
+
+		case modelOpDelete:
+			key := in.key()
+			desc = fmt.Sprintf("%s: Delete(%q)", desc, key)
+
+			err := arc.Delete(key)
+
+			if _, found := want[string(key)]; found {
+				assertModelErr(t, desc, err, nil)
+				delete(want, string(key))
+			} else {
+				assertModelErr(t, desc, err, ErrKeyNotFound)
+			}
+
+		case modelOpGet:
+			key := in.key()
+			desc = fmt.Sprintf("%s: Get(%q)", desc, key)
+
+			got, err := arc.Get(key)
+
+			if wantValue, found := want[string(key)]; found {
+				assertModelErr(t, desc, err, nil)
+
+				if !bytes.Equal(got, wantValue) {
+					t.Fatalf("%s: unexpected value: got:%q, want:%q", desc, got, wantValue)
+				}
+			} else {
+				assertModelErr(t, desc, err, ErrKeyNotFound)
+			}
+
+		case modelOpScan:
+			prefix := in.key()
+			desc = fmt.Sprintf("%s: Scan(%q)", desc, prefix)
+
+			var got []string
+
+			for key, value := range arc.Scan(prefix) {
+				if !bytes.Equal(value, want[string(key)]) {
+					t.Fatalf("%s: unexpected value of %q: got:%q, want:%q", desc, key, value, want[string(key)])
+				}
+
+				got = append(got, string(key))
+			}
+
+			wantKeys := want.sortedKeys(prefix, prefixSuccessor(prefix))
+
+			if !slices.Equal(got, wantKeys) {
+				t.Fatalf("%s: unexpected keys: got:%q, want:%q", desc, got, wantKeys)
+			}
+
+		case modelOpDeletePrefix:
+			prefix := in.key()
+			desc = fmt.Sprintf("%s: DeletePrefix(%q)", desc, prefix)
+
+			wantKeys := want.sortedKeys(prefix, prefixSuccessor(prefix))
+			count, err := arc.DeletePrefix(prefix)
+
+			assertModelErr(t, desc, err, nil)
+
+			if count != len(wantKeys) {
+				t.Fatalf("%s: unexpected count: got:%d, want:%d", desc, count, len(wantKeys))
+			}
+
+			for _, key := range wantKeys {
+				delete(want, key)
+			}
+
+		case modelOpDeleteRange:
+			start, end := in.key(), in.key()
+			desc = fmt.Sprintf("%s: DeleteRange(%q, %q)", desc, start, end)
+
+			count, err := arc.DeleteRange(start, end)
+
+			if bytes.Compare(start, end) > 0 {
+				assertModelErr(t, desc, err, ErrInvalidRange)
+				break
+			}
+
+			wantKeys := want.sortedKeys(start, end)
+			assertModelErr(t, desc, err, nil)
+
+			if count != len(wantKeys) {
+				t.Fatalf("%s: unexpected count: got:%d, want:%d", desc, count, len(wantKeys))
+			}
+
+			for _, key := range wantKeys {
+				delete(want, key)
+			}
+
+		case modelOpRootHash:
+			desc = fmt.Sprintf("%s: RootHash()", desc)
+
+			// The root hash caches the hashes of the nodes, which the
+			// invariants then check against later mutations.
+			var wantHash Hash
+
+			if arc.root != nil {
+				mh := newMerkleHasher(false, nil, 0)
+				mh.addChild(arc.root.key, freshMerkleHash(arc.root))
+				wantHash = mh.sum()
+			}
+
+			if got := arc.RootHash(); got != wantHash {
+				t.Fatalf("%s: unexpected root hash: got:%s, want:%s", desc, got, wantHash)
+			}
+
+		case modelOpPutFanout:
+			keys, value := in.fanout(), in.value()
+			desc = fmt.Sprintf("%s: Put(%d keys, %d bytes)", desc, len(keys), len(value))
+
+			for _, key := range keys {
+				assertModelErr(t, desc, arc.Put(key, value), nil)
+				want[string(key)] = value
+			}
+
+		case modelOpDeleteFanout:
+			keys := in.fanout()
+			desc = fmt.Sprintf("%s: Delete(%d keys)", desc, len(keys))
+
+			for _, key := range keys {
+				if _, found := want[string(key)]; found {
+					assertModelErr(t, desc, arc.Delete(key), nil)
+					delete(want, string(key))
+				} else {
+					assertModelErr(t, desc, arc.Delete(key), ErrKeyNotFound)
+				}
+			}
+		}
+
+		if arc.Len() != len(want) {
+			t.Fatalf("%s: unexpected length: got:%d, want:%d", desc, arc.Len(), len(want))
+		}
+
+		if err := checkInvariants(arc); err != nil {
+			t.Fatalf("%s: %v", desc, err)
+		}
+	}
+}
+
+func assertModelErr(t *testing.T, desc string, got error, want error) {
+	t.Helper()
+
+	if !errors.Is(got, want) {
+		t.Fatalf("%s: unexpected error: got:%v, want:%v", desc, got, want)
+	}
+}
+
+func TestModelSequences(t *testing.T) {
+	testCases := []struct {
+		name string
+		ops  []byte
+	}{
+		{"empty key before other keys", []byte{modelOpPut, 1, 0, 0, modelOpPut, 0, 0, modelOpGet, 0}},
+		{"empty key after other keys", []byte{modelOpPut, 1, 0, 0, modelOpPut, 1, 1, 0, modelOpPut, 0, 0, modelOpGet, 0, modelOpScan, 0}},
+		{"delete empty key", []byte{modelOpPut, 0, 0, modelOpPut, 1, 0, 0, modelOpPut, 1, 1, 0, modelOpDelete, 0}},
+		{"blob sharing", []byte{modelOpPut, 1, 0, 3, modelOpPut, 1, 1, 3, modelOpPut, 1, 0, 1, modelOpDelete, 1, 1}},
+		{"delete range over everything", []byte{modelOpPut, 2, 0, 1, 0, modelOpPut, 2, 1, 0, 0, modelOpDeleteRange, 0, 5, 4, 4, 4, 4, 4}},
+		{"cached hashes after mutations", []byte{modelOpPut, 2, 0, 1, 3, modelOpPut, 1, 2, 4, modelOpRootHash, modelOpPut, 2, 0, 2, 1, modelOpDelete, 1, 2, modelOpRootHash, modelOpDeletePrefix, 0}},
+		{"grow to node256", []byte{modelOpPutFanout, 1, 0, 255, 0, 1, modelOpRootHash, modelOpDelete, 2, 0, 0, modelOpRootHash}},
+		{"shrink to node48", []byte{modelOpPutFanout, 1, 1, 60, 100, 3, modelOpRootHash, modelOpDeleteFanout, 1, 1, 25, 100, modelOpRootHash}},
+		{"drop the index", []byte{modelOpPutFanout, 0, 30, 0, 0, modelOpDeleteFanout, 0, 20, 5, modelOpRootHash, modelOpPutFanout, 0, 5, 5, 4}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			runModel(t, tc.ops)
+		})
+	}
+}
+
+// checkInvariants verifies the structural invariants of the tree: the node and
+// record counters, the order and indexing of siblings, the absence of
+// redundant nodes, the placement of values, the blob reference counts, and
+// the consistency of the cached Merkle hashes.
+func checkInvariants(a *Arc) error {
+	if a.root == nil {
+		if a.numNodes != 0 || a.numRecords != 0 || len(a.blobs) != 0 {
+			return fmt.Errorf("empty tree with counters nodes:%d, records:%d, blobs:%d", a.numNodes, a.numRecords, len(a.blobs))
+		}
+
+		return nil
+	}
+
+	c := invariantChecker{blobRefs: map[blobID]int{}}
+
+	if err := c.check(a.root, nil, true); err != nil {
+		return err
+	}
+
+	if c.numNodes != a.numNodes {
+		return fmt.Errorf("numNodes mismatch: got:%d, want:%d", a.numNodes, c.numNodes)
+	}
+
+	if c.numRecords != a.numRecords {
+		return fmt.Errorf("numRecords mismatch: got:%d, want:%d", a.numRecords, c.numRecords)
+	}
+
+	if len(c.blobRefs) != len(a.blobs) {
+		return fmt.Errorf("blob count mismatch: got:%d, want:%d", len(a.blobs), len(c.blobRefs))
+	}
+
+	for id, refs := range c.blobRefs {
+		b, found := a.blobs[id]
+
+		if !found {
+			return fmt.Errorf("missing blob %x", id)
+		}
+
+		if b.refCount != refs {
+			return fmt.Errorf("blob %x refCount mismatch: got:%d, want:%d", id, b.refCount, refs)
+		}
+
+		if makeBlobID(b.value) != id {
+			return fmt.Errorf("blob %x does not match its value", id)
+		}
+	}
+
+	return nil
+}
+
+// invariantChecker accumulates the state of checkInvariants.
+type invariantChecker struct {
+	numNodes   int
+	numRecords int
+	blobRefs   map[blobID]int
+}
+
+func (c *invariantChecker) check(n *node, path []byte, isRoot bool) error {
+	path = appendPath(path, n.key)
+	where := fmt.Sprintf("node %q", path)
+
+	c.numNodes++
+
+	if !isRoot && len(n.key) == 0 {
+		return fmt.Errorf("%s: non-root node with an empty key", where)
+	}
+
+	if n.isRecord {
+		c.numRecords++
+
+		if n.blobValue {
+			id, err := sliceToBlobID(n.data)
+
+			if err != nil {
+				return fmt.Errorf("%s: invalid blobID", where)
+			}
+
+			c.blobRefs[id]++
+		} else if len(n.data) > inlineValueThreshold {
+			return fmt.Errorf("%s: inline value of %d bytes", where, len(n.data))
+		}
+	} else {
+		if n.data != nil || n.blobValue || n.expiresAt != 0 {
+			return fmt.Errorf("%s: non-record node with a value", where)
+		}
+
+		// A non-record node exists only to branch. The root may be a leaf
+		// only if it is a record, since an empty tree has no root.
+		if n.numChildren < 2 {
+			return fmt.Errorf("%s: redundant non-record node with %d children", where, n.numChildren)
+		}
+	}
+
+	// Verify the cached hash against a fresh computation of the subtree.
+	if n.hash != nil {
+		cached := *n.hash
+		fresh := freshMerkleHash(n)
+
+		if cached != fresh {
+			return fmt.Errorf("%s: stale cached hash", where)
+		}
+	}
+
+	count := 0
+
+	for child := n.firstChild; child != nil; child = child.nextSibling {
+		count++
+
+		if next := child.nextSibling; next != nil {
+			if bytes.Compare(child.key, next.key) >= 0 {
+				return fmt.Errorf("%s: unsorted children %q and %q", where, child.key, next.key)
+			}
+
+			if len(child.key) > 0 && len(next.key) > 0 && child.key[0] == next.key[0] {
+				return fmt.Errorf("%s: children %q and %q share a first byte", where, child.key, next.key)
+			}
+		}
+
+		if n.index != nil && len(child.key) > 0 && n.index.get(child.key[0]) != child {
+			return fmt.Errorf("%s: child %q is missing from the index", where, child.key)
+		}
+
+		if err := c.check(child, path, false); err != nil {
+			return err
+		}
+	}
+
+	if count != n.numChildren {
+		return fmt.Errorf("%s: numChildren mismatch: got:%d, want:%d", where, n.numChildren, count)
+	}
+
+	if n.numChildren >= minIndexedChildren && n.index == nil {
+		return fmt.Errorf("%s: missing index with %d children", where, n.numChildren)
+	}
+
+	if n.numChildren < indexShrinkChildren && n.index != nil {
+		return fmt.Errorf("%s: retained index with %d children", where, n.numChildren)
+	}
+
+	if n.index == nil {
+		return nil
+	}
+
+	if n.numChildren > node48MaxChildren && !n.index.isNode256() {
+		return fmt.Errorf("%s: Node48 index with %d children", where, n.numChildren)
+	}
+
+	if n.numChildren < node256ShrinkChildren && n.index.isNode256() {
+		return fmt.Errorf("%s: retained Node256 index with %d children", where, n.numChildren)
+	}
+
+	indexed := 0
+
+	for _, word := range n.index.present {
+		indexed += bits.OnesCount64(word)
+	}
+
+	if indexed != n.numChildren {
+		return fmt.Errorf("%s: index of %d children with %d children", where, indexed, n.numChildren)
+	}
+
+	return nil
+}
+
+// freshMerkleHash computes the Merkle hash of the subtree without consulting
+// or updating the cached hashes.
+func freshMerkleHash(n *node) Hash {
+	mh := newMerkleHasher(n.isRecord, n.data, n.expiresAt)
+
+	for child := n.firstChild; child != nil; child = child.nextSibling {
+		mh.addChild(child.key, freshMerkleHash(child))
+	}
+
+	return mh.sum()
+}
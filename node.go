@@ -3,12 +3,22 @@
 
 package arc
 
-import "bytes"
+import (
+	"bytes"
+	"crypto/sha256"
+)
 
 // node represents an in-memory node of a Radix tree. This implementation is
 // designed to be memory-efficient by maintaining a minimal set of fields for
 // both node representation and persistence metadata. Consider memory overhead
 // carefully before adding new fields to this struct.
+//
+// A node occupies 104 bytes, which the allocator rounds up to 112. Without the
+// expiry time, the cached hash and the child index, it would occupy 80. With
+// 1.0 to 1.4 nodes per record, these fields add 32 to 45 bytes to the 185 to
+// 220 bytes per record that BenchmarkMemoryPerRecord reports. Keeping them in
+// side tables would save that, but would add a lookup to every read, and cost
+// more than the fields once RootHash has cached the hash of every node.
 type node struct {
 	key         []byte // Path segment of the node.
 	isRecord    bool   // True if the node contains a database record.
@@ -16,6 +26,12 @@ type node struct {
 	numChildren int    // Number of connected child nodes.
 	firstChild  *node  // Pointer to the first child node.
 	nextSibling *node  // Pointer to the adjacent sibling node.
+	expiresAt   int64  // Expiry time in Unix nanoseconds. Zero never expires.
+	hash        *Hash  // Cached Merkle hash of the subtree. Nil if stale.
+
+	// First-byte index over the children of wide nodes. Nil for nodes whose
+	// fan-out is small enough for the sibling list to be scanned.
+	index *childIndex
 
 	// Holds the node's content. For values less than or equal to 32 bytes,
 	// it stores the content directly. For larger values, it stores a blobID
@@ -44,6 +60,12 @@ func (n node) isLeaf() bool {
 	return n.firstChild == nil
 }
 
+// expired returns true if the node is a record whose expiry time has passed.
+// The clock is only consulted for records that have an expiry time.
+func (n node) expired() bool {
+	return n.expiresAt != 0 && n.expiresAt <= timeNow().UnixNano()
+}
+
 // value returns a copy of the node's value.
 func (n node) value(bs blobStore) []byte {
 	if n.data == nil {
@@ -61,6 +83,32 @@ func (n node) value(bs blobStore) []byte {
 	return bs.get(n.data)
 }
 
+// valueEquals returns true if the node's value is equal to the given value.
+// Blob values are compared by their blobID, which avoids copying the blob.
+func (n node) valueEquals(value []byte) bool {
+	if !n.blobValue {
+		return bytes.Equal(n.data, value)
+	}
+
+	if len(value) <= inlineValueThreshold {
+		return false
+	}
+
+	id := makeBlobID(value)
+
+	return bytes.Equal(n.data, id[:])
+}
+
+// valueHash returns the SHA-256 hash of the node's value. The hash of a blob
+// value is its blobID, which avoids rehashing the blob.
+func (n node) valueHash() Hash {
+	if n.blobValue {
+		return Hash(n.data)
+	}
+
+	return sha256.Sum256(n.data)
+}
+
 // forEachChild loops over the children of the node, and calls the given
 // callback function on each visit.
 func (n node) forEachChild(cb func(int, *node) error) error {
@@ -81,8 +129,59 @@ func (n node) forEachChild(cb func(int, *node) error) error {
 	return nil
 }
 
+// countSubtree returns the number of nodes and records in the subtree rooted
+// at the receiver node, including the receiver node itself.
+func (n *node) countSubtree() (numNodes int, numRecords int) {
+	numNodes = 1
+
+	if n.isRecord {
+		numRecords = 1
+	}
+
+	for child := n.firstChild; child != nil; child = child.nextSibling {
+		childNodes, childRecords := child.countSubtree()
+
+		numNodes += childNodes
+		numRecords += childRecords
+	}
+
+	return numNodes, numRecords
+}
+
+// releaseSubtree releases the blobs that are referenced by the subtree rooted
+// at the receiver node. It returns the number of nodes and records within the
+// subtree, including the receiver node itself.
+func (n *node) releaseSubtree(bs blobStore) (numNodes int, numRecords int) {
+	numNodes = 1
+
+	if n.isRecord {
+		numRecords = 1
+	}
+
+	if n.blobValue {
+		bs.release(n.data)
+	}
+
+	for child := n.firstChild; child != nil; child = child.nextSibling {
+		childNodes, childRecords := child.releaseSubtree(bs)
+
+		numNodes += childNodes
+		numRecords += childRecords
+	}
+
+	return numNodes, numRecords
+}
+
 // findChild returns the node's child that matches the given key.
 func (n node) findChild(key []byte) (*node, error) {
+	if n.index != nil && len(key) > 0 {
+		if child := n.index.get(key[0]); child != nil && bytes.Equal(child.key, key) {
+			return child, nil
+		}
+
+		return nil, ErrKeyNotFound
+	}
+
 	for child := n.firstChild; child != nil; child = child.nextSibling {
 		if bytes.Equal(child.key, key) {
 			return child, nil
@@ -94,6 +193,12 @@ func (n node) findChild(key []byte) (*node, error) {
 
 // findCompatibleChild returns the first child that shares a common prefix.
 func (n node) findCompatibleChild(key []byte) *node {
+	// Siblings never share a first byte, therefore the child that begins with
+	// the first byte of the key is the only compatible child.
+	if n.index != nil && len(key) > 0 {
+		return n.index.get(key[0])
+	}
+
 	for child := n.firstChild; child != nil; child = child.nextSibling {
 		prefix := longestCommonPrefix(child.key, key)
 
@@ -111,11 +216,14 @@ func (n *node) setKey(key []byte) {
 }
 
 // setValue sets the given value to the node and flags it as a record node.
+// Any expiry time of the previous value is cleared.
 func (n *node) setValue(bs blobStore, value []byte) {
 	if n.blobValue {
 		bs.release(n.data)
 	}
 
+	n.expiresAt = 0
+
 	if len(value) <= inlineValueThreshold {
 		n.data = value
 		n.blobValue = false
@@ -135,6 +243,8 @@ func (n *node) deleteValue(bs blobStore) {
 	}
 
 	n.data = nil
+	n.blobValue = false
+	n.expiresAt = 0
 }
 
 // prependKey prepends the given prefix to the node's existing key.
@@ -156,6 +266,30 @@ func (n *node) prependKey(prefix []byte) {
 func (n *node) addChild(child *node) {
 	n.numChildren++
 
+	if n.index != nil && len(child.key) > 0 {
+		// The index yields the insertion point without scanning the list.
+		if prev := n.index.predecessor(child.key[0]); prev != nil {
+			child.nextSibling = prev.nextSibling
+			prev.nextSibling = child
+		} else {
+			child.nextSibling = n.firstChild
+			n.firstChild = child
+		}
+	} else {
+		n.linkChild(child)
+	}
+
+	// A full Node48 ignores the child, but grows to include it on resize.
+	if n.index != nil {
+		n.index.set(child)
+	}
+
+	n.resizeIndex()
+}
+
+// linkChild inserts the given child into the sorted linked-list of children by
+// scanning the list for the insertion point.
+func (n *node) linkChild(child *node) {
 	// Empty list means the given child becomes the firstChild.
 	if n.firstChild == nil {
 		// Becoming a first child means there are no siblings.
@@ -192,6 +326,26 @@ func (n *node) removeChild(child *node) error {
 		return ErrKeyNotFound
 	}
 
+	if n.index != nil && len(child.key) > 0 {
+		target := n.index.get(child.key[0])
+
+		if target == nil || !bytes.Equal(target.key, child.key) {
+			return ErrKeyNotFound
+		}
+
+		if prev := n.index.predecessor(child.key[0]); prev != nil {
+			prev.nextSibling = target.nextSibling
+		} else {
+			n.firstChild = target.nextSibling
+		}
+
+		n.index.unset(child.key[0])
+		n.numChildren--
+		n.resizeIndex()
+
+		return nil
+	}
+
 	// Special case: removing first child.
 	if bytes.Equal(n.firstChild.key, child.key) {
 		n.firstChild = n.firstChild.nextSibling
@@ -228,7 +382,11 @@ func (n *node) shallowCopyFrom(src *node) {
 	n.key = src.key
 	n.data = src.data
 	n.isRecord = src.isRecord
+	n.blobValue = src.blobValue
+	n.expiresAt = src.expiresAt
+	n.hash = src.hash
 	n.numChildren = src.numChildren
+	n.index = src.index
 	n.firstChild = src.firstChild
 	n.nextSibling = src.nextSibling
 }
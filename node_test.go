@@ -6,6 +6,7 @@ package arc
 import (
 	"bytes"
 	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -133,3 +134,34 @@ func TestPrependKey(t *testing.T) {
 		t.Errorf("unexpected result, got:%q, want:%q", subject.key, expected)
 	}
 }
+
+func TestShallowCopyFrom(t *testing.T) {
+	child := &node{key: []byte("c")}
+	sibling := &node{key: []byte("s")}
+
+	src := &node{
+		key:         []byte("key"),
+		isRecord:    true,
+		blobValue:   true,
+		numChildren: 1,
+		firstChild:  child,
+		nextSibling: sibling,
+		expiresAt:   1700000000000000000,
+		hash:        &Hash{1},
+		index:       &childIndex{},
+		data:        []byte("blob-id"),
+	}
+
+	subject := &node{key: []byte("old"), data: []byte("inline")}
+	subject.shallowCopyFrom(src)
+
+	// Every field must be carried over. A blob reference that is copied as
+	// an inline value would return the blobID as the record's value.
+	if !reflect.DeepEqual(subject, src) {
+		t.Errorf("unexpected result, got:%+v, want:%+v", subject, src)
+	}
+
+	if subject.firstChild != child || subject.index != src.index || subject.hash != src.hash {
+		t.Error("expected references to be shared with the source")
+	}
+}
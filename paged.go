@@ -0,0 +1,820 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/chronohq/arc/format"
+)
+
+// OpenPaged opens the database in the paged file at path, and attaches the
+// database to the file, so that Flush persists the changes that were made
+// since the previous Flush. Unlike Save, which rewrites the whole file, Flush
+// writes only the modified nodes and blobs into free pages, and then publishes
+// them by atomically switching the root pointer of the file. A crash at any
+// point leaves the file as of the last completed Flush. If the file does not
+// exist, OpenPaged creates an empty one. A file that does not use the paged
+// layout fails with ErrNotPaged. Open reads paged files as well, but does not
+// attach to them, and Save must not replace an attached file.
+func OpenPaged(path string) (*Arc, error) {
+	return OpenPagedFS(OSFS{}, path)
+}
+
+// OpenPagedFS is like OpenPaged, but accesses the file through the given VFS.
+func OpenPagedFS(fsys VFS, path string) (*Arc, error) {
+	src, err := readFile(fsys, path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		// The empty file is created atomically, as with Save, so that a crash
+		// never leaves a file without a valid meta behind.
+		if src, err = newPagedFile(); err == nil {
+			err = replaceFile(fsys, path, bytes.NewReader(src))
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := decodeTree(src)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t.paged == nil {
+		return nil, ErrNotPaged
+	}
+
+	f, err := fsys.OpenFile(path, os.O_RDWR, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := New()
+	ret.replace(t)
+	ret.pager = &pager{fsys: fsys, path: path, f: f, pagedState: *t.paged}
+
+	return ret, nil
+}
+
+// Flush persists the changes that were made since the previous Flush to the
+// attached file, see OpenPaged. The I/O of Flush is proportional to the
+// modified nodes and blobs, and to the size of the free-space map, rather than
+// to the size of the database. Flush returns ErrNotAttached if the database is
+// not attached to a file. If publishing the new root pointer fails, it is
+// unknown whether it took effect, and every later Flush returns the same
+// error, until the file is reopened.
+func (a *Arc) Flush() error {
+	a.mu.RLock()
+	p := a.pager
+	a.mu.RUnlock()
+
+	if p == nil {
+		return ErrNotAttached
+	}
+
+	return p.flush(a)
+}
+
+// Close flushes the database and detaches it from its file. The database
+// remains usable in memory. Close returns ErrNotAttached if the database is
+// not attached to a file.
+func (a *Arc) Close() error {
+	a.mu.Lock()
+	p := a.pager
+	a.pager = nil
+	a.mu.Unlock()
+
+	if p == nil {
+		return ErrNotAttached
+	}
+
+	err := p.flush(a)
+
+	return errors.Join(err, p.close())
+}
+
+// extent is a region of a paged file.
+type extent struct {
+	offset uint64
+	size   uint64
+}
+
+// end returns the offset that follows the extent.
+func (e extent) end() uint64 {
+	return e.offset + e.size
+}
+
+// diskRecord is a node record of a paged file. Records are never modified in
+// place, so the record of a node remains valid for as long as the key and the
+// Merkle hash of the node are unchanged.
+type diskRecord struct {
+	extent
+	key      []byte    // Key of the node when the record was written.
+	hash     Hash      // Merkle hash of the subtree when the record was written.
+	children []uint64  // Offsets of the records of the children.
+	blob     *diskBlob // Blob that the record references, if any.
+	node     *node     // Node that the record was written or decoded from.
+}
+
+// diskBlob is a blob of a paged file, which is shared by the records that
+// reference its ID.
+type diskBlob struct {
+	extent
+	id   blobID
+	refs int // Number of live records that reference the blob.
+}
+
+// pagedState indexes the live objects of a paged file. It is built by
+// decoding the file, and is kept current by the flushes of the pager.
+type pagedState struct {
+	meta    format.Meta            // Most recently published meta.
+	pages   []int                  // Number of live objects on each page.
+	records map[uint64]*diskRecord // Live records by offset.
+	nodes   map[*node]*diskRecord  // Live records by the node they were written from.
+	blobs   map[blobID]*diskBlob   // Live blobs by ID.
+	freeMap extent                 // Extent of the current free-space map.
+}
+
+// newPagedState returns the state of a file with the given meta, whose
+// reserved pages are in use and whose objects are yet to be added.
+func newPagedState(meta format.Meta) *pagedState {
+	ret := &pagedState{
+		meta:    meta,
+		pages:   make([]int, meta.NumPages),
+		records: map[uint64]*diskRecord{},
+		nodes:   map[*node]*diskRecord{},
+		blobs:   map[blobID]*diskBlob{},
+	}
+
+	for page := range format.NumReservedPages {
+		ret.pages[page] = 1
+	}
+
+	return ret
+}
+
+// addRecord adds the record of the decoded node at the given extent.
+func (s *pagedState) addRecord(n *node, e extent, children []uint64, blob *diskBlob) {
+	rec := &diskRecord{
+		extent:   e,
+		key:      bytes.Clone(n.key),
+		hash:     n.merkleHash(),
+		children: children,
+		blob:     blob,
+		node:     n,
+	}
+
+	s.records[e.offset] = rec
+	s.nodes[n] = rec
+}
+
+// refBlob adds a reference to the blob with the given ID at the given offset.
+// Every record that references a blob ID must reference the same blob.
+func (s *pagedState) refBlob(id blobID, offset uint64) (*diskBlob, error) {
+	ret, found := s.blobs[id]
+
+	if !found {
+		ret = &diskBlob{extent: extent{offset: offset}, id: id}
+		s.blobs[id] = ret
+	}
+
+	if ret.offset != offset {
+		return nil, ErrNodeCorrupted
+	}
+
+	ret.refs++
+
+	return ret, nil
+}
+
+// addPages adds delta to the object counts of the pages that the extent
+// spans.
+func addPages(pages []int, e extent, delta int) {
+	for page := e.offset / format.PageSize; page*format.PageSize < e.end(); page++ {
+		pages[page] += delta
+	}
+}
+
+// freeMapBitmap returns the bitmap of the free-space map of the given pages.
+func freeMapBitmap(pages []int) []byte {
+	ret := make([]byte, format.FreeMapSize(uint64(len(pages))))
+
+	for page, count := range pages {
+		if count > 0 {
+			ret[page/8] |= 1 << (page % 8)
+		}
+	}
+
+	return ret
+}
+
+// findRun returns the first page of the first run of the given number of free
+// pages. Pages past the end of the file are free.
+func findRun(pages []int, numPages uint64) uint64 {
+	start := uint64(format.NumReservedPages)
+
+	for page := start; page < uint64(len(pages)) && page-start < numPages; page++ {
+		if pages[page] > 0 {
+			start = page + 1
+		}
+	}
+
+	return start
+}
+
+// pagesFor returns the number of pages that hold the given number of bytes.
+func pagesFor(size uint64) uint64 {
+	return (size + format.PageSize - 1) / format.PageSize
+}
+
+// newPagedFile returns the contents of an empty paged file. Its header is
+// static, since the meta holds the root offset and the counts instead.
+func newPagedFile() ([]byte, error) {
+	ret, err := appendPagedHeader(nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	const numPages = format.NumReservedPages + 1
+
+	s := newPagedState(format.Meta{PageSize: format.PageSize, NumPages: numPages})
+	s.pages[numPages-1] = 1
+	s.meta.FreeMapOffset = (numPages - 1) * format.PageSize
+
+	ret = append(ret, make([]byte, s.meta.FreeMapOffset-uint64(len(ret)))...)
+
+	if ret, err = format.AppendBlob(ret, freeMapBitmap(s.pages)); err != nil {
+		return nil, err
+	}
+
+	ret = append(ret, make([]byte, numPages*format.PageSize-len(ret))...)
+	copy(ret[format.MetaOffset(s.meta.Generation):], s.meta.Append(nil))
+
+	return ret, nil
+}
+
+// appendPagedHeader appends the static header of a paged file to dst. Since
+// the header is never rewritten, it declares expiry times up front, as any
+// Flush may write them.
+func appendPagedHeader(dst []byte) ([]byte, error) {
+	header := format.NewHeader()
+	header.Incompat = format.FeaturePagedLayout | format.FeatureNodeExpiry
+
+	return header.Append(dst)
+}
+
+// pager writes the changes of an attached database to its paged file.
+type pager struct {
+	mu   sync.Mutex // Serializes the flushes and compaction batches.
+	fsys VFS
+	path string
+	f    File
+	err  error // Sticky error of a failed publication.
+
+	// Rewrites the file into a fresh one if started by Compact.
+	compaction *compaction
+
+	pagedState
+}
+
+// flushPlan is a flush that was encoded, but not yet written.
+type flushPlan struct {
+	meta    format.Meta       // Meta that publishes the flush.
+	run     extent            // Free pages that the flush writes.
+	buf     []byte            // Contents of the run.
+	records []*diskRecord     // New records.
+	blobs   []*diskBlob       // New blobs.
+	refs    map[*diskBlob]int // Reference count deltas of the blobs.
+	dead    []*diskRecord     // Records that are no longer reachable.
+	pages   []int             // Object counts of the pages after the flush.
+	freeMap extent            // Extent of the new free-space map.
+}
+
+// pendingRecord is a record that a flush writes.
+type pendingRecord struct {
+	rec      *diskRecord
+	node     format.Node   // Encoding of the record, without the offsets.
+	children []*diskRecord // Records of the children.
+}
+
+// flushBuilder collects the new objects that bring a paged file up to date
+// with the database.
+type flushBuilder struct {
+	s        *pagedState
+	blobs    blobStore
+	limit    uint64               // Size after which no records are added, or 0.
+	size     uint64               // Size of the new objects.
+	pending  []pendingRecord      // New records in postorder.
+	newBlobs map[blobID]*diskBlob // New blobs by ID.
+	plan     *flushPlan
+	reused   map[uint64]bool // Offsets of the records that remain live.
+}
+
+// newFlushBuilder returns a builder for the file of the given state.
+func newFlushBuilder(s *pagedState, blobs blobStore, limit uint64) *flushBuilder {
+	return &flushBuilder{
+		s:        s,
+		blobs:    blobs,
+		limit:    limit,
+		newBlobs: map[blobID]*diskBlob{},
+		plan:     &flushPlan{refs: map[*diskBlob]int{}},
+		reused:   map[uint64]bool{},
+	}
+}
+
+// flush writes and publishes the changes of the database. The objects are
+// written into free pages and synced before the meta that references them is
+// written into the slot of the older generation, so the previous generation
+// stays intact until the new one is durable.
+func (p *pager) flush(a *Arc) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	a.mu.Lock()
+	plan, err := p.plan(a)
+	a.mu.Unlock()
+
+	if err != nil || plan == nil {
+		return err
+	}
+
+	if _, err := p.f.WriteAt(plan.buf, int64(plan.run.offset)); err != nil {
+		return err
+	}
+
+	if err := p.f.Sync(); err != nil {
+		return err
+	}
+
+	meta := plan.meta.Append(nil)
+
+	if _, err := p.f.WriteAt(meta, int64(format.MetaOffset(plan.meta.Generation))); err != nil {
+		p.err = err
+		return err
+	}
+
+	if err := p.f.Sync(); err != nil {
+		p.err = err
+		return err
+	}
+
+	p.apply(plan)
+
+	return nil
+}
+
+// close closes the file of the pager, and abandons its compaction.
+func (p *pager) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.compaction != nil {
+		p.abortCompaction()
+	}
+
+	return p.f.Close()
+}
+
+// plan encodes the changes of the database since the last flush, without
+// modifying the state of the pager. It returns nil if there are none. The
+// caller must hold the write lock of the database, since the Merkle hashes
+// are cached in the nodes.
+func (p *pager) plan(a *Arc) (*flushPlan, error) {
+	b := newFlushBuilder(&p.pagedState, a.blobs, 0)
+
+	var root *diskRecord
+
+	if a.root != nil {
+		root = b.visit(a.root)
+	}
+
+	plan := b.plan
+	plan.meta = format.Meta{
+		Generation: p.meta.Generation + 1,
+		PageSize:   format.PageSize,
+		NumNodes:   uint64(a.numNodes),
+		NumRecords: uint64(a.numRecords),
+		Sequence:   a.seq,
+	}
+
+	if root != nil {
+		plan.meta.RootOffset = root.offset
+	}
+
+	if len(b.pending) == 0 && plan.meta.RootOffset == p.meta.RootOffset && plan.meta.Sequence == p.meta.Sequence {
+		return nil, nil
+	}
+
+	b.release(p.meta.RootOffset)
+
+	// The new objects are written back to back into one run of free pages,
+	// followed by the free-space map, whose size depends on the number of
+	// pages of the file. Its size only grows while the run is searched.
+	var start, numRun, numPages uint64
+
+	for {
+		numRun = pagesFor(b.size + plan.freeMap.size)
+		start = findRun(p.pages, numRun)
+		numPages = max(uint64(len(p.pages)), start+numRun)
+
+		freeMapSize := uint64(format.BlobSize(int(format.FreeMapSize(numPages))))
+
+		if freeMapSize <= plan.freeMap.size {
+			break
+		}
+
+		plan.freeMap.size = freeMapSize
+	}
+
+	plan.freeMap.size = uint64(format.BlobSize(int(format.FreeMapSize(numPages))))
+
+	plan.run = extent{offset: start * format.PageSize, size: numRun * format.PageSize}
+	plan.meta.NumPages = numPages
+
+	plan.freeMap.offset = b.assign(plan.run.offset)
+	plan.meta.FreeMapOffset = plan.freeMap.offset
+
+	if root != nil {
+		plan.meta.RootOffset = root.offset
+	}
+
+	return plan, b.encode()
+}
+
+// visit collects the records of the subtree of the node that must be written,
+// and returns the record of the node. The record of a node is reused if the
+// key and the Merkle hash of the node are unchanged, along with the records of
+// its entire subtree. Nodes only move deeper into the subtrees of their
+// ancestors, or are detached, so a reused subtree never shares records with
+// the rest of the tree. Once the size of the new objects reaches the limit,
+// visit returns nil for every node that is not reused, so that only complete
+// subtrees are collected.
+func (b *flushBuilder) visit(n *node) *diskRecord {
+	hash := n.merkleHash()
+
+	if rec, found := b.s.nodes[n]; found && rec.hash == hash && bytes.Equal(rec.key, n.key) {
+		b.reused[rec.offset] = true
+		return rec
+	}
+
+	pr := pendingRecord{
+		rec: &diskRecord{key: bytes.Clone(n.key), hash: hash, node: n},
+	}
+
+	for child := n.firstChild; child != nil; child = child.nextSibling {
+		rec := b.visit(child)
+
+		if rec == nil {
+			return nil
+		}
+
+		pr.children = append(pr.children, rec)
+	}
+
+	if b.limit != 0 && b.size >= b.limit {
+		return nil
+	}
+
+	if n.blobValue {
+		id, _ := sliceToBlobID(n.data)
+		pr.rec.blob = b.blob(id)
+		b.plan.refs[pr.rec.blob]++
+	}
+
+	pr.node = makePersistentNode(*n).toFormat()
+	pr.node.Children = make([]uint64, len(pr.children))
+	pr.rec.size = uint64(pr.node.PagedSize())
+	pr.rec.children = pr.node.Children
+	b.size += pr.rec.size
+
+	b.pending = append(b.pending, pr)
+	b.plan.records = append(b.plan.records, pr.rec)
+
+	return pr.rec
+}
+
+// blob returns the live blob with the given ID, or the new blob that the flush
+// writes if there is none.
+func (b *flushBuilder) blob(id blobID) *diskBlob {
+	if ret, found := b.s.blobs[id]; found {
+		return ret
+	}
+
+	if ret, found := b.newBlobs[id]; found {
+		return ret
+	}
+
+	ret := &diskBlob{id: id}
+	ret.size = uint64(format.BlobSize(len(b.blobs[id].value)))
+	b.size += ret.size
+	b.newBlobs[id] = ret
+	b.plan.blobs = append(b.plan.blobs, ret)
+
+	return ret
+}
+
+// release collects the records of the previous generation that are no longer
+// reachable, starting at the record at the given offset. The walk stops at
+// reused records, so it only visits the records that were replaced.
+func (b *flushBuilder) release(offset uint64) {
+	if offset == 0 || b.reused[offset] {
+		return
+	}
+
+	rec := b.s.records[offset]
+	b.plan.dead = append(b.plan.dead, rec)
+
+	if rec.blob != nil {
+		b.plan.refs[rec.blob]--
+	}
+
+	for _, child := range rec.children {
+		b.release(child)
+	}
+}
+
+// encode computes the object counts of the pages after the flush, and encodes
+// the run. The offsets of the new objects must be assigned.
+func (b *flushBuilder) encode() error {
+	plan := b.plan
+	plan.pages = slices.Clone(b.s.pages)
+	plan.pages = append(plan.pages, make([]int, int(plan.meta.NumPages)-len(plan.pages))...)
+
+	for _, blob := range plan.blobs {
+		addPages(plan.pages, blob.extent, 1)
+	}
+
+	for _, rec := range plan.records {
+		addPages(plan.pages, rec.extent, 1)
+	}
+
+	for _, rec := range plan.dead {
+		addPages(plan.pages, rec.extent, -1)
+	}
+
+	for blob, delta := range plan.refs {
+		if blob.refs+delta == 0 {
+			addPages(plan.pages, blob.extent, -1)
+		}
+	}
+
+	addPages(plan.pages, b.s.freeMap, -1)
+	addPages(plan.pages, plan.freeMap, 1)
+
+	buf, err := b.appendObjects(make([]byte, 0, plan.run.size))
+
+	if err != nil {
+		return err
+	}
+
+	if buf, err = format.AppendBlob(buf, freeMapBitmap(plan.pages)); err != nil {
+		return err
+	}
+
+	plan.buf = append(buf, make([]byte, plan.run.size-uint64(len(buf)))...)
+
+	return nil
+}
+
+// assign assigns offsets to the new objects, which are laid out back to back
+// from the given offset, blobs first. It returns the end of the last object.
+func (b *flushBuilder) assign(offset uint64) uint64 {
+	for _, blob := range b.plan.blobs {
+		blob.offset = offset
+		offset += blob.size
+	}
+
+	for _, pr := range b.pending {
+		pr.rec.offset = offset
+		offset += pr.rec.size
+	}
+
+	return offset
+}
+
+// appendObjects appends the encoded new objects to dst in the order of
+// assign, and returns the extended buffer.
+func (b *flushBuilder) appendObjects(dst []byte) ([]byte, error) {
+	var err error
+
+	for _, blob := range b.plan.blobs {
+		if dst, err = format.AppendBlob(dst, b.blobs[blob.id].value); err != nil {
+			return dst, err
+		}
+	}
+
+	for _, pr := range b.pending {
+		for i, child := range pr.children {
+			pr.node.Children[i] = child.offset
+		}
+
+		if pr.rec.blob != nil {
+			pr.node.BlobOffset = pr.rec.blob.offset
+		}
+
+		if dst, err = pr.node.AppendPaged(dst); err != nil {
+			return dst, err
+		}
+	}
+
+	return dst, nil
+}
+
+// apply updates the state of the pager once the flush is published.
+func (p *pager) apply(plan *flushPlan) {
+	for _, rec := range plan.dead {
+		delete(p.records, rec.offset)
+
+		if p.nodes[rec.node] == rec {
+			delete(p.nodes, rec.node)
+		}
+	}
+
+	for _, rec := range plan.records {
+		p.records[rec.offset] = rec
+		p.nodes[rec.node] = rec
+	}
+
+	for _, blob := range plan.blobs {
+		p.blobs[blob.id] = blob
+	}
+
+	for blob, delta := range plan.refs {
+		if blob.refs += delta; blob.refs == 0 {
+			delete(p.blobs, blob.id)
+		}
+	}
+
+	p.meta = plan.meta
+	p.pages = plan.pages
+	p.freeMap = plan.freeMap
+}
+
+// decodePagedTree decodes a file in the paged layout, whose header was
+// decoded into ret. The header of a paged file is static, and the current meta
+// holds the root offset, the counts and the sequence number instead. Every
+// object must lie within the pages of the meta without overlapping another,
+// and the free-space map must mark exactly the pages that hold live objects.
+func decodePagedTree(src []byte, header arcHeader, ret decodedTree) (decodedTree, error) {
+	if header.incompat&format.FeatureCompactNodes != 0 || header.rootOffset != 0 ||
+		header.numNodes != 0 || header.numRecords != 0 || header.seq != 0 {
+		return ret, corruptionAt(0, ErrCorrupted)
+	}
+
+	meta, err := decodeCurrentMeta(src)
+
+	if err != nil {
+		return ret, err
+	}
+
+	metaOffset := format.MetaOffset(meta.Generation)
+
+	if uint64(len(src))/format.PageSize < meta.NumPages {
+		return ret, corruptionAt(metaOffset, ErrCorrupted)
+	}
+
+	s := newPagedState(meta)
+	d := treeDecoder{
+		src:      src[:meta.NumPages*format.PageSize],
+		paged:    s,
+		expiry:   header.incompat&format.FeatureNodeExpiry != 0,
+		start:    format.NumReservedPages * format.PageSize,
+		visited:  map[uint64]bool{},
+		blobRefs: map[blobID]int{},
+	}
+
+	if meta.RootOffset != 0 {
+		if meta.RootOffset >= uint64(len(d.src)) {
+			return ret, corruptionAt(metaOffset, ErrCorrupted)
+		}
+
+		if ret.root, _, err = d.decodeNode(meta.RootOffset, 0, true); err != nil {
+			return ret, err
+		}
+
+		if !ret.root.isRecord && ret.root.numChildren < 2 {
+			return ret, corruptionAt(meta.RootOffset, ErrNodeCorrupted)
+		}
+	}
+
+	if meta.NumNodes != uint64(d.numNodes) || meta.NumRecords != uint64(d.numRecords) {
+		return ret, corruptionAt(metaOffset, ErrCorrupted)
+	}
+
+	ret.numNodes = d.numNodes
+	ret.numRecords = d.numRecords
+	ret.seq = meta.Sequence
+
+	extents := []extent{}
+
+	for id, b := range s.blobs {
+		value, size, err := d.decodePagedBlob(b.offset)
+
+		if err == nil && makeBlobID(value) != id {
+			err = ErrCorrupted
+		}
+
+		if err != nil {
+			return ret, corruptionAt(b.offset, err)
+		}
+
+		b.size = uint64(size)
+		ret.blobs[id] = &blob{value: value, refCount: b.refs}
+		extents = append(extents, b.extent)
+	}
+
+	bitmap, size, err := d.decodePagedBlob(meta.FreeMapOffset)
+
+	if err == nil && uint64(len(bitmap)) != format.FreeMapSize(meta.NumPages) {
+		err = ErrCorrupted
+	}
+
+	if err != nil {
+		return ret, corruptionAt(meta.FreeMapOffset, err)
+	}
+
+	s.freeMap = extent{offset: meta.FreeMapOffset, size: uint64(size)}
+	extents = append(extents, s.freeMap)
+
+	for _, rec := range s.records {
+		extents = append(extents, rec.extent)
+	}
+
+	slices.SortFunc(extents, func(x, y extent) int {
+		return cmp.Compare(x.offset, y.offset)
+	})
+
+	for i, e := range extents {
+		if i > 0 && extents[i-1].end() > e.offset {
+			return ret, corruptionAt(e.offset, ErrCorrupted)
+		}
+
+		addPages(s.pages, e, 1)
+	}
+
+	if !bytes.Equal(bitmap, freeMapBitmap(s.pages)) {
+		return ret, corruptionAt(meta.FreeMapOffset, ErrCorrupted)
+	}
+
+	ret.paged = s
+
+	return ret, nil
+}
+
+// decodePagedBlob decodes the blob at the given offset of a paged file.
+func (d *treeDecoder) decodePagedBlob(offset uint64) ([]byte, int, error) {
+	if offset < d.start || offset >= uint64(len(d.src)) {
+		return nil, 0, ErrCorrupted
+	}
+
+	return format.DecodeBlob(d.src[offset:])
+}
+
+// decodeCurrentMeta decodes the valid meta with the highest generation. A
+// meta is only valid in the slot of its generation, so a meta that was torn
+// while it was written is ignored in favor of the previous generation.
+func decodeCurrentMeta(src []byte) (format.Meta, error) {
+	var ret format.Meta
+
+	found := false
+
+	for generation := range uint64(2) {
+		offset := format.MetaOffset(generation)
+
+		if uint64(len(src)) < offset+format.MetaSize {
+			continue
+		}
+
+		meta, err := format.DecodeMeta(src[offset:])
+
+		if err != nil || format.MetaOffset(meta.Generation) != offset {
+			continue
+		}
+
+		if !found || meta.Generation > ret.Generation {
+			ret, found = meta, true
+		}
+	}
+
+	if !found {
+		return ret, corruptionAt(format.MetaOffset(0), ErrCorrupted)
+	}
+
+	return ret, nil
+}
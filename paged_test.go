@@ -0,0 +1,621 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/chronohq/arc/format"
+)
+
+func TestOpenPaged(t *testing.T) {
+	testCases := []struct {
+		name string
+		fsys VFS
+		path string
+	}{
+		{"os filesystem", OSFS{}, filepath.Join(t.TempDir(), "arc.db")},
+		{"memory filesystem", NewMemFS(), "arc.db"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			arc, err := OpenPagedFS(tc.fsys, tc.path)
+
+			if err != nil {
+				t.Fatalf("OpenPagedFS(): %v", err)
+			}
+
+			if arc.Len() != 0 {
+				t.Errorf("unexpected length: got:%d, want:0", arc.Len())
+			}
+
+			for _, n := range basicTestTreeData() {
+				arc.Put(n.key, n.data)
+			}
+
+			arc.Put([]byte("apple"), blobValueX())
+
+			if err := arc.Close(); err != nil {
+				t.Fatalf("Close(): %v", err)
+			}
+
+			got, err := OpenPagedFS(tc.fsys, tc.path)
+
+			if err != nil {
+				t.Fatalf("OpenPagedFS(): %v", err)
+			}
+
+			assertEquivalentTree(t, got, arc)
+
+			// Open reads paged files as well, without attaching to them.
+			detached, err := OpenFS(tc.fsys, tc.path)
+
+			if err != nil {
+				t.Fatalf("OpenFS(): %v", err)
+			}
+
+			assertEquivalentTree(t, detached, arc)
+
+			if err := detached.Flush(); !errors.Is(err, ErrNotAttached) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrNotAttached)
+			}
+
+			if err := got.Close(); err != nil {
+				t.Fatalf("Close(): %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenPagedNotPaged(t *testing.T) {
+	m := NewMemFS()
+
+	if err := basicTestTree().SaveFS(m, "arc.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenPagedFS(m, "arc.db"); !errors.Is(err, ErrNotPaged) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrNotPaged)
+	}
+}
+
+func TestFlushNotAttached(t *testing.T) {
+	arc := New()
+
+	if err := arc.Flush(); !errors.Is(err, ErrNotAttached) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrNotAttached)
+	}
+
+	if err := arc.Close(); !errors.Is(err, ErrNotAttached) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrNotAttached)
+	}
+
+	arc, err := OpenPagedFS(NewMemFS(), "arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := arc.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+
+	// The database remains usable in memory after Close.
+	arc.Put([]byte("apple"), []byte("red"))
+
+	if err := arc.Flush(); !errors.Is(err, ErrNotAttached) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrNotAttached)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	large := func(c byte) []byte {
+		return bytes.Repeat([]byte{c}, inlineValueThreshold*2)
+	}
+
+	// Each step modifies the database before it is flushed, and builds on the
+	// previous steps.
+	testCases := []struct {
+		name   string
+		modify func(a *Arc)
+	}{
+		{"insert", func(a *Arc) {
+			for _, n := range basicTestTreeData() {
+				a.Put(n.key, n.data)
+			}
+		}},
+		{"no changes", func(a *Arc) {}},
+		{"update", func(a *Arc) { a.Put([]byte("bald"), []byte("eagle")) }},
+		{"split", func(a *Arc) { a.Put([]byte("banter"), []byte("chat")) }},
+		{"merge", func(a *Arc) { a.Delete([]byte("banter")) }},
+		{"blob", func(a *Arc) { a.Put([]byte("apple"), large('a')) }},
+		{"shared blob", func(a *Arc) { a.Put([]byte("apricot"), large('a')) }},
+		{"release shared blob", func(a *Arc) { a.Delete([]byte("apple")) }},
+		{"replace blob", func(a *Arc) { a.Put([]byte("apricot"), large('b')) }},
+		{"expiry", func(a *Arc) { a.PutWithTTL([]byte("cherry"), []byte("red"), time.Hour) }},
+		{"delete all", func(a *Arc) {
+			for _, key := range collectKeys(a) {
+				a.Delete(key)
+			}
+		}},
+		{"reinsert", func(a *Arc) {
+			for i := range 500 {
+				a.Put(fmt.Appendf(nil, "key-%04d", i), fmt.Appendf(nil, "value-%d", i))
+			}
+
+			a.Put([]byte("blob"), large('c'))
+		}},
+	}
+
+	m := NewMemFS()
+	arc, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.modify(arc)
+
+			if err := arc.Flush(); err != nil {
+				t.Fatalf("Flush(): %v", err)
+			}
+
+			got, err := OpenPagedFS(m, "arc.db")
+
+			if err != nil {
+				t.Fatalf("OpenPagedFS(): %v", err)
+			}
+
+			assertEquivalentTree(t, got, arc)
+			assertPagedState(t, arc.pager.pagedState, got.pager.pagedState)
+		})
+	}
+}
+
+func TestFlushIsPartial(t *testing.T) {
+	fsys := &countingFS{VFS: NewMemFS()}
+	arc, err := OpenPagedFS(fsys, "arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 20000 {
+		arc.Put(fmt.Appendf(nil, "key-%06d", i), fmt.Appendf(nil, "value-%d", i))
+	}
+
+	if err := arc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	fileSize := arc.pager.meta.NumPages * format.PageSize
+
+	// Flushing an unchanged database writes nothing.
+	fsys.written = 0
+
+	if err := arc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fsys.written != 0 {
+		t.Errorf("unexpected bytes written: got:%d, want:0", fsys.written)
+	}
+
+	// A single update rewrites its path from the root, along with the meta
+	// and the free-space map. The path fits into a page or two, and the
+	// free-space map of a file of this size into a page.
+	arc.Put([]byte("key-010000"), []byte("updated"))
+
+	if err := arc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if limit := uint64(3*format.PageSize + format.MetaSize); fsys.written > limit {
+		t.Errorf("flush wrote too much: got:%d, want:<=%d of %d", fsys.written, limit, fileSize)
+	}
+}
+
+func TestFlushReusesPages(t *testing.T) {
+	m := NewMemFS()
+	arc, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 1000 {
+		arc.Put(fmt.Appendf(nil, "key-%04d", i), fmt.Appendf(nil, "value-%d", i))
+	}
+
+	if err := arc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	numPages := arc.pager.meta.NumPages
+
+	// The pages of every replaced generation are freed once the next one is
+	// published, so repeated updates do not grow the file.
+	for i := range 100 {
+		arc.Put([]byte("key-0500"), bytes.Repeat([]byte{byte(i)}, inlineValueThreshold*2))
+
+		if err := arc.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := arc.pager.meta.NumPages; got > numPages+2 {
+		t.Errorf("file grew from %d to %d pages", numPages, got)
+	}
+
+	got, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquivalentTree(t, got, arc)
+	assertPagedState(t, arc.pager.pagedState, got.pager.pagedState)
+}
+
+func TestFlushFaults(t *testing.T) {
+	populate := func(a *Arc) {
+		for _, n := range basicTestTreeData() {
+			a.Put(n.key, n.data)
+		}
+
+		a.Put([]byte("apple"), blobValueX())
+	}
+
+	modify := func(a *Arc) {
+		a.Put([]byte("apricot"), blobValueX())
+		a.Delete([]byte("apple"))
+		a.Delete([]byte("banana"))
+		a.Put([]byte("cherry"), bytes.Repeat([]byte("c"), inlineValueThreshold*2))
+	}
+
+	// Count the steps of a fault-free flush.
+	m := NewMemFS()
+	arc, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	populate(arc)
+
+	if err := arc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := m.Steps()
+	modify(arc)
+
+	if err := arc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	numSteps := m.Steps() - start
+
+	// Inject every fault into every step of the second flush. The file must
+	// hold either the previous or the new database after a restart, and must
+	// accept further flushes.
+	for step := range numSteps {
+		for _, fault := range []Fault{FaultCrash, FaultTornWrite, FaultError} {
+			t.Run(fmt.Sprintf("fault %d at step %d", fault, step), func(t *testing.T) {
+				m := NewMemFS()
+				arc, err := OpenPagedFS(m, "arc.db")
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				populate(arc)
+
+				if err := arc.Flush(); err != nil {
+					t.Fatal(err)
+				}
+
+				before := arc.RootHash()
+				modify(arc)
+				after := arc.RootHash()
+
+				m.InjectFault(m.Steps()+step, fault)
+
+				if err := arc.Flush(); !errors.Is(err, ErrInjectedFault) {
+					t.Fatalf("unexpected error: got:%v, want:%v", err, ErrInjectedFault)
+				}
+
+				m.Crash()
+				m.Restart()
+
+				got, err := OpenPagedFS(m, "arc.db")
+
+				if err != nil {
+					t.Fatalf("OpenPagedFS(): %v", err)
+				}
+
+				if hash := got.RootHash(); hash != before && hash != after {
+					t.Fatalf("unexpected root hash: got:%x, want:%x or %x", hash, before, after)
+				}
+
+				modify(got)
+				got.Put([]byte("durian"), []byte("spiky"))
+
+				if err := got.Flush(); err != nil {
+					t.Fatalf("Flush(): %v", err)
+				}
+
+				reopened, err := OpenPagedFS(m, "arc.db")
+
+				if err != nil {
+					t.Fatalf("OpenPagedFS(): %v", err)
+				}
+
+				assertEquivalentTree(t, reopened, got)
+			})
+		}
+	}
+}
+
+func TestFlushErrors(t *testing.T) {
+	// A flush writes and syncs the new objects, and then writes and syncs
+	// the meta.
+	testCases := []struct {
+		name   string
+		step   int
+		sticky bool
+	}{
+		{"write objects", 0, false},
+		{"sync objects", 1, false},
+		{"write meta", 2, true},
+		{"sync meta", 3, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMemFS()
+			arc, err := OpenPagedFS(m, "arc.db")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			arc.Put([]byte("apple"), []byte("red"))
+			m.InjectFault(m.Steps()+tc.step, FaultError)
+
+			if err := arc.Flush(); !errors.Is(err, ErrInjectedFault) {
+				t.Fatalf("unexpected error: got:%v, want:%v", err, ErrInjectedFault)
+			}
+
+			err = arc.Flush()
+
+			if tc.sticky {
+				// The outcome of the publication is unknown, so the pager
+				// refuses to write over either generation.
+				if !errors.Is(err, ErrInjectedFault) {
+					t.Fatalf("unexpected error: got:%v, want:%v", err, ErrInjectedFault)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Flush(): %v", err)
+			}
+
+			got, err := OpenPagedFS(m, "arc.db")
+
+			if err != nil {
+				t.Fatalf("OpenPagedFS(): %v", err)
+			}
+
+			assertEquivalentTree(t, got, arc)
+		})
+	}
+}
+
+func TestOpenPagedCorrupted(t *testing.T) {
+	m := NewMemFS()
+	arc, err := OpenPagedFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range basicTestTreeData() {
+		arc.Put(n.key, n.data)
+	}
+
+	arc.Put([]byte("apple"), blobValueX())
+
+	if err := arc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := m.ReadFile("arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := func(modify func(*format.Meta)) []byte {
+		ret := bytes.Clone(src)
+		offset := format.MetaOffset(1)
+		current, err := format.DecodeMeta(ret[offset:])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		modify(&current)
+		copy(ret[offset:], current.Append(nil))
+
+		return ret
+	}
+
+	testCases := []struct {
+		name string
+		src  []byte
+	}{
+		{"no valid meta", func() []byte {
+			ret := bytes.Clone(src)
+			clear(ret[format.PageSize : format.NumReservedPages*format.PageSize])
+			return ret
+		}()},
+		{"truncated pages", src[:len(src)-format.PageSize]},
+		{"counts mismatch", meta(func(m *format.Meta) { m.NumRecords++ })},
+		{"root in reserved pages", meta(func(m *format.Meta) { m.RootOffset = format.PageSize })},
+		{"root beyond pages", meta(func(m *format.Meta) { m.RootOffset = m.NumPages * format.PageSize })},
+		{"free map in reserved pages", meta(func(m *format.Meta) { m.FreeMapOffset = 0 })},
+		{"free map mismatch", meta(func(m *format.Meta) { m.NumPages++ })},
+		{"header root offset", func() []byte {
+			ret := bytes.Clone(src)
+			header, _ := format.DecodeHeader(ret)
+			header.RootOffset = format.NumReservedPages * format.PageSize
+			encoded, _ := header.Append(nil)
+			copy(ret, encoded)
+			return ret
+		}()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMemFS()
+			m.WriteFile("arc.db", tc.src)
+
+			_, err := OpenPagedFS(m, "arc.db")
+
+			var ce *CorruptionError
+
+			if !errors.As(err, &ce) {
+				t.Errorf("unexpected error: got:%v, want:*CorruptionError", err)
+			}
+		})
+	}
+
+	// Flipping any byte either fails with a *CorruptionError, or with an
+	// unsupported error if it hits the version or the features, or leaves a
+	// valid database if the byte is unused, such as the padding of a page or
+	// the meta of the previous generation.
+	for i := range src {
+		corrupted := bytes.Clone(src)
+		corrupted[i] ^= 0xff
+
+		m := NewMemFS()
+		m.WriteFile("arc.db", corrupted)
+
+		got, err := OpenPagedFS(m, "arc.db")
+
+		if err != nil {
+			var ce *CorruptionError
+
+			if !errors.As(err, &ce) && !isUnsupported(err) {
+				t.Fatalf("untyped error for byte %d: %v", i, err)
+			}
+
+			continue
+		}
+
+		if err := checkInvariants(got); err != nil {
+			t.Fatalf("database violates invariants for byte %d: %v", i, err)
+		}
+	}
+}
+
+// assertPagedState verifies that the state of a pager matches the state that
+// is decoded from its file.
+func assertPagedState(t *testing.T, got pagedState, want pagedState) {
+	t.Helper()
+
+	if got.meta != want.meta {
+		t.Fatalf("unexpected meta: got:%+v, want:%+v", got.meta, want.meta)
+	}
+
+	if !slices.Equal(got.pages, want.pages) {
+		t.Fatalf("unexpected page counts: got:%v, want:%v", got.pages, want.pages)
+	}
+
+	if got.freeMap != want.freeMap {
+		t.Fatalf("unexpected free map: got:%+v, want:%+v", got.freeMap, want.freeMap)
+	}
+
+	if gotOffsets, wantOffsets := slices.Sorted(maps.Keys(got.records)), slices.Sorted(maps.Keys(want.records)); !slices.Equal(gotOffsets, wantOffsets) {
+		t.Fatalf("unexpected records: got:%v, want:%v", gotOffsets, wantOffsets)
+	}
+
+	if len(got.nodes) != len(got.records) {
+		t.Fatalf("unexpected node count: got:%d, want:%d", len(got.nodes), len(got.records))
+	}
+
+	if len(got.blobs) != len(want.blobs) {
+		t.Fatalf("unexpected blob count: got:%d, want:%d", len(got.blobs), len(want.blobs))
+	}
+
+	for id, wantBlob := range want.blobs {
+		if gotBlob, found := got.blobs[id]; !found || *gotBlob != *wantBlob {
+			t.Fatalf("unexpected blob %x: got:%+v, want:%+v", id, gotBlob, wantBlob)
+		}
+	}
+}
+
+// collectKeys returns the keys of the records of the database.
+func collectKeys(a *Arc) [][]byte {
+	var ret [][]byte
+
+	for key := range a.Scan(nil) {
+		ret = append(ret, bytes.Clone(key))
+	}
+
+	return ret
+}
+
+// countingFS is a VFS that counts the bytes that are written through it.
+type countingFS struct {
+	VFS
+	written uint64
+}
+
+// OpenFile opens the named file, whose writes are counted.
+func (c *countingFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := c.VFS.OpenFile(name, flag, perm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &countingFile{File: f, fs: c}, nil
+}
+
+// countingFile is a file of a countingFS.
+type countingFile struct {
+	File
+	fs *countingFS
+}
+
+// Write writes to the file and counts the written bytes.
+func (f *countingFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.fs.written += uint64(n)
+
+	return n, err
+}
+
+// WriteAt writes to the file and counts the written bytes.
+func (f *countingFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	f.fs.written += uint64(n)
+
+	return n, err
+}
@@ -0,0 +1,237 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import "bytes"
+
+// Proof is a Merkle proof of the presence or absence of a key under a root
+// hash. It consists of the nodes along the path of the key, which carry the
+// hashes of their children that are off the path.
+type Proof struct {
+	Root  Hash        // Root hash under which the proof was generated.
+	Nodes []ProofNode // Nodes along the path, starting with the virtual root.
+}
+
+// ProofNode is a node along the path of a proven key. The first node is the
+// virtual parent of the root node, which is not a record.
+type ProofNode struct {
+	Record    bool         // True if the node holds a record.
+	Value     []byte       // Value of the record, or its SHA-256 hash if it exceeds 32 bytes.
+	ExpiresAt int64        // Expiry time of the record in Unix nanoseconds, or zero.
+	Children  []ProofChild // Children of the node in ascending key order.
+}
+
+// ProofChild is a child of a ProofNode. The child that continues the path of
+// the proven key has a zero hash, since its hash is derived from the next node.
+type ProofChild struct {
+	Key  []byte // Key segment of the child.
+	Hash Hash   // Merkle hash of the child's subtree.
+}
+
+// Prove returns the value of the key along with a proof of its presence under
+// the current root hash. If the key does not exist, the returned value is nil
+// and the proof shows its absence. Records that have expired but have not yet
+// been reclaimed are part of the root hash, and are therefore proven present.
+func (a *Arc) Prove(key []byte) ([]byte, *Proof, error) {
+	if key == nil {
+		return nil, nil, ErrNilKey
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	a.hashMu.Lock()
+	defer a.hashMu.Unlock()
+
+	proof := &Proof{Root: a.rootHash(), Nodes: []ProofNode{{}}}
+
+	if a.root == nil {
+		return nil, proof, nil
+	}
+
+	// The virtual root has the root node as its only child.
+	current := a.root
+	siblings := []*node{a.root}
+
+	for {
+		last := &proof.Nodes[len(proof.Nodes)-1]
+		onPath := bytes.HasPrefix(key, current.key)
+
+		for _, sibling := range siblings {
+			child := ProofChild{Key: bytes.Clone(sibling.key)}
+
+			if sibling != current || !onPath {
+				child.Hash = sibling.merkleHash()
+			}
+
+			last.Children = append(last.Children, child)
+		}
+
+		if !onPath {
+			return nil, proof, nil
+		}
+
+		key = key[len(current.key):]
+
+		pn := ProofNode{Record: current.isRecord, ExpiresAt: current.expiresAt}
+
+		if current.isRecord {
+			pn.Value = bytes.Clone(current.data)
+
+			if pn.Value == nil {
+				pn.Value = []byte{}
+			}
+		}
+
+		proof.Nodes = append(proof.Nodes, pn)
+
+		siblings = siblings[:0]
+
+		for child := current.firstChild; child != nil; child = child.nextSibling {
+			siblings = append(siblings, child)
+		}
+
+		if len(key) == 0 {
+			break
+		}
+
+		if current = current.findCompatibleChild(key); current == nil {
+			break
+		}
+	}
+
+	// Record the children of the last node, none of which is on the path.
+	last := &proof.Nodes[len(proof.Nodes)-1]
+
+	for _, sibling := range siblings {
+		last.Children = append(last.Children, ProofChild{
+			Key:  bytes.Clone(sibling.key),
+			Hash: sibling.merkleHash(),
+		})
+	}
+
+	if len(key) > 0 || !last.Record {
+		return nil, proof, nil
+	}
+
+	value := current.value(a.blobs)
+
+	if value == nil {
+		value = []byte{}
+	}
+
+	return value, proof, nil
+}
+
+// VerifyProof verifies that the proof shows the given value of the key under
+// the root hash. A nil value verifies the absence of the key, whereas a
+// non-nil empty value verifies the presence of an empty value. It returns
+// ErrInvalidProof if the proof does not hold.
+func VerifyProof(root Hash, key []byte, value []byte, proof *Proof) error {
+	if key == nil {
+		return ErrNilKey
+	}
+
+	if proof == nil || len(proof.Nodes) == 0 {
+		return ErrInvalidProof
+	}
+
+	// Every key is absent from an empty database.
+	if root.IsZero() {
+		if value == nil && len(proof.Nodes) == 1 && len(proof.Nodes[0].Children) == 0 {
+			return nil
+		}
+
+		return ErrInvalidProof
+	}
+
+	// The virtual root is not a record, and its only child is the root node.
+	if proof.Nodes[0].Record || len(proof.Nodes[0].Children) != 1 {
+		return ErrInvalidProof
+	}
+
+	// Follow the key through the path, where every node except the last one
+	// must have exactly one child that continues the path.
+	remaining := key
+	last := len(proof.Nodes) - 1
+	pathIndex := make([]int, last)
+
+	for i, pn := range proof.Nodes[:last] {
+		pathIndex[i] = -1
+
+		for j, child := range pn.Children {
+			if !child.Hash.IsZero() {
+				continue
+			}
+
+			if pathIndex[i] != -1 || !bytes.HasPrefix(remaining, child.Key) {
+				return ErrInvalidProof
+			}
+
+			pathIndex[i] = j
+		}
+
+		if pathIndex[i] == -1 {
+			return ErrInvalidProof
+		}
+
+		remaining = remaining[len(pn.Children[pathIndex[i]].Key):]
+	}
+
+	// The last node decides whether the key is present.
+	terminal := proof.Nodes[last]
+	found := last > 0 && len(remaining) == 0 && terminal.Record
+
+	for _, child := range terminal.Children {
+		if child.Hash.IsZero() {
+			return ErrInvalidProof
+		}
+
+		// A child on the path means that the proof ends prematurely.
+		if len(remaining) > 0 && bytes.HasPrefix(remaining, child.Key) {
+			return ErrInvalidProof
+		}
+	}
+
+	switch {
+	case value == nil && found:
+		return ErrInvalidProof
+	case value != nil && (!found || !bytes.Equal(terminal.Value, hashedValue(value))):
+		return ErrInvalidProof
+	}
+
+	// Recompute the hashes from the last node up to the root.
+	var h Hash
+
+	for i := last; i >= 0; i-- {
+		pn := proof.Nodes[i]
+		mh := newMerkleHasher(pn.Record, pn.Value, pn.ExpiresAt)
+
+		for j, child := range pn.Children {
+			if i < last && j == pathIndex[i] {
+				mh.addChild(child.Key, h)
+			} else {
+				mh.addChild(child.Key, child.Hash)
+			}
+		}
+
+		h = mh.sum()
+	}
+
+	if h != root {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+// hashedValue returns the representation of the value in the Merkle hash,
+// which is the value itself or its blobID.
+func hashedValue(value []byte) []byte {
+	if len(value) <= inlineValueThreshold {
+		return value
+	}
+
+	return makeBlobID(value).Slice()
+}
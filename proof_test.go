@@ -0,0 +1,144 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestProve(t *testing.T) {
+	arc := basicTestTree()
+	arc.Put([]byte("apple"), blobValueX())
+	arc.Put([]byte("empty"), []byte{})
+
+	root := arc.RootHash()
+
+	testCases := []struct {
+		name      string
+		key       []byte
+		wantValue []byte
+	}{
+		{"inline value", []byte("grape"), []byte("vine")},
+		{"blob value", []byte("apple"), blobValueX()},
+		{"empty value", []byte("empty"), []byte{}},
+		{"record with children", []byte("grapefruit"), []byte("citrus")},
+		{"absent prefix of key", []byte("appl"), nil},
+		{"absent extension of key", []byte("applesauce"), nil},
+		{"absent divergent key", []byte("apz"), nil},
+		{"absent first byte", []byte("zebra"), nil},
+		{"absent empty key", []byte{}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, proof, err := arc.Prove(tc.key)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !bytes.Equal(value, tc.wantValue) || (value == nil) != (tc.wantValue == nil) {
+				t.Fatalf("unexpected value: got:%q, want:%q", value, tc.wantValue)
+			}
+
+			if proof.Root != root {
+				t.Errorf("unexpected proof root: got:%s, want:%s", proof.Root, root)
+			}
+
+			if err := VerifyProof(root, tc.key, value, proof); err != nil {
+				t.Errorf("VerifyProof(): %v", err)
+			}
+
+			// The proof must not hold for a different value.
+			wrongValues := [][]byte{[]byte("forged"), blobValueX()[1:]}
+
+			if value == nil {
+				wrongValues = append(wrongValues, []byte{})
+			} else {
+				wrongValues = append(wrongValues, nil)
+			}
+
+			for _, wrong := range wrongValues {
+				if err := VerifyProof(root, tc.key, wrong, proof); !errors.Is(err, ErrInvalidProof) {
+					t.Errorf("unexpected error for value %q: got:%v, want:%v", wrong, err, ErrInvalidProof)
+				}
+			}
+
+			// The proof must not hold under a different root.
+			if err := VerifyProof(basicTestTree().RootHash(), tc.key, value, proof); !errors.Is(err, ErrInvalidProof) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidProof)
+			}
+		})
+	}
+}
+
+func TestProveTampered(t *testing.T) {
+	arc := basicTestTree()
+	root := arc.RootHash()
+	value, proof, _ := arc.Prove([]byte("bandage"))
+
+	testCases := []struct {
+		name   string
+		tamper func(p *Proof)
+	}{
+		{"truncated path", func(p *Proof) { p.Nodes = p.Nodes[:len(p.Nodes)-1] }},
+		{"flipped record", func(p *Proof) { p.Nodes[len(p.Nodes)-1].Record = false }},
+		{"altered expiry", func(p *Proof) { p.Nodes[len(p.Nodes)-1].ExpiresAt = 1 }},
+		{"altered sibling hash", func(p *Proof) { p.Nodes[1].Children[0].Hash[0] ^= 1 }},
+		{"altered sibling key", func(p *Proof) { p.Nodes[1].Children[0].Key = []byte("a") }},
+		{"removed sibling", func(p *Proof) { p.Nodes[1].Children = p.Nodes[1].Children[1:] }},
+		{"record virtual root", func(p *Proof) { p.Nodes[0].Record = true }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tampered := cloneProof(proof)
+			tc.tamper(tampered)
+
+			if err := VerifyProof(root, []byte("bandage"), value, tampered); !errors.Is(err, ErrInvalidProof) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidProof)
+			}
+		})
+	}
+
+	// A proof of one key does not prove another key.
+	if err := VerifyProof(root, []byte("bandsaw"), value, proof); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidProof)
+	}
+}
+
+func TestProveEmpty(t *testing.T) {
+	arc := New()
+	value, proof, err := arc.Prove([]byte("apple"))
+
+	if err != nil || value != nil {
+		t.Fatalf("unexpected result: value:%q, err:%v", value, err)
+	}
+
+	if err := VerifyProof(Hash{}, []byte("apple"), nil, proof); err != nil {
+		t.Errorf("VerifyProof(): %v", err)
+	}
+
+	if err := VerifyProof(Hash{}, []byte("apple"), []byte("red"), proof); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidProof)
+	}
+
+	if _, _, err := arc.Prove(nil); !errors.Is(err, ErrNilKey) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrNilKey)
+	}
+}
+
+func cloneProof(p *Proof) *Proof {
+	ret := &Proof{Root: p.Root}
+
+	for _, pn := range p.Nodes {
+		clone := pn
+		clone.Children = append([]ProofChild(nil), pn.Children...)
+		ret.Nodes = append(ret.Nodes, clone)
+	}
+
+	return ret
+}
@@ -0,0 +1,585 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Replication messages are framed as the message type (uint8), the payload
+// length (uint32), and the payload. Payloads longer than maxFrameSize are
+// split into several frames, all of which but the last have frameContinued
+// set in their message type. The follower opens the stream with a hello
+// message that carries the sequence number of its last applied change, and
+// the epoch of the history that the sequence number belongs to. The source
+// responds with the missing changes if the epochs match and the changes are
+// retained, and with a snapshot otherwise. It then sends new changes as they
+// occur and periodic heartbeats. A follower that
+// receives a change which does not directly follow its last applied change
+// sends a resync message, to which the source responds with a snapshot.
+const (
+	msgHello     = byte(0x01) // Payload: last applied sequence number and epoch.
+	msgSnapshot  = byte(0x02) // Payload: sequence number, epoch and database file.
+	msgChange    = byte(0x03) // Payload: encoded Change.
+	msgHeartbeat = byte(0x04) // Payload: sequence number of the source.
+	msgResync    = byte(0x05) // Payload: none.
+
+	// frameHeaderLen is the length of the replication message header.
+	frameHeaderLen = sizeOfUint8 + sizeOfUint32
+
+	// maxFrameSize is the maximum payload length of a frame. It bounds what a
+	// reader allocates for a frame before receiving its payload, so that the
+	// memory of a message grows only as its frames arrive.
+	maxFrameSize = 1 << 20
+
+	// frameContinued is set in the message type of a frame that is followed
+	// by another frame of the same message.
+	frameContinued = byte(0x80)
+
+	// changeHeaderLen is the length of the fixed fields of an encoded Change.
+	changeHeaderLen = sizeOfUint64 + sizeOfUint8 + sizeOfUint64 + sizeOfUint16
+
+	// defaultHeartbeatInterval is the default interval between heartbeats.
+	defaultHeartbeatInterval = time.Second
+
+	// defaultReplicationLogSize is the change log capacity that NewSource
+	// enables on databases without a change log.
+	defaultReplicationLogSize = 4096
+)
+
+// Source streams the changes of a primary database to its followers.
+type Source struct {
+	db *Arc
+
+	// HeartbeatInterval is the interval at which the source reports its
+	// sequence number to idle followers.
+	HeartbeatInterval time.Duration
+}
+
+// NewSource returns a replication source for the given database. Followers
+// that are within the change log of the database catch up by replaying the
+// missing changes, and all others are bootstrapped with a snapshot. If the
+// database has no change log, a change log of 4096 changes is enabled.
+func NewSource(db *Arc) *Source {
+	db.mu.Lock()
+
+	if db.changeLog == nil {
+		db.changeLog = newChangeLog(defaultReplicationLogSize)
+	}
+
+	db.mu.Unlock()
+
+	return &Source{db: db, HeartbeatInterval: defaultHeartbeatInterval}
+}
+
+// Serve replicates the database to the follower on the other end of conn. It
+// blocks until the context is done or the connection fails. Requests of the
+// follower are read from conn until it fails, so the caller should close conn
+// once Serve returns.
+func (s *Source) Serve(ctx context.Context, conn io.ReadWriter) error {
+	msgType, payload, err := readFrame(conn)
+
+	if err != nil {
+		return err
+	}
+
+	if msgType != msgHello || len(payload) != 2*sizeOfUint64 {
+		return ErrReplicationProtocol
+	}
+
+	sent := binary.LittleEndian.Uint64(payload)
+	epoch := binary.LittleEndian.Uint64(payload[sizeOfUint64:])
+
+	ticker := time.NewTicker(s.HeartbeatInterval)
+	defer ticker.Stop()
+
+	resyncs := make(chan struct{}, 1)
+	errs := make(chan error, 1)
+
+	go readRequests(conn, resyncs, errs)
+
+	for {
+		published := s.db.published()
+
+		if sent, epoch, err = s.sendChanges(conn, sent, epoch); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err := <-errs:
+			return err
+
+		case <-resyncs:
+			if sent, epoch, err = s.sendSnapshot(conn, sent, epoch); err != nil {
+				return err
+			}
+
+		case <-published:
+
+		case <-ticker.C:
+			if err := writeFrame(conn, msgHeartbeat, binary.LittleEndian.AppendUint64(nil, s.db.Seq())); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ServeListener accepts follower connections from the listener and serves
+// each of them in its own goroutine. It blocks until the context is done or
+// the listener fails, and closes the listener and connections on return.
+func (s *Source) ServeListener(ctx context.Context, ln net.Listener) error {
+	var wg sync.WaitGroup
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	defer wg.Wait()
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return err
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+
+			s.Serve(ctx, conn)
+		}()
+	}
+}
+
+// readRequests reads the messages of a follower from r. Resync requests are
+// signaled on resyncs, and the first error is sent to errs.
+func readRequests(r io.Reader, resyncs chan<- struct{}, errs chan<- error) {
+	for {
+		msgType, payload, err := readFrame(r)
+
+		if err == nil && (msgType != msgResync || len(payload) != 0) {
+			err = ErrReplicationProtocol
+		}
+
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		// A pending request already covers this one.
+		select {
+		case resyncs <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sendChanges sends the changes after the given sequence number to the
+// follower, or a snapshot if the follower is at a different epoch or the
+// changes are no longer retained. It returns the sequence number and the
+// epoch of the follower after the changes were sent.
+func (s *Source) sendChanges(w io.Writer, sent uint64, epoch uint64) (uint64, uint64, error) {
+	s.db.mu.RLock()
+	current := s.db.epoch
+	changes, err := s.db.changesSince(sent)
+	s.db.mu.RUnlock()
+
+	// The sequence numbers of a different epoch do not identify the same
+	// contents, even if they are equal.
+	if epoch != current || errors.Is(err, ErrChangeLogTruncated) {
+		return s.sendSnapshot(w, sent, epoch)
+	}
+
+	if err != nil {
+		return sent, epoch, err
+	}
+
+	for _, c := range changes {
+		if err := writeFrame(w, msgChange, encodeChange(c)); err != nil {
+			return sent, epoch, err
+		}
+
+		sent = c.Seq
+	}
+
+	return sent, epoch, nil
+}
+
+// sendSnapshot sends a snapshot of the database to the follower. It returns
+// the sequence number and the epoch of the snapshot, or the given ones if the
+// snapshot could not be sent.
+func (s *Source) sendSnapshot(w io.Writer, sent uint64, epoch uint64) (uint64, uint64, error) {
+	seq, current, snapshot, err := s.db.snapshot()
+
+	if err != nil {
+		return sent, epoch, err
+	}
+
+	payload := binary.LittleEndian.AppendUint64(nil, seq)
+	payload = binary.LittleEndian.AppendUint64(payload, current)
+
+	if err := writeFrame(w, msgSnapshot, append(payload, snapshot...)); err != nil {
+		return sent, epoch, err
+	}
+
+	return seq, current, nil
+}
+
+// ReplicationStatus describes the progress of a follower.
+type ReplicationStatus struct {
+	AppliedSeq  uint64    // Sequence number of the last applied change.
+	SourceSeq   uint64    // Latest sequence number reported by the source.
+	LastContact time.Time // Time of the last message from the source.
+}
+
+// Lag returns the number of changes that the follower has yet to apply.
+func (rs ReplicationStatus) Lag() uint64 {
+	if rs.SourceSeq < rs.AppliedSeq {
+		return 0
+	}
+
+	return rs.SourceSeq - rs.AppliedSeq
+}
+
+// Follower applies the changes of a primary database to a replica. The replica
+// adopts the sequence numbers of the primary, and therefore must not be written
+// to other than by the follower.
+type Follower struct {
+	db     *Arc
+	mu     sync.Mutex
+	status ReplicationStatus
+}
+
+// NewFollower returns a follower that replicates into the given database.
+func NewFollower(db *Arc) *Follower {
+	return &Follower{db: db, status: ReplicationStatus{AppliedSeq: db.Seq()}}
+}
+
+// Status returns the replication progress of the follower.
+func (f *Follower) Status() ReplicationStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.status
+}
+
+// Run connects the follower to the source on the other end of conn, and applies
+// the changes that it receives. Replication resumes from the last change that
+// was applied to the replica, unless the source has since been replaced, for
+// example by ReadFrom, in which case the replica is bootstrapped anew. A change
+// that cannot be applied ends Run with its error, and the next Run bootstraps
+// the replica anew as well. A change that does not directly follow the last
+// applied change is rejected, and the follower requests a snapshot instead. It
+// blocks until the context is done or the connection fails. Closing the
+// connection stops a blocked Run.
+func (f *Follower) Run(ctx context.Context, conn io.ReadWriter) error {
+	f.db.mu.RLock()
+	hello := binary.LittleEndian.AppendUint64(nil, f.db.seq)
+	hello = binary.LittleEndian.AppendUint64(hello, f.db.epoch)
+	f.db.mu.RUnlock()
+
+	if err := writeFrame(conn, msgHello, hello); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+
+	// Whether a snapshot was requested, in which case the changes that the
+	// source sent before the snapshot are skipped.
+	var resyncing bool
+
+	for ctx.Err() == nil {
+		msgType, payload, err := readFrame(r)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+
+			return err
+		}
+
+		if resyncing && msgType == msgChange {
+			continue
+		}
+
+		switch err := f.handle(msgType, payload); {
+		case errors.Is(err, errNonContiguousChange):
+			if err := writeFrame(conn, msgResync, nil); err != nil {
+				return err
+			}
+
+			resyncing = true
+		case err != nil:
+			return err
+		case msgType == msgSnapshot:
+			resyncing = false
+		}
+	}
+
+	return ctx.Err()
+}
+
+// handle processes a single message from the source.
+func (f *Follower) handle(msgType byte, payload []byte) error {
+	var seq uint64
+
+	switch msgType {
+	case msgSnapshot:
+		if len(payload) < 2*sizeOfUint64 {
+			return ErrReplicationProtocol
+		}
+
+		seq = binary.LittleEndian.Uint64(payload)
+		epoch := binary.LittleEndian.Uint64(payload[sizeOfUint64:])
+
+		t, err := decodeTree(payload[2*sizeOfUint64:])
+
+		if err != nil {
+			return err
+		}
+
+		f.db.restore(seq, epoch, t)
+
+	case msgChange:
+		c, err := decodeChange(payload)
+
+		if err != nil {
+			return err
+		}
+
+		if c.Seq != f.db.Seq()+1 {
+			return errNonContiguousChange
+		}
+
+		seq = c.Seq
+
+		if err := f.db.apply(c); err != nil {
+			return err
+		}
+
+	case msgHeartbeat:
+		if len(payload) != sizeOfUint64 {
+			return ErrReplicationProtocol
+		}
+
+	default:
+		return ErrReplicationProtocol
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if msgType == msgHeartbeat {
+		f.status.SourceSeq = binary.LittleEndian.Uint64(payload)
+	} else {
+		f.status.AppliedSeq = seq
+		f.status.SourceSeq = max(f.status.SourceSeq, seq)
+	}
+
+	f.status.LastContact = time.Now()
+
+	return nil
+}
+
+// errNonContiguousChange is returned by Follower.handle for a change that does
+// not directly follow the last change applied to the replica.
+var errNonContiguousChange = fmt.Errorf("%w: non-contiguous change", ErrReplicationProtocol)
+
+// restore replaces the contents of the database with a snapshot of the given
+// sequence number and epoch. The retained changes are discarded, since they
+// no longer precede the contents of the database.
+func (a *Arc) restore(seq uint64, epoch uint64, t decodedTree) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.replace(t)
+	a.seq = seq
+	a.epoch = epoch
+}
+
+// apply applies a change of another database, and adopts its sequence number.
+// Every change that the other database published had an effect on it, so a
+// change that has no effect on this database shows that the contents differ.
+// In that case, the sequence number is left as is, and the epoch is drawn
+// anew, so that the source bootstraps the replica from a snapshot.
+func (a *Arc) apply(c Change) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.seq = c.Seq - 1
+
+	var err error
+
+	switch c.Op {
+	case OpPut:
+		var expiresAt int64
+
+		if !c.ExpiresAt.IsZero() {
+			expiresAt = c.ExpiresAt.UnixNano()
+		}
+
+		err = a.put(c.Key, c.Value, expiresAt, true)
+	case OpDelete:
+		err = a.delete(c.Key)
+	case OpDeletePrefix:
+		a.deletePrefix(c.Key)
+	}
+
+	// Deleting an expired record publishes the change, but reports the key
+	// as not found.
+	if a.seq == c.Seq {
+		return nil
+	}
+
+	a.epoch = rand.Uint64()
+
+	if err == nil {
+		err = fmt.Errorf("%w: change %d has no effect on the replica", ErrReplicationProtocol, c.Seq)
+	}
+
+	return err
+}
+
+// encodeChange encodes the change for replication. The layout is the sequence
+// number (uint64), the operation (uint8), the expiry time in Unix nanoseconds
+// (int64), the key length (uint16), the key, and the value.
+func encodeChange(c Change) []byte {
+	var expiresAt int64
+
+	if !c.ExpiresAt.IsZero() {
+		expiresAt = c.ExpiresAt.UnixNano()
+	}
+
+	buf := make([]byte, 0, changeHeaderLen+len(c.Key)+len(c.Value))
+	buf = binary.LittleEndian.AppendUint64(buf, c.Seq)
+	buf = append(buf, byte(c.Op))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(expiresAt))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(c.Key)))
+	buf = append(buf, c.Key...)
+
+	return append(buf, c.Value...)
+}
+
+// decodeChange decodes a change that was encoded by encodeChange.
+func decodeChange(src []byte) (Change, error) {
+	var ret Change
+
+	if len(src) < changeHeaderLen {
+		return ret, ErrReplicationProtocol
+	}
+
+	ret.Seq = binary.LittleEndian.Uint64(src)
+	ret.Op = ChangeOp(src[sizeOfUint64])
+	ret.ExpiresAt = expiryTime(int64(binary.LittleEndian.Uint64(src[sizeOfUint64+sizeOfUint8:])))
+
+	keyLen := int(binary.LittleEndian.Uint16(src[changeHeaderLen-sizeOfUint16:]))
+	src = src[changeHeaderLen:]
+
+	if ret.Seq == 0 || keyLen > len(src) {
+		return ret, ErrReplicationProtocol
+	}
+
+	switch ret.Op {
+	case OpPut:
+		ret.Value = src[keyLen:]
+	case OpDelete, OpDeletePrefix:
+		if keyLen != len(src) {
+			return ret, ErrReplicationProtocol
+		}
+	default:
+		return ret, ErrReplicationProtocol
+	}
+
+	ret.Key = src[:keyLen]
+
+	return ret, nil
+}
+
+// writeFrame writes a replication message to w, in as many frames as its
+// payload requires.
+func writeFrame(w io.Writer, msgType byte, payload []byte) error {
+	for {
+		chunk := payload[:min(len(payload), maxFrameSize)]
+		payload = payload[len(chunk):]
+
+		buf := make([]byte, frameHeaderLen, frameHeaderLen+len(chunk))
+		buf[0] = msgType
+
+		if len(payload) > 0 {
+			buf[0] |= frameContinued
+		}
+
+		binary.LittleEndian.PutUint32(buf[sizeOfUint8:], uint32(len(chunk)))
+
+		if _, err := w.Write(append(buf, chunk...)); err != nil {
+			return err
+		}
+
+		if len(payload) == 0 {
+			return nil
+		}
+	}
+}
+
+// readFrame reads a replication message from r, reassembling the frames that
+// its payload was split into. It returns ErrReplicationProtocol if a frame is
+// longer than maxFrameSize, or if the frames of a message differ in type.
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var msgType byte
+	var payload []byte
+
+	for first := true; ; first = false {
+		var header [frameHeaderLen]byte
+
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return 0, nil, err
+		}
+
+		size := int(binary.LittleEndian.Uint32(header[sizeOfUint8:]))
+
+		if size > maxFrameSize || (!first && header[0]&^frameContinued != msgType) {
+			return 0, nil, ErrReplicationProtocol
+		}
+
+		msgType = header[0] &^ frameContinued
+		payload = slices.Grow(payload, size)
+		payload = payload[:len(payload)+size]
+
+		if _, err := io.ReadFull(r, payload[len(payload)-size:]); err != nil {
+			return 0, nil, err
+		}
+
+		if header[0]&frameContinued == 0 {
+			return msgType, payload, nil
+		}
+	}
+}
@@ -0,0 +1,533 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// startReplication connects a follower of the replica to a source of the
+// primary over an in-memory connection. The replication stops when the test
+// completes.
+func startReplication(t *testing.T, primary *Arc, replica *Arc) *Follower {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sourceConn, followerConn := net.Pipe()
+
+	source := NewSource(primary)
+	source.HeartbeatInterval = 10 * time.Millisecond
+	follower := NewFollower(replica)
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		source.Serve(ctx, sourceConn)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		follower.Run(ctx, followerConn)
+		done <- struct{}{}
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		sourceConn.Close()
+		followerConn.Close()
+		<-done
+		<-done
+	})
+
+	return follower
+}
+
+// waitForSeq waits until the follower has applied the given sequence number.
+func waitForSeq(t *testing.T, f *Follower, seq uint64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for f.Status().AppliedSeq < seq {
+		if time.Now().After(deadline) {
+			t.Fatalf("replication timed out: applied:%d, want:%d", f.Status().AppliedSeq, seq)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	primary := basicTestTree()
+	primary.Put([]byte("apple"), blobValueX())
+
+	replica := New()
+	follower := startReplication(t, primary, replica)
+
+	// The follower is bootstrapped with a snapshot, since the changes of the
+	// primary precede its change log.
+	waitForSeq(t, follower, primary.Seq())
+	assertEquivalentTree(t, replica, primary)
+
+	primary.Put([]byte("cherry"), []byte("pie"))
+	primary.Put([]byte("lemon"), blobValueX())
+	primary.PutWithTTL([]byte("session"), []byte("token"), time.Hour)
+	primary.Delete([]byte("banana"))
+	primary.Delete([]byte("missing"))
+	primary.DeletePrefix([]byte("grape"))
+
+	waitForSeq(t, follower, primary.Seq())
+	assertEquivalentTree(t, replica, primary)
+
+	if got, want := replica.Seq(), primary.Seq(); got != want {
+		t.Errorf("unexpected replica seq: got:%d, want:%d", got, want)
+	}
+
+	if ttl, _ := replica.TTL([]byte("session")); ttl <= 0 {
+		t.Errorf("unexpected TTL: %v", ttl)
+	}
+}
+
+func TestReplicationResume(t *testing.T) {
+	primary := New()
+	primary.EnableChangeLog(8)
+	primary.Put([]byte("apple"), []byte("red"))
+
+	replica := New()
+	replica.EnableChangeLog(8)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sourceConn, followerConn := net.Pipe()
+	go NewSource(primary).Serve(ctx, sourceConn)
+
+	follower := NewFollower(replica)
+	go follower.Run(ctx, followerConn)
+
+	waitForSeq(t, follower, primary.Seq())
+	cancel()
+	sourceConn.Close()
+	followerConn.Close()
+
+	// Changes made while disconnected are replayed from the change log.
+	primary.Put([]byte("banana"), []byte("yellow"))
+	primary.Delete([]byte("apple"))
+
+	follower = startReplication(t, primary, replica)
+	waitForSeq(t, follower, primary.Seq())
+	assertEquivalentTree(t, replica, primary)
+
+	// The replica records the replayed changes under the primary's numbers.
+	changes, err := replica.ChangesSince(1)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var seqs []uint64
+
+	for c := range changes {
+		seqs = append(seqs, c.Seq)
+	}
+
+	if len(seqs) != 2 || seqs[0] != 2 || seqs[1] != 3 {
+		t.Errorf("unexpected replica changes: %v", seqs)
+	}
+}
+
+func TestReplicationEpoch(t *testing.T) {
+	testCases := []struct {
+		name    string
+		replace func(t *testing.T, primary *Arc, old []byte)
+	}{
+		{
+			// The loaded contents have the sequence number of the follower.
+			name: "replaced at the same sequence number",
+			replace: func(t *testing.T, primary *Arc, old []byte) {
+				other := New()
+				other.Put([]byte("cherry"), []byte("red"))
+				other.Put([]byte("durian"), []byte("green"))
+				other.Put([]byte("elderberry"), []byte("black"))
+
+				var buf bytes.Buffer
+
+				if _, err := other.WriteTo(&buf); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := primary.ReadFrom(&buf); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			// The older contents then advance past the follower.
+			name: "restored from an older file",
+			replace: func(t *testing.T, primary *Arc, old []byte) {
+				if _, err := primary.ReadFrom(bytes.NewReader(old)); err != nil {
+					t.Fatal(err)
+				}
+
+				for _, key := range []string{"fig", "grape", "kiwi"} {
+					primary.Put([]byte(key), []byte("diverged"))
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			primary := New()
+			primary.EnableChangeLog(64)
+			primary.Put([]byte("apple"), []byte("red"))
+
+			var old bytes.Buffer
+
+			if _, err := primary.WriteTo(&old); err != nil {
+				t.Fatal(err)
+			}
+
+			primary.Put([]byte("banana"), []byte("yellow"))
+			primary.Put([]byte("apple"), []byte("green"))
+
+			replica := New()
+			ctx, cancel := context.WithCancel(context.Background())
+			sourceConn, followerConn := net.Pipe()
+			go NewSource(primary).Serve(ctx, sourceConn)
+
+			follower := NewFollower(replica)
+			go follower.Run(ctx, followerConn)
+
+			waitForSeq(t, follower, primary.Seq())
+			cancel()
+			sourceConn.Close()
+			followerConn.Close()
+
+			seq := replica.Seq()
+			tc.replace(t, primary, old.Bytes())
+
+			if primary.Seq() < seq {
+				t.Fatalf("unexpected primary sequence number: got:%d, want at least:%d", primary.Seq(), seq)
+			}
+
+			follower = startReplication(t, primary, replica)
+
+			deadline := time.Now().Add(5 * time.Second)
+
+			for replica.Len() != primary.Len() || replica.RootHash() != primary.RootHash() {
+				if time.Now().After(deadline) {
+					t.Fatal("replica did not converge")
+				}
+
+				time.Sleep(time.Millisecond)
+			}
+
+			assertEquivalentTree(t, replica, primary)
+		})
+	}
+}
+
+func TestReplicationApplyError(t *testing.T) {
+	replica := New()
+	epoch := replica.epoch
+	f := NewFollower(replica)
+
+	invalid := []Change{
+		{Seq: 1, Op: OpPut, Key: make([]byte, maxKeyBytes+1), Value: []byte("red")},
+		{Seq: 1, Op: OpDelete, Key: []byte("apple")},
+		{Seq: 1, Op: OpDeletePrefix, Key: []byte("app")},
+	}
+
+	for _, c := range invalid {
+		if err := replica.apply(c); err == nil {
+			t.Errorf("expected an error for %s", c.Op)
+		}
+
+		if replica.Seq() != 0 || f.Status().AppliedSeq != 0 {
+			t.Errorf("unexpected sequence numbers: replica:%d, applied:%d", replica.Seq(), f.Status().AppliedSeq)
+		}
+
+		if replica.epoch == epoch {
+			t.Error("expected a new epoch")
+		}
+
+		epoch = replica.epoch
+	}
+
+	if err := f.handle(msgChange, encodeChange(invalid[1])); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrKeyNotFound)
+	}
+
+	// A replica whose contents diverged without its sequence number is
+	// bootstrapped anew once a change fails to apply.
+	primary := basicTestTree()
+	primary.EnableChangeLog(64)
+
+	replica = New()
+	follower := startReplication(t, primary, replica)
+	waitForSeq(t, follower, primary.Seq())
+
+	replica.mu.Lock()
+	replica.delete([]byte("apple"))
+	replica.seq--
+	replica.mu.Unlock()
+
+	primary.Delete([]byte("apple"))
+	primary.Put([]byte("zucchini"), []byte("green"))
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for replica.Seq() != primary.Seq() || replica.RootHash() != primary.RootHash() {
+		if time.Now().After(deadline) {
+			t.Fatal("replica did not converge")
+		}
+
+		follower = startReplication(t, primary, replica)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assertEquivalentTree(t, replica, primary)
+}
+
+func TestReplicationLag(t *testing.T) {
+	f := NewFollower(New())
+
+	if err := f.handle(msgHeartbeat, []byte{7, 0, 0, 0, 0, 0, 0, 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := f.Status().Lag(); got != 7 {
+		t.Errorf("unexpected lag: got:%d, want:7", got)
+	}
+
+	for seq := range uint64(5) {
+		c := Change{Seq: seq + 1, Op: OpPut, Key: []byte("apple"), Value: []byte("red")}
+
+		if err := f.handle(msgChange, encodeChange(c)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	status := f.Status()
+
+	if status.AppliedSeq != 5 || status.Lag() != 2 || status.LastContact.IsZero() {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestReplicationGap(t *testing.T) {
+	primary := basicTestTree()
+	seq, epoch, snapshot, err := primary.snapshot()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The follower rejects changes that skip or repeat sequence numbers.
+	replica := New()
+	f := NewFollower(replica)
+
+	for _, c := range []Change{
+		{Seq: 2, Op: OpPut, Key: []byte("apple"), Value: []byte("red")},
+		{Seq: 0, Op: OpPut, Key: []byte("apple"), Value: []byte("red")},
+	} {
+		if err := f.handle(msgChange, encodeChange(c)); !errors.Is(err, ErrReplicationProtocol) {
+			t.Errorf("unexpected error for seq %d: got:%v, want:%v", c.Seq, err, ErrReplicationProtocol)
+		}
+	}
+
+	if replica.Len() != 0 || replica.Seq() != 0 || f.Status().AppliedSeq != 0 {
+		t.Fatalf("replica changed: len:%d, seq:%d", replica.Len(), replica.Seq())
+	}
+
+	// On a gap in the stream, the follower requests a snapshot and skips the
+	// changes that precede it.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sourceConn, followerConn := net.Pipe()
+	defer sourceConn.Close()
+
+	go f.Run(ctx, followerConn)
+
+	if msgType, _, err := readFrame(sourceConn); err != nil || msgType != msgHello {
+		t.Fatalf("unexpected hello: %#x, %v", msgType, err)
+	}
+
+	for _, c := range []Change{
+		{Seq: 1, Op: OpPut, Key: []byte("apple"), Value: []byte("red")},
+		{Seq: 3, Op: OpPut, Key: []byte("cherry"), Value: []byte("red")},
+		{Seq: 4, Op: OpPut, Key: []byte("durian"), Value: []byte("green")},
+	} {
+		if err := writeFrame(sourceConn, msgChange, encodeChange(c)); err != nil {
+			t.Fatal(err)
+		}
+
+		if c.Seq != 3 {
+			continue
+		}
+
+		if msgType, payload, err := readFrame(sourceConn); err != nil || msgType != msgResync || len(payload) != 0 {
+			t.Fatalf("unexpected resync: %#x, %x, %v", msgType, payload, err)
+		}
+	}
+
+	payload := binary.LittleEndian.AppendUint64(nil, seq)
+	payload = binary.LittleEndian.AppendUint64(payload, epoch)
+
+	if err := writeFrame(sourceConn, msgSnapshot, append(payload, snapshot...)); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForSeq(t, f, seq)
+	assertEquivalentTree(t, replica, primary)
+
+	// The source responds to a resync request with a snapshot.
+	source := NewSource(primary)
+	source.HeartbeatInterval = time.Hour
+	sourceConn, followerConn = net.Pipe()
+	defer followerConn.Close()
+
+	go source.Serve(ctx, sourceConn)
+
+	for _, msg := range []byte{msgHello, msgResync} {
+		payload := binary.LittleEndian.AppendUint64(nil, primary.Seq())
+		payload = binary.LittleEndian.AppendUint64(payload, primary.epoch)
+
+		if msg == msgResync {
+			payload = nil
+		}
+
+		if err := writeFrame(followerConn, msg, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if msgType, _, err := readFrame(followerConn); err != nil || msgType != msgSnapshot {
+		t.Fatalf("unexpected response: %#x, %v", msgType, err)
+	}
+}
+
+func TestEncodeChange(t *testing.T) {
+	testCases := []Change{
+		{Seq: 1, Op: OpPut, Key: []byte("apple"), Value: []byte("red")},
+		{Seq: 2, Op: OpPut, Key: []byte("session"), ExpiresAt: time.Unix(0, 1700000000000000000)},
+		{Seq: 3, Op: OpDelete, Key: []byte("apple")},
+		{Seq: 4, Op: OpDeletePrefix, Key: []byte{}},
+	}
+
+	for _, want := range testCases {
+		got, err := decodeChange(encodeChange(want))
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got.Seq != want.Seq || got.Op != want.Op || !bytes.Equal(got.Key, want.Key) ||
+			!bytes.Equal(got.Value, want.Value) || !got.ExpiresAt.Equal(want.ExpiresAt) {
+			t.Errorf("unexpected change: got:%+v, want:%+v", got, want)
+		}
+	}
+
+	invalid := [][]byte{
+		nil,
+		encodeChange(Change{Seq: 0, Op: OpPut, Key: []byte("apple")}),
+		encodeChange(Change{Seq: 1, Op: ChangeOp(9), Key: []byte("apple")}),
+		encodeChange(Change{Seq: 1, Op: OpDelete, Key: []byte("apple"), Value: []byte("red")}),
+		encodeChange(Change{Seq: 1, Op: OpPut, Key: []byte("apple")})[:changeHeaderLen+2],
+	}
+
+	for _, src := range invalid {
+		if _, err := decodeChange(src); !errors.Is(err, ErrReplicationProtocol) {
+			t.Errorf("unexpected error for %x: got:%v, want:%v", src, err, ErrReplicationProtocol)
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	testCases := [][]byte{
+		nil,
+		[]byte("apple"),
+		bytes.Repeat([]byte{0xab}, maxFrameSize),
+		bytes.Repeat([]byte{0xcd}, maxFrameSize*2+1),
+	}
+
+	for _, want := range testCases {
+		var buf bytes.Buffer
+
+		if err := writeFrame(&buf, msgChange, want); err != nil {
+			t.Fatalf("writeFrame(): %v", err)
+		}
+
+		if got, want := buf.Len(), len(want)+frameHeaderLen*max(1, (len(want)+maxFrameSize-1)/maxFrameSize); got != want {
+			t.Errorf("unexpected encoded size: got:%d, want:%d", got, want)
+		}
+
+		msgType, got, err := readFrame(&buf)
+
+		if err != nil {
+			t.Fatalf("readFrame(): %v", err)
+		}
+
+		if msgType != msgChange || !bytes.Equal(got, want) {
+			t.Errorf("unexpected frame of type %#x and length %d", msgType, len(got))
+		}
+	}
+
+	invalid := [][]byte{
+		// The header announces a payload beyond the limit.
+		{msgChange, 0xff, 0xff, 0xff, 0xff},
+		{msgChange, 0x01, 0x00, 0x10, 0x00},
+
+		// The frames of a message differ in type.
+		{msgChange | frameContinued, 0x01, 0x00, 0x00, 0x00, 0x00, msgSnapshot, 0x00, 0x00, 0x00, 0x00},
+	}
+
+	for _, src := range invalid {
+		if _, _, err := readFrame(bytes.NewReader(src)); !errors.Is(err, ErrReplicationProtocol) {
+			t.Errorf("unexpected error for %x: got:%v, want:%v", src, err, ErrReplicationProtocol)
+		}
+	}
+}
+
+func TestServeListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+
+	primary := basicTestTree()
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+
+	go func() { served <- NewSource(primary).ServeListener(ctx, ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	defer conn.Close()
+
+	replica := New()
+	follower := NewFollower(replica)
+
+	go follower.Run(ctx, conn)
+
+	waitForSeq(t, follower, primary.Seq())
+	assertEquivalentTree(t, replica, primary)
+
+	cancel()
+
+	if err := <-served; !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, context.Canceled)
+	}
+}
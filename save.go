@@ -0,0 +1,147 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// tempFileSuffix is appended to the path of a database file to name the
+// temporary file that Save writes before replacing the database file.
+const tempFileSuffix = ".tmp"
+
+// Open loads the database from the file at path. If the file does not exist,
+// Open returns an empty database, which Save creates the file for. A
+// malformed file fails with a *CorruptionError. Files of older file format
+// versions are read as well, and are upgraded by the next Save, which also
+// keeps the node encoding of the file, see EnableCompactNodes. A file that
+// requires a newer version of Arc fails with ErrUnsupportedVersion, or with
+// ErrUnsupportedFeature if it uses an incompatible feature that this version
+// does not know.
+func Open(path string) (*Arc, error) {
+	return OpenFS(OSFS{}, path)
+}
+
+// OpenFS is like Open, but reads the file through the given VFS.
+func OpenFS(fsys VFS, path string) (*Arc, error) {
+	src, err := readFile(fsys, path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return New(), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := decodeTree(src)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := New()
+	ret.replace(t)
+	ret.compactNodes = t.compactNodes
+
+	return ret, nil
+}
+
+// Upgrade rewrites the database file at path in the current file format
+// version, if it was written in an older one. The file is replaced atomically,
+// as with Save, and a file that is already current is left untouched. Unlike
+// Open, Upgrade fails if the file does not exist.
+func Upgrade(path string) error {
+	return UpgradeFS(OSFS{}, path)
+}
+
+// UpgradeFS is like Upgrade, but accesses the file through the given VFS.
+func UpgradeFS(fsys VFS, path string) error {
+	src, err := readFile(fsys, path)
+
+	if err != nil {
+		return err
+	}
+
+	// The file is decoded even if it is current, so that an unreadable file
+	// is reported rather than left in place silently.
+	t, err := decodeTree(src)
+
+	if err != nil {
+		return err
+	}
+
+	if t.version == fileFormatVersion {
+		return nil
+	}
+
+	a := New()
+	a.replace(t)
+
+	return a.SaveFS(fsys, path)
+}
+
+// readFile reads the contents of the named file.
+func readFile(fsys VFS, name string) ([]byte, error) {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// Save atomically replaces the file at path with a snapshot of the database.
+// The snapshot is written to a temporary file next to path, which is synced
+// and then renamed over path. A crash during Save therefore leaves either the
+// previous or the new file in place, but never a partially written one.
+func (a *Arc) Save(path string) error {
+	return a.SaveFS(OSFS{}, path)
+}
+
+// SaveFS is like Save, but writes the file through the given VFS.
+func (a *Arc) SaveFS(fsys VFS, path string) error {
+	return replaceFile(fsys, path, a)
+}
+
+// replaceFile atomically replaces the file at path with the contents that src
+// writes, through a synced temporary file that is renamed over path.
+func replaceFile(fsys VFS, path string, src io.WriterTo) error {
+	tmp := path + tempFileSuffix
+
+	if err := writeFile(fsys, tmp, src); err != nil {
+		// The temporary file is useless, and is removed on a best-effort basis.
+		fsys.Remove(tmp)
+		return err
+	}
+
+	return fsys.Rename(tmp, path)
+}
+
+// writeFile durably writes the contents that src writes to the named file.
+func writeFile(fsys VFS, name string, src io.WriterTo) error {
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := src.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,280 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chronohq/arc/format"
+)
+
+func TestSaveOpen(t *testing.T) {
+	want := basicTestTree()
+	want.Put([]byte("apple"), blobValueX())
+
+	testCases := []struct {
+		name string
+		fsys VFS
+		path string
+	}{
+		{"os filesystem", OSFS{}, filepath.Join(t.TempDir(), "arc.db")},
+		{"memory filesystem", NewMemFS(), "arc.db"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := want.SaveFS(tc.fsys, tc.path); err != nil {
+				t.Fatalf("SaveFS(): %v", err)
+			}
+
+			got, err := OpenFS(tc.fsys, tc.path)
+
+			if err != nil {
+				t.Fatalf("OpenFS(): %v", err)
+			}
+
+			assertEquivalentTree(t, got, want)
+
+			// Saving again replaces the file.
+			got.Delete([]byte("apple"))
+
+			if err := got.SaveFS(tc.fsys, tc.path); err != nil {
+				t.Fatalf("SaveFS(): %v", err)
+			}
+
+			reopened, err := OpenFS(tc.fsys, tc.path)
+
+			if err != nil {
+				t.Fatalf("OpenFS(): %v", err)
+			}
+
+			assertEquivalentTree(t, reopened, got)
+		})
+	}
+}
+
+func TestSaveOpenCompactNodes(t *testing.T) {
+	m := NewMemFS()
+	want := basicTestTree()
+	want.EnableCompactNodes(true)
+
+	if err := want.SaveFS(m, "arc.db"); err != nil {
+		t.Fatalf("SaveFS(): %v", err)
+	}
+
+	got, err := OpenFS(m, "arc.db")
+
+	if err != nil {
+		t.Fatalf("OpenFS(): %v", err)
+	}
+
+	assertEquivalentTree(t, got, want)
+
+	// Saving a database that was opened from a compact file keeps the
+	// compact node encoding.
+	if err := got.SaveFS(m, "arc.db"); err != nil {
+		t.Fatalf("SaveFS(): %v", err)
+	}
+
+	src, err := m.ReadFile("arc.db")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header, err := newArcHeaderFromBytes(src); err != nil || header.incompat != format.FeatureCompactNodes {
+		t.Errorf("unexpected header: %+v, %v", header, err)
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	arc, err := Open(filepath.Join(t.TempDir(), "missing.db"))
+
+	if err != nil {
+		t.Fatalf("Open(): %v", err)
+	}
+
+	if arc.Len() != 0 {
+		t.Errorf("unexpected length: got:%d, want:0", arc.Len())
+	}
+}
+
+func TestOpenCorruptedFile(t *testing.T) {
+	m := NewMemFS()
+	m.WriteFile("arc.db", []byte{magicByte, fileFormatVersion})
+
+	if _, err := OpenFS(m, "arc.db"); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrCorrupted)
+	}
+}
+
+func TestSaveFaults(t *testing.T) {
+	before := basicTestTree()
+	after := basicTestTree()
+	after.Put([]byte("apple"), blobValueX())
+	after.Delete([]byte("banana"))
+
+	// Count the steps of a fault-free save.
+	m := NewMemFS()
+
+	if err := before.SaveFS(m, "arc.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := m.Steps()
+
+	if err := after.SaveFS(m, "arc.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	numSteps := m.Steps() - start
+
+	// Inject every fault into every step of the second save. The file must
+	// hold either the previous or the new database after a restart.
+	for step := range numSteps {
+		for _, fault := range []Fault{FaultCrash, FaultTornWrite, FaultError} {
+			t.Run(fmt.Sprintf("fault %d at step %d", fault, step), func(t *testing.T) {
+				m := NewMemFS()
+
+				if err := before.SaveFS(m, "arc.db"); err != nil {
+					t.Fatal(err)
+				}
+
+				m.InjectFault(m.Steps()+step, fault)
+
+				saveErr := after.SaveFS(m, "arc.db")
+
+				if !errors.Is(saveErr, ErrInjectedFault) {
+					t.Fatalf("unexpected error: got:%v, want:%v", saveErr, ErrInjectedFault)
+				}
+
+				m.Crash()
+				m.Restart()
+
+				got, err := OpenFS(m, "arc.db")
+
+				if err != nil {
+					t.Fatalf("OpenFS(): %v", err)
+				}
+
+				// A failed save leaves the previous database in place.
+				assertEquivalentTree(t, got, before)
+			})
+		}
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	v1, err := os.ReadFile(filepath.Join(legacyGoldenDirs[0], "inline_values.arc"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2, err := os.ReadFile(filepath.Join(goldenDir, "inline_values.arc"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsupported := craftHeader(t, func(h *arcHeader) { h.incompat = 1 << 31 })
+
+	testCases := []struct {
+		name    string
+		src     []byte // Contents of the file, or nil if there is none.
+		want    []byte // Contents of the file after the upgrade.
+		wantErr error
+	}{
+		{"version 1 file", v1, v2, nil},
+		{"current file", v2, v2, nil},
+		{"unsupported feature", unsupported, unsupported, ErrUnsupportedFeature},
+		{"corrupted file", v1[:len(v1)-1], v1[:len(v1)-1], ErrCorrupted},
+		{"missing file", nil, nil, fs.ErrNotExist},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMemFS()
+
+			if tc.src != nil {
+				m.WriteFile("arc.db", tc.src)
+			}
+
+			if err := UpgradeFS(m, "arc.db"); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("unexpected error: got:%v, want:%v", err, tc.wantErr)
+			}
+
+			if got, _ := m.ReadFile("arc.db"); !bytes.Equal(got, tc.want) {
+				t.Errorf("unexpected file contents: got:%x, want:%x", got, tc.want)
+			}
+
+			if _, err := m.ReadFile("arc.db" + tempFileSuffix); err == nil {
+				t.Error("temporary file was left behind")
+			}
+		})
+	}
+}
+
+func TestOpenUnsupportedFile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		src     []byte
+		wantErr error
+	}{
+		{"unknown version", []byte{magicByte, 0xff, arcFileClosed}, ErrUnsupportedVersion},
+		{"unknown incompat feature", craftHeader(t, func(h *arcHeader) { h.incompat = 1 << 31 }), ErrUnsupportedFeature},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMemFS()
+			m.WriteFile("arc.db", tc.src)
+
+			if _, err := OpenFS(m, "arc.db"); !errors.Is(err, tc.wantErr) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func FuzzOpen(f *testing.F) {
+	for _, arc := range []*Arc{New(), basicTestTree()} {
+		var buf bytes.Buffer
+
+		if _, err := arc.WriteTo(&buf); err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(buf.Bytes())
+	}
+
+	f.Add(writePagedGolden(f, basicTestTree()))
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		m := NewMemFS()
+		m.WriteFile("arc.db", src)
+
+		arc, err := OpenFS(m, "arc.db")
+
+		if err != nil {
+			var ce *CorruptionError
+
+			if !errors.As(err, &ce) && !isUnsupported(err) {
+				t.Fatalf("untyped error: %v", err)
+			}
+
+			return
+		}
+
+		if err := checkInvariants(arc); err != nil {
+			t.Fatalf("opened database violates invariants: %v", err)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"iter"
+)
+
+// scanBatchSize is the number of records that a scan collects per read lock
+// acquisition. Releasing the lock between batches keeps long scans from
+// starving writers, and allows the loop body to access the database.
+const scanBatchSize = 64
+
+// Scan returns an iterator over the records whose keys begin with the given
+// prefix, in ascending key order. A nil or empty prefix visits every record.
+// The database may be read or modified within the loop body. Records that are
+// modified during the iteration may or may not be visited.
+func (a *Arc) Scan(prefix []byte) iter.Seq2[[]byte, []byte] {
+	return a.Range(prefix, prefixSuccessor(prefix))
+}
+
+// Range returns an iterator over the records whose keys are within the
+// half-open interval [start, end), in ascending key order. A nil start begins
+// at the smallest key, and a nil end continues through the largest key. The
+// consistency guarantees are the same as those of Scan.
+func (a *Arc) Range(start []byte, end []byte) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for {
+			keys, values := a.collect(start, end, scanBatchSize)
+
+			for i := range keys {
+				if !yield(keys[i], values[i]) {
+					return
+				}
+			}
+
+			if len(keys) < scanBatchSize {
+				return
+			}
+
+			// The smallest key that sorts after the last visited key is the
+			// key itself with a zero byte appended. The full slice expression
+			// makes append copy the key, which the caller may have retained.
+			last := keys[len(keys)-1]
+			start = append(last[:len(last):len(last)], 0x00)
+		}
+	}
+}
+
+// collect returns up to limit records within [start, end) under the read lock.
+// The returned keys and values are copies that the caller may retain.
+func (a *Arc) collect(start []byte, end []byte, limit int) ([][]byte, [][]byte) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var keys [][]byte
+	var values [][]byte
+
+	a.walk(start, end, func(key []byte, n *node) bool {
+		if n.expired() {
+			return true
+		}
+
+		// The path of the empty key is nil, but the record key must not be.
+		keys = append(keys, append([]byte{}, key...))
+		values = append(values, n.value(a.blobs))
+
+		return len(keys) < limit
+	})
+
+	return keys, values
+}
+
+// walk visits the record nodes whose full keys are within [start, end) in
+// ascending key order. The key passed to fn is only valid for the duration
+// of the call. The walk stops when fn returns false.
+func (a *Arc) walk(start []byte, end []byte, fn func(key []byte, n *node) bool) {
+	if a.empty() {
+		return
+	}
+
+	walkNode(a.root, nil, start, end, fn)
+}
+
+// walkNode is the recursive implementation of walk. It returns false when the
+// walk should stop, either because fn asked to stop or because the remaining
+// keys are beyond the end of the range.
+func walkNode(n *node, path []byte, start []byte, end []byte, fn func([]byte, *node) bool) bool {
+	path = append(path, n.key...)
+
+	// Every key in this subtree, and in the subtrees of the subsequent
+	// siblings, sorts after path. Therefore the walk is complete.
+	if end != nil && bytes.Compare(path, end) >= 0 {
+		return false
+	}
+
+	// Every key in this subtree sorts before start. Skip the subtree, but
+	// continue with the subsequent siblings.
+	if bytes.Compare(path, start) < 0 && !bytes.HasPrefix(start, path) {
+		return true
+	}
+
+	if n.isRecord && bytes.Compare(path, start) >= 0 {
+		if !fn(path, n) {
+			return false
+		}
+	}
+
+	for child := n.firstChild; child != nil; child = child.nextSibling {
+		if !walkNode(child, path, start, end, fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// prefixSuccessor returns the smallest key that sorts after every key that
+// begins with prefix. It returns nil if no such key exists, which is the case
+// when the prefix is empty or consists only of 0xff bytes.
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			ret := bytes.Clone(prefix[:i+1])
+			ret[i]++
+
+			return ret
+		}
+	}
+
+	return nil
+}
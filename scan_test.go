@@ -0,0 +1,235 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"fmt"
+	"iter"
+	"slices"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	testCases := []struct {
+		name   string
+		prefix []byte
+		want   []string
+	}{
+		{
+			name:   "with nil prefix",
+			prefix: nil,
+			want: []string{
+				"apple", "applet", "application", "apricot", "banana", "band",
+				"bandage", "bandsaw", "berry", "blueberry", "grape", "grapefruit",
+				"lemon", "lemonade", "lime", "limestone", "orange",
+			},
+		},
+		{
+			name:   "with prefix ending within a node",
+			prefix: []byte("appl"),
+			want:   []string{"apple", "applet", "application"},
+		},
+		{
+			name:   "with prefix matching a record",
+			prefix: []byte("band"),
+			want:   []string{"band", "bandage", "bandsaw"},
+		},
+		{
+			name:   "with prefix matching a non-record node",
+			prefix: []byte("l"),
+			want:   []string{"lemon", "lemonade", "lime", "limestone"},
+		},
+		{
+			name:   "with non-existing prefix",
+			prefix: []byte("bogus"),
+			want:   nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			arc := basicTestTree()
+
+			var got []string
+
+			for k, v := range arc.Scan(tc.prefix) {
+				want, err := arc.Get(k)
+
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if !bytes.Equal(v, want) {
+					t.Errorf("unexpected value: got:%q, want:%q", v, want)
+				}
+
+				got = append(got, string(k))
+			}
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("unexpected keys: got:%q, want:%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	testCases := []struct {
+		name  string
+		start []byte
+		end   []byte
+		want  []string
+	}{
+		{
+			name:  "with bounded range",
+			start: []byte("applet"),
+			end:   []byte("bandsaw"),
+			want:  []string{"applet", "application", "apricot", "banana", "band", "bandage"},
+		},
+		{
+			name:  "with nil start",
+			start: nil,
+			end:   []byte("applet"),
+			want:  []string{"apple"},
+		},
+		{
+			name:  "with nil end",
+			start: []byte("lime"),
+			end:   nil,
+			want:  []string{"lime", "limestone", "orange"},
+		},
+		{
+			name:  "with empty range",
+			start: []byte("c"),
+			end:   []byte("d"),
+			want:  nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			arc := basicTestTree()
+
+			var got []string
+
+			for k := range arc.Range(tc.start, tc.end) {
+				got = append(got, string(k))
+			}
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("unexpected keys: got:%q, want:%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScanBoundaries(t *testing.T) {
+	arc := New()
+
+	for k := range arc.Scan(nil) {
+		t.Errorf("unexpected key in an empty database: %q", k)
+	}
+
+	for _, key := range [][]byte{{}, {0x00}, {'a'}, {0xff}, {0xff, 0xff}} {
+		if err := arc.Put(key, []byte("v")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name string
+		seq  iter.Seq2[[]byte, []byte]
+		want []string
+	}{
+		{"empty key with nil prefix", arc.Scan(nil), []string{"", "\x00", "a", "\xff", "\xff\xff"}},
+		{"prefix without successor", arc.Scan([]byte{0xff}), []string{"\xff", "\xff\xff"}},
+		{"range from the empty key", arc.Range([]byte{}, []byte{'a'}), []string{"", "\x00"}},
+		{"range with equal bounds", arc.Range([]byte{'a'}, []byte{'a'}), nil},
+		{"range with reversed bounds", arc.Range([]byte{0xff}, []byte{'a'}), nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+
+			for k := range tc.seq {
+				got = append(got, string(k))
+			}
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("unexpected keys: got:%q, want:%q", got, tc.want)
+			}
+		})
+	}
+
+	// Breaking out of the loop stops the iteration.
+	var count int
+
+	for range arc.Range(nil, nil) {
+		if count++; count == 2 {
+			break
+		}
+	}
+
+	if count != 2 {
+		t.Errorf("unexpected number of iterations: got:%d, want:2", count)
+	}
+}
+
+func TestScanAcrossBatches(t *testing.T) {
+	arc := New()
+	numRecords := scanBatchSize*3 + 7
+
+	for i := 0; i < numRecords; i++ {
+		key := []byte(fmt.Sprintf("key-%04d", i))
+
+		if err := arc.Put(key, key); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var i int
+
+	// Writing within the loop body must not deadlock.
+	for k, v := range arc.Scan([]byte("key-")) {
+		want := fmt.Sprintf("key-%04d", i)
+
+		if string(k) != want || string(v) != want {
+			t.Fatalf("unexpected record: got:%q=%q, want:%q", k, v, want)
+		}
+
+		if err := arc.Delete(k); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		i++
+	}
+
+	if i != numRecords {
+		t.Errorf("unexpected record count: got:%d, want:%d", i, numRecords)
+	}
+
+	if arc.Len() != 0 {
+		t.Errorf("unexpected remaining records: got:%d, want:0", arc.Len())
+	}
+}
+
+func TestPrefixSuccessor(t *testing.T) {
+	testCases := []struct {
+		prefix []byte
+		want   []byte
+	}{
+		{[]byte("a"), []byte("b")},
+		{[]byte("ab\xff\xff"), []byte("ac")},
+		{[]byte("\xff"), nil},
+		{nil, nil},
+	}
+
+	for _, tc := range testCases {
+		if got := prefixSuccessor(tc.prefix); !bytes.Equal(got, tc.want) {
+			t.Errorf("unexpected successor: got:%q, want:%q", got, tc.want)
+		}
+	}
+}
@@ -2,16 +2,17 @@ package arc
 
 import (
 	"bytes"
-	"encoding/binary"
-	"hash/crc32"
+
+	"github.com/chronohq/arc/format"
 )
 
 const (
 	// magicByte is the first byte of an Arc file.
-	magicByte = byte(0x41)
+	magicByte = format.Magic
 
-	// fileFormatVersion is the database file format version.
-	fileFormatVersion = uint8(1)
+	// fileFormatVersion is the database file format version that writeTo
+	// produces. Files of older versions remain readable.
+	fileFormatVersion = format.CurrentVersion
 
 	// sizeOfUint8 is the size of uint8 in bytes.
 	sizeOfUint8 = 1
@@ -26,30 +27,38 @@ const (
 	sizeOfUint64 = 8
 
 	// checksumLen is the length of a checksum in bytes.
-	checksumLen = sizeOfUint32
+	checksumLen = format.ChecksumSize
 
 	// minNodeBytesLen is the minimum length of a serialized node.
-	minNodeBytesLen = sizeOfUint8 + sizeOfUint16 + sizeOfUint16 + sizeOfUint32 + sizeOfUint64 + sizeOfUint64
+	minNodeBytesLen = format.NodeFixedSize
 
-	// arcHeaderBytesLen is the length of the arc file header.
-	arcHeaderBytesLen = sizeOfUint8 + sizeOfUint8 + sizeOfUint8 + checksumLen
+	// arcHeaderBytesLen is the length of the arc file header of the current
+	// file format version.
+	arcHeaderBytesLen = format.HeaderSize
 )
 
 // Index node flags.
 const (
-	flagIsRecord = 1 << iota // 0b00000001
-	flagHasBlob              // 0b00000010
+	flagIsRecord  = format.FlagIsRecord  // 0b00000001
+	flagHasBlob   = format.FlagHasBlob   // 0b00000010
+	flagHasExpiry = format.FlagHasExpiry // 0b00000100
 )
 
 const (
-	arcFileClosed = 0
-	arcFileOpened = 1
+	arcFileClosed = format.StatusClosed
+	arcFileOpened = format.StatusOpened
 )
 
 type arcHeader struct {
-	magic   byte
-	version byte
-	status  byte
+	magic      byte
+	version    byte
+	status     byte
+	compat     uint32 // Compatible features of the file.
+	incompat   uint32 // Incompatible features of the file.
+	numNodes   uint64
+	numRecords uint64
+	rootOffset uint64 // Offset of the root node, or 0 for an empty tree.
+	seq        uint64 // Sequence number of the last mutation.
 }
 
 func newArcHeader() arcHeader {
@@ -60,52 +69,54 @@ func newArcHeader() arcHeader {
 	}
 }
 
-func (ah *arcHeader) serialize() ([]byte, error) {
-	var buf bytes.Buffer
-
-	buf.WriteByte(ah.magic)
-	buf.WriteByte(ah.version)
-	buf.WriteByte(ah.status)
-
-	checksum, err := computeChecksum(buf.Bytes())
-
-	if err != nil {
-		return nil, err
-	}
-
-	if err = binary.Write(&buf, binary.LittleEndian, checksum); err != nil {
-		return nil, err
-	}
+// size returns the length of the serialized arcHeader in bytes.
+func (ah *arcHeader) size() int {
+	return format.HeaderSizeOf(ah.version)
+}
 
-	return buf.Bytes(), nil
+func (ah *arcHeader) serialize() ([]byte, error) {
+	h := format.Header{
+		Magic:      ah.magic,
+		Version:    ah.version,
+		Status:     ah.status,
+		Compat:     ah.compat,
+		Incompat:   ah.incompat,
+		NumNodes:   ah.numNodes,
+		NumRecords: ah.numRecords,
+		RootOffset: ah.rootOffset,
+		Sequence:   ah.seq,
+	}
+
+	return h.Append(nil)
 }
 
+// newArcHeaderFromBytes decodes the header at the start of src, whose length
+// depends on the file format version that the header names.
 func newArcHeaderFromBytes(src []byte) (arcHeader, error) {
 	var ret arcHeader
 
-	if len(src) != arcHeaderBytesLen {
-		return ret, ErrCorrupted
-	}
-
-	reader := bytes.NewReader(src)
-
-	if err := binary.Read(reader, binary.LittleEndian, &ret.magic); err != nil {
-		return ret, err
-	}
+	h, err := format.DecodeHeader(src)
 
-	if err := binary.Read(reader, binary.LittleEndian, &ret.version); err != nil {
+	if err != nil {
 		return ret, err
 	}
 
-	if err := binary.Read(reader, binary.LittleEndian, &ret.status); err != nil {
-		return ret, err
-	}
+	ret.magic = h.Magic
+	ret.version = h.Version
+	ret.status = h.Status
+	ret.compat = h.Compat
+	ret.incompat = h.Incompat
+	ret.numNodes = h.NumNodes
+	ret.numRecords = h.NumRecords
+	ret.rootOffset = h.RootOffset
+	ret.seq = h.Sequence
 
 	return ret, nil
 }
 
 // persistentNode is the on-disk structure of Arc's radix tree node.
-// All fields in this struct are persisted in the same order.
+// All fields in this struct are persisted in the same order. The expiresAt
+// field is only persisted when the flagHasExpiry flag is set.
 type persistentNode struct {
 	flags             uint8
 	numChildren       uint16
@@ -115,6 +126,9 @@ type persistentNode struct {
 	nextSiblingOffset uint64
 	key               []byte
 	data              []byte
+	expiresAt         int64
+	childOffsets      []uint64 // Offsets of the children in the paged layout.
+	blobOffset        uint64   // Offset of the blob in the paged layout.
 }
 
 func makePersistentNode(n node) persistentNode {
@@ -128,6 +142,11 @@ func makePersistentNode(n node) persistentNode {
 		ret.flags |= flagHasBlob
 	}
 
+	if n.expiresAt != 0 {
+		ret.flags |= flagHasExpiry
+		ret.expiresAt = n.expiresAt
+	}
+
 	ret.numChildren = uint16(n.numChildren)
 	ret.keyLen = uint16(len(n.key))
 	ret.dataLen = uint32(len(n.data))
@@ -142,82 +161,74 @@ func makePersistentNode(n node) persistentNode {
 }
 
 func makePersistentNodeFromBytes(src []byte) (persistentNode, error) {
-	var ret persistentNode
-
-	if len(src) < minNodeBytesLen {
-		return ret, ErrNodeCorrupted
-	}
-
-	var err error
-	var gotChecksum uint32
-	var wantChecksum uint32
+	fn, size, err := format.DecodeNode(src)
 
-	checksumPos := src[len(src)-sizeOfUint32:]
-	checksumBuf := bytes.NewReader(checksumPos)
-
-	if err = binary.Read(checksumBuf, binary.LittleEndian, &wantChecksum); err != nil {
-		return ret, err
+	if err != nil {
+		return persistentNode{}, err
 	}
 
-	nodeData := src[:len(src)-sizeOfUint32]
-
-	if gotChecksum, err = computeChecksum(nodeData); err != nil {
-		return ret, err
+	// The node must fill the source exactly.
+	if size != len(src) {
+		return persistentNode{}, ErrNodeCorrupted
 	}
 
-	if gotChecksum != wantChecksum {
-		return ret, ErrInvalidChecksum
-	}
+	return makePersistentNodeFromFormat(fn), nil
+}
 
-	nodeRegion := src[:len(src)-sizeOfUint32]
-	nodeReader := bytes.NewReader(nodeRegion)
+// makePersistentNodeFromCompactBytes decodes a node in the compact encoding,
+// which starts at the given offset of the file. It returns the node along with
+// its encoded size. The next sibling offset of the node is left zero, since a
+// compact node does not encode it.
+func makePersistentNodeFromCompactBytes(src []byte, offset uint64) (persistentNode, int, error) {
+	fn, size, err := format.DecodeCompactNode(src, offset)
 
-	if err := binary.Read(nodeReader, binary.LittleEndian, &ret.flags); err != nil {
-		return ret, err
+	if err != nil {
+		return persistentNode{}, 0, err
 	}
 
-	if err := binary.Read(nodeReader, binary.LittleEndian, &ret.numChildren); err != nil {
-		return ret, err
-	}
+	return makePersistentNodeFromFormat(fn), size, nil
+}
 
-	if err := binary.Read(nodeReader, binary.LittleEndian, &ret.keyLen); err != nil {
-		return ret, err
-	}
+// makePersistentNodeFromPagedBytes decodes a node in the paged layout, and
+// returns it along with its encoded size. The offsets of its children and blob
+// are held in childOffsets and blobOffset.
+func makePersistentNodeFromPagedBytes(src []byte) (persistentNode, int, error) {
+	fn, size, err := format.DecodePagedNode(src)
 
-	if err := binary.Read(nodeReader, binary.LittleEndian, &ret.dataLen); err != nil {
-		return ret, err
-	}
-
-	if err := binary.Read(nodeReader, binary.LittleEndian, &ret.firstChildOffset); err != nil {
-		return ret, err
+	if err != nil {
+		return persistentNode{}, 0, err
 	}
 
-	if err := binary.Read(nodeReader, binary.LittleEndian, &ret.nextSiblingOffset); err != nil {
-		return ret, err
-	}
+	return makePersistentNodeFromFormat(fn), size, nil
+}
 
-	// Done reading fixed length fields. Ensure that the dynamic length
-	// regions are available. If not, the node is corrupted.
-	remaining := nodeReader.Len()
-	expectedRemaining := int(ret.keyLen) + int(ret.dataLen)
+// makePersistentNodeFromFormat copies a decoded node, whose key and data alias
+// the decoded source.
+func makePersistentNodeFromFormat(fn format.Node) persistentNode {
+	var ret persistentNode
 
-	if expectedRemaining != remaining {
-		return ret, ErrNodeCorrupted
-	}
+	ret.flags = fn.Flags
+	ret.numChildren = fn.NumChildren
+	ret.keyLen = uint16(len(fn.Key))
+	ret.dataLen = uint32(len(fn.Data))
+	ret.firstChildOffset = fn.FirstChild
+	ret.nextSiblingOffset = fn.NextSibling
+	ret.key = bytes.Clone(fn.Key)
+	ret.expiresAt = fn.ExpiresAt
+	ret.childOffsets = fn.Children
+	ret.blobOffset = fn.BlobOffset
 
-	ret.key = make([]byte, ret.keyLen)
-	if _, err := nodeReader.Read(ret.key); err != nil {
-		return ret, err
+	// The key must not be nil, even if it is empty.
+	if ret.key == nil {
+		ret.key = []byte{}
 	}
 
 	if ret.isRecord() {
-		ret.data = make([]byte, ret.dataLen)
-		if _, err := nodeReader.Read(ret.data); err != nil {
-			return ret, err
-		}
+		ret.data = make([]byte, len(fn.Data))
+		copy(ret.data, fn.Data)
 	}
 
-	return ret, nil
+	return ret
 }
 
 // isRecord returns true if the isRecord flag is set.
@@ -230,62 +241,50 @@ func (pn persistentNode) hasBlob() bool {
 	return pn.flags&flagHasBlob != 0
 }
 
-// serialize serializes the persistentNode into a standardized byte slice.
-func (pn persistentNode) serialize() ([]byte, error) {
-	var buf bytes.Buffer
-
-	if err := buf.WriteByte(pn.flags); err != nil {
-		return nil, err
-	}
-
-	if err := binary.Write(&buf, binary.LittleEndian, pn.numChildren); err != nil {
-		return nil, err
-	}
-
-	if err := binary.Write(&buf, binary.LittleEndian, pn.keyLen); err != nil {
-		return nil, err
-	}
-
-	if err := binary.Write(&buf, binary.LittleEndian, pn.dataLen); err != nil {
-		return nil, err
-	}
-
-	if err := binary.Write(&buf, binary.LittleEndian, pn.firstChildOffset); err != nil {
-		return nil, err
-	}
-
-	if err := binary.Write(&buf, binary.LittleEndian, pn.nextSiblingOffset); err != nil {
-		return nil, err
-	}
+// hasExpiry returns true if the hasExpiry flag is set.
+func (pn persistentNode) hasExpiry() bool {
+	return pn.flags&flagHasExpiry != 0
+}
 
-	if _, err := buf.Write(pn.key); err != nil {
-		return nil, err
-	}
+// size returns the length of the serialized persistentNode in bytes.
+func (pn persistentNode) size() int {
+	ret := minNodeBytesLen + int(pn.keyLen) + int(pn.dataLen) + checksumLen
 
-	if _, err := buf.Write(pn.data); err != nil {
-		return nil, err
+	if pn.hasExpiry() {
+		ret += sizeOfUint64
 	}
 
-	// Append the checksum at the end of the serialized node.
-	checksum, err := computeChecksum(buf.Bytes())
-
-	if err != nil {
-		return nil, err
-	}
+	return ret
+}
 
-	if err := binary.Write(&buf, binary.LittleEndian, checksum); err != nil {
-		return nil, err
-	}
+// compactSize returns the length of the persistentNode in the compact encoding
+// in bytes, if the node starts at the given offset.
+func (pn persistentNode) compactSize(offset uint64) int {
+	return pn.toFormat().CompactSize(offset)
+}
 
-	return buf.Bytes(), nil
+// serialize serializes the persistentNode into a standardized byte slice.
+func (pn persistentNode) serialize() ([]byte, error) {
+	return pn.toFormat().Append(nil)
 }
 
-func computeChecksum(src []byte) (uint32, error) {
-	h := crc32.NewIEEE()
+// serializeCompact serializes the persistentNode in the compact encoding, if
+// the node starts at the given offset. The next sibling offset is omitted.
+func (pn persistentNode) serializeCompact(offset uint64) ([]byte, error) {
+	return pn.toFormat().AppendCompact(nil, offset)
+}
 
-	if _, err := h.Write(src); err != nil {
-		return 0, err
+// toFormat returns the persistentNode as a format.Node.
+func (pn persistentNode) toFormat() format.Node {
+	return format.Node{
+		Flags:       pn.flags,
+		NumChildren: pn.numChildren,
+		FirstChild:  pn.firstChildOffset,
+		NextSibling: pn.nextSiblingOffset,
+		Key:         pn.key,
+		Data:        pn.data,
+		ExpiresAt:   pn.expiresAt,
+		Children:    pn.childOffsets,
+		BlobOffset:  pn.blobOffset,
 	}
-
-	return h.Sum32(), nil
 }
@@ -2,7 +2,10 @@ package arc
 
 import (
 	"bytes"
+	"errors"
 	"testing"
+
+	"github.com/chronohq/arc/format"
 )
 
 func TestArcHeaderSerialize(t *testing.T) {
@@ -22,6 +25,27 @@ func TestArcHeaderSerialize(t *testing.T) {
 				status:  arcFileOpened,
 			},
 		},
+		{
+			name: "with features and counts",
+			header: arcHeader{
+				magic:      magicByte,
+				version:    fileFormatVersion,
+				status:     arcFileClosed,
+				compat:     0x01,
+				incompat:   0x02,
+				numNodes:   3,
+				numRecords: 2,
+				rootOffset: arcHeaderBytesLen,
+			},
+		},
+		{
+			name: "with file format version 1",
+			header: arcHeader{
+				magic:   magicByte,
+				version: format.Version1,
+				status:  arcFileClosed,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -38,16 +62,12 @@ func TestArcHeaderSerialize(t *testing.T) {
 				t.Fatalf("newArcHeaderFromBytes(): %v", err)
 			}
 
-			if subject.magic != tc.header.magic {
-				t.Errorf("unexpected magicByte: got:%d, want:%d", subject.magic, tc.header.magic)
-			}
-
-			if subject.version != tc.header.version {
-				t.Errorf("unexpected version: got:%d, want:%d", subject.version, tc.header.version)
+			if len(bytes) != tc.header.size() {
+				t.Errorf("unexpected size: got:%d, want:%d", len(bytes), tc.header.size())
 			}
 
-			if subject.status != tc.header.status {
-				t.Errorf("unexpected status: got:%d, want:%d", subject.status, tc.header.status)
+			if subject != tc.header {
+				t.Errorf("unexpected header: got:%+v, want:%+v", subject, tc.header)
 			}
 		})
 	}
@@ -142,6 +162,15 @@ func TestPersistentNodeSerialize(t *testing.T) {
 		children    []node
 		numChildren int
 	}{
+		{
+			name: "with expiring record node",
+			node: node{
+				key:       []byte("session"),
+				data:      []byte("token"),
+				isRecord:  true,
+				expiresAt: 1700000000000000000,
+			},
+		},
 		{
 			name: "with record node",
 			node: node{
@@ -223,6 +252,99 @@ func TestPersistentNodeSerialize(t *testing.T) {
 			if !bytes.Equal(got.data, pn.data) {
 				t.Errorf("unexpected key: got:%q, want:%q", got.data, pn.data)
 			}
+
+			if got.expiresAt != tc.node.expiresAt {
+				t.Errorf("unexpected expiresAt: got:%d, want:%d", got.expiresAt, tc.node.expiresAt)
+			}
 		})
 	}
 }
+
+func FuzzArcHeaderFromBytes(f *testing.F) {
+	header := newArcHeader()
+	src, err := header.serialize()
+
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(src)
+	f.Add(src[:len(src)-1])
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		header, err := newArcHeaderFromBytes(src)
+
+		if err != nil {
+			if !errors.Is(err, ErrCorrupted) && !errors.Is(err, ErrInvalidChecksum) && !errors.Is(err, ErrUnsupportedVersion) {
+				t.Fatalf("untyped error: %v", err)
+			}
+
+			return
+		}
+
+		got, err := header.serialize()
+
+		if err != nil {
+			t.Fatalf("serialize(): %v", err)
+		}
+
+		if !bytes.Equal(got, src[:len(got)]) {
+			t.Fatalf("header does not round-trip: got:%x, want:%x", got, src)
+		}
+	})
+}
+
+func FuzzPersistentNodeFromBytes(f *testing.F) {
+	seeds := []node{
+		{key: []byte("session"), data: []byte("token"), isRecord: true, expiresAt: 1700000000000000000},
+		{key: []byte("app"), data: []byte("band"), isRecord: true, numChildren: 2},
+		{key: []byte("app"), numChildren: 2},
+		{key: []byte{}, data: []byte{}, isRecord: true},
+	}
+
+	for _, n := range seeds {
+		pn := makePersistentNode(n)
+		pn.firstChildOffset = 128
+		pn.nextSiblingOffset = 256
+
+		src, err := pn.serialize()
+
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(src)
+	}
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		pn, err := makePersistentNodeFromBytes(src)
+
+		if err != nil {
+			if !errors.Is(err, ErrNodeCorrupted) && !errors.Is(err, ErrInvalidChecksum) {
+				t.Fatalf("untyped error: %v", err)
+			}
+
+			return
+		}
+
+		if pn.size() != len(src) {
+			t.Fatalf("unexpected size: got:%d, want:%d", pn.size(), len(src))
+		}
+
+		// The data of non-record nodes is skipped, and cannot round-trip.
+		if !pn.isRecord() && pn.dataLen > 0 {
+			return
+		}
+
+		got, err := pn.serialize()
+
+		if err != nil {
+			t.Fatalf("serialize(): %v", err)
+		}
+
+		if !bytes.Equal(got, src) {
+			t.Fatalf("node does not round-trip: got:%x, want:%x", got, src)
+		}
+	})
+}
@@ -0,0 +1,674 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/chronohq/arc/format"
+)
+
+// The database file consists of the arcHeader, followed by the index nodes and
+// the blobs. Nodes are written in depth-first preorder, starting with the root
+// node immediately after the header, whose offset the header records along
+// with the node and record counts. Node offsets are absolute offsets from the
+// start of the file, and zero denotes the absence of a node. Each blob is
+// written as its length (uint32), its value, and a checksum of both. An empty
+// database consists of the header only.
+
+// WriteTo writes a snapshot of the database to w in the Arc file format. It
+// implements the io.WriterTo interface.
+func (a *Arc) WriteTo(w io.Writer) (int64, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.writeTo(w)
+}
+
+// EnableCompactNodes selects the node encoding of WriteTo and Save. The compact
+// encoding stores integers as varints, and child offsets relative to their
+// parent, with the children of a node laid out contiguously. It typically
+// shrinks the index nodes to a fraction of their fixed-length size, but
+// files written with it cannot be read by versions of Arc that predate it.
+// The encoding is disabled by default, and Open enables it for databases
+// that are loaded from a file that uses it.
+func (a *Arc) EnableCompactNodes(enabled bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.compactNodes = enabled
+}
+
+// ReadFrom replaces the contents of the database with the snapshot that is read
+// from r until EOF. Snapshots of older file format versions are accepted.
+// The database is left unchanged if the snapshot is invalid, in which case the
+// returned error is a *CorruptionError, or ErrUnsupportedVersion or
+// ErrUnsupportedFeature if the snapshot requires a newer version of Arc.
+// The database adopts the sequence number of the snapshot, and discards the
+// retained changes. Replacing the contents is neither recorded in the change
+// log nor observed by watchers. It implements the io.ReaderFrom interface.
+func (a *Arc) ReadFrom(r io.Reader) (int64, error) {
+	src, err := io.ReadAll(r)
+
+	if err != nil {
+		return int64(len(src)), err
+	}
+
+	t, err := decodeTree(src)
+
+	if err != nil {
+		return int64(len(src)), err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.replace(t)
+
+	return int64(len(src)), nil
+}
+
+// snapshot returns a serialized snapshot of the database, along with the
+// sequence number of the last mutation that it contains and its epoch.
+func (a *Arc) snapshot() (uint64, uint64, []byte, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var buf bytes.Buffer
+
+	if _, err := a.writeTo(&buf); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return a.seq, a.epoch, buf.Bytes(), nil
+}
+
+// writeTo is the lock-free version of WriteTo.
+func (a *Arc) writeTo(w io.Writer) (int64, error) {
+	var written int64
+
+	bw := bufio.NewWriter(w)
+	write := func(src []byte) error {
+		n, err := bw.Write(src)
+		written += int64(n)
+
+		return err
+	}
+
+	ah := newArcHeader()
+	order := preorderNodes(a.root)
+
+	if a.compactNodes {
+		ah.incompat |= format.FeatureCompactNodes
+		order = breadthFirstNodes(a.root)
+	}
+
+	// Assign the offsets before serializing the nodes, since the offsets of a
+	// node's child and sibling must be known in advance.
+	var offsets map[*node]uint64
+
+	if a.compactNodes {
+		offsets = compactOffsets(order, uint64(ah.size()))
+	} else {
+		offsets = fixedOffsets(order, uint64(ah.size()))
+	}
+
+	for _, n := range order {
+		if n.isRecord {
+			ah.numRecords++
+		}
+
+		if n.expiresAt != 0 {
+			ah.incompat |= format.FeatureNodeExpiry
+		}
+	}
+
+	ah.numNodes = uint64(len(order))
+	ah.rootOffset = offsets[a.root]
+	ah.seq = a.seq
+
+	header, err := ah.serialize()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := write(header); err != nil {
+		return written, err
+	}
+
+	for _, n := range order {
+		pn := makePersistentNode(*n)
+		pn.firstChildOffset = offsets[n.firstChild]
+
+		var src []byte
+
+		if a.compactNodes {
+			src, err = pn.serializeCompact(offsets[n])
+		} else {
+			pn.nextSiblingOffset = offsets[n.nextSibling]
+			src, err = pn.serialize()
+		}
+
+		if err != nil {
+			return written, err
+		}
+
+		if err := write(src); err != nil {
+			return written, err
+		}
+	}
+
+	// Blobs are sorted by ID so that equal databases have equal snapshots.
+	ids := make([]blobID, 0, len(a.blobs))
+
+	for id := range a.blobs {
+		ids = append(ids, id)
+	}
+
+	slices.SortFunc(ids, func(x, y blobID) int {
+		return bytes.Compare(x[:], y[:])
+	})
+
+	for _, id := range ids {
+		src, err := serializeBlob(a.blobs[id].value)
+
+		if err != nil {
+			return written, err
+		}
+
+		if err := write(src); err != nil {
+			return written, err
+		}
+	}
+
+	return written, bw.Flush()
+}
+
+// preorderNodes returns the nodes of the tree in depth-first preorder, which
+// is the layout of the fixed-length node encoding.
+func preorderNodes(root *node) []*node {
+	var ret []*node
+
+	var visit func(n *node)
+	visit = func(n *node) {
+		ret = append(ret, n)
+
+		for child := n.firstChild; child != nil; child = child.nextSibling {
+			visit(child)
+		}
+	}
+
+	if root != nil {
+		visit(root)
+	}
+
+	return ret
+}
+
+// breadthFirstNodes returns the nodes of the tree in breadth-first order, which
+// is the layout of the compact node encoding. The children of every node are
+// therefore contiguous.
+func breadthFirstNodes(root *node) []*node {
+	if root == nil {
+		return nil
+	}
+
+	ret := []*node{root}
+
+	for i := 0; i < len(ret); i++ {
+		for child := ret[i].firstChild; child != nil; child = child.nextSibling {
+			ret = append(ret, child)
+		}
+	}
+
+	return ret
+}
+
+// fixedOffsets assigns consecutive offsets to the nodes in the given order,
+// starting at start, using the fixed-length node encoding.
+func fixedOffsets(order []*node, start uint64) map[*node]uint64 {
+	ret := make(map[*node]uint64, len(order))
+
+	for _, n := range order {
+		ret[n] = start
+		start += uint64(makePersistentNode(*n).size())
+	}
+
+	return ret
+}
+
+// compactOffsets assigns consecutive offsets to the nodes in the given order,
+// starting at start, using the compact node encoding. The size of a compact
+// node depends on the distance to its first child, which depends on the sizes
+// of the nodes in between. The offsets are therefore refined until they are
+// stable. Starting from the smallest sizes, sizes only grow between rounds,
+// which guarantees termination.
+func compactOffsets(order []*node, start uint64) map[*node]uint64 {
+	ret := make(map[*node]uint64, len(order))
+	sizes := make([]uint64, len(order))
+
+	for i, n := range order {
+		sizes[i] = uint64(makePersistentNode(*n).compactSize(0))
+	}
+
+	for changed := true; changed; {
+		offset := start
+
+		for i, n := range order {
+			ret[n] = offset
+			offset += sizes[i]
+		}
+
+		changed = false
+
+		for i, n := range order {
+			pn := makePersistentNode(*n)
+			pn.firstChildOffset = ret[n.firstChild]
+
+			if size := uint64(pn.compactSize(ret[n])); size != sizes[i] {
+				sizes[i] = size
+				changed = true
+			}
+		}
+	}
+
+	return ret
+}
+
+// replace swaps the contents of the database with the decoded tree.
+func (a *Arc) replace(t decodedTree) {
+	a.root = t.root
+	a.numNodes = t.numNodes
+	a.numRecords = t.numRecords
+	a.blobs = t.blobs
+	a.seq = t.seq
+	a.epoch = rand.Uint64()
+	a.signalPublished()
+
+	// The retained changes no longer lead up to the contents.
+	if a.changeLog != nil {
+		a.changeLog = newChangeLog(len(a.changeLog.entries))
+	}
+}
+
+// serializeBlob serializes a blob value along with its length and checksum.
+func serializeBlob(value []byte) ([]byte, error) {
+	return format.AppendBlob(nil, value)
+}
+
+// CorruptionError describes a malformed database file. It matches ErrCorrupted
+// with errors.Is, along with the underlying error, such as ErrInvalidChecksum
+// or ErrNodeCorrupted.
+type CorruptionError struct {
+	Offset int64 // Offset of the malformed region from the start of the file.
+	Err    error // Underlying error.
+}
+
+// Error returns the underlying error along with the offset.
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+// Unwrap returns ErrCorrupted and the underlying error.
+func (e *CorruptionError) Unwrap() []error {
+	if e.Err == ErrCorrupted {
+		return []error{ErrCorrupted}
+	}
+
+	return []error{ErrCorrupted, e.Err}
+}
+
+// corruptionAt wraps the error in a CorruptionError at the given offset,
+// unless it already is one.
+func corruptionAt(offset uint64, err error) error {
+	var ce *CorruptionError
+
+	if errors.As(err, &ce) {
+		return err
+	}
+
+	return &CorruptionError{Offset: int64(offset), Err: err}
+}
+
+// decodedTree holds the contents of a decoded database file.
+type decodedTree struct {
+	version      uint8  // File format version of the decoded file.
+	compactNodes bool   // Whether the file uses the compact node encoding.
+	seq          uint64 // Sequence number of the last mutation of the contents.
+	root         *node
+	numNodes     int
+	numRecords   int
+	blobs        blobStore
+	paged        *pagedState // State of the paged layout, if the file uses it.
+}
+
+// treeDecoder holds the state of decoding the nodes of a database file.
+type treeDecoder struct {
+	src        []byte
+	compact    bool            // Whether the nodes use the compact encoding.
+	expiry     bool            // Whether the nodes may carry expiry times.
+	paged      *pagedState     // Collects the objects of the paged layout, if used.
+	start      uint64          // Start offset of the node region.
+	end        uint64          // End offset of the node region.
+	visited    map[uint64]bool // Offsets of the decoded nodes.
+	blobRefs   map[blobID]int  // Reference counts of the blobs.
+	numNodes   int
+	numRecords int
+}
+
+// decodeTree decodes a database file that was written by writeTo, in the
+// current or an older file format version. Every error that is caused by
+// malformed input is a *CorruptionError. Files that require a newer version
+// of Arc yield ErrUnsupportedVersion or ErrUnsupportedFeature instead.
+func decodeTree(src []byte) (decodedTree, error) {
+	ret := decodedTree{blobs: blobStore{}}
+
+	header, err := newArcHeaderFromBytes(src)
+
+	if errors.Is(err, ErrUnsupportedVersion) {
+		return ret, err
+	}
+
+	if err != nil {
+		return ret, corruptionAt(0, err)
+	}
+
+	if header.incompat&^format.KnownIncompatFeatures != 0 {
+		return ret, fmt.Errorf("%w: %#x", ErrUnsupportedFeature, header.incompat&^format.KnownIncompatFeatures)
+	}
+
+	ret.version = header.version
+	ret.seq = header.seq
+
+	if header.incompat&format.FeaturePagedLayout != 0 {
+		return decodePagedTree(src, header, ret)
+	}
+
+	ret.compactNodes = header.incompat&format.FeatureCompactNodes != 0
+	start := uint64(header.size())
+
+	// Version 1 has no root offset, and its root follows the header.
+	if header.version == format.Version1 && uint64(len(src)) > start {
+		header.rootOffset = start
+	}
+
+	d := treeDecoder{
+		src:      src,
+		compact:  header.incompat&format.FeatureCompactNodes != 0,
+		expiry:   header.incompat&format.FeatureNodeExpiry != 0,
+		start:    start,
+		end:      start,
+		visited:  map[uint64]bool{},
+		blobRefs: map[blobID]int{},
+	}
+
+	if header.rootOffset != 0 {
+		var next uint64
+
+		// The root offset is reported at the header that links it.
+		if header.rootOffset >= uint64(len(src)) {
+			return ret, corruptionAt(0, ErrCorrupted)
+		}
+
+		if ret.root, next, err = d.decodeNode(header.rootOffset, 0, true); err != nil {
+			return ret, err
+		}
+
+		// The root node has no siblings, and a non-record root must branch.
+		if (!d.compact && next != 0) || (!ret.root.isRecord && ret.root.numChildren < 2) {
+			return ret, corruptionAt(header.rootOffset, ErrNodeCorrupted)
+		}
+	}
+
+	// Version 1 headers carry no counts.
+	if header.version != format.Version1 {
+		if header.numNodes != uint64(d.numNodes) || header.numRecords != uint64(d.numRecords) {
+			return ret, corruptionAt(0, ErrCorrupted)
+		}
+	}
+
+	ret.numNodes = d.numNodes
+	ret.numRecords = d.numRecords
+
+	values, err := decodeBlobs(src, d.end)
+
+	if err != nil {
+		return ret, err
+	}
+
+	for id, refCount := range d.blobRefs {
+		value, found := values[id]
+
+		if !found {
+			return ret, corruptionAt(d.end, ErrCorrupted)
+		}
+
+		ret.blobs[id] = &blob{value: value, refCount: refCount}
+	}
+
+	return ret, nil
+}
+
+// decodeNode decodes the subtree of the node at the given offset, whose key
+// begins at the given depth of the path. It returns the node along with the
+// offset of its next sibling. In the compact encoding, that is the end of the
+// node, which is only followed for as many children as the parent has.
+// Offsets that were already decoded are rejected, which rules out cycles, and
+// the depth is bounded by the maximum key size, which bounds the recursion.
+func (d *treeDecoder) decodeNode(offset uint64, depth int, isRoot bool) (*node, uint64, error) {
+	if offset < d.start || offset > uint64(len(d.src)) {
+		return nil, 0, corruptionAt(offset, ErrNodeCorrupted)
+	}
+
+	if d.visited[offset] {
+		return nil, 0, corruptionAt(offset, ErrNodeCorrupted)
+	}
+
+	d.visited[offset] = true
+
+	pn, end, err := d.readNode(offset)
+
+	if err != nil {
+		return nil, 0, corruptionAt(offset, err)
+	}
+
+	if err := validatePersistentNode(pn, depth, isRoot); err != nil {
+		return nil, 0, corruptionAt(offset, err)
+	}
+
+	if pn.flags&flagHasExpiry != 0 && !d.expiry {
+		return nil, 0, corruptionAt(offset, ErrNodeCorrupted)
+	}
+
+	d.end = max(d.end, end)
+	d.numNodes++
+
+	ret := &node{
+		key:       pn.key,
+		isRecord:  pn.isRecord(),
+		blobValue: pn.hasBlob(),
+		expiresAt: pn.expiresAt,
+	}
+
+	if ret.isRecord {
+		d.numRecords++
+
+		if pn.dataLen > 0 {
+			ret.data = pn.data
+		}
+	}
+
+	var blobRef *diskBlob
+
+	if ret.blobValue {
+		id, err := sliceToBlobID(ret.data)
+
+		if err != nil {
+			return nil, 0, corruptionAt(offset, ErrNodeCorrupted)
+		}
+
+		d.blobRefs[id]++
+
+		if d.paged != nil {
+			if blobRef, err = d.paged.refBlob(id, pn.blobOffset); err != nil {
+				return nil, 0, corruptionAt(offset, err)
+			}
+		}
+	}
+
+	var last *node
+
+	for next := pn.firstChildOffset; ; {
+		// Paged nodes list the offsets of their children instead.
+		if d.paged != nil {
+			if ret.numChildren == len(pn.childOffsets) {
+				break
+			}
+
+			next = pn.childOffsets[ret.numChildren]
+		} else if next == 0 || (d.compact && ret.numChildren == int(pn.numChildren)) {
+			break
+		}
+
+		var child *node
+
+		childOffset := next
+
+		// Offsets beyond the file are reported at the node that links them.
+		if childOffset >= uint64(len(d.src)) {
+			return nil, 0, corruptionAt(offset, ErrNodeCorrupted)
+		}
+
+		if child, next, err = d.decodeNode(next, depth+len(ret.key), false); err != nil {
+			return nil, 0, err
+		}
+
+		// Children are sorted by key, and siblings never share a first byte.
+		if last != nil && last.key[0] >= child.key[0] {
+			return nil, 0, corruptionAt(childOffset, ErrNodeCorrupted)
+		}
+
+		if last == nil {
+			ret.firstChild = child
+		} else {
+			last.nextSibling = child
+		}
+
+		last = child
+		ret.numChildren++
+	}
+
+	if ret.numChildren != int(pn.numChildren) {
+		return nil, 0, corruptionAt(offset, ErrNodeCorrupted)
+	}
+
+	ret.resizeIndex()
+
+	if d.paged != nil {
+		d.paged.addRecord(ret, extent{offset: offset, size: end - offset}, pn.childOffsets, blobRef)
+		return ret, 0, nil
+	}
+
+	if d.compact {
+		return ret, end, nil
+	}
+
+	return ret, pn.nextSiblingOffset, nil
+}
+
+// readNode decodes the node at the given offset in the encoding of the file,
+// and returns it along with its end offset.
+func (d *treeDecoder) readNode(offset uint64) (persistentNode, uint64, error) {
+	if d.paged != nil {
+		pn, size, err := makePersistentNodeFromPagedBytes(d.src[offset:])
+
+		return pn, offset + uint64(size), err
+	}
+
+	if d.compact {
+		pn, size, err := makePersistentNodeFromCompactBytes(d.src[offset:], offset)
+
+		return pn, offset + uint64(size), err
+	}
+
+	if uint64(len(d.src))-offset < minNodeBytesLen {
+		return persistentNode{}, 0, ErrNodeCorrupted
+	}
+
+	// Peek the fixed length fields to determine the length of the node.
+	fixed := d.src[offset:]
+	pn := persistentNode{
+		flags:   fixed[0],
+		keyLen:  binary.LittleEndian.Uint16(fixed[3:]),
+		dataLen: binary.LittleEndian.Uint32(fixed[5:]),
+	}
+
+	if uint64(len(d.src))-offset < uint64(pn.size()) {
+		return persistentNode{}, 0, ErrNodeCorrupted
+	}
+
+	end := offset + uint64(pn.size())
+	pn, err := makePersistentNodeFromBytes(d.src[offset:end])
+
+	return pn, end, err
+}
+
+// validatePersistentNode verifies the fields of a decoded node whose key
+// begins at the given depth of the path. Only the root node may have an empty
+// key, or be a non-record node with fewer than two children.
+func validatePersistentNode(pn persistentNode, depth int, isRoot bool) error {
+	if pn.flags&^(flagIsRecord|flagHasBlob|flagHasExpiry) != 0 {
+		return ErrNodeCorrupted
+	}
+
+	if (!isRoot && pn.keyLen == 0) || depth+int(pn.keyLen) > maxKeyBytes {
+		return ErrNodeCorrupted
+	}
+
+	if !pn.isRecord() {
+		// A non-record node exists only to branch, and carries no value.
+		if pn.flags != 0 || pn.dataLen != 0 || (!isRoot && pn.numChildren < 2) {
+			return ErrNodeCorrupted
+		}
+
+		return nil
+	}
+
+	if pn.hasBlob() && pn.dataLen != blobIDLen {
+		return ErrNodeCorrupted
+	}
+
+	if pn.dataLen > inlineValueThreshold {
+		return ErrNodeCorrupted
+	}
+
+	return nil
+}
+
+// decodeBlobs decodes the blob region of a database file, which begins at the
+// given offset and extends to the end of the file.
+func decodeBlobs(src []byte, offset uint64) (map[blobID][]byte, error) {
+	ret := map[blobID][]byte{}
+
+	for offset < uint64(len(src)) {
+		value, size, err := format.DecodeBlob(src[offset:])
+
+		if err != nil {
+			return nil, corruptionAt(offset, err)
+		}
+
+		ret[makeBlobID(value)] = value
+		offset += uint64(size)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,565 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/chronohq/arc/format"
+)
+
+func TestWriteToReadFrom(t *testing.T) {
+	setTestClock(t)
+
+	testCases := []struct {
+		name string
+		arc  func() *Arc
+	}{
+		{"empty tree", New},
+		{"basic tree", basicTestTree},
+		{"ip string tree", ipStringTestTree},
+		{
+			name: "with blobs and expiry",
+			arc: func() *Arc {
+				arc := basicTestTree()
+				arc.Put([]byte("apple"), blobValueX())
+				arc.Put([]byte("lemon"), blobValueX())
+				arc.Put([]byte("grape"), bytes.Repeat([]byte("y"), 100))
+				arc.PutWithTTL([]byte("session"), []byte("token"), time.Minute)
+
+				return arc
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, compact := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s compact=%t", tc.name, compact), func(t *testing.T) {
+				want := tc.arc()
+				want.EnableCompactNodes(compact)
+
+				var buf bytes.Buffer
+
+				written, err := want.WriteTo(&buf)
+
+				if err != nil {
+					t.Fatalf("WriteTo(): %v", err)
+				}
+
+				if written != int64(buf.Len()) {
+					t.Errorf("unexpected written length: got:%d, want:%d", written, buf.Len())
+				}
+
+				snapshot := bytes.Clone(buf.Bytes())
+
+				got := basicTestTree()
+				got.EnableCompactNodes(compact)
+				read, err := got.ReadFrom(&buf)
+
+				if err != nil {
+					t.Fatalf("ReadFrom(): %v", err)
+				}
+
+				if read != written {
+					t.Errorf("unexpected read length: got:%d, want:%d", read, written)
+				}
+
+				assertEquivalentTree(t, got, want)
+
+				ttl, _ := want.TTL([]byte("session"))
+
+				if gotTTL, _ := got.TTL([]byte("session")); gotTTL != ttl {
+					t.Errorf("unexpected TTL: got:%v, want:%v", gotTTL, ttl)
+				}
+
+				// Equal databases must produce equal snapshots.
+				buf.Reset()
+				got.WriteTo(&buf)
+
+				if !bytes.Equal(buf.Bytes(), snapshot) {
+					t.Error("snapshot of the restored database differs")
+				}
+			})
+		}
+	}
+}
+
+func TestCompactNodes(t *testing.T) {
+	arc := ipStringTestTree()
+	arc.Put([]byte("apple"), blobValueX())
+
+	var fixed, compact bytes.Buffer
+
+	if _, err := arc.WriteTo(&fixed); err != nil {
+		t.Fatalf("WriteTo(): %v", err)
+	}
+
+	arc.EnableCompactNodes(true)
+
+	if _, err := arc.WriteTo(&compact); err != nil {
+		t.Fatalf("WriteTo(): %v", err)
+	}
+
+	header, err := newArcHeaderFromBytes(compact.Bytes())
+
+	if err != nil {
+		t.Fatalf("newArcHeaderFromBytes(): %v", err)
+	}
+
+	if header.incompat != format.FeatureCompactNodes {
+		t.Errorf("unexpected incompat features: got:%#x, want:%#x", header.incompat, format.FeatureCompactNodes)
+	}
+
+	// The blob region is shared, so the difference is in the nodes alone. In
+	// a small tree, every varint takes at most two bytes, which saves at least
+	// 16 of the 29 bytes of fixed-length fields and checksum of every node.
+	if saved := fixed.Len() - compact.Len(); saved < arc.numNodes*16 {
+		t.Errorf("unexpected savings: got:%d bytes for %d nodes", saved, arc.numNodes)
+	}
+}
+
+func TestReadFromCorrupted(t *testing.T) {
+	arc := basicTestTree()
+	arc.Put([]byte("apple"), blobValueX())
+
+	var buf bytes.Buffer
+
+	if _, err := arc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo(): %v", err)
+	}
+
+	src := buf.Bytes()
+
+	testCases := []struct {
+		name    string
+		src     []byte
+		wantErr error
+	}{
+		{"empty input", nil, ErrCorrupted},
+		{"truncated header", src[:arcHeaderBytesLen-1], ErrCorrupted},
+		{"corrupted header", append([]byte{magicByte, fileFormatVersion, 9}, src[3:]...), ErrInvalidChecksum},
+		{"unknown version", append([]byte{magicByte, 0xff}, src[2:]...), ErrUnsupportedVersion},
+		{"unknown incompat feature", craftHeader(t, func(h *arcHeader) { h.incompat = 1 << 31 }), ErrUnsupportedFeature},
+		{"node count mismatch", craftHeader(t, func(h *arcHeader) { h.numNodes = 2 }), ErrCorrupted},
+		{"record count mismatch", craftHeader(t, func(h *arcHeader) { h.numRecords = 0 }), ErrCorrupted},
+		{"root offset in header", craftHeader(t, func(h *arcHeader) { h.rootOffset = 1 }), ErrNodeCorrupted},
+		{"root offset beyond file", craftHeader(t, func(h *arcHeader) { h.rootOffset = math.MaxUint64 }), ErrCorrupted},
+		{"truncated node", src[:arcHeaderBytesLen+minNodeBytesLen], ErrNodeCorrupted},
+		{"truncated blob", src[:len(src)-1], ErrCorrupted},
+		{"missing blob", src[:len(src)-len(blobValueX())-sizeOfUint32-checksumLen], ErrCorrupted},
+		{"cyclic siblings", craftCyclicSiblings(t), ErrNodeCorrupted},
+		{"self-referencing child", craftSelfReference(t), ErrNodeCorrupted},
+		{"out of range child offset", craftOutOfRangeChild(t), ErrNodeCorrupted},
+		{"oversized data length", craftOversizedDataLen(t), ErrNodeCorrupted},
+		{"empty child key", craftBranch(t, "", "b"), ErrNodeCorrupted},
+		{"unsorted children", craftBranch(t, "b", "a"), ErrNodeCorrupted},
+		{"shared first byte", craftBranch(t, "ab", "ac"), ErrNodeCorrupted},
+		{"unknown flag", craftSnapshot(t, craftNode("a", 0x80, 0)), ErrNodeCorrupted},
+		{"expiry without feature", craftSnapshot(t, craftNode("a", flagIsRecord|flagHasExpiry, 0)), ErrNodeCorrupted},
+		{"redundant root", craftSnapshot(t, craftNode("a", 0, 0)), ErrNodeCorrupted},
+		{"oversized inline value", craftOversizedInlineValue(t), ErrNodeCorrupted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subject := basicTestTree()
+			_, err := subject.ReadFrom(bytes.NewReader(tc.src))
+
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, tc.wantErr)
+			}
+
+			var ce *CorruptionError
+
+			if isUnsupported(err) {
+				// Files of newer versions are not corrupted.
+			} else if !errors.As(err, &ce) {
+				t.Errorf("expected a CorruptionError: got:%T", err)
+			} else if ce.Offset < 0 || ce.Offset > int64(len(tc.src)) {
+				t.Errorf("offset outside of the file: %d", ce.Offset)
+			}
+
+			// A failed read leaves the database unchanged.
+			assertEquivalentTree(t, subject, basicTestTree())
+		})
+	}
+
+	// Flipping any byte of the snapshot must be detected, in either encoding.
+	arc.EnableCompactNodes(true)
+	buf.Reset()
+
+	if _, err := arc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo(): %v", err)
+	}
+
+	for _, snapshot := range [][]byte{src, buf.Bytes()} {
+		for i := range snapshot {
+			corrupted := bytes.Clone(snapshot)
+			corrupted[i] ^= 0x01
+
+			if _, err := New().ReadFrom(bytes.NewReader(corrupted)); err == nil {
+				t.Errorf("undetected corruption at offset %d", i)
+			}
+		}
+	}
+}
+
+// isUnsupported returns true if the error denotes a file that requires a newer
+// version of Arc, rather than a corrupted file.
+func isUnsupported(err error) bool {
+	return errors.Is(err, ErrUnsupportedVersion) || errors.Is(err, ErrUnsupportedFeature)
+}
+
+// craftNode returns a persistentNode without links. Records hold the value "v".
+func craftNode(key string, flags uint8, numChildren uint16) persistentNode {
+	pn := persistentNode{
+		flags:       flags,
+		numChildren: numChildren,
+		keyLen:      uint16(len(key)),
+		key:         []byte(key),
+	}
+
+	if pn.isRecord() {
+		pn.data = []byte("v")
+		pn.dataLen = uint32(len(pn.data))
+	}
+
+	return pn
+}
+
+// craftedOffset returns the offset of the i-th node of a crafted snapshot.
+func craftedOffset(nodes []persistentNode, i int) uint64 {
+	offset := uint64(arcHeaderBytesLen)
+
+	for _, pn := range nodes[:i] {
+		offset += uint64(pn.size())
+	}
+
+	return offset
+}
+
+// craftSnapshot returns a snapshot that consists of a valid header followed by
+// the given nodes, which may be linked arbitrarily.
+func craftSnapshot(t *testing.T, nodes ...persistentNode) []byte {
+	t.Helper()
+
+	header := newArcHeader()
+	header.numNodes = uint64(len(nodes))
+
+	for _, pn := range nodes {
+		if pn.isRecord() {
+			header.numRecords++
+		}
+	}
+
+	if len(nodes) > 0 {
+		header.rootOffset = arcHeaderBytesLen
+	}
+
+	return craftSnapshotWithHeader(t, header, nodes...)
+}
+
+// craftSnapshotWithHeader returns a snapshot that consists of the given header
+// followed by the given nodes.
+func craftSnapshotWithHeader(t *testing.T, header arcHeader, nodes ...persistentNode) []byte {
+	t.Helper()
+
+	ret, err := header.serialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pn := range nodes {
+		src, err := pn.serialize()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ret = append(ret, src...)
+	}
+
+	return ret
+}
+
+// craftHeader returns a snapshot of a single record whose header is modified
+// by the given function after the header fields are populated.
+func craftHeader(t *testing.T, modify func(*arcHeader)) []byte {
+	t.Helper()
+
+	header := newArcHeader()
+	header.numNodes = 1
+	header.numRecords = 1
+	header.rootOffset = arcHeaderBytesLen
+	modify(&header)
+
+	return craftSnapshotWithHeader(t, header, craftNode("a", flagIsRecord, 0))
+}
+
+// craftBranch returns a snapshot whose root branches into two record children
+// with the given keys, in the given order.
+func craftBranch(t *testing.T, first string, second string) []byte {
+	nodes := []persistentNode{
+		craftNode("", 0, 2),
+		craftNode(first, flagIsRecord, 0),
+		craftNode(second, flagIsRecord, 0),
+	}
+
+	nodes[0].firstChildOffset = craftedOffset(nodes, 1)
+	nodes[1].nextSiblingOffset = craftedOffset(nodes, 2)
+
+	return craftSnapshot(t, nodes...)
+}
+
+// craftCyclicSiblings returns a snapshot whose second child links back to the
+// first child as its sibling.
+func craftCyclicSiblings(t *testing.T) []byte {
+	nodes := []persistentNode{
+		craftNode("", 0, 2),
+		craftNode("a", flagIsRecord, 0),
+		craftNode("b", flagIsRecord, 0),
+	}
+
+	nodes[0].firstChildOffset = craftedOffset(nodes, 1)
+	nodes[1].nextSiblingOffset = craftedOffset(nodes, 2)
+	nodes[2].nextSiblingOffset = craftedOffset(nodes, 1)
+
+	return craftSnapshot(t, nodes...)
+}
+
+// craftSelfReference returns a snapshot whose root is its own child.
+func craftSelfReference(t *testing.T) []byte {
+	root := craftNode("a", flagIsRecord, 1)
+	root.firstChildOffset = arcHeaderBytesLen
+
+	return craftSnapshot(t, root)
+}
+
+// craftOutOfRangeChild returns a snapshot whose root links to a child at an
+// offset that overflows when the node length is added.
+func craftOutOfRangeChild(t *testing.T) []byte {
+	root := craftNode("a", flagIsRecord, 1)
+	root.firstChildOffset = math.MaxUint64 - 1
+
+	return craftSnapshot(t, root)
+}
+
+// craftOversizedDataLen returns a snapshot whose root claims a data length far
+// beyond the end of the file. The length field is patched after encoding, and
+// the checksum is recomputed.
+func craftOversizedDataLen(t *testing.T) []byte {
+	ret := craftSnapshot(t, craftNode("a", flagIsRecord, 0))
+	node := ret[arcHeaderBytesLen:]
+
+	binary.LittleEndian.PutUint32(node[5:], maxUint32)
+	binary.LittleEndian.PutUint32(node[len(node)-checksumLen:], format.Checksum(node[:len(node)-checksumLen]))
+
+	return ret
+}
+
+// craftOversizedInlineValue returns a snapshot whose root holds an inline value
+// that exceeds the inline value threshold.
+func craftOversizedInlineValue(t *testing.T) []byte {
+	root := craftNode("a", flagIsRecord, 0)
+	root.data = bytes.Repeat([]byte("v"), inlineValueThreshold+1)
+	root.dataLen = uint32(len(root.data))
+
+	return craftSnapshot(t, root)
+}
+
+func TestReadFromUnknownCompatFeature(t *testing.T) {
+	src := craftHeader(t, func(h *arcHeader) { h.compat = 1 << 31 })
+	subject := New()
+
+	if _, err := subject.ReadFrom(bytes.NewReader(src)); err != nil {
+		t.Fatalf("ReadFrom(): %v", err)
+	}
+
+	if got, err := subject.Get([]byte("a")); err != nil || string(got) != "v" {
+		t.Errorf("unexpected record: got:%q, %v", got, err)
+	}
+}
+
+func FuzzReadFrom(f *testing.F) {
+	seeds := []*Arc{New(), basicTestTree(), ipStringTestTree()}
+
+	withBlobs := basicTestTree()
+	withBlobs.Put([]byte("apple"), blobValueX())
+	withBlobs.Put([]byte("lemon"), blobValueX())
+	withBlobs.PutWithTTL([]byte("session"), []byte("token"), time.Hour)
+	seeds = append(seeds, withBlobs)
+
+	for _, arc := range seeds {
+		for _, compact := range []bool{false, true} {
+			var buf bytes.Buffer
+
+			arc.EnableCompactNodes(compact)
+
+			if _, err := arc.WriteTo(&buf); err != nil {
+				f.Fatal(err)
+			}
+
+			f.Add(buf.Bytes())
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		arc := New()
+
+		if _, err := arc.ReadFrom(bytes.NewReader(src)); err != nil {
+			var ce *CorruptionError
+
+			if isUnsupported(err) {
+				return
+			}
+
+			if !errors.As(err, &ce) {
+				t.Fatalf("untyped error: %v", err)
+			}
+
+			if ce.Offset < 0 || ce.Offset > int64(len(src)) {
+				t.Fatalf("offset outside of the file: %d", ce.Offset)
+			}
+
+			return
+		}
+
+		if err := checkInvariants(arc); err != nil {
+			t.Fatalf("decoded tree violates invariants: %v", err)
+		}
+
+		// The decoded tree must survive a round-trip unchanged.
+		var first bytes.Buffer
+
+		if _, err := arc.WriteTo(&first); err != nil {
+			t.Fatalf("WriteTo(): %v", err)
+		}
+
+		again := New()
+
+		if _, err := again.ReadFrom(bytes.NewReader(first.Bytes())); err != nil {
+			t.Fatalf("ReadFrom() of a re-encoded snapshot: %v", err)
+		}
+
+		var second bytes.Buffer
+
+		if _, err := again.WriteTo(&second); err != nil {
+			t.Fatalf("WriteTo(): %v", err)
+		}
+
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatal("re-encoded snapshot is not stable")
+		}
+
+		// Both node encodings hold the same tree.
+		var compact bytes.Buffer
+
+		again.EnableCompactNodes(true)
+
+		if _, err := again.WriteTo(&compact); err != nil {
+			t.Fatalf("WriteTo(): %v", err)
+		}
+
+		restored := New()
+
+		if _, err := restored.ReadFrom(bytes.NewReader(compact.Bytes())); err != nil {
+			t.Fatalf("ReadFrom() of a compact snapshot: %v", err)
+		}
+
+		second.Reset()
+
+		if _, err := restored.WriteTo(&second); err != nil {
+			t.Fatalf("WriteTo(): %v", err)
+		}
+
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatal("compact snapshot does not hold the same tree")
+		}
+	})
+}
+
+// FuzzReadFromCrafted decodes node descriptions from the fuzz input and links
+// them into a snapshot with valid checksums, which exercises the structural
+// validation that random bytes rarely reach past the checksums.
+func FuzzReadFromCrafted(f *testing.F) {
+	f.Add([]byte{0, 2, 0, 2, 0, 1, 1, 'a', 0, 3, 1, 1, 'b', 0, 0})
+	f.Add([]byte{1, 1, 1, 'a', 2, 0, 1, 0, 1, 'b', 0, 0})
+	f.Add([]byte{0, 2, 0, 2, 0, 1, 1, 'a', 0, 3, 1, 1, 'b', 0, 2})
+	f.Add([]byte{3, 0, 1, 'a', 0, 0})
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		in := &modelInput{src: src}
+
+		var nodes []persistentNode
+		var links [][2]byte
+
+		for len(in.src) > 0 && len(nodes) < 16 {
+			pn := craftNode("", in.byte()&0x0f, uint16(in.byte()))
+
+			for n := in.byte() % 4; n > 0; n-- {
+				pn.key = append(pn.key, in.byte())
+			}
+
+			pn.keyLen = uint16(len(pn.key))
+
+			if pn.hasBlob() {
+				id := makeBlobID(blobValueX())
+				pn.data = id[:]
+				pn.dataLen = blobIDLen
+			}
+
+			nodes = append(nodes, pn)
+			links = append(links, [2]byte{in.byte(), in.byte()})
+		}
+
+		// A link of zero denotes no node, and a link of k denotes the node at
+		// position k-1. Links beyond the nodes are kept as raw offsets.
+		resolve := func(link byte) uint64 {
+			if link == 0 {
+				return 0
+			}
+
+			if int(link) <= len(nodes) {
+				return craftedOffset(nodes, int(link)-1)
+			}
+
+			return uint64(link)
+		}
+
+		for i := range nodes {
+			nodes[i].firstChildOffset = resolve(links[i][0])
+			nodes[i].nextSiblingOffset = resolve(links[i][1])
+		}
+
+		snapshot := craftSnapshot(t, nodes...)
+		blob, err := serializeBlob(blobValueX())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		arc := New()
+
+		if _, err := arc.ReadFrom(bytes.NewReader(append(snapshot, blob...))); err != nil {
+			var ce *CorruptionError
+
+			if !errors.As(err, &ce) {
+				t.Fatalf("untyped error: %v", err)
+			}
+
+			return
+		}
+
+		if err := checkInvariants(arc); err != nil {
+			t.Fatalf("decoded tree violates invariants: %v", err)
+		}
+	})
+}
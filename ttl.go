@@ -0,0 +1,157 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// timeNow returns the current time. It is a variable so that tests can control
+// the clock that decides record expiry.
+var timeNow = time.Now
+
+// PutWithTTL inserts or updates a key-value pair that expires after the given
+// duration. Expired records are immediately hidden from reads and scans, and
+// are reclaimed by ReclaimExpired, by the expiry sweeper, or when the key is
+// written again. Until then, expired records are included in Len. It returns
+// ErrInvalidTTL if the duration is not positive.
+func (a *Arc) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.put(key, value, timeNow().Add(ttl).UnixNano(), true)
+}
+
+// TTL returns the remaining time to live of the given key. It returns zero if
+// the record does not expire, and ErrKeyNotFound if the key does not exist.
+func (a *Arc) TTL(key []byte) (time.Duration, error) {
+	if key == nil {
+		return 0, ErrNilKey
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	n, err := a.findRecord(key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if n.expiresAt == 0 {
+		return 0, nil
+	}
+
+	return time.Duration(n.expiresAt - timeNow().UnixNano()), nil
+}
+
+// reclaimBatchSize is the number of records that ReclaimExpired visits while
+// holding the write lock. The lock is released between batches, so that a
+// sweep of a large database does not stall the other callers.
+const reclaimBatchSize = 1024
+
+// ReclaimExpired removes every expired record from the database. Removing the
+// records merges the nodes that become redundant, and releases the blobs that
+// are no longer referenced. The records are visited in batches of bounded
+// size, and the write lock is released between batches. Therefore concurrent
+// writes may interleave with the sweep. It returns the number of reclaimed
+// records.
+func (a *Arc) ReclaimExpired() int {
+	var ret int
+
+	for next := []byte{}; next != nil; {
+		var n int
+
+		n, next = a.reclaimBatch(next, reclaimBatchSize)
+		ret += n
+	}
+
+	return ret
+}
+
+// reclaimBatch removes the expired records among at most limit records,
+// starting at the given key. It returns the number of reclaimed records, and
+// the key to resume from, which is nil when no records remain.
+func (a *Arc) reclaimBatch(start []byte, limit int) (int, []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var expired [][]byte
+	var next []byte
+	var visited int
+
+	a.walk(start, nil, func(key []byte, n *node) bool {
+		if visited == limit {
+			next = bytes.Clone(key)
+			return false
+		}
+
+		visited++
+
+		if n.expired() {
+			expired = append(expired, bytes.Clone(key))
+		}
+
+		return true
+	})
+
+	var ret int
+
+	for _, key := range expired {
+		n, parent, err := a.findNodeAndParent(key)
+
+		if err != nil {
+			continue
+		}
+
+		var oldHash Hash
+
+		if a.observed() {
+			oldHash = n.valueHash()
+		}
+
+		if err := a.removeRecord(n, parent); err != nil {
+			continue
+		}
+
+		var events []Event
+
+		if a.observed() {
+			events = append(events, Event{Type: EventDelete, Key: key, OldHash: oldHash})
+		}
+
+		a.publish(Change{Op: OpDelete, Key: key}, events...)
+		ret++
+	}
+
+	return ret, next
+}
+
+// RunExpirySweeper calls ReclaimExpired at the given interval until the context
+// is done. It blocks, therefore it is typically run in its own goroutine. It
+// returns ErrInvalidInterval if the interval is not positive, and otherwise
+// the error of the context.
+func (a *Arc) RunExpirySweeper(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return ErrInvalidInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			a.ReclaimExpired()
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// setTestClock replaces the expiry clock for the duration of the test, and
+// returns a function that advances it.
+func setTestClock(t *testing.T) func(time.Duration) {
+	t.Helper()
+
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	t.Cleanup(func() { timeNow = time.Now })
+
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestPutWithTTL(t *testing.T) {
+	advance := setTestClock(t)
+	arc := basicTestTree()
+
+	if err := arc.PutWithTTL([]byte("session"), []byte("token"), time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := arc.PutWithTTL([]byte("apple"), blobValueX(), 2*time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ttl, _ := arc.TTL([]byte("session")); ttl != time.Minute {
+		t.Errorf("unexpected TTL: got:%v, want:%v", ttl, time.Minute)
+	}
+
+	if ttl, _ := arc.TTL([]byte("orange")); ttl != 0 {
+		t.Errorf("unexpected TTL: got:%v, want:0", ttl)
+	}
+
+	advance(time.Minute)
+
+	if _, err := arc.Get([]byte("session")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrKeyNotFound)
+	}
+
+	if _, err := arc.TTL([]byte("session")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrKeyNotFound)
+	}
+
+	if got, _ := arc.Get([]byte("apple")); !bytes.Equal(got, blobValueX()) {
+		t.Errorf("unexpected value: got:%q, want:%q", got, blobValueX())
+	}
+
+	// An expired key can be added again, and the new value does not expire.
+	if err := arc.Add([]byte("session"), []byte("renewed")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	advance(time.Hour)
+
+	if got, _ := arc.Get([]byte("session")); !bytes.Equal(got, []byte("renewed")) {
+		t.Errorf("unexpected value: got:%q, want:%q", got, "renewed")
+	}
+
+	for k := range arc.Scan([]byte("app")) {
+		if bytes.Equal(k, []byte("apple")) {
+			t.Error("expected the expired record to be hidden from scans")
+		}
+	}
+
+	if err := arc.Delete([]byte("apple")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrKeyNotFound)
+	}
+
+	if len(arc.blobs) != 0 {
+		t.Error("expected deleting the expired record to release its blob")
+	}
+}
+
+func TestPutWithTTLInvalid(t *testing.T) {
+	if err := New().PutWithTTL([]byte("k"), nil, 0); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidTTL)
+	}
+}
+
+func TestPutClearsTTL(t *testing.T) {
+	advance := setTestClock(t)
+	arc := New()
+
+	arc.PutWithTTL([]byte("k"), []byte("v1"), time.Second)
+	arc.Put([]byte("k"), []byte("v2"))
+
+	advance(time.Minute)
+
+	if got, _ := arc.Get([]byte("k")); !bytes.Equal(got, []byte("v2")) {
+		t.Errorf("unexpected value: got:%q, want:%q", got, "v2")
+	}
+}
+
+func TestReadModifyWriteKeepsTTL(t *testing.T) {
+	testCases := []struct {
+		name string
+		fn   func(a *Arc) error
+	}{
+		{"CompareAndSwap", func(a *Arc) error {
+			_, err := a.CompareAndSwap([]byte("k"), []byte("v1"), []byte("v2"))
+			return err
+		}},
+		{"Swap", func(a *Arc) error {
+			_, _, err := a.Swap([]byte("k"), []byte("v2"))
+			return err
+		}},
+		{"Update", func(a *Arc) error {
+			return a.Update([]byte("k"), func([]byte, bool) ([]byte, bool, error) {
+				return []byte("v2"), true, nil
+			})
+		}},
+		{"Merge", func(a *Arc) error {
+			a.RegisterMergeOperator(nil, MergeFunc(func([]byte, bool, []byte) ([]byte, error) {
+				return []byte("v2"), nil
+			}))
+
+			return a.Merge([]byte("k"), nil)
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			advance := setTestClock(t)
+			arc := New()
+
+			arc.PutWithTTL([]byte("k"), []byte("v1"), time.Minute)
+			advance(time.Second)
+
+			if err := tc.fn(arc); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ttl, _ := arc.TTL([]byte("k")); ttl != time.Minute-time.Second {
+				t.Errorf("unexpected TTL: got:%v, want:%v", ttl, time.Minute-time.Second)
+			}
+
+			if got, _ := arc.Get([]byte("k")); !bytes.Equal(got, []byte("v2")) {
+				t.Errorf("unexpected value: got:%q, want:%q", got, "v2")
+			}
+
+			advance(time.Minute)
+
+			if _, err := arc.Get([]byte("k")); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("unexpected error: got:%v, want:%v", err, ErrKeyNotFound)
+			}
+		})
+	}
+}
+
+func TestReclaimExpired(t *testing.T) {
+	advance := setTestClock(t)
+	arc := basicTestTree()
+
+	for _, row := range basicTestTreeData() {
+		key := append([]byte("session/"), row.key...)
+
+		if err := arc.PutWithTTL(key, blobValueX(), time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	arc.PutWithTTL([]byte("lemon"), []byte("sour"), time.Second)
+	arc.PutWithTTL([]byte("limestone"), []byte("concrete"), time.Hour)
+
+	if got := arc.ReclaimExpired(); got != 0 {
+		t.Errorf("unexpected reclaim count: got:%d, want:0", got)
+	}
+
+	advance(time.Minute)
+
+	want := len(basicTestTreeData()) + 1
+
+	if got := arc.ReclaimExpired(); got != want {
+		t.Errorf("unexpected reclaim count: got:%d, want:%d", got, want)
+	}
+
+	expected := New()
+
+	for _, row := range basicTestTreeData() {
+		if !bytes.Equal(row.key, []byte("lemon")) {
+			expected.Put(row.key, row.data)
+		}
+	}
+
+	assertEquivalentTree(t, arc, expected)
+}
+
+func TestReclaimExpiredBatches(t *testing.T) {
+	advance := setTestClock(t)
+	arc := New()
+
+	// Spread the expired records across several batches, including the
+	// first and the last record of a batch.
+	count := 3*reclaimBatchSize + 1
+	expected := New()
+
+	for i := range count {
+		key := []byte(fmt.Sprintf("key/%05d", i))
+
+		if i%3 == 0 || i%reclaimBatchSize == reclaimBatchSize-1 {
+			arc.PutWithTTL(key, []byte("expired"), time.Second)
+		} else {
+			arc.Put(key, []byte("live"))
+			expected.Put(key, []byte("live"))
+		}
+	}
+
+	advance(time.Minute)
+
+	want := count - expected.Len()
+
+	if got := arc.ReclaimExpired(); got != want {
+		t.Errorf("unexpected reclaim count: got:%d, want:%d", got, want)
+	}
+
+	assertEquivalentTree(t, arc, expected)
+
+	if got, next := arc.reclaimBatch(nil, 2); got != 0 || !bytes.Equal(next, []byte("key/00004")) {
+		t.Errorf("unexpected batch: got:%d %q, want:0 %q", got, next, "key/00004")
+	}
+}
+
+func TestRunExpirySweeper(t *testing.T) {
+	advance := setTestClock(t)
+	arc := New()
+
+	arc.PutWithTTL([]byte("a"), []byte("1"), time.Second)
+	arc.PutWithTTL([]byte("b"), []byte("2"), time.Second)
+	arc.Put([]byte("c"), []byte("3"))
+
+	advance(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		if err := arc.RunExpirySweeper(ctx, time.Millisecond); !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: got:%v, want:%v", err, context.Canceled)
+		}
+
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for arc.Len() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	if arc.Len() != 1 {
+		t.Errorf("unexpected record count: got:%d, want:1", arc.Len())
+	}
+}
+
+func TestRunExpirySweeperInvalidInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := New().RunExpirySweeper(context.Background(), interval); !errors.Is(err, ErrInvalidInterval) {
+			t.Errorf("unexpected error: interval:%v, got:%v, want:%v", interval, err, ErrInvalidInterval)
+		}
+	}
+}
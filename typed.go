@@ -0,0 +1,95 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+// Typed wraps an Arc database with codecs for its keys and values, allowing
+// callers to work with Go types instead of byte slices. A Typed handle holds
+// no state of its own, therefore any number of handles can share one Arc.
+type Typed[K, V any] struct {
+	db     *Arc
+	keys   Codec[K]
+	values Codec[V]
+}
+
+// NewTyped returns a Typed handle that stores its records in db, using the
+// given codecs to convert keys and values.
+func NewTyped[K, V any](db *Arc, keys Codec[K], values Codec[V]) *Typed[K, V] {
+	return &Typed[K, V]{db: db, keys: keys, values: values}
+}
+
+// DB returns the underlying Arc database.
+func (t *Typed[K, V]) DB() *Arc {
+	return t.db
+}
+
+// Add inserts a new key-value pair in the database. It returns ErrDuplicateKey
+// if the key already exists.
+func (t *Typed[K, V]) Add(key K, value V) error {
+	k, v, err := t.encode(key, value)
+
+	if err != nil {
+		return err
+	}
+
+	return t.db.Add(k, v)
+}
+
+// Put inserts or updates a key-value pair in the database.
+func (t *Typed[K, V]) Put(key K, value V) error {
+	k, v, err := t.encode(key, value)
+
+	if err != nil {
+		return err
+	}
+
+	return t.db.Put(k, v)
+}
+
+// Get retrieves the value that matches the given key. Returns ErrKeyNotFound
+// if the key does not exist.
+func (t *Typed[K, V]) Get(key K) (V, error) {
+	var ret V
+
+	k, err := t.keys.Encode(key)
+
+	if err != nil {
+		return ret, err
+	}
+
+	v, err := t.db.Get(k)
+
+	if err != nil {
+		return ret, err
+	}
+
+	return t.values.Decode(v)
+}
+
+// Delete removes a record that matches the given key.
+func (t *Typed[K, V]) Delete(key K) error {
+	k, err := t.keys.Encode(key)
+
+	if err != nil {
+		return err
+	}
+
+	return t.db.Delete(k)
+}
+
+// encode converts the given key and value to their byte representation.
+func (t *Typed[K, V]) encode(key K, value V) ([]byte, []byte, error) {
+	k, err := t.keys.Encode(key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v, err := t.values.Encode(value)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return k, v, nil
+}
@@ -0,0 +1,81 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTyped(t *testing.T) {
+	db := New()
+	subject := NewTyped(db, StringCodec{}, IntCodec[int]{})
+
+	records := map[string]int{"apple": 1, "applet": -2, "banana": 3}
+
+	for k, v := range records {
+		if err := subject.Add(k, v); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := subject.Add("apple", 10); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrDuplicateKey)
+	}
+
+	if err := subject.Put("apple", 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	records["apple"] = 10
+
+	for k, want := range records {
+		got, err := subject.Get(k)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got != want {
+			t.Errorf("unexpected value: got:%d, want:%d", got, want)
+		}
+	}
+
+	// The underlying database must hold the encoded representation.
+	raw, err := db.Get([]byte("applet"))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want, _ := (IntCodec[int]{}).Encode(-2); !bytes.Equal(raw, want) {
+		t.Errorf("unexpected raw value: got:%x, want:%x", raw, want)
+	}
+
+	if err := subject.Delete("banana"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := subject.Get("banana"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrKeyNotFound)
+	}
+
+	if subject.DB().Len() != 2 {
+		t.Errorf("unexpected record count: got:%d, want:2", subject.DB().Len())
+	}
+}
+
+func TestTypedDecodeError(t *testing.T) {
+	db := New()
+	subject := NewTyped(db, StringCodec{}, IntCodec[int64]{})
+
+	if err := db.Put([]byte("bogus"), []byte("not-an-int")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := subject.Get("bogus"); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidEncoding)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"strings"
+)
+
+// Update performs an atomic read-modify-write of the given key. The callback
+// receives the current value and whether the key exists, and returns the new
+// value along with whether the record should be kept. Returning false removes
+// the record if it exists. Returning an error aborts the update, and the error
+// is returned by Update. An updated record keeps its expiry time, if any. The
+// callback runs while the write lock is held, thus it must not access the
+// database.
+func (a *Arc) Update(key []byte, fn func(old []byte, exists bool) ([]byte, bool, error)) error {
+	if key == nil {
+		return ErrNilKey
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.update(key, fn)
+}
+
+// update is the lock-free implementation of Update.
+func (a *Arc) update(key []byte, fn func(old []byte, exists bool) ([]byte, bool, error)) error {
+	var old []byte
+	var exists bool
+	var expiresAt int64
+
+	if n, err := a.findRecord(key); err == nil {
+		old = n.value(a.blobs)
+		exists = true
+		expiresAt = n.expiresAt
+	}
+
+	value, keep, err := fn(old, exists)
+
+	if err != nil {
+		return err
+	}
+
+	if keep {
+		return a.put(key, value, expiresAt, true)
+	}
+
+	if exists {
+		return a.delete(key)
+	}
+
+	return nil
+}
+
+// MergeOperator combines an operand with the current value of a key. It is
+// used by Merge to update values without a separate read and write.
+type MergeOperator interface {
+	// Merge returns the new value of a key, given its current value, whether
+	// the key exists, and the operand that was passed to (*Arc).Merge.
+	Merge(existing []byte, exists bool, operand []byte) ([]byte, error)
+}
+
+// MergeFunc is an adapter to allow the use of ordinary functions as merge
+// operators.
+type MergeFunc func(existing []byte, exists bool, operand []byte) ([]byte, error)
+
+// Merge calls f(existing, exists, operand).
+func (f MergeFunc) Merge(existing []byte, exists bool, operand []byte) ([]byte, error) {
+	return f(existing, exists, operand)
+}
+
+// RegisterMergeOperator registers a merge operator for the keys that begin with
+// the given prefix. When multiple registered prefixes match a key, the longest
+// prefix wins. An empty prefix registers a default operator for every key.
+// Registering a nil operator removes the registration of the prefix.
+func (a *Arc) RegisterMergeOperator(prefix []byte, op MergeOperator) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if op == nil {
+		delete(a.mergeOps, string(prefix))
+		return
+	}
+
+	if a.mergeOps == nil {
+		a.mergeOps = map[string]MergeOperator{}
+	}
+
+	a.mergeOps[string(prefix)] = op
+}
+
+// Merge atomically applies the registered merge operator to the current value
+// of the given key and the operand, and stores the result. It returns
+// ErrNoMergeOperator if no operator is registered for the key.
+func (a *Arc) Merge(key []byte, operand []byte) error {
+	if key == nil {
+		return ErrNilKey
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	op := a.findMergeOperator(key)
+
+	if op == nil {
+		return ErrNoMergeOperator
+	}
+
+	return a.update(key, func(old []byte, exists bool) ([]byte, bool, error) {
+		value, err := op.Merge(old, exists, operand)
+
+		return value, err == nil, err
+	})
+}
+
+// findMergeOperator returns the merge operator that is registered under the
+// longest prefix of the given key, or nil if there is none.
+func (a *Arc) findMergeOperator(key []byte) MergeOperator {
+	var ret MergeOperator
+
+	longest := -1
+
+	for prefix, op := range a.mergeOps {
+		if len(prefix) > longest && strings.HasPrefix(string(key), prefix) {
+			ret = op
+			longest = len(prefix)
+		}
+	}
+
+	return ret
+}
+
+// CounterAdd is a merge operator that maintains a signed 64-bit counter. The
+// value and the operand are encoded as in IntCodec[int64]. A missing key is
+// treated as zero. Additions wrap around on overflow.
+type CounterAdd struct{}
+
+// Merge returns the sum of the existing value and the operand.
+func (CounterAdd) Merge(existing []byte, exists bool, operand []byte) ([]byte, error) {
+	if len(operand) != intCodecLen {
+		return nil, ErrInvalidEncoding
+	}
+
+	var sum int64
+
+	if exists {
+		if len(existing) != intCodecLen {
+			return nil, ErrInvalidEncoding
+		}
+
+		sum = decodeInt64(existing)
+	}
+
+	return encodeInt64(sum + decodeInt64(operand)), nil
+}
+
+// Append is a merge operator that appends the operand to the existing value.
+type Append struct{}
+
+// Merge returns the concatenation of the existing value and the operand.
+func (Append) Merge(existing []byte, exists bool, operand []byte) ([]byte, error) {
+	ret := make([]byte, 0, len(existing)+len(operand))
+	ret = append(ret, existing...)
+
+	return append(ret, operand...), nil
+}
+
+// SetUnion is a merge operator that maintains a set of byte strings. The value
+// and the operand are sets that are encoded by EncodeSet.
+type SetUnion struct{}
+
+// Merge returns the union of the existing set and the operand set.
+func (SetUnion) Merge(existing []byte, exists bool, operand []byte) ([]byte, error) {
+	current, err := DecodeSet(existing)
+
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := DecodeSet(operand)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return EncodeSet(append(current, added...)...), nil
+}
+
+// EncodeSet returns the canonical encoding of a set of byte strings, as used by
+// the SetUnion merge operator. The elements are sorted and deduplicated, and
+// each element is stored with a uvarint length prefix.
+func EncodeSet(elems ...[]byte) []byte {
+	sorted := slices.Clone(elems)
+	slices.SortFunc(sorted, bytes.Compare)
+	sorted = slices.CompactFunc(sorted, bytes.Equal)
+
+	ret := []byte{}
+
+	for _, elem := range sorted {
+		ret = binary.AppendUvarint(ret, uint64(len(elem)))
+		ret = append(ret, elem...)
+	}
+
+	return ret
+}
+
+// DecodeSet returns the elements of a set that was encoded by EncodeSet. It
+// returns ErrInvalidEncoding if src is malformed.
+func DecodeSet(src []byte) ([][]byte, error) {
+	var ret [][]byte
+
+	for len(src) > 0 {
+		n, size := binary.Uvarint(src)
+
+		if size <= 0 || n > uint64(len(src)-size) {
+			return nil, ErrInvalidEncoding
+		}
+
+		src = src[size:]
+		ret = append(ret, bytes.Clone(src[:n]))
+		src = src[n:]
+	}
+
+	return ret, nil
+}
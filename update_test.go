@@ -0,0 +1,205 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	arc := basicTestTree()
+	errAbort := errors.New("abort")
+
+	// Modify an existing record.
+	err := arc.Update([]byte("apple"), func(old []byte, exists bool) ([]byte, bool, error) {
+		if !exists || !bytes.Equal(old, []byte("cider")) {
+			t.Errorf("unexpected callback input: got:%q/%t, want:%q/true", old, exists, "cider")
+		}
+
+		return append(old, "-vinegar"...), true, nil
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, _ := arc.Get([]byte("apple")); !bytes.Equal(got, []byte("cider-vinegar")) {
+		t.Errorf("unexpected value: got:%q, want:%q", got, "cider-vinegar")
+	}
+
+	// Insert a non-existing record.
+	err = arc.Update([]byte("kiwi"), func(old []byte, exists bool) ([]byte, bool, error) {
+		if exists || old != nil {
+			t.Errorf("unexpected callback input: got:%q/%t, want:nil/false", old, exists)
+		}
+
+		return []byte("green"), true, nil
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Remove a record by not keeping it.
+	err = arc.Update([]byte("orange"), func(old []byte, exists bool) ([]byte, bool, error) {
+		return nil, false, nil
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := arc.Get([]byte("orange")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrKeyNotFound)
+	}
+
+	// Not keeping a non-existing record is a no-op.
+	err = arc.Update([]byte("bogus"), func(old []byte, exists bool) ([]byte, bool, error) {
+		return nil, false, nil
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Aborting must leave the record unchanged.
+	err = arc.Update([]byte("lime"), func(old []byte, exists bool) ([]byte, bool, error) {
+		return []byte("changed"), true, errAbort
+	})
+
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("unexpected error: got:%v, want:%v", err, errAbort)
+	}
+
+	if got, _ := arc.Get([]byte("lime")); !bytes.Equal(got, []byte("green")) {
+		t.Errorf("unexpected value: got:%q, want:%q", got, "green")
+	}
+
+	if arc.Len() != len(basicTestTreeData()) {
+		t.Errorf("unexpected record count: got:%d, want:%d", arc.Len(), len(basicTestTreeData()))
+	}
+}
+
+func TestMergeOperators(t *testing.T) {
+	arc := New()
+	arc.RegisterMergeOperator([]byte("counter/"), CounterAdd{})
+	arc.RegisterMergeOperator([]byte("log/"), Append{})
+	arc.RegisterMergeOperator([]byte("tags/"), SetUnion{})
+
+	for _, delta := range []int64{5, -2, 10} {
+		if err := arc.Merge([]byte("counter/visits"), encodeInt64(delta)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got, _ := arc.Get([]byte("counter/visits")); decodeInt64(got) != 13 {
+		t.Errorf("unexpected counter: got:%d, want:13", decodeInt64(got))
+	}
+
+	for _, entry := range []string{"a", "b", "c"} {
+		if err := arc.Merge([]byte("log/1"), []byte(entry)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got, _ := arc.Get([]byte("log/1")); !bytes.Equal(got, []byte("abc")) {
+		t.Errorf("unexpected log: got:%q, want:%q", got, "abc")
+	}
+
+	arc.Merge([]byte("tags/1"), EncodeSet([]byte("red"), []byte("fruit")))
+	arc.Merge([]byte("tags/1"), EncodeSet([]byte("sweet"), []byte("red")))
+
+	got, _ := arc.Get([]byte("tags/1"))
+	tags, err := DecodeSet(got)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := [][]byte{[]byte("fruit"), []byte("red"), []byte("sweet")}
+
+	if !slices.EqualFunc(tags, want, bytes.Equal) {
+		t.Errorf("unexpected tags: got:%q, want:%q", tags, want)
+	}
+
+	if err := arc.Merge([]byte("other"), []byte("x")); !errors.Is(err, ErrNoMergeOperator) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrNoMergeOperator)
+	}
+
+	if err := arc.Merge([]byte("counter/visits"), []byte("short")); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, ErrInvalidEncoding)
+	}
+
+	if got, _ := arc.Get([]byte("counter/visits")); decodeInt64(got) != 13 {
+		t.Errorf("unexpected counter after failed merge: got:%d, want:13", decodeInt64(got))
+	}
+}
+
+func TestMergeOperatorLongestPrefix(t *testing.T) {
+	arc := New()
+	arc.RegisterMergeOperator(nil, Append{})
+	arc.RegisterMergeOperator([]byte("counter/"), CounterAdd{})
+
+	arc.Merge([]byte("counter/a"), encodeInt64(1))
+	arc.Merge([]byte("counter"), []byte("x"))
+
+	if got, _ := arc.Get([]byte("counter/a")); decodeInt64(got) != 1 {
+		t.Errorf("unexpected counter: got:%d, want:1", decodeInt64(got))
+	}
+
+	if got, _ := arc.Get([]byte("counter")); !bytes.Equal(got, []byte("x")) {
+		t.Errorf("unexpected value: got:%q, want:%q", got, "x")
+	}
+
+	// Removing the registration falls back to the default operator.
+	arc.RegisterMergeOperator([]byte("counter/"), nil)
+	arc.Merge([]byte("counter/b"), []byte("y"))
+
+	if got, _ := arc.Get([]byte("counter/b")); !bytes.Equal(got, []byte("y")) {
+		t.Errorf("unexpected value: got:%q, want:%q", got, "y")
+	}
+}
+
+func TestMergeConcurrency(t *testing.T) {
+	const numWorkers = 8
+	const numIncrements = 500
+
+	arc := New()
+	arc.RegisterMergeOperator(nil, CounterAdd{})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < numIncrements; j++ {
+				if err := arc.Merge([]byte("hits"), encodeInt64(1)); err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if got, _ := arc.Get([]byte("hits")); decodeInt64(got) != numWorkers*numIncrements {
+		t.Errorf("unexpected counter: got:%d, want:%d", decodeInt64(got), numWorkers*numIncrements)
+	}
+}
+
+func TestDecodeSetInvalid(t *testing.T) {
+	for _, src := range [][]byte{{0x05, 'a'}, {0x80}} {
+		if _, err := DecodeSet(src); !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("unexpected error for %x: got:%v, want:%v", src, err, ErrInvalidEncoding)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// VFS is the filesystem through which Arc persists databases. OSFS accesses
+// the files of the operating system, whereas MemFS keeps them in memory and
+// injects faults for testing.
+type VFS interface {
+	// OpenFile opens the named file with the given os.O_* flags. The file is
+	// created with the given permissions if os.O_CREATE is set.
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+
+	// Rename atomically replaces newpath with oldpath. The rename is durable
+	// when Rename returns.
+	Rename(oldpath string, newpath string) error
+
+	// Remove removes the named file. The removal is durable when Remove
+	// returns.
+	Remove(name string) error
+}
+
+// File is an open file of a VFS. It is satisfied by *os.File.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+
+	// Sync makes the contents of the file durable.
+	Sync() error
+
+	// Truncate changes the size of the file.
+	Truncate(size int64) error
+}
+
+// OSFS is the VFS of the operating system.
+type OSFS struct{}
+
+// OpenFile opens the named file with os.OpenFile.
+func (OSFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Rename renames the file with os.Rename, and syncs the parent directory of
+// newpath so that the rename survives a crash.
+func (OSFS) Rename(oldpath string, newpath string) error {
+	if err := os.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(newpath))
+}
+
+// Remove removes the file with os.Remove, and syncs its parent directory so
+// that the removal survives a crash.
+func (OSFS) Remove(name string) error {
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir makes the entries of the directory durable.
+func syncDir(name string) error {
+	dir, err := os.Open(name)
+
+	if err != nil {
+		return err
+	}
+
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+
+	return dir.Close()
+}
@@ -0,0 +1,68 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOSFS(t *testing.T) {
+	var fsys VFS = OSFS{}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "db")
+
+	f, err := fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+
+	if err != nil {
+		t.Fatalf("OpenFile(): %v", err)
+	}
+
+	if _, err := f.Write([]byte("hello world")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+
+	if err := f.Truncate(5); err != nil {
+		t.Fatalf("Truncate(): %v", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+
+	renamed := filepath.Join(dir, "renamed")
+
+	if err := fsys.Rename(name, renamed); err != nil {
+		t.Fatalf("Rename(): %v", err)
+	}
+
+	f, err = fsys.OpenFile(renamed, os.O_RDONLY, 0)
+
+	if err != nil {
+		t.Fatalf("OpenFile(): %v", err)
+	}
+
+	if got, _ := io.ReadAll(f); string(got) != "hello" {
+		t.Errorf("unexpected contents: got:%q, want:%q", got, "hello")
+	}
+
+	f.Close()
+
+	if err := fsys.Remove(renamed); err != nil {
+		t.Fatalf("Remove(): %v", err)
+	}
+
+	if _, err := fsys.OpenFile(renamed, os.O_RDONLY, 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected error: got:%v, want:%v", err, fs.ErrNotExist)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+)
+
+// watchBufferLen is the number of events that a watcher can buffer before it
+// is considered too slow, and is closed with an EventOverflow event.
+const watchBufferLen = 256
+
+// Hash is a SHA-256 hash. The zero Hash denotes the absence of a value.
+type Hash [32]byte
+
+// IsZero returns true if the hash is the zero Hash.
+func (h Hash) IsZero() bool {
+	return h == Hash{}
+}
+
+// String returns the hexadecimal representation of the hash.
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// EventType identifies the kind of change that an Event describes.
+type EventType uint8
+
+const (
+	// EventPut is emitted when a record is inserted or updated.
+	EventPut EventType = iota + 1
+
+	// EventDelete is emitted when a record is removed, including when an
+	// expired record is reclaimed.
+	EventDelete
+
+	// EventOverflow is the final event of a watcher that did not keep up
+	// with the changes. The channel is closed after this event, and the
+	// consumer must resynchronize its state before watching again.
+	EventOverflow
+)
+
+// String returns the name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventOverflow:
+		return "overflow"
+	}
+
+	return "unknown"
+}
+
+// Event describes a change to a single record. The hashes are the SHA-256
+// hashes of the values before and after the change, which allows consumers to
+// detect changes without transferring the values. OldHash is zero if the
+// record did not exist, and NewHash is zero if the record was removed.
+type Event struct {
+	Seq     uint64 // Sequence number of the change. Zero for EventOverflow.
+	Type    EventType
+	Key     []byte
+	OldHash Hash
+	NewHash Hash
+}
+
+// watcher is an active subscription that was created by Watch.
+type watcher struct {
+	prefix []byte
+	events chan Event
+	done   chan struct{}
+}
+
+// Watch returns a channel that receives an Event for every change to a record
+// whose key begins with the given prefix. A nil or empty prefix watches every
+// record. The channel is closed when the context is done.
+//
+// Writers never block on watchers. Each watcher buffers up to 256 events, and
+// a watcher that falls further behind receives a final EventOverflow event,
+// after which its channel is closed.
+func (a *Arc) Watch(ctx context.Context, prefix []byte) <-chan Event {
+	w := &watcher{
+		prefix: bytes.Clone(prefix),
+		events: make(chan Event, watchBufferLen+1),
+		done:   make(chan struct{}),
+	}
+
+	a.mu.Lock()
+
+	if a.watchers == nil {
+		a.watchers = map[*watcher]struct{}{}
+	}
+
+	a.watchers[w] = struct{}{}
+
+	a.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			a.mu.Lock()
+			a.unwatch(w)
+			a.mu.Unlock()
+		case <-w.done:
+		}
+	}()
+
+	return w.events
+}
+
+// observed returns true if any observer needs to be notified of changes. It
+// allows write paths to skip the preparation of notifications.
+func (a *Arc) observed() bool {
+	return len(a.watchers) > 0
+}
+
+// notify delivers the event to the watchers of its key. The caller must hold
+// the write lock, which serializes deliveries with the closing of watchers.
+func (a *Arc) notify(ev Event) {
+	for w := range a.watchers {
+		if !bytes.HasPrefix(ev.Key, w.prefix) {
+			continue
+		}
+
+		// The last slot of the buffer is reserved for the overflow event.
+		if len(w.events) >= watchBufferLen {
+			w.events <- Event{Type: EventOverflow}
+			a.unwatch(w)
+
+			continue
+		}
+
+		w.events <- Event{
+			Seq:     ev.Seq,
+			Type:    ev.Type,
+			Key:     bytes.Clone(ev.Key),
+			OldHash: ev.OldHash,
+			NewHash: ev.NewHash,
+		}
+	}
+}
+
+// unwatch removes the watcher and closes its channels. The caller must hold
+// the write lock.
+func (a *Arc) unwatch(w *watcher) {
+	if _, found := a.watchers[w]; !found {
+		return
+	}
+
+	delete(a.watchers, w)
+	close(w.events)
+	close(w.done)
+}
+
+// subtreeDeleteEvents returns a deletion event for every record within the
+// subtree rooted at n. The path is the full key of n's parent.
+func subtreeDeleteEvents(n *node, path []byte) []Event {
+	var ret []Event
+
+	walkNode(n, path, nil, nil, func(key []byte, n *node) bool {
+		ret = append(ret, Event{Type: EventDelete, Key: bytes.Clone(key), OldHash: n.valueHash()})
+		return true
+	})
+
+	return ret
+}
@@ -0,0 +1,147 @@
+// Copyright Chrono Technologies LLC
+// SPDX-License-Identifier: MIT
+
+package arc
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+// receiveEvent returns the next event of the channel, or fails the test if no
+// event arrives in time.
+func receiveEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("unexpected closed channel")
+		}
+
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+
+	return Event{}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	arc := basicTestTree()
+	events := arc.Watch(ctx, []byte("app"))
+
+	arc.Put([]byte("apple"), blobValueX())
+	arc.Put([]byte("banana"), []byte("ignored"))
+	arc.Add([]byte("appetite"), []byte("big"))
+	arc.Delete([]byte("applet"))
+
+	want := []Event{
+		{Type: EventPut, Key: []byte("apple"), OldHash: sha256.Sum256([]byte("cider")), NewHash: sha256.Sum256(blobValueX())},
+		{Type: EventPut, Key: []byte("appetite"), NewHash: sha256.Sum256([]byte("big"))},
+		{Type: EventDelete, Key: []byte("applet"), OldHash: sha256.Sum256([]byte("java"))},
+	}
+
+	for _, w := range want {
+		got := receiveEvent(t, events)
+
+		if got.Type != w.Type || string(got.Key) != string(w.Key) || got.OldHash != w.OldHash || got.NewHash != w.NewHash {
+			t.Errorf("unexpected event: got:%s %q %s->%s, want:%s %q %s->%s", got.Type, got.Key, got.OldHash, got.NewHash, w.Type, w.Key, w.OldHash, w.NewHash)
+		}
+	}
+
+	cancel()
+
+	for range events {
+		t.Error("unexpected event after cancellation")
+	}
+}
+
+func TestWatchDeletePrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	arc := basicTestTree()
+	events := arc.Watch(ctx, []byte("ban"))
+
+	if _, err := arc.DeletePrefix([]byte("b")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, key := range []string{"banana", "band", "bandage", "bandsaw"} {
+		got := receiveEvent(t, events)
+
+		if got.Type != EventDelete || string(got.Key) != key || got.OldHash.IsZero() || !got.NewHash.IsZero() {
+			t.Errorf("unexpected event: got:%s %q, want:delete %q", got.Type, got.Key, key)
+		}
+	}
+
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event: %s %q", ev.Type, ev.Key)
+	default:
+	}
+}
+
+func TestWatchExpiry(t *testing.T) {
+	advance := setTestClock(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	arc := New()
+	arc.PutWithTTL([]byte("session"), []byte("token"), time.Second)
+
+	events := arc.Watch(ctx, nil)
+
+	advance(time.Minute)
+	arc.ReclaimExpired()
+
+	if got := receiveEvent(t, events); got.Type != EventDelete || string(got.Key) != "session" {
+		t.Errorf("unexpected event: got:%s %q, want:delete %q", got.Type, got.Key, "session")
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	arc := New()
+	slow := arc.Watch(ctx, nil)
+
+	// Writes must not block, even though nobody consumes the events.
+	for i := 0; i < watchBufferLen*2; i++ {
+		arc.Put([]byte{byte(i >> 8), byte(i)}, nil)
+	}
+
+	var numPuts int
+	var last Event
+
+	for ev := range slow {
+		if ev.Type == EventPut {
+			numPuts++
+		}
+
+		last = ev
+	}
+
+	if numPuts != watchBufferLen {
+		t.Errorf("unexpected number of events: got:%d, want:%d", numPuts, watchBufferLen)
+	}
+
+	if last.Type != EventOverflow {
+		t.Errorf("unexpected final event: got:%s, want:%s", last.Type, EventOverflow)
+	}
+
+	arc.mu.RLock()
+	defer arc.mu.RUnlock()
+
+	if len(arc.watchers) != 0 {
+		t.Errorf("unexpected watcher count: got:%d, want:0", len(arc.watchers))
+	}
+}